@@ -0,0 +1,113 @@
+// Command reconcile compares one day of Stripe PaymentIntents with the payment
+// ledger and writes the discrepancies as JSON and CSV. It exits with status 2
+// when it finds any, so a scheduler can alert on the run, and with status 1
+// when it cannot reconcile.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/reconcile"
+)
+
+// Exit statuses
+const (
+	exitOK            = 0
+	exitFailed        = 1
+	exitDiscrepancies = 2
+)
+
+// setup loads the configuration and connects to Stripe and MongoDB. The
+// returned func disconnects; tests replace setup to avoid real services.
+var setup = func() (*payments.Service, func(), error) {
+	config.LoadConfig("vehicle-stock-service")
+	svc, err := payments.New(config.AppConfig)
+	if err != nil {
+		log.Println("Stripe configuration failed:", err)
+		return nil, nil, err
+	}
+	if _, err := mongo.ConnectMongo(config.AppConfig.MongoURI); err != nil {
+		log.Println("MongoDB connection failed:", err)
+		return nil, nil, err
+	}
+	return svc, func() { mongo.Disconnect(context.Background()) }, nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run reconciles the day selected by args and returns the exit status
+func run(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	date := flags.String("date", time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly), "UTC day to reconcile (YYYY-MM-DD), default yesterday")
+	out := flags.String("out", ".", "directory for the reconcile-<date>.json and .csv reports; - prints the JSON report to stdout")
+	if err := flags.Parse(args); err != nil {
+		return exitFailed
+	}
+
+	from, err := time.Parse(time.DateOnly, *date)
+	if err != nil {
+		log.Println("Invalid -date:", err)
+		return exitFailed
+	}
+	to := from.AddDate(0, 0, 1)
+
+	svc, disconnect, err := setup()
+	if err != nil {
+		return exitFailed
+	}
+	defer disconnect()
+
+	report, err := reconcile.Run(svc, config.AppConfig.MongoDB, config.AppConfig.LedgerCollection(), from, to)
+	if err != nil {
+		log.Println("Reconciliation failed:", err)
+		return exitFailed
+	}
+	if *out == "-" {
+		if err := report.WriteJSON(stdout); err != nil {
+			log.Println("Writing JSON report failed:", err)
+			return exitFailed
+		}
+	} else {
+		base := filepath.Join(*out, "reconcile-"+*date)
+		if err := writeFile(base+".json", report.WriteJSON); err != nil {
+			log.Println("Writing JSON report failed:", err)
+			return exitFailed
+		}
+		if err := writeFile(base+".csv", report.WriteCSV); err != nil {
+			log.Println("Writing CSV report failed:", err)
+			return exitFailed
+		}
+		log.Printf("Wrote %s.json and %s.csv", base, base)
+	}
+
+	log.Printf("Reconciled %s: %d Stripe and %d local PaymentIntents, %d matched, %d unfunded, %d discrepancies",
+		*date, report.StripeIntents, report.LocalIntents, report.Matched, report.Unfunded, len(report.Discrepancies))
+	if len(report.Discrepancies) > 0 {
+		return exitDiscrepancies
+	}
+	return exitOK
+}
+
+// writeFile creates path and fills it with write
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/client"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/stripefake"
+)
+
+var day = time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+
+// useFakes points setup at a fake Stripe server and the ledger at entries
+func useFakes(t *testing.T, entries *[]models.LedgerEntry) (*stripefake.Server, *bool) {
+	srv := stripefake.NewServer()
+	t.Cleanup(srv.Close)
+	disconnected := new(bool)
+
+	origSetup, origFind, origCfg := setup, mongo.FindLedgerEntries, config.AppConfig
+	t.Cleanup(func() { setup, mongo.FindLedgerEntries, config.AppConfig = origSetup, origFind, origCfg })
+
+	config.AppConfig = config.Config{MongoDB: "vehicles"}
+	setup = func() (*payments.Service, func(), error) {
+		svc, err := payments.New(config.Config{StripeKey: "sk_test_default", StripeAPIBase: srv.URL})
+		return svc, func() { *disconnected = true }, err
+	}
+	mongo.FindLedgerEntries = func(database, collection string, q mongo.LedgerQuery) ([]models.LedgerEntry, error) {
+		assert.Equal(t, "vehicles", database)
+		assert.Equal(t, "payment_ledger", collection)
+		assert.Equal(t, day, q.From)
+		return *entries, nil
+	}
+	return srv, disconnected
+}
+
+// hold creates a confirmed manual-capture PaymentIntent on the fake during day
+func hold(t *testing.T, srv *stripefake.Server) *stripe.PaymentIntent {
+	srv.SetClock(func() time.Time { return day.Add(10 * time.Hour) })
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(srv.URL), MaxNetworkRetries: stripe.Int64(0)})
+	pi, err := client.New("sk_test_default", &stripe.Backends{API: backend}).PaymentIntents.New(&stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(5000),
+		Currency:      stripe.String("usd"),
+		PaymentMethod: stripe.String("pm_card_visa"),
+		CaptureMethod: stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		Confirm:       stripe.Bool(true),
+	})
+	assert.NoError(t, err)
+	return pi
+}
+
+func TestRunReportsToStdout(t *testing.T) {
+	var entries []models.LedgerEntry
+	srv, disconnected := useFakes(t, &entries)
+	pi := hold(t, srv)
+	entries = append(entries, models.LedgerEntry{ID: "hold:" + pi.ID, Type: models.LedgerHold, PaymentIntentID: pi.ID,
+		Status: "requires_capture", Amount: 5000, Currency: "usd", CreatedAt: day.Add(10 * time.Hour)})
+
+	var stdout bytes.Buffer
+	assert.Equal(t, exitOK, run([]string{"-date", "2025-08-01", "-out", "-"}, &stdout))
+	assert.True(t, *disconnected)
+
+	var report map[string]interface{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.Equal(t, "2025-08-01T00:00:00Z", report["from"])
+	assert.Equal(t, float64(1), report["matched"])
+	assert.Empty(t, report["discrepancies"])
+}
+
+func TestRunWritesReportsAndFlagsDiscrepancies(t *testing.T) {
+	var entries []models.LedgerEntry
+	srv, _ := useFakes(t, &entries)
+	hold(t, srv)
+
+	dir := t.TempDir()
+	var stdout bytes.Buffer
+	assert.Equal(t, exitDiscrepancies, run([]string{"-date", "2025-08-01", "-out", dir}, &stdout))
+	assert.Empty(t, stdout.String())
+
+	b, err := os.ReadFile(filepath.Join(dir, "reconcile-2025-08-01.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"missing_locally"`)
+	b, err = os.ReadFile(filepath.Join(dir, "reconcile-2025-08-01.csv"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "missing_locally")
+}
+
+func TestRunFailures(t *testing.T) {
+	var entries []models.LedgerEntry
+	srv, _ := useFakes(t, &entries)
+	var stdout bytes.Buffer
+
+	assert.Equal(t, exitFailed, run([]string{"-bogus"}, &stdout))
+	assert.Equal(t, exitFailed, run([]string{"-date", "yesterday"}, &stdout))
+	assert.Equal(t, exitFailed, run([]string{"-date", "2025-08-01", "-out", filepath.Join(t.TempDir(), "missing")}, &stdout))
+
+	srv.FailNext(500, "api_error", "", "Stripe is down")
+	assert.Equal(t, exitFailed, run([]string{"-date", "2025-08-01", "-out", "-"}, &stdout))
+
+	setup = func() (*payments.Service, func(), error) { return nil, nil, errors.New("mongo down") }
+	assert.Equal(t, exitFailed, run([]string{"-date", "2025-08-01", "-out", "-"}, &stdout))
+	assert.Empty(t, stdout.String())
+}
@@ -0,0 +1,19 @@
+// Command stripefake serves the in-memory Stripe stand-in for local development.
+// Run the service with stripe_api_base (or STRIPE_API_BASE) set to its address.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/yourusername/vehicle-stock-service/internal/stripefake"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:12111", "listen address")
+	flag.Parse()
+
+	log.Printf("Fake Stripe API listening on http://%s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, stripefake.NewBackend()))
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -18,6 +21,321 @@ type Config struct {
 	MongoDB      string   `json:"mongo_db"`
 	MongoColl    string   `json:"mongo_collection"`
 	StripeKey    string   `json:"stripe_key"`
+
+	// StripeKeys maps a vehicle region (e.g. "US", "CA") to its own Stripe account key;
+	// regions without one use StripeKey
+	StripeKeys map[string]string `json:"stripe_keys"`
+
+	// StripeAPIBase overrides https://api.stripe.com, e.g. to point at the local stripefake server
+	StripeAPIBase string `json:"stripe_api_base"`
+
+	// Stripe webhooks: signing secret, event store and republish topic
+	StripeWebhookSecret string `json:"stripe_webhook_secret"`
+	StripeEventsColl    string `json:"stripe_events_collection"`
+	PaymentEventsTopic  string `json:"payment_events_topic"`
+
+	// Subscription source: "http", "file" or "mongo"
+	SubscriptionSource string `json:"subscription_source"`
+	SubscriptionURL    string `json:"subscription_url"`
+	SubscriptionFile   string `json:"subscription_file"`
+	SubscriptionColl   string `json:"subscription_collection"`
+
+	// StockTimezone is the IANA zone used to resolve date-only stock lookups
+	StockTimezone     string `json:"stock_timezone"`
+	MigrateStockTimes bool   `json:"migrate_stock_times"`
+	// StockSchemaDryRun reports stock collection index/validator drift at startup without changing it
+	StockSchemaDryRun bool `json:"stock_schema_dry_run"`
+	// StockTimeSeries stores ticks in a MongoDB time-series collection when the server supports it
+	StockTimeSeries StockTimeSeriesConfig `json:"stock_timeseries"`
+
+	// Stock tick batching: ticks are stored with one bulk upsert per StockBatchSize
+	// ticks or StockFlushIntervalMs; producers block once StockQueueSize ticks wait
+	StockBatchSize       int `json:"stock_batch_size"`
+	StockFlushIntervalMs int `json:"stock_flush_interval_ms"`
+	StockQueueSize       int `json:"stock_queue_size"`
+
+	// WebSocket /ws/stock limits; zero means use the default
+	WSMaxSubscriptions int `json:"ws_max_subscriptions"`
+	WSSendBuffer       int `json:"ws_send_buffer"`
+	WSPingIntervalSec  int `json:"ws_ping_interval_seconds"`
+
+	Pricing PricingConfig `json:"pricing"`
+
+	// Vehicle reservations
+	ReservationsColl      string `json:"reservations_collection"`
+	ReservationTTLMinutes int    `json:"reservation_ttl_minutes"`
+	ReservationSweepSec   int    `json:"reservation_sweep_seconds"`
+
+	// Currencies whitelists ISO-4217 payment currencies with optional amount limits
+	Currencies map[string]CurrencyLimits `json:"currencies"`
+
+	// PaymentsColl stores payment records with their refund history
+	PaymentsColl string `json:"payments_collection"`
+
+	// LedgerColl is the append-only ledger of every payment operation
+	LedgerColl string `json:"ledger_collection"`
+
+	// StreamTokensColl persists the change stream position of the live stock feed
+	StreamTokensColl string `json:"stream_tokens_collection"`
+
+	// InstanceName identifies this replica, e.g. for its live feed resume token;
+	// leave it out of shared configuration so each replica uses its own
+	InstanceName string `json:"instance_id"`
+
+	// Idempotency-Key records for payment requests
+	IdempotencyColl     string `json:"idempotency_collection"`
+	IdempotencyTTLHours int    `json:"idempotency_ttl_hours"`
+
+	// RunMode is "producer" (HTTP API and tick producer), "consumer" (Kafka to MongoDB) or "all"
+	RunMode         string `json:"run_mode"`
+	KafkaGroupID    string `json:"kafka_group_id"`
+	ConsumerWorkers int    `json:"consumer_workers"`
+	// ProducerStoresTicks lets a producer without consumers store its ticks;
+	// wherever consumers run, Kafka is the only write path
+	ProducerStoresTicks bool `json:"producer_stores_ticks"`
+
+	// Consumers store ticks and commit offsets per ConsumerBatchSize messages
+	// or ConsumerBatchWaitMs, whichever comes first; zero means use the default
+	ConsumerBatchSize   int `json:"consumer_batch_size"`
+	ConsumerBatchWaitMs int `json:"consumer_batch_wait_ms"`
+
+	// Consumer error handling: transient MongoDB errors are retried with
+	// exponential backoff; undecodable or unstorable messages go to the
+	// dead-letter topic. Zero means use the default.
+	KafkaDeadLetterTopic   string `json:"kafka_dead_letter_topic"`
+	ConsumerMaxAttempts    int    `json:"consumer_max_attempts"`
+	ConsumerRetryBackoffMs int    `json:"consumer_retry_backoff_ms"`
+	ConsumerMaxBackoffMs   int    `json:"consumer_max_backoff_ms"`
+
+	// Shutdown timeouts; zero means use the default
+	ShutdownTimeoutSec  int `json:"shutdown_timeout_seconds"`
+	KafkaFlushTimeoutMs int `json:"kafka_flush_timeout_ms"`
+}
+
+// Run modes
+const (
+	RunModeProducer = "producer"
+	RunModeConsumer = "consumer"
+	RunModeAll      = "all"
+)
+
+// ParseRunMode validates a run mode; empty means RunModeProducer
+func ParseRunMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", RunModeProducer:
+		return RunModeProducer, nil
+	case RunModeConsumer:
+		return RunModeConsumer, nil
+	case RunModeAll:
+		return RunModeAll, nil
+	default:
+		return "", fmt.Errorf("unknown run mode %q", mode)
+	}
+}
+
+// RunsProducer reports whether mode serves the HTTP API and produces ticks
+func RunsProducer(mode string) bool {
+	return mode == RunModeProducer || mode == RunModeAll
+}
+
+// RunsConsumer reports whether mode consumes ticks from Kafka
+func RunsConsumer(mode string) bool {
+	return mode == RunModeConsumer || mode == RunModeAll
+}
+
+// ProducerStores reports whether the producer of mode writes its ticks to
+// MongoDB itself. Consumers store every tick on the topic, so it never does
+// when they run in the same process.
+func (c Config) ProducerStores(mode string) bool {
+	return c.ProducerStoresTicks && RunsProducer(mode) && !RunsConsumer(mode)
+}
+
+// Workers is the number of Kafka consumer workers to start (default 1)
+func (c Config) Workers() int {
+	if c.ConsumerWorkers <= 0 {
+		return 1
+	}
+	return c.ConsumerWorkers
+}
+
+// GroupID is the Kafka consumer group (default "vehicle-stock-service")
+func (c Config) GroupID() string {
+	if c.KafkaGroupID == "" {
+		return "vehicle-stock-service"
+	}
+	return c.KafkaGroupID
+}
+
+// DeadLetterTopic receives messages the consumers give up on (default "<kafka_topic>.dlq")
+func (c Config) DeadLetterTopic() string {
+	if c.KafkaDeadLetterTopic == "" {
+		return c.KafkaTopic + ".dlq"
+	}
+	return c.KafkaDeadLetterTopic
+}
+
+// StripeEventsCollection is where webhook events are stored (default "stripe_events")
+func (c Config) StripeEventsCollection() string {
+	if c.StripeEventsColl == "" {
+		return "stripe_events"
+	}
+	return c.StripeEventsColl
+}
+
+// PaymentTopic is the Kafka topic for normalized payment events (default "payment-events")
+func (c Config) PaymentTopic() string {
+	if c.PaymentEventsTopic == "" {
+		return "payment-events"
+	}
+	return c.PaymentEventsTopic
+}
+
+// ReservationsCollection stores vehicle reservations (default "reservations")
+func (c Config) ReservationsCollection() string {
+	if c.ReservationsColl == "" {
+		return "reservations"
+	}
+	return c.ReservationsColl
+}
+
+// ReservationTTL is how long a reservation holds its VIN (default 30m)
+func (c Config) ReservationTTL() time.Duration {
+	if c.ReservationTTLMinutes <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(c.ReservationTTLMinutes) * time.Minute
+}
+
+// ReservationSweepInterval is how often expired reservations are released (default 1m)
+func (c Config) ReservationSweepInterval() time.Duration {
+	if c.ReservationSweepSec <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.ReservationSweepSec) * time.Second
+}
+
+// PaymentsCollection stores payment records and refunds (default "payments")
+func (c Config) PaymentsCollection() string {
+	if c.PaymentsColl == "" {
+		return "payments"
+	}
+	return c.PaymentsColl
+}
+
+// LedgerCollection is the payment ledger (default "payment_ledger")
+func (c Config) LedgerCollection() string {
+	if c.LedgerColl == "" {
+		return "payment_ledger"
+	}
+	return c.LedgerColl
+}
+
+// IdempotencyCollection stores Idempotency-Key records (default "idempotency_keys")
+func (c Config) IdempotencyCollection() string {
+	if c.IdempotencyColl == "" {
+		return "idempotency_keys"
+	}
+	return c.IdempotencyColl
+}
+
+// IdempotencyTTL is how long Idempotency-Key responses are replayed (default 24h, as Stripe)
+func (c Config) IdempotencyTTL() time.Duration {
+	if c.IdempotencyTTLHours <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.IdempotencyTTLHours) * time.Hour
+}
+
+// StreamTokensCollection stores live feed resume tokens (default "stream_resume_tokens")
+func (c Config) StreamTokensCollection() string {
+	if c.StreamTokensColl == "" {
+		return "stream_resume_tokens"
+	}
+	return c.StreamTokensColl
+}
+
+// InstanceID identifies this replica: the configured instance_id, else the
+// INSTANCE_ID environment variable, else the host name (the pod name on Kubernetes)
+func (c Config) InstanceID() string {
+	if c.InstanceName != "" {
+		return c.InstanceName
+	}
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "default"
+}
+
+// StockBatch is the number of ticks stored per bulk upsert (default 500)
+func (c Config) StockBatch() int {
+	if c.StockBatchSize <= 0 {
+		return 500
+	}
+	return c.StockBatchSize
+}
+
+// StockFlushInterval is the longest a tick waits for its batch (default 1s)
+func (c Config) StockFlushInterval() time.Duration {
+	if c.StockFlushIntervalMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.StockFlushIntervalMs) * time.Millisecond
+}
+
+// StockQueue is the number of ticks buffered before producers block (default 4 batches)
+func (c Config) StockQueue() int {
+	if c.StockQueueSize <= 0 {
+		return 4 * c.StockBatch()
+	}
+	return c.StockQueueSize
+}
+
+// ShutdownTimeout bounds HTTP draining, component stop and each cleanup step (default 15s)
+func (c Config) ShutdownTimeout() time.Duration {
+	if c.ShutdownTimeoutSec <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(c.ShutdownTimeoutSec) * time.Second
+}
+
+// KafkaFlushTimeout bounds the final producer flush (default 5s)
+func (c Config) KafkaFlushTimeout() time.Duration {
+	if c.KafkaFlushTimeoutMs <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.KafkaFlushTimeoutMs) * time.Millisecond
+}
+
+// PricingConfig selects and parameterises the stock pricing model
+type PricingConfig struct {
+	Model             string             `json:"model"` // random_walk, mean_reverting or feature
+	Seed              int64              `json:"seed"`  // 0 seeds from the clock
+	Base              *float64           `json:"base"`  // nil uses the model default; 0 is a valid setting
+	Spread            *float64           `json:"spread"`
+	Volatility        *float64           `json:"volatility"`
+	Reversion         *float64           `json:"reversion"`
+	BrandFactors      map[string]float64 `json:"brand_factors"`
+	RegionFactors     map[string]float64 `json:"region_factors"`
+	GenerationFactors map[string]float64 `json:"generation_factors"`
+	FeaturePremiums   map[string]float64 `json:"feature_premiums"`
+}
+
+// StockTimeSeriesConfig describes the time-series stock collection. It only
+// applies when the collection is created; existing collections keep their type.
+type StockTimeSeriesConfig struct {
+	Enabled            bool   `json:"enabled"`
+	MetaField          string `json:"meta_field"`           // tick field identifying the series; only "meta" is supported
+	Granularity        string `json:"granularity"`          // seconds (default), minutes or hours
+	ExpireAfterSeconds int64  `json:"expire_after_seconds"` // 0 keeps ticks forever
+}
+
+// CurrencyLimits bounds payment amounts in major units (e.g. 0.50 usd); zero means Stripe's limit
+type CurrencyLimits struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
 }
 
 // AppConfig is the exported global configuration
@@ -51,6 +369,73 @@ func LoadConfig(serviceName string) {
 				MongoDB:      getEnvOrDefault("MONGO_DB", "vehicle_stock_db"),
 				MongoColl:    getEnvOrDefault("MONGO_COLLECTION", "stock_data"),
 				StripeKey:    os.Getenv("STRIPE_KEY"),
+				StripeKeys:   getEnvStripeKeys(),
+
+				StripeAPIBase: os.Getenv("STRIPE_API_BASE"),
+
+				StripeWebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+				StripeEventsColl:    getEnvOrDefault("STRIPE_EVENTS_COLLECTION", "stripe_events"),
+				PaymentEventsTopic:  getEnvOrDefault("PAYMENT_EVENTS_TOPIC", "payment-events"),
+
+				SubscriptionSource: getEnvOrDefault("SUBSCRIPTION_SOURCE", "file"),
+				SubscriptionURL:    os.Getenv("SUBSCRIPTION_URL"),
+				SubscriptionFile:   getEnvOrDefault("SUBSCRIPTION_FILE", "subscriptions.json"),
+				SubscriptionColl:   getEnvOrDefault("SUBSCRIPTION_COLLECTION", "vehicle_subscriptions"),
+
+				StockTimezone:     getEnvOrDefault("STOCK_TIMEZONE", "UTC"),
+				MigrateStockTimes: os.Getenv("MIGRATE_STOCK_TIMES") == "true",
+				StockSchemaDryRun: os.Getenv("STOCK_SCHEMA_DRY_RUN") == "true",
+				StockTimeSeries: StockTimeSeriesConfig{
+					Enabled:            os.Getenv("STOCK_TIMESERIES") == "true",
+					MetaField:          getEnvOrDefault("STOCK_TIMESERIES_META_FIELD", "meta"),
+					Granularity:        getEnvOrDefault("STOCK_TIMESERIES_GRANULARITY", "seconds"),
+					ExpireAfterSeconds: getEnvInt64OrDefault("STOCK_TIMESERIES_EXPIRE_SECONDS", 0),
+				},
+
+				StockBatchSize:       int(getEnvInt64OrDefault("STOCK_BATCH_SIZE", 500)),
+				StockFlushIntervalMs: int(getEnvInt64OrDefault("STOCK_FLUSH_INTERVAL_MS", 1000)),
+				StockQueueSize:       int(getEnvInt64OrDefault("STOCK_QUEUE_SIZE", 2000)),
+
+				WSMaxSubscriptions: int(getEnvInt64OrDefault("WS_MAX_SUBSCRIPTIONS", 100)),
+				WSSendBuffer:       int(getEnvInt64OrDefault("WS_SEND_BUFFER", 256)),
+				WSPingIntervalSec:  int(getEnvInt64OrDefault("WS_PING_INTERVAL_SECONDS", 30)),
+
+				Pricing: PricingConfig{
+					Model: getEnvOrDefault("PRICING_MODEL", "random_walk"),
+					Seed:  getEnvInt64OrDefault("PRICING_SEED", 0),
+				},
+
+				ReservationsColl:      getEnvOrDefault("RESERVATIONS_COLLECTION", "reservations"),
+				ReservationTTLMinutes: int(getEnvInt64OrDefault("RESERVATION_TTL_MINUTES", 30)),
+				ReservationSweepSec:   int(getEnvInt64OrDefault("RESERVATION_SWEEP_SECONDS", 60)),
+
+				Currencies: getEnvCurrencies("PAYMENT_CURRENCIES"),
+
+				PaymentsColl: getEnvOrDefault("PAYMENTS_COLLECTION", "payments"),
+				LedgerColl:   getEnvOrDefault("LEDGER_COLLECTION", "payment_ledger"),
+
+				StreamTokensColl: getEnvOrDefault("STREAM_TOKENS_COLLECTION", "stream_resume_tokens"),
+				InstanceName:     os.Getenv("INSTANCE_ID"),
+
+				IdempotencyColl:     getEnvOrDefault("IDEMPOTENCY_COLLECTION", "idempotency_keys"),
+				IdempotencyTTLHours: int(getEnvInt64OrDefault("IDEMPOTENCY_TTL_HOURS", 24)),
+
+				RunMode:         getEnvOrDefault("RUN_MODE", RunModeProducer),
+				KafkaGroupID:    getEnvOrDefault("KAFKA_GROUP_ID", "vehicle-stock-service"),
+				ConsumerWorkers: int(getEnvInt64OrDefault("CONSUMER_WORKERS", 1)),
+
+				ProducerStoresTicks: os.Getenv("PRODUCER_STORES_TICKS") == "true",
+
+				ConsumerBatchSize:   int(getEnvInt64OrDefault("CONSUMER_BATCH_SIZE", 100)),
+				ConsumerBatchWaitMs: int(getEnvInt64OrDefault("CONSUMER_BATCH_WAIT_MS", 1000)),
+
+				KafkaDeadLetterTopic:   os.Getenv("KAFKA_DEAD_LETTER_TOPIC"),
+				ConsumerMaxAttempts:    int(getEnvInt64OrDefault("CONSUMER_MAX_ATTEMPTS", 5)),
+				ConsumerRetryBackoffMs: int(getEnvInt64OrDefault("CONSUMER_RETRY_BACKOFF_MS", 100)),
+				ConsumerMaxBackoffMs:   int(getEnvInt64OrDefault("CONSUMER_MAX_BACKOFF_MS", 10000)),
+
+				ShutdownTimeoutSec:  int(getEnvInt64OrDefault("SHUTDOWN_TIMEOUT_SECONDS", 15)),
+				KafkaFlushTimeoutMs: int(getEnvInt64OrDefault("KAFKA_FLUSH_TIMEOUT_MS", 5000)),
 			}
 		}
 	}
@@ -65,6 +450,48 @@ func getEnvOrDefault(key, def string) string {
 	return val
 }
 
+// getEnvInt64OrDefault returns the integer value of the environment variable or the default if unset or invalid
+func getEnvInt64OrDefault(key string, def int64) int64 {
+	val, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return def
+	}
+	return val
+}
+
+// getEnvStripeKeys collects STRIPE_KEY_<REGION> variables into a region map; nil if none are set
+func getEnvStripeKeys() map[string]string {
+	var keys map[string]string
+	for _, kv := range os.Environ() {
+		name, val, _ := strings.Cut(kv, "=")
+		region, ok := strings.CutPrefix(name, "STRIPE_KEY_")
+		if !ok || region == "" || val == "" {
+			continue
+		}
+		if keys == nil {
+			keys = make(map[string]string)
+		}
+		keys[region] = val
+	}
+	return keys
+}
+
+// getEnvCurrencies parses a comma-separated currency list with default limits; nil if unset
+func getEnvCurrencies(key string) map[string]CurrencyLimits {
+	var out map[string]CurrencyLimits
+	for _, code := range strings.Split(os.Getenv(key), ",") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]CurrencyLimits)
+		}
+		out[code] = CurrencyLimits{}
+	}
+	return out
+}
+
 var fetchSecretsFromAWS = func(secretName string) (string, error) {
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String(os.Getenv("AWS_REGION")),
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,6 +16,8 @@ func TestLoadConfigHappyPath(t *testing.T) {
 	os.Setenv("MONGO_DB", "test_db")
 	os.Setenv("MONGO_COLLECTION", "test_collection")
 	os.Setenv("STRIPE_KEY", "sk_test_123")
+	os.Setenv("STRIPE_API_BASE", "http://localhost:12111")
+	defer os.Unsetenv("STRIPE_API_BASE")
 
 	LoadConfig("vehicle-stock-service")
 
@@ -24,6 +27,7 @@ func TestLoadConfigHappyPath(t *testing.T) {
 	assert.Equal(t, "test_db", AppConfig.MongoDB)
 	assert.Equal(t, "test_collection", AppConfig.MongoColl)
 	assert.Equal(t, "sk_test_123", AppConfig.StripeKey)
+	assert.Equal(t, "http://localhost:12111", AppConfig.StripeAPIBase)
 }
 
 func TestLoadConfigDefaults(t *testing.T) {
@@ -42,6 +46,127 @@ func TestLoadConfigDefaults(t *testing.T) {
 	assert.Equal(t, "vehicle_stock_db", AppConfig.MongoDB)
 	assert.Equal(t, "stock_data", AppConfig.MongoColl)
 	assert.Equal(t, "", AppConfig.StripeKey)
+	assert.Equal(t, "", AppConfig.StripeAPIBase)
+	assert.Equal(t, "file", AppConfig.SubscriptionSource)
+	assert.Equal(t, "subscriptions.json", AppConfig.SubscriptionFile)
+	assert.Equal(t, "vehicle_subscriptions", AppConfig.SubscriptionColl)
+	assert.Equal(t, "UTC", AppConfig.StockTimezone)
+	assert.False(t, AppConfig.MigrateStockTimes)
+	assert.False(t, AppConfig.StockSchemaDryRun)
+	assert.Equal(t, StockTimeSeriesConfig{MetaField: "meta", Granularity: "seconds"}, AppConfig.StockTimeSeries)
+	assert.Equal(t, 500, AppConfig.StockBatch())
+	assert.Equal(t, time.Second, AppConfig.StockFlushInterval())
+	assert.Equal(t, 2000, AppConfig.StockQueue())
+	assert.Equal(t, 2000, Config{}.StockQueue())
+	assert.Equal(t, 100, AppConfig.WSMaxSubscriptions)
+	assert.Equal(t, 256, AppConfig.WSSendBuffer)
+	assert.Equal(t, 30, AppConfig.WSPingIntervalSec)
+	assert.Equal(t, "random_walk", AppConfig.Pricing.Model)
+	assert.Equal(t, int64(0), AppConfig.Pricing.Seed)
+	assert.Equal(t, 15*time.Second, AppConfig.ShutdownTimeout())
+	assert.Equal(t, 5*time.Second, AppConfig.KafkaFlushTimeout())
+	assert.Equal(t, RunModeProducer, AppConfig.RunMode)
+	assert.Equal(t, "vehicle-stock-service", AppConfig.KafkaGroupID)
+	assert.Equal(t, 1, AppConfig.ConsumerWorkers)
+	assert.False(t, AppConfig.ProducerStoresTicks)
+	assert.Equal(t, 100, AppConfig.ConsumerBatchSize)
+	assert.Equal(t, 1000, AppConfig.ConsumerBatchWaitMs)
+	assert.Equal(t, "vehicle-stock.dlq", AppConfig.DeadLetterTopic())
+	assert.Equal(t, 5, AppConfig.ConsumerMaxAttempts)
+	assert.Equal(t, 100, AppConfig.ConsumerRetryBackoffMs)
+	assert.Equal(t, 10000, AppConfig.ConsumerMaxBackoffMs)
+}
+
+func TestParseRunMode(t *testing.T) {
+	for in, want := range map[string]string{"": RunModeProducer, "producer": RunModeProducer, "Consumer": RunModeConsumer, "ALL": RunModeAll} {
+		mode, err := ParseRunMode(in)
+		assert.NoError(t, err)
+		assert.Equal(t, want, mode)
+	}
+	_, err := ParseRunMode("both")
+	assert.Error(t, err)
+
+	assert.True(t, RunsProducer(RunModeProducer))
+	assert.True(t, RunsProducer(RunModeAll))
+	assert.False(t, RunsProducer(RunModeConsumer))
+	assert.True(t, RunsConsumer(RunModeConsumer))
+	assert.True(t, RunsConsumer(RunModeAll))
+	assert.False(t, RunsConsumer(RunModeProducer))
+
+	stores := Config{ProducerStoresTicks: true}
+	assert.True(t, stores.ProducerStores(RunModeProducer))
+	assert.False(t, stores.ProducerStores(RunModeAll))
+	assert.False(t, stores.ProducerStores(RunModeConsumer))
+	assert.False(t, Config{}.ProducerStores(RunModeProducer))
+}
+
+func TestStripeWebhookDefaults(t *testing.T) {
+	assert.Equal(t, "stripe_events", Config{}.StripeEventsCollection())
+	assert.Equal(t, "payment-events", Config{}.PaymentTopic())
+
+	cfg := Config{StripeEventsColl: "events", PaymentEventsTopic: "payments"}
+	assert.Equal(t, "events", cfg.StripeEventsCollection())
+	assert.Equal(t, "payments", cfg.PaymentTopic())
+}
+
+func TestReservationDefaults(t *testing.T) {
+	assert.Equal(t, "reservations", Config{}.ReservationsCollection())
+	assert.Equal(t, 30*time.Minute, Config{}.ReservationTTL())
+	assert.Equal(t, time.Minute, Config{}.ReservationSweepInterval())
+
+	cfg := Config{ReservationsColl: "holds", ReservationTTLMinutes: 10, ReservationSweepSec: 5}
+	assert.Equal(t, "holds", cfg.ReservationsCollection())
+	assert.Equal(t, 10*time.Minute, cfg.ReservationTTL())
+	assert.Equal(t, 5*time.Second, cfg.ReservationSweepInterval())
+}
+
+func TestPaymentsDefaults(t *testing.T) {
+	assert.Equal(t, "payments", Config{}.PaymentsCollection())
+	assert.Equal(t, "charges", Config{PaymentsColl: "charges"}.PaymentsCollection())
+	assert.Equal(t, "payment_ledger", Config{}.LedgerCollection())
+	assert.Equal(t, "ledger", Config{LedgerColl: "ledger"}.LedgerCollection())
+	assert.Equal(t, "stream_resume_tokens", Config{}.StreamTokensCollection())
+}
+
+func TestInstanceID(t *testing.T) {
+	t.Setenv("INSTANCE_ID", "")
+	host, err := os.Hostname()
+	assert.NoError(t, err)
+	assert.Equal(t, host, Config{}.InstanceID())
+
+	t.Setenv("INSTANCE_ID", "stock-api-1")
+	assert.Equal(t, "stock-api-1", Config{}.InstanceID())
+	assert.Equal(t, "stock-api-2", Config{InstanceName: "stock-api-2"}.InstanceID())
+}
+
+func TestIdempotencyDefaults(t *testing.T) {
+	assert.Equal(t, "idempotency_keys", Config{}.IdempotencyCollection())
+	assert.Equal(t, 24*time.Hour, Config{}.IdempotencyTTL())
+
+	cfg := Config{IdempotencyColl: "keys", IdempotencyTTLHours: 2}
+	assert.Equal(t, "keys", cfg.IdempotencyCollection())
+	assert.Equal(t, 2*time.Hour, cfg.IdempotencyTTL())
+}
+
+func TestConsumerDefaults(t *testing.T) {
+	assert.Equal(t, 1, Config{}.Workers())
+	assert.Equal(t, "vehicle-stock-service", Config{}.GroupID())
+
+	assert.Equal(t, "ticks.dlq", Config{KafkaTopic: "ticks"}.DeadLetterTopic())
+
+	cfg := Config{ConsumerWorkers: 4, KafkaGroupID: "stock-writers", KafkaDeadLetterTopic: "ticks-poison"}
+	assert.Equal(t, 4, cfg.Workers())
+	assert.Equal(t, "stock-writers", cfg.GroupID())
+	assert.Equal(t, "ticks-poison", cfg.DeadLetterTopic())
+}
+
+func TestShutdownTimeouts(t *testing.T) {
+	assert.Equal(t, 15*time.Second, Config{}.ShutdownTimeout())
+	assert.Equal(t, 5*time.Second, Config{}.KafkaFlushTimeout())
+
+	cfg := Config{ShutdownTimeoutSec: 30, KafkaFlushTimeoutMs: 250}
+	assert.Equal(t, 30*time.Second, cfg.ShutdownTimeout())
+	assert.Equal(t, 250*time.Millisecond, cfg.KafkaFlushTimeout())
 }
 
 func TestLoadConfig_AWSSecretSuccess(t *testing.T) {
@@ -118,6 +243,34 @@ func TestLoadConfig_ConfigJsonDecodeError(t *testing.T) {
 	LoadConfig("vehicle-stock-service")
 }
 
+func TestGetEnvInt64OrDefault(t *testing.T) {
+	os.Setenv("PRICING_SEED", "42")
+	assert.Equal(t, int64(42), getEnvInt64OrDefault("PRICING_SEED", 0))
+	os.Setenv("PRICING_SEED", "not-a-number")
+	assert.Equal(t, int64(7), getEnvInt64OrDefault("PRICING_SEED", 7))
+	os.Unsetenv("PRICING_SEED")
+	assert.Equal(t, int64(7), getEnvInt64OrDefault("PRICING_SEED", 7))
+}
+
+func TestGetEnvStripeKeys(t *testing.T) {
+	os.Setenv("STRIPE_KEY_US", "sk_us")
+	os.Setenv("STRIPE_KEY_CA", "sk_ca")
+	os.Setenv("STRIPE_KEY_", "ignored")
+	defer func() {
+		os.Unsetenv("STRIPE_KEY_US")
+		os.Unsetenv("STRIPE_KEY_CA")
+		os.Unsetenv("STRIPE_KEY_")
+	}()
+	assert.Equal(t, map[string]string{"US": "sk_us", "CA": "sk_ca"}, getEnvStripeKeys())
+}
+
+func TestGetEnvCurrencies(t *testing.T) {
+	os.Setenv("PAYMENT_CURRENCIES", " USD, cad,,")
+	assert.Equal(t, map[string]CurrencyLimits{"usd": {}, "cad": {}}, getEnvCurrencies("PAYMENT_CURRENCIES"))
+	os.Unsetenv("PAYMENT_CURRENCIES")
+	assert.Nil(t, getEnvCurrencies("PAYMENT_CURRENCIES"))
+}
+
 func TestGetEnvOrDefault(t *testing.T) {
 	os.Setenv("FOO", "bar")
 	assert.Equal(t, "bar", getEnvOrDefault("FOO", "baz"))
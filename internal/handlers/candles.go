@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+)
+
+const (
+	defaultCandleInterval = "1h"
+	maxCandles            = 5000
+)
+
+// StockCandlesResponse is the body returned by /stock/{vin}/candles
+type StockCandlesResponse struct {
+	VIN      string         `json:"vin"`
+	Ticker   string         `json:"ticker"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Interval string         `json:"interval"`
+	Candles  []mongo.Candle `json:"candles"`
+}
+
+// StockCandlesHandler handles GET /stock/{vin}/candles?from=&to=&interval=
+//
+// interval is one of 1m, 5m, 1h or 1d (default 1h); from/to behave as in /history.
+func (h *StockHandlers) StockCandlesHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validation.Errors
+	vin := mux.Vars(r)["vin"]
+	validation.Required(&errs, "vin", vin)
+
+	q := r.URL.Query()
+	from, to := parseTimeRange(&errs, q.Get("from"), q.Get("to"))
+
+	name := q.Get("interval")
+	if name == "" {
+		name = defaultCandleInterval
+	}
+	interval, ok := mongo.CandleIntervals[name]
+	if !ok {
+		validation.OneOf(&errs, "interval", name, "1m", "5m", "1h", "1d")
+	} else if to.Sub(from)/interval > maxCandles {
+		errs.Add("interval", validation.CodeTooLarge, "requested range has too many candles for this interval")
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	if h.candles == nil {
+		writeJSONError(w, http.StatusInternalServerError, "candle aggregator not configured")
+		return
+	}
+	ticker := "VEHICLE-" + vin
+	candles, err := h.candles.Candles(r.Context(), ticker, from, to, interval)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to aggregate candles")
+		return
+	}
+	if candles == nil {
+		candles = []mongo.Candle{}
+	}
+
+	writeJSON(w, http.StatusOK, StockCandlesResponse{
+		VIN:      vin,
+		Ticker:   ticker,
+		From:     from,
+		To:       to,
+		Interval: name,
+		Candles:  candles,
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+type failingCandles struct{}
+
+func (failingCandles) Candles(ctx context.Context, ticker string, from, to time.Time, interval time.Duration) ([]mongo.Candle, error) {
+	return nil, errors.New("aggregate failed")
+}
+
+func doCandlesRequest(candles mongo.CandleAggregator, url string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/stock/{vin}/candles", NewStockHandlers(nil, nil, candles, nil, nil).StockCandlesHandler)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", url, nil))
+	return rw
+}
+
+func TestStockCandlesHandlerHappyPath(t *testing.T) {
+	agg := &mongo.MemoryCandleAggregator{}
+	agg.Add(historyTicks(12, 30*time.Second)...)
+	rw := doCandlesRequest(agg, "/stock/VIN1/candles?from=2025-08-24T10:00:00Z&to=2025-08-24T11:00:00Z&interval=5m")
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var body StockCandlesResponse
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+	assert.Equal(t, "5m", body.Interval)
+	assert.Len(t, body.Candles, 2)
+	assert.Equal(t, 10, body.Candles[0].Count)
+	assert.Equal(t, 100.0, body.Candles[0].Bid.Open)
+	assert.Equal(t, 109.0, body.Candles[0].Bid.Close)
+	assert.Equal(t, 112.0, body.Candles[1].Ask.High)
+}
+
+func TestStockCandlesHandlerEmptyRange(t *testing.T) {
+	rw := doCandlesRequest(&mongo.MemoryCandleAggregator{}, "/stock/VIN1/candles")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+	assert.Equal(t, "1h", body["interval"])
+	assert.Equal(t, []interface{}{}, body["candles"])
+}
+
+func TestStockCandlesHandlerBadParams(t *testing.T) {
+	for _, url := range []string{
+		"/stock/VIN1/candles?interval=2m",
+		"/stock/VIN1/candles?from=bad",
+		"/stock/VIN1/candles?from=2020-01-01T00:00:00Z&to=2025-01-01T00:00:00Z&interval=1m",
+	} {
+		rw := doCandlesRequest(&mongo.MemoryCandleAggregator{}, url)
+		assert.Equal(t, http.StatusBadRequest, rw.Code, url)
+	}
+}
+
+func TestStockCandlesHandlerAggregatorError(t *testing.T) {
+	rw := doCandlesRequest(failingCandles{}, "/stock/VIN1/candles")
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestStockCandlesHandlerNoAggregator(t *testing.T) {
+	rw := doCandlesRequest(nil, "/stock/VIN1/candles")
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestStockCandlesHandlerMissingVIN(t *testing.T) {
+	rw := httptest.NewRecorder()
+	NewStockHandlers(nil, nil, &mongo.MemoryCandleAggregator{}, nil, nil).StockCandlesHandler(rw, httptest.NewRequest("GET", "/stock//candles", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+// swap replaces *target with v until the test ends
+func swap[T any](t *testing.T, target *T, v T) {
+	orig := *target
+	*target = v
+	t.Cleanup(func() { *target = orig })
+}
+
+// fakeCollection is an in-memory MongoDB collection keyed by _id that the
+// mongo function fakes below are built on. unique, when set, reports two
+// documents that a unique index would refuse to store side by side.
+type fakeCollection[T any] struct {
+	t      *testing.T
+	name   string
+	unique func(a, b T) bool
+
+	mu   sync.Mutex
+	docs map[string]T
+	ids  []string
+}
+
+func newFakeCollection[T any](t *testing.T, name string) *fakeCollection[T] {
+	return &fakeCollection[T]{t: t, name: name, docs: map[string]T{}}
+}
+
+// in asserts that a mongo function was called for this collection
+func (c *fakeCollection[T]) in(collection string) {
+	assert.Equal(c.t, c.name, collection)
+}
+
+func (c *fakeCollection[T]) store(id string, doc T) {
+	if _, ok := c.docs[id]; !ok {
+		c.ids = append(c.ids, id)
+	}
+	c.docs[id] = doc
+}
+
+// get returns the document with id, or the zero value
+func (c *fakeCollection[T]) get(id string) T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.docs[id]
+}
+
+func (c *fakeCollection[T]) has(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.docs[id]
+	return ok
+}
+
+// put stores doc without any index checks, for seeding tests
+func (c *fakeCollection[T]) put(id string, doc T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(id, doc)
+}
+
+// insert stores doc unless id or a unique index is taken, in which case it
+// returns the conflicting document
+func (c *fakeCollection[T]) insert(id string, doc T) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.docs[id]; ok {
+		return existing, false
+	}
+	if c.unique != nil {
+		for _, existing := range c.docs {
+			if c.unique(existing, doc) {
+				return existing, false
+			}
+		}
+	}
+	c.store(id, doc)
+	return doc, true
+}
+
+// update applies fn to the document with id and keeps the result when fn
+// returns true. It reports whether a document was modified.
+func (c *fakeCollection[T]) update(id string, fn func(doc *T) bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc, ok := c.docs[id]
+	if !ok || !fn(&doc) {
+		return false
+	}
+	c.store(id, doc)
+	return true
+}
+
+// upsert applies fn to the document with id, or to a zero value when there
+// is none, and stores the result
+func (c *fakeCollection[T]) upsert(id string, fn func(doc *T, found bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc, ok := c.docs[id]
+	fn(&doc, ok)
+	c.store(id, doc)
+}
+
+func (c *fakeCollection[T]) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.docs[id]; !ok {
+		return
+	}
+	delete(c.docs, id)
+	for i, existing := range c.ids {
+		if existing == id {
+			c.ids = append(c.ids[:i], c.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// find returns the documents matching match in insertion order
+func (c *fakeCollection[T]) find(match func(T) bool) []T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []T
+	for _, id := range c.ids {
+		if doc := c.docs[id]; match == nil || match(doc) {
+			out = append(out, doc)
+		}
+	}
+	return out
+}
+
+func (c *fakeCollection[T]) all() []T {
+	return c.find(nil)
+}
+
+// fakeIdempotencyStore keeps idempotency records in memory
+type fakeIdempotencyStore struct {
+	*fakeCollection[models.IdempotencyRecord]
+	claimErr error
+}
+
+func useFakeIdempotency(t *testing.T) *fakeIdempotencyStore {
+	f := &fakeIdempotencyStore{fakeCollection: newFakeCollection[models.IdempotencyRecord](t, "idempotency_keys")}
+	swap(t, &mongo.ClaimIdempotencyKey, func(database, collection string, rec models.IdempotencyRecord, staleBefore time.Time) (*models.IdempotencyRecord, error) {
+		f.in(collection)
+		if f.claimErr != nil {
+			return nil, f.claimErr
+		}
+		rec.Status = models.IdempotencyInProgress
+		if existing, ok := f.insert(rec.Key, rec); !ok {
+			return &existing, nil
+		}
+		return nil, nil
+	})
+	swap(t, &mongo.CompleteIdempotencyKey, func(database, collection, key string, code int, body []byte) error {
+		f.in(collection)
+		f.upsert(key, func(rec *models.IdempotencyRecord, _ bool) {
+			rec.Status = models.IdempotencyCompleted
+			rec.ResponseCode = code
+			rec.ResponseBody = string(body)
+		})
+		return nil
+	})
+	swap(t, &mongo.ReleaseIdempotencyKey, func(database, collection, key string) error {
+		f.in(collection)
+		f.remove(key)
+		return nil
+	})
+	return f
+}
+
+// fakeLedger keeps ledger entries in memory, ignoring repeated IDs like the unique _id
+type fakeLedger struct {
+	*fakeCollection[models.LedgerEntry]
+	query   mongo.LedgerQuery
+	findErr error
+}
+
+func useFakeLedger(t *testing.T) *fakeLedger {
+	f := &fakeLedger{fakeCollection: newFakeCollection[models.LedgerEntry](t, "payment_ledger")}
+	swap(t, &mongo.AppendLedgerEntry, func(database, collection string, e models.LedgerEntry) error {
+		f.in(collection)
+		f.insert(e.ID, e)
+		return nil
+	})
+	swap(t, &mongo.FindLedgerEntry, func(database, collection, id string) (*models.LedgerEntry, error) {
+		f.in(collection)
+		if !f.has(id) {
+			return nil, mongo.ErrLedgerEntryNotFound
+		}
+		e := f.get(id)
+		return &e, nil
+	})
+	swap(t, &mongo.FindLedgerEntries, func(database, collection string, q mongo.LedgerQuery) ([]models.LedgerEntry, error) {
+		f.in(collection)
+		f.query = q
+		if f.findErr != nil {
+			return nil, f.findErr
+		}
+		entries := f.find(func(e models.LedgerEntry) bool {
+			return q.After == nil || e.CreatedAt.After(q.After.CreatedAt) || e.CreatedAt.Equal(q.After.CreatedAt) && e.ID > q.After.ID
+		})
+		slices.SortStableFunc(entries, func(a, b models.LedgerEntry) int {
+			return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+		})
+		if q.Limit > 0 && int64(len(entries)) > q.Limit {
+			entries = entries[:q.Limit]
+		}
+		return entries, nil
+	})
+	return f
+}
+
+// fakePayments keeps payment records in memory
+type fakePayments struct {
+	*fakeCollection[models.Payment]
+	recordErr error
+}
+
+func useFakePayments(t *testing.T) *fakePayments {
+	f := &fakePayments{fakeCollection: newFakeCollection[models.Payment](t, "payments")}
+	swap(t, &mongo.RecordPayment, func(database, collection string, p models.Payment) error {
+		f.in(collection)
+		if f.recordErr != nil {
+			return f.recordErr
+		}
+		f.upsert(p.ID, func(stored *models.Payment, found bool) {
+			if !found {
+				*stored = models.Payment{ID: p.ID, VIN: p.VIN, Region: p.Region, Amount: p.Amount, Currency: p.Currency, Refunds: []models.Refund{}}
+			}
+			stored.Status, stored.AmountReceived = p.Status, p.AmountReceived
+		})
+		return nil
+	})
+	swap(t, &mongo.RecordRefund, func(database, collection string, p models.Payment, r models.Refund) error {
+		f.in(collection)
+		if f.recordErr != nil {
+			return f.recordErr
+		}
+		f.upsert(p.ID, func(stored *models.Payment, found bool) {
+			if !found {
+				*stored = models.Payment{ID: p.ID, VIN: p.VIN, Region: p.Region, Amount: p.Amount, Currency: p.Currency}
+			}
+			for _, existing := range stored.Refunds {
+				if existing.ID == r.ID {
+					return
+				}
+			}
+			stored.Status, stored.AmountReceived, stored.AmountRefunded = p.Status, p.AmountReceived, p.AmountRefunded
+			stored.Refunds = append(stored.Refunds, r)
+		})
+		return nil
+	})
+	swap(t, &mongo.FindPayment, func(database, collection, id string) (*models.Payment, error) {
+		f.in(collection)
+		if !f.has(id) {
+			return nil, mongo.ErrPaymentNotFound
+		}
+		p := f.get(id)
+		return &p, nil
+	})
+	return f
+}
+
+// fakeReservations is an in-memory stand-in for the reservation collection that
+// enforces one active reservation per VIN like the unique partial index
+type fakeReservations struct {
+	*fakeCollection[models.Reservation]
+	activateErr error
+}
+
+func useFakeReservations(t *testing.T) *fakeReservations {
+	f := &fakeReservations{fakeCollection: newFakeCollection[models.Reservation](t, "reservations")}
+	f.unique = func(a, b models.Reservation) bool { return a.Active && b.Active && a.VIN == b.VIN }
+
+	var seq int
+	var seqMu sync.Mutex
+	swap(t, &newReservationID, func() string {
+		seqMu.Lock()
+		defer seqMu.Unlock()
+		seq++
+		return fmt.Sprintf("res_%d", seq)
+	})
+	swap(t, &mongo.InsertReservation, func(database, collection string, r models.Reservation) error {
+		f.in(collection)
+		r.Active = true
+		if _, ok := f.insert(r.ID, r); !ok {
+			return mongo.ErrVINReserved
+		}
+		return nil
+	})
+	swap(t, &mongo.AttachReservationIntent, func(database, collection, id, paymentIntentID string) error {
+		f.in(collection)
+		f.update(id, func(r *models.Reservation) bool {
+			r.PaymentIntentID = paymentIntentID
+			return true
+		})
+		return nil
+	})
+	swap(t, &mongo.ActivateReservation, func(database, collection, id, paymentIntentID string) error {
+		f.in(collection)
+		if f.activateErr != nil {
+			return f.activateErr
+		}
+		f.update(id, func(r *models.Reservation) bool {
+			r.Status = models.ReservationActive
+			r.PaymentIntentID = paymentIntentID
+			return true
+		})
+		return nil
+	})
+	swap(t, &mongo.ReleaseReservation, func(database, collection, id, status string) (bool, error) {
+		f.in(collection)
+		return f.update(id, func(r *models.Reservation) bool {
+			if !r.Active {
+				return false
+			}
+			r.Active = false
+			r.Status = status
+			return true
+		}), nil
+	})
+	swap(t, &mongo.FindReservation, func(database, collection, id string) (*models.Reservation, error) {
+		f.in(collection)
+		if !f.has(id) {
+			return nil, mongo.ErrReservationNotFound
+		}
+		r := f.get(id)
+		return &r, nil
+	})
+	swap(t, &mongo.FindExpiredReservations, func(database, collection string, now time.Time, limit int64) ([]models.Reservation, error) {
+		f.in(collection)
+		return f.find(func(r models.Reservation) bool { return r.Active && !r.ExpiresAt.After(now) }), nil
+	})
+	return f
+}
+
+// recordingPublisher captures published events; with err set deliveries fail
+type recordingPublisher struct {
+	keys   []string
+	events []models.PaymentEvent
+	err    error
+}
+
+func (p *recordingPublisher) Deliver(ctx context.Context, key string, value []byte) error {
+	if p.err != nil {
+		return p.err
+	}
+	var pe models.PaymentEvent
+	json.Unmarshal(value, &pe)
+	p.keys = append(p.keys, key)
+	p.events = append(p.events, pe)
+	return nil
+}
+
+// storedEvent is a Stripe event document as the fake event store keeps it
+type storedEvent struct {
+	event     models.PaymentEvent
+	published bool
+}
+
+// fakeEventStore replaces the Stripe event functions with an in-memory collection
+type fakeEventStore struct {
+	*fakeCollection[storedEvent]
+	err error
+}
+
+func (f *fakeEventStore) event(id string) models.PaymentEvent { return f.get(id).event }
+
+func (f *fakeEventStore) published(id string) bool { return f.get(id).published }
+
+func useWebhookFakes(t *testing.T) (*PaymentHandlers, *fakeEventStore, *recordingPublisher) {
+	store := &fakeEventStore{fakeCollection: newFakeCollection[storedEvent](t, "stripe_events")}
+	pub := &recordingPublisher{}
+
+	swap(t, &config.AppConfig, config.AppConfig)
+	config.AppConfig.StripeWebhookSecret = testWebhookSecret
+	config.AppConfig.MongoDB = "test_db"
+	swap(t, &mongo.InsertStripeEvent, func(database, collection string, event models.PaymentEvent, raw []byte) (bool, error) {
+		assert.Equal(t, "test_db", database)
+		store.in(collection)
+		assert.NotEmpty(t, raw)
+		if store.err != nil {
+			return false, store.err
+		}
+		existing, ok := store.insert(event.EventID, storedEvent{event: event})
+		return !ok && existing.published, nil
+	})
+	swap(t, &mongo.MarkStripeEventPublished, func(database, collection, eventID string) error {
+		store.in(collection)
+		store.update(eventID, func(e *storedEvent) bool {
+			e.published = true
+			return true
+		})
+		return nil
+	})
+	return NewPaymentHandlers(nil, nil, nil, pub), store, pub
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+	defaultHistoryRange = 24 * time.Hour
+)
+
+// StockHistoryResponse is the body returned by /stock/{vin}/history
+type StockHistoryResponse struct {
+	VIN        string             `json:"vin"`
+	Ticker     string             `json:"ticker"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Interval   string             `json:"interval,omitempty"`
+	Count      int                `json:"count"`
+	Ticks      []models.StockData `json:"ticks"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// StockHistoryHandler handles GET /stock/{vin}/history?from=&to=&interval=&limit=&cursor=
+//
+// from/to are RFC3339 timestamps (default: the last 24 hours). interval, when set,
+// is a Go duration that down-samples the series to at most one tick per interval.
+// Pages are returned oldest first; pass nextCursor back as cursor for the next page.
+func (h *StockHandlers) StockHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validation.Errors
+	vin := mux.Vars(r)["vin"]
+	validation.Required(&errs, "vin", vin)
+
+	q := r.URL.Query()
+	from, to := parseTimeRange(&errs, q.Get("from"), q.Get("to"))
+
+	var interval time.Duration
+	if v := q.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs.Add("interval", validation.CodeInvalid, "interval must be a positive duration such as 30s or 5m")
+		}
+		interval = d
+	}
+
+	limit := defaultHistoryLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs.Add("limit", validation.CodeInvalid, "limit must be an integer")
+		} else {
+			validation.Range(&errs, "limit", int64(n), 1, maxHistoryLimit)
+		}
+		limit = n
+	}
+
+	var last time.Time
+	if v := q.Get("cursor"); v != "" {
+		var err error
+		if last, err = decodeHistoryCursor(v); err != nil {
+			errs.Add("cursor", validation.CodeInvalid, "invalid cursor")
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	// The cursor is the time of the last tick returned; resume just after it
+	// (or one interval after it when down-sampling).
+	queryFrom := from
+	if !last.IsZero() {
+		next := last.Add(time.Millisecond)
+		if interval > 0 {
+			next = last.Add(interval)
+		}
+		if next.After(queryFrom) {
+			queryFrom = next
+		}
+	}
+
+	ticker := "VEHICLE-" + vin
+	if h.stocks == nil {
+		writeJSONError(w, http.StatusInternalServerError, "stock repository not configured")
+		return
+	}
+	raw, err := h.stocks.Range(r.Context(), mongo.StockRangeQuery{
+		Ticker: ticker,
+		From:   queryFrom,
+		To:     to,
+		Limit:  int64(limit + 1),
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to load stock history")
+		return
+	}
+
+	hasMore := len(raw) > limit
+	if hasMore {
+		raw = raw[:limit]
+	}
+	ticks := sampleTicks(raw, interval)
+
+	resp := StockHistoryResponse{
+		VIN:    vin,
+		Ticker: ticker,
+		From:   from,
+		To:     to,
+		Count:  len(ticks),
+		Ticks:  ticks,
+	}
+	if interval > 0 {
+		resp.Interval = interval.String()
+	}
+	if hasMore && len(ticks) > 0 {
+		resp.NextCursor = encodeHistoryCursor(ticks[len(ticks)-1].Time)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseTimeRange parses optional RFC3339 from/to query values, defaulting to
+// the last 24 hours
+func parseTimeRange(errs *validation.Errors, fromParam, toParam string) (time.Time, time.Time) {
+	to := time.Now().UTC()
+	if toParam != "" {
+		t, err := time.Parse(time.RFC3339, toParam)
+		if err != nil {
+			errs.Add("to", validation.CodeInvalid, "to must be an RFC3339 timestamp")
+			return time.Time{}, time.Time{}
+		}
+		to = t
+	}
+	from := to.Add(-defaultHistoryRange)
+	if fromParam != "" {
+		t, err := time.Parse(time.RFC3339, fromParam)
+		if err != nil {
+			errs.Add("from", validation.CodeInvalid, "from must be an RFC3339 timestamp")
+			return time.Time{}, time.Time{}
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		errs.Add("from", validation.CodeInvalid, "from must be before to")
+	}
+	return from, to
+}
+
+// sampleTicks keeps the first tick and then every tick at least interval after the last kept one
+func sampleTicks(ticks []models.StockData, interval time.Duration) []models.StockData {
+	out := make([]models.StockData, 0, len(ticks))
+	if interval <= 0 {
+		return append(out, ticks...)
+	}
+	var last time.Time
+	for i, t := range ticks {
+		if i == 0 || !t.Time.Before(last.Add(interval)) {
+			out = append(out, t)
+			last = t.Time
+		}
+	}
+	return out
+}
+
+func encodeHistoryCursor(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixMilli(), 10)))
+}
+
+func decodeHistoryCursor(cursor string) (time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || ms < 0 {
+		return time.Time{}, errors.New("malformed cursor")
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
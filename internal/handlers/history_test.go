@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+var historyBase = time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC)
+
+// failingStocks is a StockRepository whose range queries fail
+type failingStocks struct {
+	mongo.StockRepository
+}
+
+func (failingStocks) Range(ctx context.Context, q mongo.StockRangeQuery) ([]models.StockData, error) {
+	return nil, errors.New("boom")
+}
+
+func historyTicks(n int, step time.Duration) []models.StockData {
+	ticks := make([]models.StockData, 0, n)
+	for i := 0; i < n; i++ {
+		ticks = append(ticks, models.StockData{
+			Ticker: "VEHICLE-VIN1",
+			Bid:    100 + float64(i),
+			Ask:    101 + float64(i),
+			Time:   historyBase.Add(time.Duration(i) * step),
+		})
+	}
+	return ticks
+}
+
+func doHistoryRequest(t *testing.T, h *StockHandlers, url string) (*httptest.ResponseRecorder, StockHistoryResponse) {
+	r := mux.NewRouter()
+	r.HandleFunc("/stock/{vin}/history", h.StockHistoryHandler)
+	req := httptest.NewRequest("GET", url, nil)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	var body StockHistoryResponse
+	if rw.Code == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+	}
+	return rw, body
+}
+
+func TestStockHistoryHandlerReturnsSortedRange(t *testing.T) {
+	h := NewStockHandlers(mongo.NewMemoryStockRepository(historyTicks(10, 30*time.Second)...), nil, nil, nil, nil)
+
+	rw, body := doHistoryRequest(t, h, "/stock/VIN1/history?from=2025-08-24T10:01:00Z&to=2025-08-24T10:03:00Z")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "VEHICLE-VIN1", body.Ticker)
+	assert.Equal(t, 4, body.Count)
+	assert.Equal(t, historyBase.Add(time.Minute), body.Ticks[0].Time)
+	assert.Equal(t, historyBase.Add(150*time.Second), body.Ticks[3].Time)
+	assert.Empty(t, body.NextCursor)
+}
+
+func TestStockHistoryHandlerPagination(t *testing.T) {
+	h := NewStockHandlers(mongo.NewMemoryStockRepository(historyTicks(5, 30*time.Second)...), nil, nil, nil, nil)
+
+	base := "/stock/VIN1/history?from=2025-08-24T10:00:00Z&to=2025-08-24T11:00:00Z&limit=2"
+	var seen []time.Time
+	url := base
+	for page := 0; page < 5; page++ {
+		rw, body := doHistoryRequest(t, h, url)
+		assert.Equal(t, http.StatusOK, rw.Code)
+		for _, tick := range body.Ticks {
+			seen = append(seen, tick.Time)
+		}
+		if body.NextCursor == "" {
+			break
+		}
+		url = base + "&cursor=" + body.NextCursor
+	}
+	assert.Len(t, seen, 5)
+	for i := 1; i < len(seen); i++ {
+		assert.True(t, seen[i].After(seen[i-1]))
+	}
+}
+
+func TestStockHistoryHandlerInterval(t *testing.T) {
+	h := NewStockHandlers(mongo.NewMemoryStockRepository(historyTicks(20, 30*time.Second)...), nil, nil, nil, nil)
+
+	rw, body := doHistoryRequest(t, h, "/stock/VIN1/history?from=2025-08-24T10:00:00Z&to=2025-08-24T11:00:00Z&interval=2m")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "2m0s", body.Interval)
+	assert.Equal(t, 5, body.Count)
+	for i := 1; i < len(body.Ticks); i++ {
+		assert.Equal(t, 2*time.Minute, body.Ticks[i].Time.Sub(body.Ticks[i-1].Time))
+	}
+
+	// Paging with an interval keeps the spacing across page boundaries
+	base := "/stock/VIN1/history?from=2025-08-24T10:00:00Z&to=2025-08-24T11:00:00Z&interval=2m&limit=3"
+	var seen []time.Time
+	url := base
+	for page := 0; page < 20; page++ {
+		_, body := doHistoryRequest(t, h, url)
+		for _, tick := range body.Ticks {
+			seen = append(seen, tick.Time)
+		}
+		if body.NextCursor == "" {
+			break
+		}
+		url = base + "&cursor=" + body.NextCursor
+	}
+	assert.Len(t, seen, 5)
+	for i := 1; i < len(seen); i++ {
+		assert.Equal(t, 2*time.Minute, seen[i].Sub(seen[i-1]))
+	}
+}
+
+func TestStockHistoryHandlerBadParams(t *testing.T) {
+	h := NewStockHandlers(&mongo.MemoryStockRepository{}, nil, nil, nil, nil)
+
+	for _, url := range []string{
+		"/stock/VIN1/history?from=yesterday",
+		"/stock/VIN1/history?to=2025-08-24",
+		"/stock/VIN1/history?from=2025-08-24T11:00:00Z&to=2025-08-24T10:00:00Z",
+		"/stock/VIN1/history?interval=-5m",
+		"/stock/VIN1/history?interval=often",
+		"/stock/VIN1/history?limit=0",
+		"/stock/VIN1/history?limit=5000",
+		"/stock/VIN1/history?cursor=***",
+		"/stock/VIN1/history?cursor=" + "bm90LWEtbnVtYmVy",
+	} {
+		rw, _ := doHistoryRequest(t, h, url)
+		assert.Equal(t, http.StatusBadRequest, rw.Code, url)
+		var body map[string]string
+		json.NewDecoder(rw.Body).Decode(&body)
+		assert.NotEmpty(t, body["error"], url)
+	}
+}
+
+func TestStockHistoryHandlerReportsEveryBadParam(t *testing.T) {
+	rw, _ := doHistoryRequest(t, NewStockHandlers(nil, nil, nil, nil, nil), "/stock/VIN1/history?from=yesterday&limit=0&interval=often")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+	assert.Equal(t, []interface{}{"from", "interval", "limit"}, fieldNames(body))
+}
+
+func TestStockHistoryHandlerMissingVIN(t *testing.T) {
+	req := httptest.NewRequest("GET", "/stock//history", nil)
+	rw := httptest.NewRecorder()
+	NewStockHandlers(nil, nil, nil, nil, nil).StockHistoryHandler(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestStockHistoryHandlerStoreError(t *testing.T) {
+	h := NewStockHandlers(failingStocks{}, nil, nil, nil, nil)
+
+	rw, _ := doHistoryRequest(t, h, "/stock/VIN1/history")
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestHistoryCursorRoundTrip(t *testing.T) {
+	at := time.Date(2025, 8, 24, 10, 0, 0, 123000000, time.UTC)
+	got, err := decodeHistoryCursor(encodeHistoryCursor(at))
+	assert.NoError(t, err)
+	assert.Equal(t, at, got)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+const (
+	idempotencyHeader         = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+
+	// idempotencyLockTimeout is how long an in-progress request owns its key
+	// before a retry may take over (Stripe dedupes the retried call)
+	idempotencyLockTimeout = time.Minute
+)
+
+// withIdempotency runs do at most once per key within scope. The first request
+// records its response; retries with the same body get that response replayed,
+// retries with a different body get 422 and concurrent retries get 409.
+// Server errors are not recorded so the request can be retried. do is passed
+// the scoped key to forward to Stripe.
+func withIdempotency(w http.ResponseWriter, scope, key string, req interface{}, do func(scopedKey string) (int, interface{})) {
+	if len(key) > maxIdempotencyKeyLength {
+		writeJSONError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		return
+	}
+
+	db, coll := config.AppConfig.MongoDB, config.AppConfig.IdempotencyCollection()
+	storeKey := scopedIdempotencyKey(scope, key)
+	hash := requestHash(req)
+	now := time.Now().UTC()
+	existing, err := mongo.ClaimIdempotencyKey(db, coll, models.IdempotencyRecord{
+		Key:         storeKey,
+		RequestHash: hash,
+		LockedAt:    now,
+		ExpiresAt:   now.Add(config.AppConfig.IdempotencyTTL()),
+	}, now.Add(-idempotencyLockTimeout))
+	if err != nil {
+		log.Println("Claiming idempotency key failed:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to record Idempotency-Key")
+		return
+	}
+
+	if existing != nil {
+		switch {
+		case existing.RequestHash != hash:
+			writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body")
+		case existing.Status == models.IdempotencyInProgress:
+			writeJSONError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		default:
+			w.Header().Set(idempotencyReplayedHeader, "true")
+			writeRawJSON(w, existing.ResponseCode, []byte(existing.ResponseBody))
+		}
+		return
+	}
+
+	status, v := do(storeKey)
+	body, _ := json.Marshal(v)
+	body = append(body, '\n')
+	if status >= http.StatusInternalServerError {
+		err = mongo.ReleaseIdempotencyKey(db, coll, storeKey)
+	} else {
+		err = mongo.CompleteIdempotencyKey(db, coll, storeKey, status, body)
+	}
+	if err != nil {
+		log.Printf("Recording idempotency key %s failed: %v", storeKey, err)
+	}
+	writeRawJSON(w, status, body)
+}
+
+// scopedIdempotencyKey is the key a client's Idempotency-Key is recorded under
+// and sent to Stripe as, so a key reused on another endpoint or payment makes
+// an independent Stripe call. Keys longer than Stripe accepts are hashed.
+func scopedIdempotencyKey(scope, key string) string {
+	scoped := scope + ":" + key
+	if len(scoped) <= maxIdempotencyKeyLength {
+		return scoped
+	}
+	sum := sha256.Sum256([]byte(scoped))
+	return scope + ":" + hex.EncodeToString(sum[:])
+}
+
+// requestHash fingerprints the decoded request so formatting differences do not matter
+func requestHash(req interface{}) string {
+	b, _ := json.Marshal(req)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeRawJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+// countingPaymentIntentNew returns a fresh PaymentIntent per call and records idempotency keys
+func countingPaymentIntentNew() (*PaymentHandlers, *[]string) {
+	var keys []string
+	h, _ := stripeNewHandlers(func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+		keys = append(keys, *params.IdempotencyKey)
+		return &stripe.PaymentIntent{ID: fmt.Sprintf("pi_%d", len(keys)), Status: "requires_capture", Amount: *params.Amount, Currency: stripe.Currency(*params.Currency)}, nil
+	})
+	return h, &keys
+}
+
+func postHold(h *PaymentHandlers, body, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/holdpayment", bytes.NewReader([]byte(body)))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rw := httptest.NewRecorder()
+	h.HoldPaymentHandler(rw, req)
+	return rw
+}
+
+const holdBody = `{"amount": 1000, "currency": "usd", "payment_method": "pm_test_123"}`
+
+func TestHoldPaymentIdempotentReplay(t *testing.T) {
+	useFakeIdempotency(t)
+	h, keys := countingPaymentIntentNew()
+
+	first := postHold(h, holdBody, "key-1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	// Same body with different formatting is still the same request
+	retry := postHold(h, `{"payment_method":"pm_test_123","currency":"usd","amount":1000}`, "key-1")
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	var resp map[string]interface{}
+	json.Unmarshal(retry.Body.Bytes(), &resp)
+	assert.Equal(t, "pi_1", resp["payment_intent_id"])
+	assert.Equal(t, []string{"holdpayment:key-1"}, *keys)
+}
+
+func TestHoldPaymentIdempotencyConflictingBody(t *testing.T) {
+	useFakeIdempotency(t)
+	h, keys := countingPaymentIntentNew()
+
+	postHold(h, holdBody, "key-1")
+	rw := postHold(h, `{"amount": 2000, "currency": "usd", "payment_method": "pm_test_123"}`, "key-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, rw.Code)
+	assert.Len(t, *keys, 1)
+}
+
+func TestHoldPaymentIdempotencyInProgress(t *testing.T) {
+	store := useFakeIdempotency(t)
+	h, _ := countingPaymentIntentNew()
+	store.put("holdpayment:key-1", models.IdempotencyRecord{
+		Key: "holdpayment:key-1", RequestHash: requestHash(HoldPaymentRequest{Amount: 1000, Currency: "usd", PaymentMethod: "pm_test_123"}),
+		Status: models.IdempotencyInProgress,
+	})
+
+	rw := postHold(h, holdBody, "key-1")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+}
+
+func TestHoldPaymentIdempotencyRecordsStripeErrors(t *testing.T) {
+	store := useFakeIdempotency(t)
+	calls := 0
+	h, _ := stripeNewHandlers(func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+		calls++
+		return nil, &stripe.Error{Type: stripe.ErrorTypeCard, Msg: "Your card was declined."}
+	})
+
+	assert.Equal(t, http.StatusPaymentRequired, postHold(h, holdBody, "key-1").Code)
+	rw := postHold(h, holdBody, "key-1")
+	assert.Equal(t, http.StatusPaymentRequired, rw.Code)
+	assert.Equal(t, "true", rw.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, models.IdempotencyCompleted, store.get("holdpayment:key-1").Status)
+}
+
+func TestHoldPaymentIdempotencyRetriesTransientStripeErrors(t *testing.T) {
+	store := useFakeIdempotency(t)
+	calls := 0
+	h, _ := stripeNewHandlers(func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+		calls++
+		switch calls {
+		case 1:
+			return nil, &stripe.Error{Type: stripe.ErrorTypeAPI, HTTPStatusCode: http.StatusServiceUnavailable, Msg: "Stripe is unavailable"}
+		case 2:
+			return nil, &stripe.Error{Code: stripe.ErrorCodeRateLimit, Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: http.StatusTooManyRequests}
+		}
+		return mockPaymentIntentNew(params)
+	})
+
+	assert.Equal(t, http.StatusBadGateway, postHold(h, holdBody, "key-1").Code)
+	assert.Empty(t, store.all())
+	assert.Equal(t, http.StatusServiceUnavailable, postHold(h, holdBody, "key-1").Code)
+	assert.Empty(t, store.all())
+
+	rw := postHold(h, holdBody, "key-1")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Empty(t, rw.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, models.IdempotencyCompleted, store.get("holdpayment:key-1").Status)
+}
+
+func TestHoldPaymentWithoutKeyIsNotRecorded(t *testing.T) {
+	store := useFakeIdempotency(t)
+	h, _ := stripeNewHandlers(func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+		assert.Nil(t, params.IdempotencyKey)
+		return mockPaymentIntentNew(params)
+	})
+
+	assert.Equal(t, http.StatusOK, postHold(h, holdBody, "").Code)
+	assert.Empty(t, store.all())
+}
+
+func TestHoldPaymentIdempotencyKeyErrors(t *testing.T) {
+	store := useFakeIdempotency(t)
+	h, _ := countingPaymentIntentNew()
+
+	rw := postHold(h, holdBody, strings.Repeat("k", 256))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	store.claimErr = errors.New("mongo down")
+	rw = postHold(h, holdBody, "key-1")
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestWithIdempotencyReleasesServerErrors(t *testing.T) {
+	store := useFakeIdempotency(t)
+	calls := 0
+	do := func(scopedKey string) (int, interface{}) {
+		calls++
+		if calls == 1 {
+			return http.StatusBadGateway, map[string]string{"error": "upstream"}
+		}
+		return http.StatusOK, map[string]string{"ok": "yes"}
+	}
+
+	rw := httptest.NewRecorder()
+	withIdempotency(rw, "test", "key-1", "req", do)
+	assert.Equal(t, http.StatusBadGateway, rw.Code)
+	assert.Empty(t, store.all())
+
+	rw = httptest.NewRecorder()
+	withIdempotency(rw, "test", "key-1", "req", do)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, 2, calls)
+}
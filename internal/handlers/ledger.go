@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+)
+
+// actorHeader names the user or system making a payment request, for the ledger
+const actorHeader = "X-Actor"
+
+// Ledger actors for operations without a caller-supplied actor
+const (
+	actorAPI     = "api"
+	actorStripe  = "stripe"
+	actorSweeper = "reservation_sweeper"
+)
+
+// PaymentLedgerResponse is the body returned by /payments. When HasMore is
+// set, NextCursor fetches the following page.
+type PaymentLedgerResponse struct {
+	From       time.Time            `json:"from"`
+	To         time.Time            `json:"to"`
+	Count      int                  `json:"count"`
+	Entries    []models.LedgerEntry `json:"entries"`
+	HasMore    bool                 `json:"has_more"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// ListPaymentsHandler handles GET /payments?vin=&status=&type=&from=&to=&limit=&cursor=
+//
+// It returns payment ledger entries oldest first. from/to are RFC3339
+// timestamps (default: the last 24 hours); cursor is the next_cursor of the
+// previous page.
+func ListPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	var errs validation.Errors
+	q := r.URL.Query()
+	from, to := parseTimeRange(&errs, q.Get("from"), q.Get("to"))
+	var after *mongo.LedgerCursor
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeLedgerCursor(v)
+		if err != nil {
+			errs.Add("cursor", validation.CodeInvalid, "cursor must be a next_cursor returned by /payments")
+		}
+		after = c
+	}
+	if v := q.Get("type"); v != "" {
+		validation.OneOf(&errs, "type", v, models.LedgerTypes...)
+	}
+	limit := defaultHistoryLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs.Add("limit", validation.CodeInvalid, "limit must be an integer")
+		} else {
+			validation.Range(&errs, "limit", int64(n), 1, maxHistoryLimit)
+		}
+		limit = n
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	// One extra entry tells whether another page follows
+	entries, err := mongo.FindLedgerEntries(config.AppConfig.MongoDB, config.AppConfig.LedgerCollection(), mongo.LedgerQuery{
+		VIN:    q.Get("vin"),
+		Status: q.Get("status"),
+		Type:   q.Get("type"),
+		From:   from,
+		To:     to,
+		After:  after,
+		Limit:  int64(limit) + 1,
+	})
+	if err != nil {
+		log.Println("Loading payment ledger failed:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load payments")
+		return
+	}
+	resp := PaymentLedgerResponse{From: from, To: to, Entries: entries}
+	if len(entries) > limit {
+		resp.Entries = entries[:limit]
+		resp.HasMore = true
+		resp.NextCursor = encodeLedgerCursor(resp.Entries[limit-1])
+	}
+	if resp.Entries == nil {
+		resp.Entries = []models.LedgerEntry{}
+	}
+	resp.Count = len(resp.Entries)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// encodeLedgerCursor returns an opaque cursor for the entries sorted after e
+func encodeLedgerCursor(e models.LedgerEntry) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(e.CreatedAt.UTC().Format(time.RFC3339Nano) + " " + e.ID))
+}
+
+func decodeLedgerCursor(s string) (*mongo.LedgerCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	ts, id, ok := strings.Cut(string(b), " ")
+	if !ok || id == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.LedgerCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// requestActor returns the X-Actor header, or "api" when it is absent
+func requestActor(r *http.Request) string {
+	if actor := strings.TrimSpace(r.Header.Get(actorHeader)); actor != "" {
+		return actor
+	}
+	return actorAPI
+}
+
+// intentLedgerEntry describes an operation on pi; its ID makes it unique per PaymentIntent
+func intentLedgerEntry(entryType string, pi *stripe.PaymentIntent, amount int64, actor string) models.LedgerEntry {
+	return models.LedgerEntry{
+		ID:              entryType + ":" + pi.ID,
+		Type:            entryType,
+		PaymentIntentID: pi.ID,
+		Status:          string(pi.Status),
+		Amount:          amount,
+		Currency:        string(pi.Currency),
+		VIN:             pi.Metadata["vin"],
+		Region:          pi.Metadata["region"],
+		ReservationID:   pi.Metadata["reservation_id"],
+		Actor:           actor,
+		CreatedAt:       time.Now().UTC(),
+	}
+}
+
+// recordFailedHold records a hold Stripe refused after creating its
+// PaymentIntent, so every intent in Stripe has a ledger entry
+func recordFailedHold(err error, actor string) {
+	var serr *stripe.Error
+	if !errors.As(err, &serr) || serr.PaymentIntent == nil {
+		return
+	}
+	entry := intentLedgerEntry(models.LedgerHold, serr.PaymentIntent, serr.PaymentIntent.Amount, actor)
+	entry.FailureCode = string(serr.Code)
+	if serr.DeclineCode != "" {
+		entry.FailureCode = string(serr.DeclineCode)
+	}
+	recordLedger(entry)
+}
+
+// recordLedger appends e to the ledger. Stripe has already applied the
+// operation, so a failed write is logged rather than failing the request.
+func recordLedger(e models.LedgerEntry) {
+	if err := mongo.AppendLedgerEntry(config.AppConfig.MongoDB, config.AppConfig.LedgerCollection(), e); err != nil {
+		log.Printf("Recording ledger entry %s failed: %v", e.ID, err)
+	}
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/stripefake"
+)
+
+func doActorRequest(handler http.HandlerFunc, target, id, body, actor string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest("POST", target, bytes.NewReader([]byte(body)))
+	req.Header.Set(actorHeader, actor)
+	if id != "" {
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+	}
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw, resp
+}
+
+func ledgerSummary(entries []models.LedgerEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.Type + "/" + e.Status + "/" + e.Actor
+	}
+	return out
+}
+
+func TestLedgerRecordsPaymentOperations(t *testing.T) {
+	h, _ := stripeFakeHandlers(t, staticSubs(reservationPayload))
+	useFakePayments(t)
+	ledger := useFakeLedger(t)
+
+	_, resp := doActorRequest(h.HoldPaymentHandler, "/holdpayment", "", `{"vin": "VIN1", "amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`, "clerk@example.com")
+	id := resp["payment_intent_id"].(string)
+	doActorRequest(h.CaptureHoldHandler, "/holdpayment/"+id+"/capture", id, `{"amount_to_capture": 4000}`, "clerk@example.com")
+	_, refund := doActorRequest(h.RefundPaymentHandler, "/payments/"+id+"/refunds", id, `{"amount": 1500}`, "finance@example.com")
+
+	_, resp = doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 700, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	other := resp["payment_intent_id"].(string)
+	doPaymentRequest(h.CancelHoldHandler, "POST", "/holdpayment/"+other+"/cancel", other, "")
+
+	entries := ledger.all()
+	assert.Equal(t, []string{
+		"hold/requires_capture/clerk@example.com",
+		"capture/succeeded/clerk@example.com",
+		"refund/succeeded/finance@example.com",
+		"hold/requires_capture/api",
+		"cancel/canceled/api",
+	}, ledgerSummary(entries))
+	assert.Equal(t, []int64{5000, 4000, 1500, 700, 700}, []int64{entries[0].Amount, entries[1].Amount, entries[2].Amount, entries[3].Amount, entries[4].Amount})
+	for _, e := range entries[:3] {
+		assert.Equal(t, id, e.PaymentIntentID)
+		assert.Equal(t, "VIN1", e.VIN)
+		assert.Equal(t, "US", e.Region)
+		assert.Equal(t, "usd", e.Currency)
+	}
+	assert.Equal(t, refund["refund_id"], entries[2].RefundID)
+	assert.Equal(t, "refund:"+refund["refund_id"].(string), entries[2].ID)
+	assert.Empty(t, entries[3].VIN)
+}
+
+func TestLedgerRecordsDeclinedHolds(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, staticSubs(reservationPayload))
+	useFakeReservations(t)
+	ledger := useFakeLedger(t)
+
+	rw, _ := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"vin": "VIN1", "amount": 5000, "currency": "usd", "payment_method": "`+stripefake.PaymentMethodDeclined+`"}`)
+	assert.Equal(t, http.StatusPaymentRequired, rw.Code)
+	rw, _ = postReservation(h, `{"vin": "VIN2", "amount": 50000, "currency": "cad", "payment_method": "`+stripefake.PaymentMethodDeclined+`"}`)
+	assert.Equal(t, http.StatusPaymentRequired, rw.Code)
+
+	// A hold rejected before Stripe created an intent has nothing to record
+	srv.FailNext(http.StatusInternalServerError, "api_error", "", "Stripe is down")
+	doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+
+	// The failed reservation also cancels its declined intent
+	entries := ledger.all()
+	assert.Equal(t, []string{
+		"hold/requires_payment_method/api",
+		"hold/requires_payment_method/api",
+		"cancel/canceled/api",
+	}, ledgerSummary(entries))
+	for _, e := range entries[:2] {
+		_, ok := srv.PaymentIntent(e.PaymentIntentID)
+		assert.True(t, ok)
+		assert.Equal(t, "generic_decline", e.FailureCode)
+	}
+	pi, _ := srv.PaymentIntent(entries[0].PaymentIntentID)
+	assert.Equal(t, "requires_payment_method", pi.Status)
+	assert.Equal(t, entries[1].PaymentIntentID, entries[2].PaymentIntentID)
+	assert.Equal(t, []string{"VIN1", "VIN2"}, []string{entries[0].VIN, entries[1].VIN})
+	assert.Equal(t, "res_1", entries[1].ReservationID)
+	assert.Equal(t, "CA", entries[1].Region)
+}
+
+func TestLedgerRecordsReservationHolds(t *testing.T) {
+	store := useFakeReservations(t)
+	h, _ := stripeFakeHandlers(t, staticSubs(reservationPayload))
+	ledger := useFakeLedger(t)
+
+	postReservation(h, vin1Reservation)
+	postReservation(h, `{"vin": "VIN2", "amount": 50000, "currency": "cad", "payment_method": "pm_card_visa"}`)
+	doReservationRequest(h.ReleaseReservationHandler, "POST", "res_1")
+
+	// Expire the second reservation for the sweeper
+	res := store.get("res_2")
+	res.ExpiresAt = time.Now().Add(-time.Minute)
+	store.put("res_2", res)
+	_, err := h.SweepExpiredReservations(time.Now().UTC())
+	assert.NoError(t, err)
+
+	entries := ledger.all()
+	assert.Equal(t, []string{
+		"hold/requires_capture/api",
+		"hold/requires_capture/api",
+		"cancel/canceled/api",
+		"cancel/canceled/reservation_sweeper",
+	}, ledgerSummary(entries))
+	assert.Equal(t, "res_1", entries[2].ReservationID)
+	assert.Equal(t, "res_2", entries[3].ReservationID)
+	assert.Equal(t, "CA", entries[3].Region)
+}
+
+func TestLedgerRecordsWebhookEvents(t *testing.T) {
+	h, _, _ := useWebhookFakes(t)
+	ledger := useFakeLedger(t)
+	payload := paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent)
+
+	doWebhookRequest(h, payload, testWebhookSecret)
+	doWebhookRequest(h, payload, testWebhookSecret)
+
+	entries := ledger.all()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, models.LedgerEntry{
+		ID:              "webhook:evt_1",
+		Type:            models.LedgerWebhook,
+		PaymentIntentID: "pi_123",
+		EventID:         "evt_1",
+		EventType:       "payment_intent.canceled",
+		Status:          "canceled",
+		Amount:          1000,
+		Currency:        "usd",
+		VIN:             "VIN1",
+		Actor:           "stripe",
+		CreatedAt:       entries[0].CreatedAt,
+	}, entries[0])
+}
+
+func TestListPaymentsHandler(t *testing.T) {
+	ledger := useFakeLedger(t)
+	ledger.put("hold:pi_1", models.LedgerEntry{ID: "hold:pi_1", Type: models.LedgerHold, PaymentIntentID: "pi_1", VIN: "VIN1", Amount: 5000})
+
+	req := httptest.NewRequest("GET", "/payments?vin=VIN1&status=succeeded&type=refund&from=2025-08-01T00:00:00Z&to=2025-09-01T00:00:00Z&limit=50", nil)
+	rw := httptest.NewRecorder()
+	ListPaymentsHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var resp PaymentLedgerResponse
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, 1, resp.Count)
+	assert.Equal(t, "hold:pi_1", resp.Entries[0].ID)
+	assert.Equal(t, mongo.LedgerQuery{
+		VIN:    "VIN1",
+		Status: "succeeded",
+		Type:   "refund",
+		From:   time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC),
+		Limit:  51,
+	}, ledger.query)
+	assert.False(t, resp.HasMore)
+	assert.Empty(t, resp.NextCursor)
+
+	// The default range is the last 24 hours
+	rw = httptest.NewRecorder()
+	ListPaymentsHandler(rw, httptest.NewRequest("GET", "/payments", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), ledger.query.From, time.Minute)
+	assert.Equal(t, int64(defaultHistoryLimit)+1, ledger.query.Limit)
+}
+
+func TestListPaymentsHandlerPages(t *testing.T) {
+	ledger := useFakeLedger(t)
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i, id := range []string{"hold:pi_1", "capture:pi_1", "hold:pi_2", "hold:pi_3", "cancel:pi_3"} {
+		// Entries written in the same instant are ordered by ID
+		ledger.put(id, models.LedgerEntry{ID: id, Type: models.LedgerHold, CreatedAt: start.Add(time.Duration(i/2) * time.Minute)})
+	}
+
+	var ids []string
+	cursor, pages := "", 0
+	for {
+		target := "/payments?limit=2"
+		if cursor != "" {
+			target += "&cursor=" + cursor
+		}
+		rw := httptest.NewRecorder()
+		ListPaymentsHandler(rw, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusOK, rw.Code)
+		var resp PaymentLedgerResponse
+		assert.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+		assert.Equal(t, len(resp.Entries), resp.Count)
+		for _, e := range resp.Entries {
+			ids = append(ids, e.ID)
+		}
+		pages++
+		if !resp.HasMore {
+			assert.Empty(t, resp.NextCursor)
+			break
+		}
+		assert.Len(t, resp.Entries, 2)
+		cursor = resp.NextCursor
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []string{"capture:pi_1", "hold:pi_1", "hold:pi_2", "hold:pi_3", "cancel:pi_3"}, ids)
+
+	rw := httptest.NewRecorder()
+	ListPaymentsHandler(rw, httptest.NewRequest("GET", "/payments?cursor=not-a-cursor", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	assert.Equal(t, []interface{}{"cursor"}, fieldNames(resp))
+}
+
+func TestListPaymentsHandlerRejects(t *testing.T) {
+	ledger := useFakeLedger(t)
+
+	rw := httptest.NewRecorder()
+	ListPaymentsHandler(rw, httptest.NewRequest("GET", "/payments?type=charge&limit=0&from=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	assert.Equal(t, []interface{}{"from", "type", "limit"}, fieldNames(resp))
+
+	ledger.findErr = errors.New("mongo down")
+	rw = httptest.NewRecorder()
+	ListPaymentsHandler(rw, httptest.NewRequest("GET", "/payments", nil))
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/stripefake"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+)
+
+// stripeFakeHandlers returns payment handlers that reach a local fake Stripe
+// server through the real Stripe client, with a default account and a separate
+// CA account
+func stripeFakeHandlers(t *testing.T, subs subscription.SubscriptionSource) (*PaymentHandlers, *stripefake.Server) {
+	srv := stripefake.NewServer()
+	svc, err := payments.New(config.Config{
+		StripeKey:     "sk_test_default",
+		StripeKeys:    map[string]string{"CA": "sk_test_ca"},
+		StripeAPIBase: srv.URL,
+	})
+	assert.NoError(t, err)
+	t.Cleanup(srv.Close)
+	return NewPaymentHandlers(svc, subs, nil, nil), srv
+}
+
+func doPaymentRequest(handler http.HandlerFunc, method, target, id, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+	if id != "" {
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+	}
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw, resp
+}
+
+func TestHoldLifecycleAgainstStripeFake(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, nil)
+
+	rw, resp := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "requires_capture", resp["status"])
+	id, _ := resp["payment_intent_id"].(string)
+
+	rw, resp = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/"+id, id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, float64(5000), resp["amount_capturable"])
+
+	rw, resp = doPaymentRequest(h.CaptureHoldHandler, "POST", "/holdpayment/"+id+"/capture", id, `{"amount_to_capture": 3000}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "succeeded", resp["status"])
+	assert.Equal(t, float64(3000), resp["amount_received"])
+
+	rw, _ = doPaymentRequest(h.CancelHoldHandler, "POST", "/holdpayment/"+id+"/cancel", id, "")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+
+	pi, ok := srv.PaymentIntent(id)
+	assert.True(t, ok)
+	assert.Equal(t, "manual", pi.CaptureMethod)
+	assert.Equal(t, int64(3000), pi.AmountReceived)
+}
+
+func TestHoldErrorsAgainstStripeFake(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, nil)
+
+	rw, resp := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 5000, "currency": "usd", "payment_method": "`+stripefake.PaymentMethodDeclined+`"}`)
+	assert.Equal(t, http.StatusPaymentRequired, rw.Code)
+	assert.Contains(t, resp["error"], "declined")
+
+	rw, _ = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/pi_missing", "pi_missing", "")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	srv.FailNext(http.StatusInternalServerError, "api_error", "", "Stripe is down")
+	rw, _ = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/pi_missing", "pi_missing", "")
+	assert.Equal(t, http.StatusBadGateway, rw.Code)
+}
+
+func TestRegionHoldsAgainstStripeFake(t *testing.T) {
+	h, _ := stripeFakeHandlers(t, staticSubs(reservationPayload))
+	ledger := useFakeLedger(t)
+
+	rw, resp := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"vin": "VIN2", "amount": 5000, "currency": "cad", "payment_method": "pm_card_visa"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "CA", resp["region"])
+	id, _ := resp["payment_intent_id"].(string)
+
+	// The hold lives in the CA account only, as its ledger entry records
+	rw, resp = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/"+id, id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "CA", resp["region"])
+	rw, _ = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/"+id+"?region=ca", id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	rw, _ = doPaymentRequest(h.CaptureHoldHandler, "POST", "/holdpayment/"+id+"/capture?region=us", id, "")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	// Without a ledger entry Stripe is asked, but only in the claimed region's account
+	ledger.remove(models.LedgerHold + ":" + id)
+	rw, resp = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/"+id, id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "CA", resp["region"])
+	rw, _ = doPaymentRequest(h.CancelHoldHandler, "POST", "/holdpayment/"+id+"/cancel?region=us", id, "")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	rw, _ = doPaymentRequest(h.GetHoldHandler, "GET", "/holdpayment/pi_missing", "pi_missing", "")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestReservationAgainstStripeFake(t *testing.T) {
+	store := useFakeReservations(t)
+	h, srv := stripeFakeHandlers(t, staticSubs(reservationPayload))
+
+	rw, resp := postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	id, _ := resp["payment_intent_id"].(string)
+	assert.Equal(t, id, store.get("res_1").PaymentIntentID)
+
+	rw, _ = doReservationRequest(h.ReleaseReservationHandler, "POST", "res_1")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	pi, _ := srv.PaymentIntent(id)
+	assert.Equal(t, "canceled", pi.Status)
+	assert.Equal(t, "requested_by_customer", pi.CancellationReason)
+	assert.Equal(t, "res_1", pi.Metadata["reservation_id"])
+}
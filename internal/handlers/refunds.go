@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+)
+
+// RefundRequest is the optional input for /payments/{id}/refunds.
+// Omitting amount refunds everything not refunded yet.
+// Example: {"amount": 2000, "reason": "requested_by_customer"}
+type RefundRequest struct {
+	Amount *int64 `json:"amount,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// refundReasons are the reason codes Stripe accepts for a refund
+var refundReasons = []string{
+	string(stripe.RefundReasonDuplicate),
+	string(stripe.RefundReasonFraudulent),
+	string(stripe.RefundReasonRequestedByCustomer),
+}
+
+// RefundPaymentHandler refunds a captured payment, fully or partially, adds the
+// refund to the payment's history and the ledger and publishes a refund.created event.
+// The Stripe account is the one holding the payment; an Idempotency-Key header
+// is forwarded to Stripe scoped to the payment's refunds, and retries replay
+// the original response.
+func (h *PaymentHandlers) RefundPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefundRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+	var errs validation.Errors
+	if req.Amount != nil && *req.Amount <= 0 {
+		errs.Add("amount", validation.CodeTooSmall, "amount must be positive")
+	}
+	if req.Reason != "" {
+		validation.OneOf(&errs, "reason", req.Reason, refundReasons...)
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	region, status, err := h.holdRegion(id, r.URL.Query().Get("region"))
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	intents, ok := h.paymentIntents(w, region)
+	if !ok {
+		return
+	}
+	refunds, err := h.payments.Refunds(region)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	key, actor := r.Header.Get(idempotencyHeader), requestActor(r)
+	if key == "" {
+		status, body := h.refundPayment(intents, refunds, id, req, "", actor)
+		writeJSON(w, status, body)
+		return
+	}
+	withIdempotency(w, "refunds:"+id, key, req, func(stripeKey string) (int, interface{}) {
+		return h.refundPayment(intents, refunds, id, req, stripeKey, actor)
+	})
+}
+
+// refundPayment issues the refund and returns the response status and body
+func (h *PaymentHandlers) refundPayment(intents payments.Intents, refunds payments.Refunds, id string, req RefundRequest, idempotencyKey, actor string) (int, interface{}) {
+	getParams := &stripe.PaymentIntentParams{}
+	getParams.AddExpand("latest_charge")
+	pi, err := intents.Get(id, getParams)
+	if err != nil {
+		return stripeErrorStatus(err), map[string]string{"error": err.Error()}
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		return http.StatusConflict, map[string]string{"error": fmt.Sprintf("payment intent %s is %s and cannot be refunded", id, pi.Status)}
+	}
+
+	// Stripe's charge counts every refund, including any whose record failed to save
+	var refunded int64
+	if pi.LatestCharge != nil {
+		refunded = pi.LatestCharge.AmountRefunded
+	}
+	remaining := pi.AmountReceived - refunded
+	// A retry with the same Idempotency-Key finds its own refund counted
+	// already, so keyed requests are checked by Stripe, which replays it
+	checked := idempotencyKey == ""
+	if checked && remaining <= 0 {
+		return http.StatusConflict, map[string]string{"error": fmt.Sprintf("payment intent %s is already fully refunded", id)}
+	}
+
+	params := &stripe.RefundParams{PaymentIntent: stripe.String(id)}
+	params.AddExpand("charge")
+	if req.Amount != nil {
+		var errs validation.Errors
+		if checked {
+			validation.Range(&errs, "amount", *req.Amount, 1, remaining)
+		}
+		if len(errs) > 0 {
+			return http.StatusBadRequest, ValidationErrorResponse{Error: "Invalid request", Fields: errs}
+		}
+		params.Amount = stripe.Int64(*req.Amount)
+	}
+	if req.Reason != "" {
+		params.Reason = stripe.String(req.Reason)
+	}
+	for _, k := range []string{"vin", "region"} {
+		if v := pi.Metadata[k]; v != "" {
+			params.AddMetadata(k, v)
+		}
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+	re, err := refunds.New(params)
+	if err != nil {
+		return stripeErrorStatus(err), map[string]string{"error": err.Error()}
+	}
+	// A replayed refund carries the charge as it was then, which the
+	// earlier read already counts
+	total := refunded + re.Amount
+	if re.Charge != nil {
+		total = max(refunded, re.Charge.AmountRefunded)
+	}
+
+	payment := paymentRecord(pi)
+	payment.AmountRefunded = total
+	refund := models.Refund{
+		ID:        re.ID,
+		Amount:    re.Amount,
+		Currency:  string(re.Currency),
+		Reason:    string(re.Reason),
+		Status:    string(re.Status),
+		CreatedAt: time.Unix(re.Created, 0).UTC(),
+	}
+	recordErr := mongo.RecordRefund(config.AppConfig.MongoDB, config.AppConfig.PaymentsCollection(), payment, refund)
+	entry := intentLedgerEntry(models.LedgerRefund, pi, refund.Amount, actor)
+	entry.ID = models.LedgerRefund + ":" + refund.ID
+	entry.RefundID = refund.ID
+	entry.Status = refund.Status
+	recordLedger(entry)
+	err = h.publishPaymentEvent(context.Background(), models.PaymentEvent{
+		EventID:         re.ID,
+		Type:            models.RefundCreatedEvent,
+		PaymentIntentID: pi.ID,
+		Status:          payment.Status,
+		Amount:          payment.Amount,
+		AmountReceived:  payment.AmountReceived,
+		Currency:        payment.Currency,
+		Metadata:        pi.Metadata,
+		Livemode:        pi.Livemode,
+		Created:         refund.CreatedAt,
+		RefundID:        refund.ID,
+		RefundAmount:    refund.Amount,
+		RefundReason:    refund.Reason,
+		AmountRefunded:  payment.AmountRefunded,
+	})
+	if err != nil {
+		log.Printf("Publishing refund %s of %s failed: %v", re.ID, id, err)
+	}
+	if recordErr != nil {
+		// The money has moved; a retry with the same Idempotency-Key records the replayed refund
+		log.Printf("Recording refund %s of %s failed: %v", re.ID, id, recordErr)
+		return http.StatusInternalServerError, map[string]interface{}{
+			"error":             fmt.Sprintf("Refund %s was issued but recording it failed", re.ID),
+			"refund_id":         re.ID,
+			"payment_intent_id": pi.ID,
+		}
+	}
+
+	return http.StatusCreated, map[string]interface{}{
+		"refund_id":         refund.ID,
+		"payment_intent_id": pi.ID,
+		"amount":            refund.Amount,
+		"currency":          refund.Currency,
+		"reason":            refund.Reason,
+		"status":            refund.Status,
+		"amount_refunded":   payment.AmountRefunded,
+		"amount_remaining":  payment.AmountReceived - payment.AmountRefunded,
+	}
+}
+
+// ListRefundsHandler returns the stored refund history of a payment
+func ListRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := mongo.FindPayment(config.AppConfig.MongoDB, config.AppConfig.PaymentsCollection(), mux.Vars(r)["id"])
+	if errors.Is(err, mongo.ErrPaymentNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("Loading payment failed:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load payment")
+		return
+	}
+	if p.Refunds == nil {
+		p.Refunds = []models.Refund{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"payment_intent_id": p.ID,
+		"currency":          p.Currency,
+		"amount_received":   p.AmountReceived,
+		"amount_refunded":   p.AmountRefunded,
+		"refunds":           p.Refunds,
+	})
+}
+
+// paymentRecord is the stored form of pi, without its refunds
+func paymentRecord(pi *stripe.PaymentIntent) models.Payment {
+	return models.Payment{
+		ID:             pi.ID,
+		VIN:            pi.Metadata["vin"],
+		Region:         pi.Metadata["region"],
+		Status:         string(pi.Status),
+		Amount:         pi.Amount,
+		AmountReceived: pi.AmountReceived,
+		Currency:       string(pi.Currency),
+	}
+}
+
+// recordPayment stores pi's payment record once its hold is placed or
+// captured. Stripe has already applied the operation, so a failed write is
+// logged rather than failing the request.
+func recordPayment(pi *stripe.PaymentIntent) {
+	if err := mongo.RecordPayment(config.AppConfig.MongoDB, config.AppConfig.PaymentsCollection(), paymentRecord(pi)); err != nil {
+		log.Printf("Recording payment %s failed: %v", pi.ID, err)
+	}
+}
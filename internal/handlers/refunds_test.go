@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+// capturedPayment holds and captures 5000 cents on the fake Stripe server
+func capturedPayment(t *testing.T, h *PaymentHandlers) string {
+	_, resp := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	id, _ := resp["payment_intent_id"].(string)
+	rw, _ := doPaymentRequest(h.CaptureHoldHandler, "POST", "/holdpayment/"+id+"/capture", id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	return id
+}
+
+func postRefund(h *PaymentHandlers, id, body string) (int, map[string]interface{}) {
+	rw, resp := doPaymentRequest(h.RefundPaymentHandler, "POST", "/payments/"+id+"/refunds", id, body)
+	return rw.Code, resp
+}
+
+func TestPaymentRecordedFromHold(t *testing.T) {
+	h, _ := stripeFakeHandlers(t, nil)
+	store := useFakePayments(t)
+
+	_, resp := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	id := resp["payment_intent_id"].(string)
+	held := store.get(id)
+	assert.Equal(t, "requires_capture", held.Status)
+	assert.Equal(t, int64(5000), held.Amount)
+	assert.Zero(t, held.AmountReceived)
+
+	rw, list := doPaymentRequest(ListRefundsHandler, "GET", "/payments/"+id+"/refunds", id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Empty(t, list["refunds"])
+
+	rw, _ = doPaymentRequest(h.CaptureHoldHandler, "POST", "/holdpayment/"+id+"/capture", id, `{"amount_to_capture": 4000}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	captured := store.get(id)
+	assert.Equal(t, "succeeded", captured.Status)
+	assert.Equal(t, int64(4000), captured.AmountReceived)
+
+	// The refund is appended to the record the hold created
+	code, _ := postRefund(h, id, `{"amount": 1000}`)
+	assert.Equal(t, http.StatusCreated, code)
+	refunded := store.get(id)
+	assert.Equal(t, int64(1000), refunded.AmountRefunded)
+	assert.Len(t, refunded.Refunds, 1)
+	assert.Equal(t, int64(5000), refunded.Amount)
+}
+
+func TestRefundPayment(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, nil)
+	store := useFakePayments(t)
+	pub := &recordingPublisher{}
+	h.events = pub
+	id := capturedPayment(t, h)
+
+	code, resp := postRefund(h, id, `{"amount": 2000, "reason": "requested_by_customer"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, float64(2000), resp["amount"])
+	assert.Equal(t, "requested_by_customer", resp["reason"])
+	assert.Equal(t, float64(3000), resp["amount_remaining"])
+
+	// No amount refunds the rest
+	code, resp = postRefund(h, id, "")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, float64(3000), resp["amount"])
+	assert.Equal(t, float64(0), resp["amount_remaining"])
+
+	code, _ = postRefund(h, id, "")
+	assert.Equal(t, http.StatusConflict, code)
+
+	stored := store.get(id)
+	assert.Equal(t, int64(5000), stored.AmountRefunded)
+	assert.Len(t, stored.Refunds, 2)
+	assert.Len(t, srv.Refunds(id), 2)
+
+	assert.Len(t, pub.events, 2)
+	assert.Equal(t, []string{id, id}, pub.keys)
+	assert.Equal(t, models.RefundCreatedEvent, pub.events[0].Type)
+	assert.Equal(t, int64(2000), pub.events[0].RefundAmount)
+	assert.Equal(t, int64(5000), pub.events[1].AmountRefunded)
+
+	rw, list := doPaymentRequest(ListRefundsHandler, "GET", "/payments/"+id+"/refunds", id, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, float64(5000), list["amount_refunded"])
+	assert.Len(t, list["refunds"], 2)
+}
+
+func TestRefundPaymentRejects(t *testing.T) {
+	h, _ := stripeFakeHandlers(t, nil)
+	useFakePayments(t)
+	id := capturedPayment(t, h)
+
+	code, resp := postRefund(h, id, `{"amount": 0, "reason": "changed_mind"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []interface{}{"amount", "reason"}, fieldNames(resp))
+
+	code, resp = postRefund(h, id, `{"amount": 5001}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []interface{}{"amount"}, fieldNames(resp))
+
+	_, hold := doPaymentRequest(h.HoldPaymentHandler, "POST", "/holdpayment", "", `{"amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	code, resp = postRefund(h, hold["payment_intent_id"].(string), "")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Contains(t, resp["error"], "requires_capture")
+
+	code, _ = postRefund(h, "pi_missing", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	rw, _ := doPaymentRequest(ListRefundsHandler, "GET", "/payments/pi_missing/refunds", "pi_missing", "")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestRefundPaymentIdempotencyKey(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, nil)
+	useFakePayments(t)
+	useFakeIdempotency(t)
+	id := capturedPayment(t, h)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/payments/"+id+"/refunds", strings.NewReader(`{"amount": 1000}`))
+		req.Header.Set(idempotencyHeader, "refund-1")
+		rw := httptest.NewRecorder()
+		h.RefundPaymentHandler(rw, mux.SetURLVars(req, map[string]string{"id": id}))
+		var resp map[string]interface{}
+		json.NewDecoder(rw.Body).Decode(&resp)
+		assert.Equal(t, http.StatusCreated, rw.Code)
+		assert.Equal(t, float64(4000), resp["amount_remaining"])
+		if i == 1 {
+			assert.Equal(t, "true", rw.Header().Get(idempotencyReplayedHeader))
+		}
+	}
+	assert.Len(t, srv.Refunds(id), 1)
+}
+
+func postKeyedRefund(h *PaymentHandlers, id, body, key string) (int, map[string]interface{}) {
+	req := httptest.NewRequest("POST", "/payments/"+id+"/refunds", strings.NewReader(body))
+	req.Header.Set(idempotencyHeader, key)
+	rw := httptest.NewRecorder()
+	h.RefundPaymentHandler(rw, mux.SetURLVars(req, map[string]string{"id": id}))
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw.Code, resp
+}
+
+func TestRefundPaymentIdempotencyKeyIsScoped(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, nil)
+	useFakePayments(t)
+	useFakeIdempotency(t)
+
+	// The client reuses one key for a hold and for refunds of two payments
+	req := httptest.NewRequest("POST", "/holdpayment", strings.NewReader(`{"amount": 5000, "currency": "usd", "payment_method": "pm_card_visa"}`))
+	req.Header.Set(idempotencyHeader, "shared-key")
+	rw := httptest.NewRecorder()
+	h.HoldPaymentHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var hold map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&hold)
+	first := hold["payment_intent_id"].(string)
+	rw, _ = doPaymentRequest(h.CaptureHoldHandler, "POST", "/holdpayment/"+first+"/capture", first, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	second := capturedPayment(t, h)
+
+	// Each endpoint and payment gets its own Stripe call
+	code, _ := postKeyedRefund(h, first, `{"amount": 1000}`, "shared-key")
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = postKeyedRefund(h, second, `{"amount": 1000}`, "shared-key")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Len(t, srv.Refunds(first), 1)
+	assert.Len(t, srv.Refunds(second), 1)
+}
+
+func TestScopedIdempotencyKey(t *testing.T) {
+	assert.Equal(t, "refunds:pi_1:key-1", scopedIdempotencyKey("refunds:pi_1", "key-1"))
+
+	// Keys too long for Stripe are hashed, still per scope
+	long := strings.Repeat("k", maxIdempotencyKeyLength)
+	hashed := scopedIdempotencyKey("refunds:pi_1", long)
+	assert.LessOrEqual(t, len(hashed), maxIdempotencyKeyLength)
+	assert.True(t, strings.HasPrefix(hashed, "refunds:pi_1:"))
+	assert.Equal(t, hashed, scopedIdempotencyKey("refunds:pi_1", long))
+	assert.NotEqual(t, hashed, scopedIdempotencyKey("refunds:pi_2", long))
+}
+
+func TestRefundPaymentStoreFailure(t *testing.T) {
+	h, srv := stripeFakeHandlers(t, nil)
+	store := useFakePayments(t)
+	useFakeIdempotency(t)
+	id := capturedPayment(t, h)
+
+	store.recordErr = errors.New("mongo down")
+	code, resp := postKeyedRefund(h, id, `{"amount": 1000}`, "refund-1")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Len(t, srv.Refunds(id), 1)
+	first := srv.Refunds(id)[0].ID
+	assert.Equal(t, first, resp["refund_id"])
+	assert.Empty(t, store.get(id).Refunds)
+
+	// The unrecorded refund still counts against the amount left to refund
+	store.recordErr = nil
+	code, resp = postRefund(h, id, `{"amount": 4001}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []interface{}{"amount"}, fieldNames(resp))
+	code, resp = postRefund(h, id, `{"amount": 1000}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, float64(2000), resp["amount_refunded"])
+	assert.Equal(t, float64(3000), resp["amount_remaining"])
+
+	// Retrying the failed request records Stripe's replayed refund
+	code, resp = postKeyedRefund(h, id, `{"amount": 1000}`, "refund-1")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, first, resp["refund_id"])
+	assert.Equal(t, float64(2000), resp["amount_refunded"])
+	assert.Len(t, srv.Refunds(id), 2)
+	stored := store.get(id)
+	assert.Equal(t, int64(2000), stored.AmountRefunded)
+	assert.Len(t, stored.Refunds, 2)
+
+	// Keyed requests leave the remaining amount to Stripe
+	code, _ = postKeyedRefund(h, id, `{"amount": 3001}`, "refund-2")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = postKeyedRefund(h, id, "", "refund-3")
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = postKeyedRefund(h, id, "", "refund-4")
+	assert.Equal(t, http.StatusConflict, code)
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReservationRequest is the expected input for POST /reservations
+// Example: {"vin": "AA450000007141513", "amount": 50000, "currency": "usd", "payment_method": "pm_xxx"}
+type ReservationRequest struct {
+	VIN           string `json:"vin"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+// reservationSweepBatch caps how many expired reservations one sweep releases
+const reservationSweepBatch = 100
+
+// newReservationID is a function variable for testability
+var newReservationID = func() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// CreateReservationHandler reserves a subscribed VIN by claiming it in MongoDB
+// and then placing a payment hold for it
+func (h *PaymentHandlers) CreateReservationHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	var errs validation.Errors
+	validation.Required(&errs, "vin", req.VIN)
+	hold := HoldPaymentRequest{Amount: req.Amount, Currency: req.Currency, PaymentMethod: req.PaymentMethod}
+	h.validateHold(&errs, &hold)
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	vehicle, status, err := h.findVehicle(r.Context(), req.VIN)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	if vehicle.VehicleStatus != models.VehicleStatusSubscribed {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("vehicle %s is %s, not %s", req.VIN, vehicle.VehicleStatus, models.VehicleStatusSubscribed))
+		return
+	}
+	intents, ok := h.paymentIntents(w, vehicle.Region)
+	if !ok {
+		return
+	}
+
+	// Claim the VIN first so concurrent requests cannot both place a hold
+	now := time.Now().UTC()
+	res := models.Reservation{
+		ID:        newReservationID(),
+		VIN:       req.VIN,
+		Region:    vehicle.Region,
+		Status:    models.ReservationPending,
+		Amount:    req.Amount,
+		Currency:  hold.Currency,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.AppConfig.ReservationTTL()),
+	}
+	db, coll := config.AppConfig.MongoDB, config.AppConfig.ReservationsCollection()
+	if err := mongo.InsertReservation(db, coll, res); err != nil {
+		if errors.Is(err, mongo.ErrVINReserved) {
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("vehicle %s already has an active reservation", req.VIN))
+			return
+		}
+		log.Println("Storing reservation failed:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store reservation")
+		return
+	}
+
+	// Store the intent before confirming it, so a hold placed just before a
+	// crash still has an ID the sweeper can cancel
+	actor := requestActor(r)
+	pi, err := intents.New(holdParams(hold, res.Region, map[string]string{"vin": req.VIN, "reservation_id": res.ID}, ""))
+	if err != nil {
+		releaseReservation(res.ID, models.ReservationFailed)
+		writeStripeError(w, err)
+		return
+	}
+	if err := mongo.AttachReservationIntent(db, coll, res.ID, pi.ID); err != nil {
+		// The intent is unconfirmed and holds no funds
+		log.Println("Storing reservation hold failed:", err)
+		releaseReservation(res.ID, models.ReservationFailed)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store reservation")
+		return
+	}
+	res.PaymentIntentID = pi.ID
+
+	pi, err = intents.Confirm(pi.ID, nil)
+	if err != nil {
+		recordFailedHold(err, actor)
+		abandonReservation(intents, &res, actor)
+		writeStripeError(w, err)
+		return
+	}
+	recordLedger(intentLedgerEntry(models.LedgerHold, pi, pi.Amount, actor))
+	recordPayment(pi)
+
+	if err := mongo.ActivateReservation(db, coll, res.ID, pi.ID); err != nil {
+		log.Println("Activating reservation failed:", err)
+		abandonReservation(intents, &res, actor)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store reservation")
+		return
+	}
+	res.Status = models.ReservationActive
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// GetReservationHandler returns a reservation by ID
+func GetReservationHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := mongo.FindReservation(config.AppConfig.MongoDB, config.AppConfig.ReservationsCollection(), mux.Vars(r)["id"])
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// ReleaseReservationHandler cancels a reservation's hold and frees its VIN
+func (h *PaymentHandlers) ReleaseReservationHandler(w http.ResponseWriter, r *http.Request) {
+	db, coll := config.AppConfig.MongoDB, config.AppConfig.ReservationsCollection()
+	res, err := mongo.FindReservation(db, coll, mux.Vars(r)["id"])
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	if !res.Active {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("reservation %s is already %s", res.ID, res.Status))
+		return
+	}
+	intents, ok := h.paymentIntents(w, res.Region)
+	if !ok {
+		return
+	}
+
+	status, err := settleReservationHold(intents, res, stripe.PaymentIntentCancellationReasonRequestedByCustomer, models.ReservationReleased, requestActor(r))
+	if err != nil {
+		writeStripeError(w, err)
+		return
+	}
+	if _, err := mongo.ReleaseReservation(db, coll, res.ID, status); err != nil {
+		log.Println("Releasing reservation failed:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to release reservation")
+		return
+	}
+	res.Status = status
+	res.Active = false
+	writeJSON(w, http.StatusOK, res)
+}
+
+// SweepExpiredReservations releases the holds of reservations that expired at
+// or before now and frees their VINs. Reservations whose hold cannot be
+// cancelled are left active and retried on the next sweep.
+func (h *PaymentHandlers) SweepExpiredReservations(now time.Time) (int, error) {
+	db, coll := config.AppConfig.MongoDB, config.AppConfig.ReservationsCollection()
+	expired, err := mongo.FindExpiredReservations(db, coll, now, reservationSweepBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range expired {
+		res := &expired[i]
+		intents, err := h.payments.Intents(res.Region)
+		if err != nil {
+			log.Printf("Releasing hold %s of reservation %s failed: %v", res.PaymentIntentID, res.ID, err)
+			continue
+		}
+		status, err := settleReservationHold(intents, res, stripe.PaymentIntentCancellationReasonAbandoned, models.ReservationExpired, actorSweeper)
+		if err != nil {
+			log.Printf("Releasing hold %s of reservation %s failed: %v", res.PaymentIntentID, res.ID, err)
+			continue
+		}
+		ok, err := mongo.ReleaseReservation(db, coll, res.ID, status)
+		if err != nil {
+			log.Printf("Expiring reservation %s failed: %v", res.ID, err)
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// RunReservationSweeper expires reservations every interval until ctx is cancelled
+func (h *PaymentHandlers) RunReservationSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if h.payments == nil {
+				log.Println("Stripe not configured, skipping reservation sweep")
+				continue
+			}
+			if n, err := h.SweepExpiredReservations(now.UTC()); err != nil {
+				log.Println("Reservation sweep failed:", err)
+			} else if n > 0 {
+				log.Printf("Expired %d reservations", n)
+			}
+		}
+	}
+}
+
+// findVehicle looks vin up in the subscription source, returning the HTTP status to report on failure
+func (h *PaymentHandlers) findVehicle(ctx context.Context, vin string) (*models.VehicleSubscription, int, error) {
+	if h.subs == nil {
+		return nil, http.StatusInternalServerError, errors.New("no subscription source configured")
+	}
+	resp, err := h.subs.Fetch(ctx)
+	if err != nil {
+		log.Println("Fetching vehicle subscriptions failed:", err)
+		return nil, http.StatusBadGateway, errors.New("failed to load vehicle subscriptions")
+	}
+	for i, v := range resp.Payload.VehicleSubscriptions {
+		if v.Vin == vin {
+			return &resp.Payload.VehicleSubscriptions[i], http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusNotFound, fmt.Errorf("vehicle %s not found", vin)
+}
+
+// settleReservationHold cancels the reservation's hold if it is still
+// cancelable and returns the final reservation status: releasedStatus, or
+// completed if the hold was already captured.
+func settleReservationHold(intents payments.Intents, res *models.Reservation, reason stripe.PaymentIntentCancellationReason, releasedStatus, actor string) (string, error) {
+	if res.PaymentIntentID == "" {
+		return releasedStatus, nil
+	}
+	pi, err := intents.Get(res.PaymentIntentID, nil)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pi.Status == stripe.PaymentIntentStatusSucceeded:
+		return models.ReservationCompleted, nil
+	case cancelableStatuses[pi.Status]:
+		canceled, err := intents.Cancel(res.PaymentIntentID, &stripe.PaymentIntentCancelParams{
+			CancellationReason: stripe.String(string(reason)),
+		})
+		if err != nil {
+			return "", err
+		}
+		recordLedger(intentLedgerEntry(models.LedgerCancel, canceled, canceled.Amount, actor))
+	}
+	return releasedStatus, nil
+}
+
+// abandonReservation cancels the hold of a reservation that could not be
+// completed and frees its VIN. A hold that cannot be cancelled keeps the
+// reservation active, so the sweeper retries it once it expires.
+func abandonReservation(intents payments.Intents, res *models.Reservation, actor string) {
+	status, err := settleReservationHold(intents, res, stripe.PaymentIntentCancellationReasonAbandoned, models.ReservationFailed, actor)
+	if err != nil {
+		log.Printf("Cancelling hold %s of reservation %s failed: %v", res.PaymentIntentID, res.ID, err)
+		return
+	}
+	releaseReservation(res.ID, status)
+}
+
+func releaseReservation(id, status string) {
+	if _, err := mongo.ReleaseReservation(config.AppConfig.MongoDB, config.AppConfig.ReservationsCollection(), id, status); err != nil {
+		log.Printf("Releasing reservation %s failed: %v", id, err)
+	}
+}
+
+func writeReservationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, mongo.ErrReservationNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	log.Println("Loading reservation failed:", err)
+	writeJSONError(w, http.StatusInternalServerError, "Failed to load reservation")
+}
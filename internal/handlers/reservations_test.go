@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+const reservationPayload = `{"payload": {"vehicleSubscriptions": [
+	{"vehicleStatus": "SUBSCRIBED", "vin": "VIN1", "region": "US", "activePaidSubscriptions": true},
+	{"vehicleStatus": "SUBSCRIBED", "vin": "VIN2", "region": "CA", "activePaidSubscriptions": true},
+	{"vehicleStatus": "UNSUBSCRIBED", "vin": "VIN3"}
+]}}`
+
+// reservationHandlers serves the payment endpoints from a fake Stripe account
+// holding heldIntent, with the vehicles of reservationPayload
+func reservationHandlers() (*PaymentHandlers, *fakeHolds) {
+	holds := &fakeHolds{pi: heldIntent()}
+	return fakeHoldHandlers(holds, staticSubs(reservationPayload)), holds
+}
+
+func postReservation(h *PaymentHandlers, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest("POST", "/reservations", bytes.NewReader([]byte(body)))
+	rw := httptest.NewRecorder()
+	h.CreateReservationHandler(rw, req)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw, resp
+}
+
+const vin1Reservation = `{"vin": "VIN1", "amount": 50000, "currency": "usd", "payment_method": "pm_card_visa"}`
+
+func TestCreateReservation(t *testing.T) {
+	store := useFakeReservations(t)
+	payments := useFakePayments(t)
+	h, holds := reservationHandlers()
+
+	rw, resp := postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, "requires_capture", payments.get("pi_test_123").Status)
+	assert.Equal(t, "res_1", resp["id"])
+	assert.Equal(t, "active", resp["status"])
+	assert.Equal(t, "pi_test_123", resp["payment_intent_id"])
+
+	stored := store.get("res_1")
+	assert.True(t, stored.Active)
+	assert.Equal(t, "pi_test_123", stored.PaymentIntentID)
+	assert.WithinDuration(t, time.Now().Add(config.AppConfig.ReservationTTL()), stored.ExpiresAt, time.Minute)
+
+	assert.Len(t, holds.created, 1)
+	params := holds.created[0]
+	assert.Equal(t, "manual", *params.CaptureMethod)
+	assert.Equal(t, int64(50000), *params.Amount)
+	assert.Equal(t, "VIN1", params.Metadata["vin"])
+	assert.Equal(t, "res_1", params.Metadata["reservation_id"])
+}
+
+func TestReservationsUseRegionAccount(t *testing.T) {
+	store := useFakeReservations(t)
+	h, us, ca := regionHandlers(staticSubs(reservationPayload))
+
+	rw, resp := postReservation(h, `{"vin": "VIN2", "amount": 50000, "currency": "cad", "payment_method": "pm_card_visa"}`)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, "CA", resp["region"])
+	assert.Equal(t, "CA", store.get("res_1").Region)
+	assert.Empty(t, us.created)
+	assert.Len(t, ca.created, 1)
+	assert.Equal(t, "CA", ca.created[0].Metadata["region"])
+
+	req := httptest.NewRequest("POST", "/reservations/res_1/release", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "res_1"})
+	rw = httptest.NewRecorder()
+	h.ReleaseReservationHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.NotNil(t, ca.cancelParams)
+	assert.Nil(t, us.cancelParams)
+}
+
+func TestCreateReservationOnePerVIN(t *testing.T) {
+	useFakeReservations(t)
+	h, holds := reservationHandlers()
+
+	rw, _ := postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	rw, resp := postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, resp["error"], "active reservation")
+	// The losing request never reaches Stripe
+	assert.Len(t, holds.created, 1)
+
+	rw, _ = postReservation(h, `{"vin": "VIN2", "amount": 50000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+}
+
+func TestCreateReservationVehicleChecks(t *testing.T) {
+	useFakeReservations(t)
+	h, holds := reservationHandlers()
+
+	rw, _ := postReservation(h, `{"vin": "NOPE", "amount": 50000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	rw, resp := postReservation(h, `{"vin": "VIN3", "amount": 50000, "currency": "usd", "payment_method": "pm_card_visa"}`)
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, resp["error"], "UNSUBSCRIBED")
+
+	rw, resp = postReservation(h, `{"amount": 1}`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, []interface{}{"vin", "currency", "payment_method"}, fieldNames(resp))
+
+	rw, _ = postReservation(h, `not-json`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	h.subs = failingSource{}
+	rw, _ = postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusBadGateway, rw.Code)
+
+	h.subs = nil
+	rw, _ = postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.Empty(t, holds.created)
+}
+
+func TestCreateReservationHoldFailureFreesVIN(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+	holds.confirmFn = func(id string) error {
+		return &stripe.Error{Type: stripe.ErrorTypeCard, Msg: "Your card was declined."}
+	}
+
+	rw, resp := postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusPaymentRequired, rw.Code)
+	assert.Contains(t, resp["error"], "declined")
+	assert.Equal(t, models.ReservationFailed, store.get("res_1").Status)
+	assert.False(t, store.get("res_1").Active)
+	assert.NotNil(t, holds.cancelParams)
+
+	holds.newFn = func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+		return nil, &stripe.Error{Type: stripe.ErrorTypeAPI, Msg: "Stripe unavailable"}
+	}
+	rw, _ = postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusBadGateway, rw.Code)
+	assert.Equal(t, models.ReservationFailed, store.get("res_2").Status)
+	assert.False(t, store.get("res_2").Active)
+}
+
+func TestCreateReservationStoresIntentBeforeConfirming(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+
+	// Stop the request right after Stripe places the hold, as a crash would
+	var atConfirm models.Reservation
+	crash := &stripe.Error{Type: stripe.ErrorTypeAPI, Msg: "connection reset"}
+	holds.confirmFn = func(id string) error {
+		atConfirm = store.get("res_1")
+		holds.getErr = crash
+		return crash
+	}
+	postReservation(h, vin1Reservation)
+	assert.Equal(t, models.ReservationPending, atConfirm.Status)
+	assert.Equal(t, "pi_test_123", atConfirm.PaymentIntentID)
+	assert.True(t, store.get("res_1").Active)
+	assert.Nil(t, holds.cancelParams)
+
+	holds.getErr = nil
+	n, err := h.SweepExpiredReservations(store.get("res_1").ExpiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, models.ReservationExpired, store.get("res_1").Status)
+	assert.Equal(t, "abandoned", *holds.cancelParams.CancellationReason)
+}
+
+func TestCreateReservationActivateFailureCancelsHold(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+	store.activateErr = errors.New("mongo down")
+
+	rw, _ := postReservation(h, vin1Reservation)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.NotNil(t, holds.cancelParams)
+	assert.False(t, store.get("res_1").Active)
+}
+
+func doReservationRequest(handler http.HandlerFunc, method, id string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := mux.SetURLVars(httptest.NewRequest(method, "/reservations/"+id, nil), map[string]string{"id": id})
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw, resp
+}
+
+func TestGetAndReleaseReservation(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+	postReservation(h, vin1Reservation)
+
+	rw, resp := doReservationRequest(GetReservationHandler, "GET", "res_1")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "VIN1", resp["vin"])
+
+	rw, resp = doReservationRequest(h.ReleaseReservationHandler, "POST", "res_1")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "released", resp["status"])
+	assert.Equal(t, "requested_by_customer", *holds.cancelParams.CancellationReason)
+	assert.False(t, store.get("res_1").Active)
+
+	rw, _ = doReservationRequest(h.ReleaseReservationHandler, "POST", "res_1")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+
+	rw, _ = doReservationRequest(GetReservationHandler, "GET", "missing")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	rw, _ = doReservationRequest(h.ReleaseReservationHandler, "POST", "missing")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestSweepExpiredReservations(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+	now := time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC)
+
+	store.put("held", models.Reservation{ID: "held", VIN: "VIN1", Active: true, Status: models.ReservationActive, PaymentIntentID: "pi_test_123", ExpiresAt: now.Add(-time.Minute)})
+	store.put("pending", models.Reservation{ID: "pending", VIN: "VIN2", Active: true, Status: models.ReservationPending, ExpiresAt: now.Add(-time.Minute)})
+	store.put("fresh", models.Reservation{ID: "fresh", VIN: "VIN3", Active: true, Status: models.ReservationActive, PaymentIntentID: "pi_other", ExpiresAt: now.Add(time.Minute)})
+
+	n, err := h.SweepExpiredReservations(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, models.ReservationExpired, store.get("held").Status)
+	assert.Equal(t, models.ReservationExpired, store.get("pending").Status)
+	assert.True(t, store.get("fresh").Active)
+	assert.Equal(t, "abandoned", *holds.cancelParams.CancellationReason)
+}
+
+func TestSweepExpiredReservationsCapturedHold(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+	holds.pi.Status = stripe.PaymentIntentStatusSucceeded
+	now := time.Now().UTC()
+	store.put("paid", models.Reservation{ID: "paid", VIN: "VIN1", Active: true, PaymentIntentID: "pi_test_123", ExpiresAt: now.Add(-time.Second)})
+
+	n, err := h.SweepExpiredReservations(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, models.ReservationCompleted, store.get("paid").Status)
+	assert.Nil(t, holds.cancelParams)
+}
+
+func TestSweepExpiredReservationsRetriesStripeFailures(t *testing.T) {
+	store := useFakeReservations(t)
+	h, holds := reservationHandlers()
+	holds.getErr = &stripe.Error{Type: stripe.ErrorTypeAPI, Msg: "Stripe unavailable"}
+	now := time.Now().UTC()
+	store.put("held", models.Reservation{ID: "held", VIN: "VIN1", Active: true, PaymentIntentID: "pi_test_123", ExpiresAt: now.Add(-time.Second)})
+
+	n, err := h.SweepExpiredReservations(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, store.get("held").Active)
+
+	mongo.FindExpiredReservations = func(database, collection string, now time.Time, limit int64) ([]models.Reservation, error) {
+		return nil, errors.New("find failed")
+	}
+	_, err = h.SweepExpiredReservations(now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+)
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes the {"error": "..."} body used by the JSON endpoints
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// ValidationErrorResponse is the 400 body for invalid request input
+type ValidationErrorResponse struct {
+	Error  string            `json:"error"`
+	Fields validation.Errors `json:"fields"`
+}
+
+// writeValidationError writes a 400 listing every invalid field
+func writeValidationError(w http.ResponseWriter, errs validation.Errors) {
+	writeJSON(w, http.StatusBadRequest, ValidationErrorResponse{Error: "Invalid request", Fields: errs})
+}
+
+// writeBodyError reports a JSON body that could not be decoded, naming the field when known
+func writeBodyError(w http.ResponseWriter, err error) {
+	var errs validation.Errors
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		errs.Add(typeErr.Field, validation.CodeInvalid, "%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind()))
+	} else {
+		errs.Add("body", validation.CodeInvalid, "request body must be a JSON object")
+	}
+	writeValidationError(w, errs)
+}
+
+// jsonTypeName describes a Go kind the way a JSON client sees it
+func jsonTypeName(k reflect.Kind) string {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	default:
+		return "a valid value"
+	}
+}
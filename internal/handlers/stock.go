@@ -6,94 +6,61 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/yourusername/vehicle-stock-service/internal/config"
 	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/stream"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+	"github.com/yourusername/vehicle-stock-service/internal/ws"
 )
 
-// vehiclePayloadSource returns the JSON payload for vehicles (can be mocked in tests)
-var vehiclePayloadSource = func() string {
-	return `{
-	   "status": {
-		   "messages": [
-			   {
-				   "description": "Request Processed Successfully",
-				   "responseCode": "SUB-0000",
-				   "detailedDescription": "Request Processed Successfully"
-			   }
-		   ]
-	   },
-	   "payload": {
-		   "guid": "200d617c92c9a889cdda4c31559472f",
-		   "vehicleSubscriptions": [
-			   {
-				   "vehicleStatus": "SUBSCRIBED",
-				   "generation": "24MM",
-				   "region": "US",
-				   "vin": "AA450000007141513",
-				   "isSafetyActive": true,
-				   "isServiceConnectActive": false,
-				   "isRemoteActive": false,
-				   "isDigitalKeyRemoteActive": false,
-				   "isDestinationAssistActive": false,
-				   "isNavigationActive": false,
-				   "isVirtualAssistantActive": false,
-				   "isIntegratedStreamingActive": false,
-				   "isWifiActive": false,
-				   "brand": "L",
-				   "activePaidSubscriptions": true
-			   },
-			   {
-				   "vehicleStatus": "SUBSCRIBED",
-				   "generation": "24MM",
-				   "region": "CA",
-				   "vin": "AA450000007141573",
-				   "isSafetyActive": true,
-				   "isServiceConnectActive": false,
-				   "isRemoteActive": false,
-				   "isDigitalKeyRemoteActive": false,
-				   "isDestinationAssistActive": false,
-				   "isNavigationActive": false,
-				   "isVirtualAssistantActive": false,
-				   "isIntegratedStreamingActive": false,
-				   "isWifiActive": false,
-				   "brand": "T",
-				   "activePaidSubscriptions": false
-			   },
-			   {
-				   "vehicleStatus": "SUBSCRIBED",
-				   "generation": "24MM",
-				   "region": "CA",
-				   "vin": "AA450000007141603",
-				   "isSafetyActive": true
-			   }
-		   ]
-	   }
-	}`
+// StockHandlers serves the endpoints that read stored and live stock ticks
+type StockHandlers struct {
+	stocks  mongo.StockRepository
+	subs    subscription.SubscriptionSource
+	candles mongo.CandleAggregator
+	live    *stream.Hub
+	sockets *ws.Hub
+}
+
+// NewStockHandlers returns the /getstock, /stock/{vin}/history,
+// /stock/{vin}/candles, /stream/stock and /ws/stock handlers. They read ticks
+// from stocks, the vehicles of /getstock from subs and candles from candles.
+// /stream/stock follows live and /ws/stock is served by sockets; either
+// endpoint answers 500 while its hub is nil.
+func NewStockHandlers(stocks mongo.StockRepository, subs subscription.SubscriptionSource, candles mongo.CandleAggregator, live *stream.Hub, sockets *ws.Hub) *StockHandlers {
+	return &StockHandlers{stocks: stocks, subs: subs, candles: candles, live: live, sockets: sockets}
 }
 
 // GetStockHandler handles /getstock requests
-func GetStockHandler(w http.ResponseWriter, r *http.Request) {
+func (h *StockHandlers) GetStockHandler(w http.ResponseWriter, r *http.Request) {
 	startDate := r.Header.Get("startDate")
 	endDate := r.Header.Get("endDate")
-	if startDate == "" || endDate == "" {
-		http.Error(w, "startDate and endDate headers are required", http.StatusBadRequest)
+
+	// Date-only headers resolve to the last tick at or before the end of that day
+	var errs validation.Errors
+	loc := stockLocation()
+	startAt := resolveDateHeader(&errs, "startDate", startDate, loc)
+	endAt := resolveDateHeader(&errs, "endDate", endDate, loc)
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
 
 	log.Printf("Fetching stock data from %s to %s\n", startDate, endDate)
 
-	// Use the payload source (can be mocked in tests)
-	jsonInput := vehiclePayloadSource()
-
-	// Parse vehicle payload
-	var vehicleResp struct {
-		Status  interface{} `json:"status"`
-		Payload struct {
-			Guid                 string                   `json:"guid"`
-			VehicleSubscriptions []map[string]interface{} `json:"vehicleSubscriptions"`
-		} `json:"payload"`
+	if h.subs == nil {
+		http.Error(w, "Subscription source not configured", http.StatusInternalServerError)
+		return
+	}
+	vehicleResp, err := h.subs.Fetch(r.Context())
+	if err != nil {
+		log.Println("Fetching vehicle subscriptions failed:", err)
+		http.Error(w, "Failed to load vehicle subscriptions", http.StatusBadGateway)
+		return
 	}
-	if err := json.Unmarshal([]byte(jsonInput), &vehicleResp); err != nil {
-		http.Error(w, "Failed to parse vehicle payload", http.StatusInternalServerError)
+	if h.stocks == nil {
+		http.Error(w, "Stock repository not configured", http.StatusInternalServerError)
 		return
 	}
 
@@ -116,13 +83,13 @@ func GetStockHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	var vehicleStocks []VehicleStock
 	for _, v := range vehicleResp.Payload.VehicleSubscriptions {
-		vin, _ := v["vin"].(string)
-		region, _ := v["region"].(string)
+		vin := v.Vin
+		region := v.Region
 		ticker := "VEHICLE-" + vin
 
-		// Fetch start and end price from MongoDB
-		startStock, _ := mongo.FindStockByTickerAndDate("vehicle_stock_db", "stock_data", ticker, startDate)
-		endStock, _ := mongo.FindStockByTickerAndDate("vehicle_stock_db", "stock_data", ticker, endDate)
+		// Fetch start and end price from the stock repository
+		startStock, _ := h.stocks.FindAt(r.Context(), ticker, startAt)
+		endStock, _ := h.stocks.FindAt(r.Context(), ticker, endAt)
 
 		var startPrice, endPrice, diff *struct {
 			Bid float64 `json:"bid"`
@@ -161,7 +128,7 @@ func GetStockHandler(w http.ResponseWriter, r *http.Request) {
 	// Check for any active paid subscriptions
 	hasActive := false
 	for _, v := range vehicleResp.Payload.VehicleSubscriptions {
-		if active, ok := v["activePaidSubscriptions"].(bool); ok && active {
+		if v.ActivePaidSubscriptions {
 			hasActive = true
 			break
 		}
@@ -170,6 +137,9 @@ func GetStockHandler(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]interface{}{
 		"startDate":               startDate,
 		"endDate":                 endDate,
+		"startAsOf":               startAt,
+		"endAsOf":                 endAt,
+		"timezone":                loc.String(),
 		"activePaidSubscriptions": hasActive,
 		"vehiclePayload":          vehicleResp.Payload,
 		"vehicleStocks":           vehicleStocks,
@@ -180,3 +150,28 @@ func GetStockHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// stockLocation returns the configured stock timezone, falling back to UTC
+func stockLocation() *time.Location {
+	if config.AppConfig.StockTimezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(config.AppConfig.StockTimezone)
+	if err != nil {
+		log.Printf("Invalid stock_timezone %q, using UTC: %v", config.AppConfig.StockTimezone, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// resolveDateHeader resolves a required date header, recording an error if it is missing or malformed
+func resolveDateHeader(errs *validation.Errors, header, value string, loc *time.Location) time.Time {
+	if !validation.Required(errs, header, value) {
+		return time.Time{}
+	}
+	at, err := mongo.ResolveAsOf(value, loc)
+	if err != nil {
+		errs.Add(header, validation.CodeInvalid, "%s", err.Error())
+	}
+	return at
+}
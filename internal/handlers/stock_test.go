@@ -1,32 +1,67 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
 	"github.com/yourusername/vehicle-stock-service/internal/models"
 	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
 )
 
-func mockFindStockByTickerAndDate(database, collection, ticker, date string) (*models.StockData, error) {
-	return &models.StockData{Ticker: ticker, Bid: 100.0, Ask: 101.0, Time: date}, nil
+const testVehiclePayload = `{
+	"status": {"messages": [{"description": "Request Processed Successfully", "responseCode": "SUB-0000"}]},
+	"payload": {
+		"guid": "200d617c92c9a889cdda4c31559472f",
+		"vehicleSubscriptions": [
+			{"vehicleStatus": "SUBSCRIBED", "generation": "24MM", "region": "US", "vin": "AA450000007141513", "brand": "L", "activePaidSubscriptions": true},
+			{"vehicleStatus": "SUBSCRIBED", "generation": "24MM", "region": "CA", "vin": "AA450000007141573", "brand": "T", "activePaidSubscriptions": false},
+			{"vehicleStatus": "SUBSCRIBED", "generation": "24MM", "region": "CA", "vin": "AA450000007141603"}
+		]
+	}
+}`
+
+// staticSubs is a subscription source serving payload
+func staticSubs(payload string) subscription.SubscriptionSource {
+	return &subscription.StaticSource{JSON: payload}
+}
+
+type failingSource struct{}
+
+func (failingSource) Fetch(ctx context.Context) (*models.VehicleResponse, error) {
+	return nil, errors.New("upstream down")
+}
+
+// lookupStocks is a StockRepository whose FindAt calls lookup
+type lookupStocks struct {
+	mongo.StockRepository
+	lookup func(ticker string, at time.Time) (*models.StockData, error)
+}
+
+func (s lookupStocks) FindAt(ctx context.Context, ticker string, at time.Time) (*models.StockData, error) {
+	return s.lookup(ticker, at)
+}
+
+func mockFindAt(ticker string, at time.Time) (*models.StockData, error) {
+	return &models.StockData{Ticker: ticker, Bid: 100.0, Ask: 101.0, Time: at}, nil
 }
 
 func TestGetStockHandlerHappyPath(t *testing.T) {
-	// Patch mongo.FindStockByTickerAndDate
-	orig := mongo.FindStockByTickerAndDate
-	mongo.FindStockByTickerAndDate = mockFindStockByTickerAndDate
-	defer func() { mongo.FindStockByTickerAndDate = orig }()
+	h := NewStockHandlers(lookupStocks{lookup: mockFindAt}, staticSubs(testVehiclePayload), nil, nil, nil)
 
 	req := httptest.NewRequest("GET", "/getstock", nil)
 	req.Header.Set("startDate", "2025-08-01")
 	req.Header.Set("endDate", "2025-08-24")
 	rw := httptest.NewRecorder()
 
-	GetStockHandler(rw, req)
+	h.GetStockHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -50,16 +85,17 @@ func TestGetStockHandlerHappyPath(t *testing.T) {
 func TestGetStockHandlerMissingHeaders(t *testing.T) {
 	req := httptest.NewRequest("GET", "/getstock", nil)
 	rw := httptest.NewRecorder()
-	GetStockHandler(rw, req)
+	NewStockHandlers(nil, nil, nil, nil, nil).GetStockHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, []interface{}{"startDate", "endDate"}, fieldNames(body))
 }
 
 func TestGetStockHandlerInvalidJSON(t *testing.T) {
 	// Simulate invalid JSON by patching the handler to use a broken payload
-	orig := mongo.FindStockByTickerAndDate
-	mongo.FindStockByTickerAndDate = mockFindStockByTickerAndDate
-	defer func() { mongo.FindStockByTickerAndDate = orig }()
+	h := NewStockHandlers(lookupStocks{lookup: mockFindAt}, staticSubs(testVehiclePayload), nil, nil, nil)
 
 	// Temporarily replace the jsonInput in the handler (requires refactor for full testability)
 	// Instead, test by sending a request with missing headers to trigger error branch
@@ -70,24 +106,23 @@ func TestGetStockHandlerInvalidJSON(t *testing.T) {
 
 	// Directly call handler, expecting 200 OK since the payload is hardcoded and always valid
 	// To truly test invalid JSON, refactor handler to accept payload as parameter
-	GetStockHandler(rw, req)
+	h.GetStockHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
 func TestGetStockHandlerNoStockData(t *testing.T) {
-	orig := mongo.FindStockByTickerAndDate
-	mongo.FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
+	subs := staticSubs(testVehiclePayload)
+	h := NewStockHandlers(lookupStocks{lookup: func(ticker string, at time.Time) (*models.StockData, error) {
 		return nil, nil
-	}
-	defer func() { mongo.FindStockByTickerAndDate = orig }()
+	}}, subs, nil, nil, nil)
 
 	req := httptest.NewRequest("GET", "/getstock", nil)
 	req.Header.Set("startDate", "2025-08-01")
 	req.Header.Set("endDate", "2025-08-24")
 	rw := httptest.NewRecorder()
 
-	GetStockHandler(rw, req)
+	h.GetStockHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -106,34 +141,133 @@ func TestGetStockHandlerNoStockData(t *testing.T) {
 }
 
 func TestGetStockHandlerNoActivePaidSubscriptions(t *testing.T) {
-	orig := mongo.FindStockByTickerAndDate
-	mongo.FindStockByTickerAndDate = mockFindStockByTickerAndDate
-	defer func() { mongo.FindStockByTickerAndDate = orig }()
-
-	// Patch vehiclePayloadSource to return no activePaidSubscriptions
-	origPayload := vehiclePayloadSource
-	vehiclePayloadSource = func() string {
-		return `{
-			"status": {"messages": [{"description": "Request Processed Successfully"}]},
-			"payload": {
-				"guid": "test-guid",
-				"vehicleSubscriptions": [
-					{"vin": "VIN1", "region": "US", "activePaidSubscriptions": false},
-					{"vin": "VIN2", "region": "CA", "activePaidSubscriptions": false}
-				]
-			}
-		}`
-	}
-	defer func() { vehiclePayloadSource = origPayload }()
+	// A subscription source with no activePaidSubscriptions
+	subs := staticSubs(`{
+		"status": {"messages": [{"description": "Request Processed Successfully"}]},
+		"payload": {
+			"guid": "test-guid",
+			"vehicleSubscriptions": [
+				{"vin": "VIN1", "region": "US", "activePaidSubscriptions": false},
+				{"vin": "VIN2", "region": "CA", "activePaidSubscriptions": false}
+			]
+		}
+	}`)
+	h := NewStockHandlers(lookupStocks{lookup: mockFindAt}, subs, nil, nil, nil)
 
 	req := httptest.NewRequest("GET", "/getstock", nil)
 	req.Header.Set("startDate", "2025-08-01")
 	req.Header.Set("endDate", "2025-08-24")
 	rw := httptest.NewRecorder()
 
-	GetStockHandler(rw, req)
+	h.GetStockHandler(rw, req)
 	resp := rw.Result()
 	var body map[string]interface{}
 	json.NewDecoder(resp.Body).Decode(&body)
 	assert.False(t, body["activePaidSubscriptions"].(bool))
 }
+
+func TestGetStockHandlerSourceError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/getstock", nil)
+	req.Header.Set("startDate", "2025-08-01")
+	req.Header.Set("endDate", "2025-08-24")
+	rw := httptest.NewRecorder()
+
+	NewStockHandlers(nil, failingSource{}, nil, nil, nil).GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusBadGateway, rw.Result().StatusCode)
+}
+
+func TestGetStockHandlerNoSource(t *testing.T) {
+	req := httptest.NewRequest("GET", "/getstock", nil)
+	req.Header.Set("startDate", "2025-08-01")
+	req.Header.Set("endDate", "2025-08-24")
+	rw := httptest.NewRecorder()
+
+	NewStockHandlers(nil, nil, nil, nil, nil).GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusInternalServerError, rw.Result().StatusCode)
+}
+
+func TestGetStockHandlerUsesSourceVINs(t *testing.T) {
+	var tickers []string
+	subs := staticSubs(`{"payload":{"vehicleSubscriptions":[{"vin":"VINONLY","activePaidSubscriptions":true}]}}`)
+	h := NewStockHandlers(lookupStocks{lookup: func(ticker string, at time.Time) (*models.StockData, error) {
+		tickers = append(tickers, ticker)
+		return nil, nil
+	}}, subs, nil, nil, nil)
+
+	req := httptest.NewRequest("GET", "/getstock", nil)
+	req.Header.Set("startDate", "2025-08-01")
+	req.Header.Set("endDate", "2025-08-24")
+	rw := httptest.NewRecorder()
+
+	h.GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Result().StatusCode)
+	assert.Equal(t, []string{"VEHICLE-VINONLY", "VEHICLE-VINONLY"}, tickers)
+}
+
+func TestGetStockHandlerResolvesDateOnlyInTimezone(t *testing.T) {
+	var lookups []time.Time
+	subs := staticSubs(`{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","activePaidSubscriptions":true}]}}`)
+	h := NewStockHandlers(lookupStocks{lookup: func(ticker string, at time.Time) (*models.StockData, error) {
+		lookups = append(lookups, at)
+		return &models.StockData{Ticker: ticker, Bid: 100, Ask: 101, Time: at}, nil
+	}}, subs, nil, nil, nil)
+	origTZ := config.AppConfig.StockTimezone
+	config.AppConfig.StockTimezone = "America/Toronto"
+	defer func() { config.AppConfig.StockTimezone = origTZ }()
+
+	req := httptest.NewRequest("GET", "/getstock", nil)
+	req.Header.Set("startDate", "2025-08-01")
+	req.Header.Set("endDate", "2025-08-24T10:00:00Z")
+	rw := httptest.NewRecorder()
+	h.GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	assert.Len(t, lookups, 2)
+	// End of 2025-08-01 in Toronto (EDT, UTC-4) is 2025-08-02T03:59:59.999Z
+	assert.Equal(t, time.Date(2025, 8, 2, 3, 59, 59, 999000000, time.UTC), lookups[0].UTC())
+	assert.Equal(t, time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC), lookups[1].UTC())
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+	assert.Equal(t, "America/Toronto", body["timezone"])
+}
+
+func TestGetStockHandlerInvalidDate(t *testing.T) {
+	h := NewStockHandlers(nil, staticSubs(testVehiclePayload), nil, nil, nil)
+	req := httptest.NewRequest("GET", "/getstock", nil)
+	req.Header.Set("startDate", "yesterday")
+	req.Header.Set("endDate", "2025-08-24")
+	rw := httptest.NewRecorder()
+	h.GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	req.Header.Set("startDate", "2025-08-01")
+	req.Header.Set("endDate", "08/24/2025")
+	rw = httptest.NewRecorder()
+	h.GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestStockLocationFallsBackToUTC(t *testing.T) {
+	origTZ := config.AppConfig.StockTimezone
+	defer func() { config.AppConfig.StockTimezone = origTZ }()
+
+	config.AppConfig.StockTimezone = ""
+	assert.Equal(t, time.UTC, stockLocation())
+	config.AppConfig.StockTimezone = "Not/AZone"
+	assert.Equal(t, time.UTC, stockLocation())
+	config.AppConfig.StockTimezone = "America/Vancouver"
+	assert.Equal(t, "America/Vancouver", stockLocation().String())
+}
+
+func TestGetStockHandlerNoRepository(t *testing.T) {
+	h := NewStockHandlers(nil, staticSubs(testVehiclePayload), nil, nil, nil)
+
+	req := httptest.NewRequest("GET", "/getstock", nil)
+	req.Header.Set("startDate", "2025-08-01")
+	req.Header.Set("endDate", "2025-08-24")
+	rw := httptest.NewRecorder()
+
+	h.GetStockHandler(rw, req)
+	assert.Equal(t, http.StatusInternalServerError, rw.Result().StatusCode)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/stream"
+)
+
+// StreamKeepAlive is how often an idle /stream/stock connection receives a comment line
+var StreamKeepAlive = 15 * time.Second
+
+// streamBuffer is the number of updates a slow client may lag behind before it misses some
+const streamBuffer = 64
+
+// StockStreamHandler handles GET /stream/stock, sending every new tick as a
+// Server-Sent Event "tick". The vin, region and brand query parameters take
+// one or more comma-separated values and narrow the feed.
+func (h *StockHandlers) StockStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if h.live == nil {
+		http.Error(w, "Live stock feed not configured", http.StatusInternalServerError)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := stream.Filter{VINs: queryList(q, "vin"), Regions: queryList(q, "region"), Brands: queryList(q, "brand")}
+	sub, err := h.live.Subscribe(filter, streamBuffer)
+	if err != nil {
+		http.Error(w, "Live stock feed closed", http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(StreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case u, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(u)
+			fmt.Fprintf(w, "event: tick\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// queryList collects the comma-separated values of every key parameter
+func queryList(q url.Values, key string) []string {
+	var out []string
+	for _, v := range q[key] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// StockSocketHandler handles GET /ws/stock. Clients send
+// {"action": "subscribe"|"unsubscribe", "vins": [...]} and receive
+// {"type": "tick", "tick": {...}} for every tick of their VINs.
+func (h *StockHandlers) StockSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if h.sockets == nil {
+		http.Error(w, "WebSocket hub not configured", http.StatusInternalServerError)
+		return
+	}
+	h.sockets.ServeHTTP(w, r)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/stream"
+)
+
+func TestStockStreamHandler(t *testing.T) {
+	origKeepAlive := StreamKeepAlive
+	defer func() { StreamKeepAlive = origKeepAlive }()
+	StreamKeepAlive = 20 * time.Millisecond
+	live := stream.NewHub()
+
+	srv := httptest.NewServer(http.HandlerFunc(NewStockHandlers(nil, nil, nil, live, nil).StockStreamHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/stream/stock?vin=VIN1,VIN2&region=us")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewReader(resp.Body)
+	line, _ := lines.ReadString('\n')
+	assert.Equal(t, ": connected\n", line)
+	assert.Equal(t, 1, live.Subscribers())
+
+	at := time.Date(2025, 8, 1, 10, 0, 0, 0, time.UTC)
+	live.Publish(stream.Update{VIN: "VIN3", Region: "US", Ticker: "VEHICLE-VIN3", Time: at})
+	live.Publish(stream.Update{VIN: "VIN2", Region: "CA", Ticker: "VEHICLE-VIN2", Time: at})
+	live.Publish(stream.Update{VIN: "VIN1", Region: "US", Ticker: "VEHICLE-VIN1", Bid: 100, Ask: 101, Time: at})
+
+	var event []string
+	for len(event) < 2 {
+		line, err := lines.ReadString('\n')
+		assert.NoError(t, err)
+		if strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "data:") {
+			event = append(event, strings.TrimSpace(line))
+		}
+	}
+	assert.Equal(t, []string{
+		"event: tick",
+		`data: {"vin":"VIN1","region":"US","ticker":"VEHICLE-VIN1","bid":100,"ask":101,"time":"2025-08-01T10:00:00Z"}`,
+	}, event)
+
+	// Idle connections get keep-alive comments; closing the hub ends the response
+	for {
+		line, err := lines.ReadString('\n')
+		assert.NoError(t, err)
+		if line == ": keepalive\n" {
+			break
+		}
+	}
+	live.Close()
+	assert.Eventually(t, func() bool {
+		_, err := lines.ReadString('\n')
+		return err != nil
+	}, time.Second, time.Millisecond)
+}
+
+func TestStockStreamHandlerUnavailable(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewStockHandlers(nil, nil, nil, nil, nil).StockStreamHandler(rr, httptest.NewRequest("GET", "/stream/stock", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	live := stream.NewHub()
+	live.Close()
+	rr = httptest.NewRecorder()
+	NewStockHandlers(nil, nil, nil, live, nil).StockStreamHandler(rr, httptest.NewRequest("GET", "/stream/stock", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
@@ -2,62 +2,416 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
 	"net/http"
-	"os"
+	"slices"
+	"strings"
 
+	"github.com/gorilla/mux"
 	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/paymentintent"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
 )
 
-// HoldPaymentRequest is the expected input for /holdpayment
-// Example: {"amount": 1000, "currency": "usd", "payment_method": "pm_xxx"}
+// HoldPaymentRequest is the expected input for /holdpayment.
+// The optional vin selects the Stripe account of the vehicle's region.
+// Example: {"amount": 1000, "currency": "usd", "payment_method": "pm_xxx", "vin": "AA450000007141513"}
 type HoldPaymentRequest struct {
 	Amount        int64  `json:"amount"`
 	Currency      string `json:"currency"`
 	PaymentMethod string `json:"payment_method"`
+	VIN           string `json:"vin,omitempty"`
 }
 
-// PaymentIntentNew is a function variable for testability
-var PaymentIntentNew = paymentintent.New
+// CaptureHoldRequest is the optional input for /holdpayment/{id}/capture.
+// Omitting amount_to_capture captures the full held amount.
+type CaptureHoldRequest struct {
+	AmountToCapture *int64 `json:"amount_to_capture,omitempty"`
+}
+
+// CancelHoldRequest is the optional input for /holdpayment/{id}/cancel
+type CancelHoldRequest struct {
+	CancellationReason string `json:"cancellation_reason,omitempty"`
+}
+
+// PaymentHandlers serves the payment hold, refund, reservation and Stripe
+// webhook endpoints
+type PaymentHandlers struct {
+	payments  *payments.Service
+	subs      subscription.SubscriptionSource
+	validator *validation.Validator
+	events    EventPublisher
+}
+
+// NewPaymentHandlers returns the payment handlers. Stripe calls go to the
+// account of a vehicle's region in svc, and vehicles are looked up in subs.
+// Payment currencies and amounts are checked by validator, the built-in
+// limits when nil. Webhook and refund events are delivered to events; without
+// it they are dropped.
+func NewPaymentHandlers(svc *payments.Service, subs subscription.SubscriptionSource, validator *validation.Validator, events EventPublisher) *PaymentHandlers {
+	if validator == nil {
+		validator = validation.Default()
+	}
+	return &PaymentHandlers{payments: svc, subs: subs, validator: validator, events: events}
+}
+
+// cancelableStatuses are the PaymentIntent states Stripe allows cancelling from
+var cancelableStatuses = map[stripe.PaymentIntentStatus]bool{
+	stripe.PaymentIntentStatusRequiresPaymentMethod: true,
+	stripe.PaymentIntentStatusRequiresConfirmation:  true,
+	stripe.PaymentIntentStatusRequiresAction:        true,
+	stripe.PaymentIntentStatusRequiresCapture:       true,
+	stripe.PaymentIntentStatusProcessing:            true,
+}
 
-// HoldPaymentHandler places a hold on a payment method using Stripe manual capture
-func HoldPaymentHandler(w http.ResponseWriter, r *http.Request) {
+// cancellationReasons are the reasons a client may give when releasing a hold
+var cancellationReasons = []string{
+	string(stripe.PaymentIntentCancellationReasonDuplicate),
+	string(stripe.PaymentIntentCancellationReasonFraudulent),
+	string(stripe.PaymentIntentCancellationReasonRequestedByCustomer),
+	string(stripe.PaymentIntentCancellationReasonAbandoned),
+}
+
+// HoldPaymentHandler places a hold on a payment method using Stripe manual capture.
+// With an Idempotency-Key header the key, scoped to the endpoint, is forwarded
+// to Stripe and retries with the same key and body replay the original response.
+func (h *PaymentHandlers) HoldPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	var req HoldPaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		writeBodyError(w, err)
+		return
+	}
+	var errs validation.Errors
+	h.validateHold(&errs, &req)
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
 		return
 	}
 
-	stripe.Key = os.Getenv("STRIPE_KEY")
-	if stripe.Key == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Stripe key not set"})
+	var region string
+	if req.VIN != "" {
+		v, status, err := h.findVehicle(r.Context(), req.VIN)
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		region = v.Region
+	}
+	intents, ok := h.paymentIntents(w, region)
+	if !ok {
+		return
+	}
+
+	key, actor := r.Header.Get(idempotencyHeader), requestActor(r)
+	if key == "" {
+		status, body := holdPayment(intents, req, region, "", actor)
+		writeJSON(w, status, body)
 		return
 	}
+	withIdempotency(w, "holdpayment", key, req, func(stripeKey string) (int, interface{}) {
+		return holdPayment(intents, req, region, stripeKey, actor)
+	})
+}
+
+// validateHold checks a hold request and normalizes its currency
+func (h *PaymentHandlers) validateHold(errs *validation.Errors, req *HoldPaymentRequest) {
+	req.Currency = h.validator.Currency(errs, "currency", req.Currency)
+	h.validator.Amount(errs, "amount", req.Amount, req.Currency)
+	validation.PaymentMethod(errs, "payment_method", req.PaymentMethod)
+}
+
+// holdPayment creates the hold, records it in the ledger and returns the response status and body
+func holdPayment(intents payments.Intents, req HoldPaymentRequest, region, idempotencyKey, actor string) (int, interface{}) {
+	var metadata map[string]string
+	if req.VIN != "" {
+		metadata = map[string]string{"vin": req.VIN}
+	}
+	pi, err := createHold(intents, req, region, metadata, idempotencyKey)
+	if err != nil {
+		recordFailedHold(err, actor)
+		return stripeErrorStatus(err), map[string]string{"error": err.Error()}
+	}
+	recordLedger(intentLedgerEntry(models.LedgerHold, pi, pi.Amount, actor))
+	recordPayment(pi)
+	resp := map[string]interface{}{
+		"payment_intent_id": pi.ID,
+		"status":            pi.Status,
+		"amount":            pi.Amount,
+		"currency":          pi.Currency,
+	}
+	if region != "" {
+		resp["region"] = region
+	}
+	return http.StatusOK, resp
+}
+
+// createHold creates and confirms a manual-capture PaymentIntent for req,
+// tagging it with the region whose account holds it
+func createHold(intents payments.Intents, req HoldPaymentRequest, region string, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, error) {
+	params := holdParams(req, region, metadata, idempotencyKey)
+	params.Confirm = stripe.Bool(true)
+	return intents.New(params)
+}
 
+// holdParams builds the unconfirmed manual-capture PaymentIntent for req
+func holdParams(req HoldPaymentRequest, region string, metadata map[string]string, idempotencyKey string) *stripe.PaymentIntentParams {
 	params := &stripe.PaymentIntentParams{
 		Amount:        stripe.Int64(req.Amount),
 		Currency:      stripe.String(req.Currency),
 		PaymentMethod: stripe.String(req.PaymentMethod),
 		CaptureMethod: stripe.String("manual"),
-		Confirm:       stripe.Bool(true),
 		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
 			Enabled:        stripe.Bool(true),
 			AllowRedirects: stripe.String("never"),
 		},
 	}
-	pi, err := PaymentIntentNew(params)
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+	if region != "" {
+		params.AddMetadata("region", region)
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+	return params
+}
+
+// GetHoldHandler returns the current state of a payment hold
+func (h *PaymentHandlers) GetHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	intents, ok := h.holdIntents(w, r, id)
+	if !ok {
+		return
+	}
+	pi, err := intents.Get(id, nil)
+	if err != nil {
+		writeStripeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, holdResponse(pi))
+}
+
+// CaptureHoldHandler captures a held PaymentIntent, fully or partially
+func (h *PaymentHandlers) CaptureHoldHandler(w http.ResponseWriter, r *http.Request) {
+	var req CaptureHoldRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+	if req.AmountToCapture != nil && *req.AmountToCapture <= 0 {
+		var errs validation.Errors
+		errs.Add("amount_to_capture", validation.CodeTooSmall, "amount_to_capture must be positive")
+		writeValidationError(w, errs)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	intents, ok := h.holdIntents(w, r, id)
+	if !ok {
+		return
+	}
+
+	pi, err := intents.Get(id, nil)
+	if err != nil {
+		writeStripeError(w, err)
+		return
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("payment intent %s is %s and cannot be captured", id, pi.Status))
+		return
+	}
+
+	params := &stripe.PaymentIntentCaptureParams{}
+	if req.AmountToCapture != nil {
+		var errs validation.Errors
+		validation.Range(&errs, "amount_to_capture", *req.AmountToCapture, 1, pi.AmountCapturable)
+		if len(errs) > 0 {
+			writeValidationError(w, errs)
+			return
+		}
+		params.AmountToCapture = stripe.Int64(*req.AmountToCapture)
+	}
+
+	captured, err := intents.Capture(id, params)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		writeStripeError(w, err)
 		return
 	}
+	recordLedger(intentLedgerEntry(models.LedgerCapture, captured, captured.AmountReceived, requestActor(r)))
+	recordPayment(captured)
+	writeJSON(w, http.StatusOK, holdResponse(captured))
+}
+
+// CancelHoldHandler releases a held PaymentIntent
+func (h *PaymentHandlers) CancelHoldHandler(w http.ResponseWriter, r *http.Request) {
+	var req CancelHoldRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+	if req.CancellationReason != "" {
+		var errs validation.Errors
+		validation.OneOf(&errs, "cancellation_reason", req.CancellationReason, cancellationReasons...)
+		if len(errs) > 0 {
+			writeValidationError(w, errs)
+			return
+		}
+	}
+	id := mux.Vars(r)["id"]
+	intents, ok := h.holdIntents(w, r, id)
+	if !ok {
+		return
+	}
+
+	pi, err := intents.Get(id, nil)
+	if err != nil {
+		writeStripeError(w, err)
+		return
+	}
+	if !cancelableStatuses[pi.Status] {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("payment intent %s is %s and cannot be canceled", id, pi.Status))
+		return
+	}
+
+	params := &stripe.PaymentIntentCancelParams{}
+	if req.CancellationReason != "" {
+		params.CancellationReason = stripe.String(req.CancellationReason)
+	}
+	canceled, err := intents.Cancel(id, params)
+	if err != nil {
+		writeStripeError(w, err)
+		return
+	}
+	recordLedger(intentLedgerEntry(models.LedgerCancel, canceled, canceled.Amount, requestActor(r)))
+	writeJSON(w, http.StatusOK, holdResponse(canceled))
+}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+// holdResponse is the JSON view of a PaymentIntent returned by the hold endpoints
+func holdResponse(pi *stripe.PaymentIntent) map[string]interface{} {
+	resp := map[string]interface{}{
 		"payment_intent_id": pi.ID,
 		"status":            pi.Status,
 		"amount":            pi.Amount,
+		"amount_capturable": pi.AmountCapturable,
+		"amount_received":   pi.AmountReceived,
 		"currency":          pi.Currency,
-	})
+	}
+	if pi.CancellationReason != "" {
+		resp["cancellation_reason"] = pi.CancellationReason
+	}
+	if region := pi.Metadata["region"]; region != "" {
+		resp["region"] = region
+	}
+	return resp
+}
+
+// paymentIntents returns the Stripe client for region, writing a 500 if no account serves it
+func (h *PaymentHandlers) paymentIntents(w http.ResponseWriter, region string) (payments.Intents, bool) {
+	intents, err := h.payments.Intents(region)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return intents, true
+}
+
+// holdIntents returns the Stripe client of the account holding PaymentIntent
+// id, writing an error if it cannot be found
+func (h *PaymentHandlers) holdIntents(w http.ResponseWriter, r *http.Request, id string) (payments.Intents, bool) {
+	region, status, err := h.holdRegion(id, r.URL.Query().Get("region"))
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return nil, false
+	}
+	return h.paymentIntents(w, region)
+}
+
+// holdRegion returns the region whose Stripe account holds PaymentIntent id.
+// The region is taken from the hold's ledger entry; for holds missing from
+// the ledger the account is found by asking Stripe, only in the account of
+// claimed when set. claimed, the client's ?region=, must match the hold's
+// region and is never used as a fallback.
+func (h *PaymentHandlers) holdRegion(id, claimed string) (string, int, error) {
+	e, err := mongo.FindLedgerEntry(config.AppConfig.MongoDB, config.AppConfig.LedgerCollection(), models.LedgerHold+":"+id)
+	switch {
+	case err == nil:
+		if claimed != "" && !strings.EqualFold(claimed, e.Region) {
+			return "", http.StatusBadRequest, fmt.Errorf("payment intent %s is not held in region %q", id, claimed)
+		}
+		return e.Region, http.StatusOK, nil
+	case !errors.Is(err, mongo.ErrLedgerEntryNotFound):
+		// Stripe still knows the account, so a ledger outage only costs lookups
+		log.Printf("Loading ledger entry of %s failed: %v", id, err)
+	}
+
+	candidates := []string{claimed}
+	if claimed == "" {
+		candidates = slices.Sorted(maps.Keys(h.payments.Accounts()))
+		if len(candidates) == 0 {
+			return "", http.StatusInternalServerError, payments.ErrNotConfigured
+		}
+	} else if _, err := h.payments.Account(claimed); err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	for _, region := range candidates {
+		intents, err := h.payments.Intents(region)
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		pi, err := intents.Get(id, nil)
+		if status := stripeErrorStatus(err); err != nil && status != http.StatusNotFound {
+			return "", status, err
+		}
+		if err != nil {
+			continue
+		}
+		if held := pi.Metadata["region"]; claimed != "" && held != "" && !strings.EqualFold(claimed, held) {
+			return "", http.StatusBadRequest, fmt.Errorf("payment intent %s is not held in region %q", id, claimed)
+		}
+		return region, http.StatusOK, nil
+	}
+	return "", http.StatusNotFound, fmt.Errorf("payment intent %s not found", id)
+}
+
+// decodeOptionalBody decodes a JSON body into v, accepting an empty body
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeStripeError maps a Stripe API error to an HTTP status and JSON error body
+func writeStripeError(w http.ResponseWriter, err error) {
+	writeJSONError(w, stripeErrorStatus(err), err.Error())
+}
+
+// stripeErrorStatus maps Stripe errors to a status. Outages and rate limits map
+// to 5xx so that idempotent requests are not recorded and can be retried.
+func stripeErrorStatus(err error) int {
+	var serr *stripe.Error
+	if !errors.As(err, &serr) {
+		return http.StatusBadGateway
+	}
+	switch {
+	case serr.Code == stripe.ErrorCodeResourceMissing || serr.HTTPStatusCode == http.StatusNotFound:
+		return http.StatusNotFound
+	case serr.Code == stripe.ErrorCodePaymentIntentUnexpectedState || serr.Code == stripe.ErrorCodeChargeAlreadyRefunded:
+		return http.StatusConflict
+	case serr.Code == stripe.ErrorCodeRateLimit || serr.HTTPStatusCode == http.StatusTooManyRequests:
+		return http.StatusServiceUnavailable
+	case serr.Type == stripe.ErrorTypeCard:
+		return http.StatusPaymentRequired
+	case serr.Type == stripe.ErrorTypeInvalidRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
 }
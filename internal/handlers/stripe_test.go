@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
 )
 
 func mockPaymentIntentNew(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
@@ -21,11 +25,14 @@ func mockPaymentIntentNew(params *stripe.PaymentIntentParams) (*stripe.PaymentIn
 	}, nil
 }
 
+// stripeNewHandlers serves the payment endpoints from a fake default Stripe account whose New calls fn
+func stripeNewHandlers(fn func(*stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)) (*PaymentHandlers, *fakeHolds) {
+	f := &fakeHolds{pi: heldIntent(), newFn: fn}
+	return fakeHoldHandlers(f, nil), f
+}
+
 func TestHoldPaymentHandlerHappyPath(t *testing.T) {
-	os.Setenv("STRIPE_KEY", "sk_test_123")
-	orig := PaymentIntentNew
-	PaymentIntentNew = mockPaymentIntentNew
-	defer func() { PaymentIntentNew = orig }()
+	h, _ := stripeNewHandlers(mockPaymentIntentNew)
 
 	body := HoldPaymentRequest{
 		Amount:        1000,
@@ -35,7 +42,7 @@ func TestHoldPaymentHandlerHappyPath(t *testing.T) {
 	b, _ := json.Marshal(body)
 	req := httptest.NewRequest("POST", "/holdpayment", bytes.NewReader(b))
 	rw := httptest.NewRecorder()
-	HoldPaymentHandler(rw, req)
+	h.HoldPaymentHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -49,37 +56,330 @@ func TestHoldPaymentHandlerHappyPath(t *testing.T) {
 }
 
 func TestHoldPaymentHandlerInvalidBody(t *testing.T) {
-	os.Setenv("STRIPE_KEY", "sk_test_123")
+	h, _ := stripeNewHandlers(mockPaymentIntentNew)
 	req := httptest.NewRequest("POST", "/holdpayment", bytes.NewReader([]byte("invalid-json")))
 	rw := httptest.NewRecorder()
-	HoldPaymentHandler(rw, req)
+	h.HoldPaymentHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
+// fieldNames lists the fields of a validation error response in order
+func fieldNames(resp map[string]interface{}) []interface{} {
+	var names []interface{}
+	fields, _ := resp["fields"].([]interface{})
+	for _, f := range fields {
+		names = append(names, f.(map[string]interface{})["field"])
+	}
+	return names
+}
+
+func TestHoldPaymentHandlerValidation(t *testing.T) {
+	h, f := stripeNewHandlers(mockPaymentIntentNew)
+
+	cases := map[string][]interface{}{
+		`{}`: {"currency", "amount", "payment_method"},
+		`{"amount": 0, "currency": "usd", "payment_method": "pm_1"}`:         {"amount"},
+		`{"amount": 49, "currency": "usd", "payment_method": "pm_1"}`:        {"amount"},
+		`{"amount": 100000000, "currency": "usd", "payment_method": "pm_1"}`: {"amount"},
+		`{"amount": 1000, "currency": "xyz", "payment_method": "pm_1"}`:      {"currency"},
+		`{"amount": 1000, "currency": "usd", "payment_method": "card_1"}`:    {"payment_method"},
+		`{"amount": "1000", "currency": "usd", "payment_method": "pm_1"}`:    {"amount"},
+	}
+	for body, fields := range cases {
+		rw, resp := doHoldRequest(h.HoldPaymentHandler, "POST", body)
+		assert.Equal(t, http.StatusBadRequest, rw.Code, body)
+		assert.Equal(t, fields, fieldNames(resp), body)
+	}
+	assert.Empty(t, f.created)
+
+	// Currency codes are normalized before reaching Stripe
+	rw, _ := doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 1000, "currency": " USD ", "payment_method": "pm_1"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "usd", *f.created[0].Currency)
+}
+
+func TestHoldPaymentHandlerUsesConfiguredCurrencies(t *testing.T) {
+	h, _ := stripeNewHandlers(mockPaymentIntentNew)
+	v, err := validation.New(map[string]config.CurrencyLimits{"jpy": {Max: 100000}})
+	assert.NoError(t, err)
+	h.validator = v
+
+	rw, _ := doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 5000, "currency": "jpy", "payment_method": "pm_1"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	rw, resp := doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 200000, "currency": "jpy", "payment_method": "pm_1"}`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, []interface{}{"amount"}, fieldNames(resp))
+	rw, resp = doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 1000, "currency": "usd", "payment_method": "pm_1"}`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, []interface{}{"currency"}, fieldNames(resp))
+}
+
 func TestHoldPaymentHandlerMissingStripeKey(t *testing.T) {
-	os.Unsetenv("STRIPE_KEY")
+	h := NewPaymentHandlers(nil, nil, nil, nil)
 	body := HoldPaymentRequest{Amount: 1000, Currency: "usd", PaymentMethod: "pm_test_123"}
 	b, _ := json.Marshal(body)
 	req := httptest.NewRequest("POST", "/holdpayment", bytes.NewReader(b))
 	rw := httptest.NewRecorder()
-	HoldPaymentHandler(rw, req)
+	h.HoldPaymentHandler(rw, req)
 	resp := rw.Result()
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
 }
 
 func TestHoldPaymentHandlerStripeError(t *testing.T) {
-	os.Setenv("STRIPE_KEY", "sk_test_123")
-	orig := PaymentIntentNew
-	PaymentIntentNew = func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	h, _ := stripeNewHandlers(func(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
 		return nil, assert.AnError
-	}
-	defer func() { PaymentIntentNew = orig }()
+	})
 	body := HoldPaymentRequest{Amount: 1000, Currency: "usd", PaymentMethod: "pm_test_123"}
 	b, _ := json.Marshal(body)
 	req := httptest.NewRequest("POST", "/holdpayment", bytes.NewReader(b))
 	rw := httptest.NewRecorder()
-	HoldPaymentHandler(rw, req)
+	h.HoldPaymentHandler(rw, req)
 	resp := rw.Result()
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+// fakeHolds is an in-memory payments.Intents around a single PaymentIntent
+type fakeHolds struct {
+	pi            *stripe.PaymentIntent
+	newFn         func(*stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	getErr        error
+	confirmFn     func(id string) error
+	created       []*stripe.PaymentIntentParams
+	captureParams *stripe.PaymentIntentCaptureParams
+	cancelParams  *stripe.PaymentIntentCancelParams
+}
+
+func (f *fakeHolds) New(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	f.created = append(f.created, params)
+	if f.newFn != nil {
+		return f.newFn(params)
+	}
+	return f.pi, nil
+}
+
+func (f *fakeHolds) Get(id string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	return f.pi, nil
+}
+
+func (f *fakeHolds) Confirm(id string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error) {
+	if f.confirmFn != nil {
+		if err := f.confirmFn(id); err != nil {
+			return nil, err
+		}
+	}
+	return f.pi, nil
+}
+
+func (f *fakeHolds) Capture(id string, params *stripe.PaymentIntentCaptureParams) (*stripe.PaymentIntent, error) {
+	f.captureParams = params
+	amount := f.pi.AmountCapturable
+	if params.AmountToCapture != nil {
+		amount = *params.AmountToCapture
+	}
+	f.pi.Status = stripe.PaymentIntentStatusSucceeded
+	f.pi.AmountReceived = amount
+	f.pi.AmountCapturable = 0
+	return f.pi, nil
+}
+
+func (f *fakeHolds) Cancel(id string, params *stripe.PaymentIntentCancelParams) (*stripe.PaymentIntent, error) {
+	f.cancelParams = params
+	f.pi.Status = stripe.PaymentIntentStatusCanceled
+	if params.CancellationReason != nil {
+		f.pi.CancellationReason = stripe.PaymentIntentCancellationReason(*params.CancellationReason)
+	}
+	return f.pi, nil
+}
+
+// fakeHoldHandlers serves the payment endpoints from f as the default Stripe
+// account, looking vehicles up in subs
+func fakeHoldHandlers(f *fakeHolds, subs subscription.SubscriptionSource) *PaymentHandlers {
+	return NewPaymentHandlers(payments.NewWithIntents(nil, f), subs, nil, nil)
+}
+
+// regionHandlers serves the payment endpoints from separate fake US and CA
+// Stripe accounts with no default
+func regionHandlers(subs subscription.SubscriptionSource) (h *PaymentHandlers, us, ca *fakeHolds) {
+	us, ca = &fakeHolds{pi: heldIntent()}, &fakeHolds{pi: heldIntent()}
+	h = NewPaymentHandlers(payments.NewWithIntents(map[string]payments.Intents{"US": us, "CA": ca}, nil), subs, nil, nil)
+	return h, us, ca
+}
+
+func heldIntent() *stripe.PaymentIntent {
+	return &stripe.PaymentIntent{ID: "pi_test_123", Status: stripe.PaymentIntentStatusRequiresCapture, Amount: 1000, AmountCapturable: 1000, Currency: "usd"}
+}
+
+func doHoldRequest(handler http.HandlerFunc, method, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, "/holdpayment/pi_test_123", bytes.NewReader([]byte(body)))
+	req = mux.SetURLVars(req, map[string]string{"id": "pi_test_123"})
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw, resp
+}
+
+func TestGetHoldHandler(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	h := fakeHoldHandlers(f, nil)
+
+	rw, resp := doHoldRequest(h.GetHoldHandler, "GET", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.Equal(t, "pi_test_123", resp["payment_intent_id"])
+	assert.Equal(t, "requires_capture", resp["status"])
+	assert.Equal(t, float64(1000), resp["amount_capturable"])
+
+	f.getErr = &stripe.Error{Code: stripe.ErrorCodeResourceMissing, HTTPStatusCode: http.StatusNotFound, Msg: "No such payment_intent"}
+	rw, resp = doHoldRequest(h.GetHoldHandler, "GET", "")
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	assert.NotEmpty(t, resp["error"])
+}
+
+func TestCaptureHoldHandlerFull(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	h := fakeHoldHandlers(f, nil)
+
+	rw, resp := doHoldRequest(h.CaptureHoldHandler, "POST", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Nil(t, f.captureParams.AmountToCapture)
+	assert.Equal(t, "succeeded", resp["status"])
+	assert.Equal(t, float64(1000), resp["amount_received"])
+}
+
+func TestCaptureHoldHandlerPartial(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	h := fakeHoldHandlers(f, nil)
+
+	rw, resp := doHoldRequest(h.CaptureHoldHandler, "POST", `{"amount_to_capture": 400}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, int64(400), *f.captureParams.AmountToCapture)
+	assert.Equal(t, float64(400), resp["amount_received"])
+}
+
+func TestCaptureHoldHandlerValidation(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	h := fakeHoldHandlers(f, nil)
+
+	for _, body := range []string{`{"amount_to_capture": 0}`, `{"amount_to_capture": -5}`, `{"amount_to_capture": 1001}`, `{"amount_to_capture": "all"}`} {
+		rw, resp := doHoldRequest(h.CaptureHoldHandler, "POST", body)
+		assert.Equal(t, http.StatusBadRequest, rw.Code, body)
+		assert.Equal(t, []interface{}{"amount_to_capture"}, fieldNames(resp), body)
+	}
+	rw, resp := doHoldRequest(h.CaptureHoldHandler, "POST", `not-json`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, []interface{}{"body"}, fieldNames(resp))
+	assert.Nil(t, f.captureParams)
+}
+
+func TestCaptureHoldHandlerInvalidState(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	f.pi.Status = stripe.PaymentIntentStatusCanceled
+	h := fakeHoldHandlers(f, nil)
+
+	rw, resp := doHoldRequest(h.CaptureHoldHandler, "POST", "")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, resp["error"], "canceled")
+	assert.Nil(t, f.captureParams)
+}
+
+func TestCancelHoldHandler(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	h := fakeHoldHandlers(f, nil)
+
+	rw, resp := doHoldRequest(h.CancelHoldHandler, "POST", `{"cancellation_reason": "requested_by_customer"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "canceled", resp["status"])
+	assert.Equal(t, "requested_by_customer", resp["cancellation_reason"])
+
+	// A canceled hold cannot be canceled again
+	rw, _ = doHoldRequest(h.CancelHoldHandler, "POST", "")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+}
+
+func TestCancelHoldHandlerRejects(t *testing.T) {
+	f := &fakeHolds{pi: heldIntent()}
+	h := fakeHoldHandlers(f, nil)
+
+	rw, resp := doHoldRequest(h.CancelHoldHandler, "POST", `{"cancellation_reason": "because"}`)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Equal(t, []interface{}{"cancellation_reason"}, fieldNames(resp))
+
+	f.pi.Status = stripe.PaymentIntentStatusSucceeded
+	rw, resp = doHoldRequest(h.CancelHoldHandler, "POST", "")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Contains(t, resp["error"], "succeeded")
+	assert.Nil(t, f.cancelParams)
+}
+
+func TestHoldHandlersMissingStripeKey(t *testing.T) {
+	h := NewPaymentHandlers(nil, nil, nil, nil)
+	for _, handler := range []http.HandlerFunc{h.GetHoldHandler, h.CaptureHoldHandler, h.CancelHoldHandler} {
+		rw, resp := doHoldRequest(handler, "POST", "")
+		assert.Equal(t, http.StatusInternalServerError, rw.Code)
+		assert.Equal(t, payments.ErrNotConfigured.Error(), resp["error"])
+	}
+}
+
+func TestHoldPaymentHandlerRoutesByVehicleRegion(t *testing.T) {
+	h, us, ca := regionHandlers(staticSubs(testVehiclePayload))
+
+	rw, resp := doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 1000, "currency": "cad", "payment_method": "pm_1", "vin": "AA450000007141573"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "CA", resp["region"])
+	assert.Empty(t, us.created)
+	assert.Len(t, ca.created, 1)
+	assert.Equal(t, "AA450000007141573", ca.created[0].Metadata["vin"])
+	assert.Equal(t, "CA", ca.created[0].Metadata["region"])
+
+	rw, _ = doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 1000, "currency": "usd", "payment_method": "pm_1", "vin": "AA450000007141513"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Len(t, us.created, 1)
+
+	rw, _ = doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 1000, "currency": "usd", "payment_method": "pm_1", "vin": "UNKNOWN"}`)
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	// Without a VIN or a default account there is no Stripe account to use
+	rw, _ = doHoldRequest(h.HoldPaymentHandler, "POST", `{"amount": 1000, "currency": "usd", "payment_method": "pm_1"}`)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestHoldHandlersSelectRegionFromQuery(t *testing.T) {
+	h, us, ca := regionHandlers(nil)
+	ca.pi.Metadata = map[string]string{"region": "CA"}
+
+	req := httptest.NewRequest("POST", "/holdpayment/pi_test_123/capture?region=ca", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "pi_test_123"})
+	rw := httptest.NewRecorder()
+	h.CaptureHoldHandler(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.NotNil(t, ca.captureParams)
+	assert.Nil(t, us.captureParams)
+
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	assert.Equal(t, "CA", resp["region"])
+
+	// Regions without an account are rejected rather than falling back
+	req = httptest.NewRequest("POST", "/holdpayment/pi_test_123/cancel?region=mx", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "pi_test_123"})
+	rw = httptest.NewRecorder()
+	h.CancelHoldHandler(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Nil(t, ca.cancelParams)
+	assert.Nil(t, us.cancelParams)
+}
+
+func TestStripeErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, stripeErrorStatus(&stripe.Error{Code: stripe.ErrorCodeResourceMissing}))
+	assert.Equal(t, http.StatusConflict, stripeErrorStatus(&stripe.Error{Code: stripe.ErrorCodePaymentIntentUnexpectedState, Type: stripe.ErrorTypeInvalidRequest}))
+	assert.Equal(t, http.StatusPaymentRequired, stripeErrorStatus(&stripe.Error{Type: stripe.ErrorTypeCard}))
+	assert.Equal(t, http.StatusBadRequest, stripeErrorStatus(&stripe.Error{Type: stripe.ErrorTypeInvalidRequest}))
+	assert.Equal(t, http.StatusServiceUnavailable, stripeErrorStatus(&stripe.Error{Code: stripe.ErrorCodeRateLimit, Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: http.StatusTooManyRequests}))
+	assert.Equal(t, http.StatusBadGateway, stripeErrorStatus(&stripe.Error{Type: stripe.ErrorTypeAPI}))
+	assert.Equal(t, http.StatusBadGateway, stripeErrorStatus(assert.AnError))
 }
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+// maxWebhookBytes caps webhook bodies, as recommended by Stripe
+const maxWebhookBytes = 65536
+
+// EventPublisher delivers keyed messages, e.g. a kafka.Producer
+type EventPublisher interface {
+	Deliver(ctx context.Context, key string, value []byte) error
+}
+
+// paymentEventTimeout bounds the wait for a payment event to be acknowledged
+const paymentEventTimeout = 10 * time.Second
+
+// StripeWebhookHandler verifies Stripe-Signature, stores payment_intent.*
+// events and republishes them as models.PaymentEvent. An event only counts
+// as processed once it is published, so a failed publish is retried on
+// Stripe's redelivery. Other event types are acknowledged and ignored.
+func (h *PaymentHandlers) StripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	secret := config.AppConfig.StripeWebhookSecret
+	if secret == "" {
+		writeJSONError(w, http.StatusInternalServerError, "Stripe webhook secret not set")
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), secret,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !strings.HasPrefix(string(event.Type), "payment_intent.") {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"received": true, "ignored": true})
+		return
+	}
+	paymentEvent, err := normalizePaymentEvent(event)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid payment_intent payload")
+		return
+	}
+
+	duplicate, err := mongo.InsertStripeEvent(config.AppConfig.MongoDB, config.AppConfig.StripeEventsCollection(), paymentEvent, payload)
+	if err != nil {
+		// A non-2xx response makes Stripe retry the delivery later
+		log.Println("Storing Stripe event failed:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store event")
+		return
+	}
+	if duplicate {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"received": true, "duplicate": true})
+		return
+	}
+
+	recordLedger(webhookLedgerEntry(paymentEvent))
+	if err := h.publishPaymentEvent(r.Context(), paymentEvent); err != nil {
+		log.Printf("Publishing Stripe event %s failed: %v", paymentEvent.EventID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to publish event")
+		return
+	}
+	if err := mongo.MarkStripeEventPublished(config.AppConfig.MongoDB, config.AppConfig.StripeEventsCollection(), paymentEvent.EventID); err != nil {
+		// The event is out; a redelivery would only publish it once more
+		log.Printf("Marking Stripe event %s published failed: %v", paymentEvent.EventID, err)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"received": true, "duplicate": false})
+}
+
+// normalizePaymentEvent flattens a payment_intent.* event into a PaymentEvent
+func normalizePaymentEvent(event stripe.Event) (models.PaymentEvent, error) {
+	var pi stripe.PaymentIntent
+	if event.Data == nil {
+		return models.PaymentEvent{}, io.ErrUnexpectedEOF
+	}
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return models.PaymentEvent{}, err
+	}
+
+	pe := models.PaymentEvent{
+		EventID:            event.ID,
+		Type:               string(event.Type),
+		PaymentIntentID:    pi.ID,
+		Status:             string(pi.Status),
+		Amount:             pi.Amount,
+		AmountCapturable:   pi.AmountCapturable,
+		AmountReceived:     pi.AmountReceived,
+		Currency:           string(pi.Currency),
+		CancellationReason: string(pi.CancellationReason),
+		Metadata:           pi.Metadata,
+		Livemode:           event.Livemode,
+		Created:            time.Unix(event.Created, 0).UTC(),
+	}
+	if pi.LastPaymentError != nil {
+		pe.FailureCode = string(pi.LastPaymentError.Code)
+		pe.FailureMessage = pi.LastPaymentError.Msg
+	}
+	return pe, nil
+}
+
+// webhookLedgerEntry records a payment_intent.* event as reported by Stripe
+func webhookLedgerEntry(pe models.PaymentEvent) models.LedgerEntry {
+	return models.LedgerEntry{
+		ID:              models.LedgerWebhook + ":" + pe.EventID,
+		Type:            models.LedgerWebhook,
+		PaymentIntentID: pe.PaymentIntentID,
+		EventID:         pe.EventID,
+		EventType:       pe.Type,
+		Status:          pe.Status,
+		Amount:          pe.Amount,
+		Currency:        pe.Currency,
+		VIN:             pe.Metadata["vin"],
+		Region:          pe.Metadata["region"],
+		ReservationID:   pe.Metadata["reservation_id"],
+		Actor:           actorStripe,
+		CreatedAt:       time.Now().UTC(),
+	}
+}
+
+// publishPaymentEvent delivers pe keyed by its PaymentIntent
+func (h *PaymentHandlers) publishPaymentEvent(ctx context.Context, pe models.PaymentEvent) error {
+	if h.events == nil {
+		log.Println("No payment event publisher configured, dropping", pe.EventID)
+		return nil
+	}
+	value, err := json.Marshal(pe)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, paymentEventTimeout)
+	defer cancel()
+	return h.events.Deliver(ctx, pe.PaymentIntentID, value)
+}
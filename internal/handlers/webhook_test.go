@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+)
+
+const testWebhookSecret = "whsec_test_secret"
+
+func paymentIntentEvent(id, eventType, object string) []byte {
+	return []byte(`{
+		"id": "` + id + `",
+		"object": "event",
+		"api_version": "2020-08-27",
+		"created": 1756029600,
+		"livemode": false,
+		"type": "` + eventType + `",
+		"data": {"object": ` + object + `}
+	}`)
+}
+
+func doWebhookRequest(h *PaymentHandlers, payload []byte, secret string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: secret, Timestamp: time.Now()})
+	req := httptest.NewRequest("POST", "/webhooks/stripe", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signed.Header)
+	rw := httptest.NewRecorder()
+	h.StripeWebhookHandler(rw, req)
+	var resp map[string]interface{}
+	json.NewDecoder(rw.Body).Decode(&resp)
+	return rw, resp
+}
+
+const canceledIntent = `{"id": "pi_123", "object": "payment_intent", "status": "canceled", "amount": 1000,
+	"amount_capturable": 0, "amount_received": 0, "currency": "usd", "cancellation_reason": "automatic",
+	"metadata": {"vin": "VIN1"}}`
+
+func TestStripeWebhookStoresAndPublishes(t *testing.T) {
+	h, store, pub := useWebhookFakes(t)
+
+	rw, resp := doWebhookRequest(h, paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent), testWebhookSecret)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, false, resp["duplicate"])
+
+	stored := store.event("evt_1")
+	assert.Equal(t, "payment_intent.canceled", stored.Type)
+	assert.Equal(t, "pi_123", stored.PaymentIntentID)
+	assert.Equal(t, "canceled", stored.Status)
+	assert.Equal(t, "automatic", stored.CancellationReason)
+	assert.Equal(t, "VIN1", stored.Metadata["vin"])
+	assert.Equal(t, time.Unix(1756029600, 0).UTC(), stored.Created)
+
+	assert.Equal(t, []string{"pi_123"}, pub.keys)
+	assert.Equal(t, stored, pub.events[0])
+}
+
+func TestStripeWebhookDuplicateIsNotRepublished(t *testing.T) {
+	h, _, pub := useWebhookFakes(t)
+	payload := paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent)
+
+	doWebhookRequest(h, payload, testWebhookSecret)
+	rw, resp := doWebhookRequest(h, payload, testWebhookSecret)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, true, resp["duplicate"])
+	assert.Len(t, pub.events, 1)
+}
+
+func TestStripeWebhookPaymentFailed(t *testing.T) {
+	h, store, _ := useWebhookFakes(t)
+	failed := `{"id": "pi_9", "object": "payment_intent", "status": "requires_payment_method", "amount": 500, "currency": "cad",
+		"last_payment_error": {"code": "card_declined", "message": "Your card was declined."}}`
+
+	rw, _ := doWebhookRequest(h, paymentIntentEvent("evt_2", "payment_intent.payment_failed", failed), testWebhookSecret)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "card_declined", store.event("evt_2").FailureCode)
+	assert.Equal(t, "Your card was declined.", store.event("evt_2").FailureMessage)
+}
+
+func TestStripeWebhookIgnoresOtherEvents(t *testing.T) {
+	h, store, pub := useWebhookFakes(t)
+
+	rw, resp := doWebhookRequest(h, paymentIntentEvent("evt_3", "customer.created", `{"id": "cus_1", "object": "customer"}`), testWebhookSecret)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, true, resp["ignored"])
+	assert.Empty(t, store.all())
+	assert.Empty(t, pub.events)
+}
+
+func TestStripeWebhookRejectsBadSignature(t *testing.T) {
+	h, store, _ := useWebhookFakes(t)
+	payload := paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent)
+
+	rw, resp := doWebhookRequest(h, payload, "whsec_wrong")
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.NotEmpty(t, resp["error"])
+
+	req := httptest.NewRequest("POST", "/webhooks/stripe", bytes.NewReader(payload))
+	rw = httptest.NewRecorder()
+	h.StripeWebhookHandler(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Empty(t, store.all())
+}
+
+func TestStripeWebhookRejectsStaleTimestamp(t *testing.T) {
+	h, _, _ := useWebhookFakes(t)
+	payload := paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent)
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: testWebhookSecret, Timestamp: time.Now().Add(-time.Hour)})
+
+	req := httptest.NewRequest("POST", "/webhooks/stripe", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signed.Header)
+	rw := httptest.NewRecorder()
+	h.StripeWebhookHandler(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestStripeWebhookStoreErrorAsksForRetry(t *testing.T) {
+	h, store, pub := useWebhookFakes(t)
+	store.err = errors.New("mongo down")
+
+	rw, _ := doWebhookRequest(h, paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent), testWebhookSecret)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.Empty(t, pub.events)
+}
+
+func TestStripeWebhookPublishErrorAsksForRetry(t *testing.T) {
+	h, store, pub := useWebhookFakes(t)
+	payload := paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent)
+
+	pub.err = errors.New("broker down")
+	rw, _ := doWebhookRequest(h, payload, testWebhookSecret)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+	assert.True(t, store.has("evt_1"))
+	assert.False(t, store.published("evt_1"))
+
+	// Stripe's redelivery publishes the stored event
+	pub.err = nil
+	rw, resp := doWebhookRequest(h, payload, testWebhookSecret)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, false, resp["duplicate"])
+	assert.Len(t, pub.events, 1)
+	assert.True(t, store.published("evt_1"))
+
+	_, resp = doWebhookRequest(h, payload, testWebhookSecret)
+	assert.Equal(t, true, resp["duplicate"])
+	assert.Len(t, pub.events, 1)
+}
+
+func TestStripeWebhookMissingSecret(t *testing.T) {
+	h, _, _ := useWebhookFakes(t)
+	config.AppConfig.StripeWebhookSecret = ""
+
+	rw, _ := doWebhookRequest(h, paymentIntentEvent("evt_1", "payment_intent.canceled", canceledIntent), testWebhookSecret)
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestStripeWebhookBodyTooLarge(t *testing.T) {
+	h, _, _ := useWebhookFakes(t)
+	payload := []byte(strings.Repeat("x", maxWebhookBytes+1))
+	rw, _ := doWebhookRequest(h, payload, testWebhookSecret)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+}
+
+func TestNormalizePaymentEventWithoutData(t *testing.T) {
+	_, err := normalizePaymentEvent(stripe.Event{ID: "evt_1", Type: "payment_intent.created"})
+	assert.Error(t, err)
+}
@@ -1,34 +1,114 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
 	"github.com/yourusername/vehicle-stock-service/internal/mongo"
 )
 
 var KafkaConsumerConstructor func(conf *kafka.ConfigMap) (*kafka.Consumer, error) = kafka.NewConsumer
 
+// ConsumerPollTimeout bounds each ReadMessage call so the loop notices stop requests
+var ConsumerPollTimeout = 500 * time.Millisecond
+
+// DeadLetterTimeout bounds the wait for Kafka to acknowledge a dead-lettered message
+var DeadLetterTimeout = 10 * time.Second
+
+// Headers added to dead-lettered messages; the original key, value and headers are kept
+const (
+	HeaderError             = "x-error"              // the error that made the consumer give up
+	HeaderErrorKind         = "x-error-kind"         // FailureDecode or FailureStore
+	HeaderOriginalTopic     = "x-original-topic"     // topic the message was consumed from
+	HeaderOriginalPartition = "x-original-partition" // its partition, in decimal
+	HeaderOriginalOffset    = "x-original-offset"    // its offset, in decimal
+	HeaderAttempts          = "x-attempts"           // storage attempts made, in decimal
+)
+
+// Dead-letter failure kinds
+const (
+	FailureDecode = "decode" // the value is not a JSON stock tick
+	FailureStore  = "store"  // storing the tick failed permanently or after every retry
+)
+
+// RetryPolicy controls how transient storage errors are retried; zero values use the defaults
+type RetryPolicy struct {
+	MaxAttempts    int           // attempts per message including the first, default 5
+	InitialBackoff time.Duration // wait before the first retry, default 100ms
+	MaxBackoff     time.Duration // cap of the doubling wait, default 10s
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	return p
+}
+
+// backoff is the wait after failed attempt n (1-based)
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxBackoff)
+}
+
+// ConsumerOptions configures batching and how a Consumer handles messages it cannot store
+type ConsumerOptions struct {
+	MaxBatch  int           // messages stored and committed together, default 100
+	BatchWait time.Duration // longest a message waits for its batch, default 1s
+	Retry     RetryPolicy
+	// DeadLetters receives messages that cannot be decoded or stored; without it they are only logged
+	DeadLetters *Producer
+}
+
 // KafkaConsumer is an interface for mocking
 type KafkaConsumer interface {
 	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
 	Close() error
 }
 
-// Consumer wraps a Kafka consumer
+// Consumer wraps a Kafka consumer and persists stock ticks to a StockRepository.
+// Offsets are committed only after the ticks before them are stored or
+// dead-lettered, so every message is handled at least once; redelivered ticks
+// are upserted and do not create duplicates, except on a time-series
+// collection, which only supports inserts.
 type Consumer struct {
-	consumer KafkaConsumer
-	topic    string
+	consumer    KafkaConsumer
+	topic       string
+	stocks      mongo.StockRepository
+	onTick      func(tick models.StockData)
+	maxBatch    int
+	batchWait   time.Duration
+	retry       RetryPolicy
+	deadLetters *Producer
 }
 
-// NewConsumer initializes a Kafka consumer
-func NewConsumer(brokers, groupID, topic string) (*Consumer, error) {
+// NewConsumer initializes a Kafka consumer that stores ticks in stocks. onTick,
+// when set, is called with every tick once it is stored, e.g. to feed the
+// WebSocket hub.
+func NewConsumer(brokers, groupID, topic string, stocks mongo.StockRepository, onTick func(tick models.StockData), opts ConsumerOptions) (*Consumer, error) {
 	c, err := KafkaConsumerConstructor(&kafka.ConfigMap{
-		"bootstrap.servers": brokers,
-		"group.id":          groupID,
-		"auto.offset.reset": "earliest",
+		"bootstrap.servers":  brokers,
+		"group.id":           groupID,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
 	})
 	if err != nil {
 		return nil, err
@@ -38,37 +118,277 @@ func NewConsumer(brokers, groupID, topic string) (*Consumer, error) {
 		return nil, err
 	}
 
-	return &Consumer{consumer: c, topic: topic}, nil
+	return &Consumer{
+		consumer:    c,
+		topic:       topic,
+		stocks:      stocks,
+		onTick:      onTick,
+		maxBatch:    opts.MaxBatch,
+		batchWait:   opts.BatchWait,
+		retry:       opts.Retry,
+		deadLetters: opts.DeadLetters,
+	}, nil
 }
 
-// ConsumeLoop continuously reads messages from Kafka and stores in MongoDB
-func (c *Consumer) ConsumeLoop(stopChan ...chan struct{}) {
+// ConsumeLoop continuously reads messages from Kafka, stores the ticks in
+// batches and commits the offsets of each stored batch. The pending batch is
+// flushed when the loop stops. It returns the error of a batch that cannot be
+// stored at all, such as mongo.ErrNotConnected, leaving its offsets uncommitted.
+func (c *Consumer) ConsumeLoop(stopChan ...chan struct{}) error {
+	if c == nil || c.consumer == nil {
+		return nil
+	}
+	stop := getStopChan(stopChan)
+	maxBatch, batchWait := c.maxBatch, c.batchWait
+	if maxBatch <= 0 {
+		maxBatch = 100
+	}
+	if batchWait <= 0 {
+		batchWait = time.Second
+	}
+
+	var batch []*kafka.Message
+	var due time.Time
 	for {
 		select {
-		case <-getStopChan(stopChan):
-			return
+		case <-stop:
+			return c.flush(batch, stop)
 		default:
-			msg, err := c.consumer.ReadMessage(-1)
-			if err != nil {
-				log.Printf("Consumer error: %v", err)
-				continue
-			}
+		}
 
+		msg, err := c.consumer.ReadMessage(ConsumerPollTimeout)
+		if err == nil {
 			log.Printf("Message received: %s", string(msg.Value))
+			if len(batch) == 0 {
+				due = time.Now().Add(batchWait)
+			}
+			batch = append(batch, msg)
+		} else if !isTimeout(err) {
+			log.Printf("Consumer error: %v", err)
+		}
+		if len(batch) >= maxBatch || (len(batch) > 0 && !time.Now().Before(due)) {
+			if err := c.flush(batch, stop); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+}
+
+// flush stores batch, commits the offsets of the messages it handled and
+// returns the error that stopped store, if any
+func (c *Consumer) flush(batch []*kafka.Message, stop <-chan struct{}) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	handled, storeErr := c.store(batch, stop)
+	offsets := commitOffsets(batch, handled)
+	if len(offsets) == 0 {
+		return storeErr
+	}
+	if _, err := c.consumer.CommitOffsets(offsets); err != nil {
+		// The messages are redelivered after a restart or rebalance and upserted again
+		log.Printf("Committing consumer offsets failed: %v", err)
+	}
+	return storeErr
+}
 
-			var stockData map[string]interface{}
-			if err := json.Unmarshal(msg.Value, &stockData); err == nil {
-				if mongo.Client != nil {
-					err := mongo.InsertDataFunc("vehicle_stock_db", "stock_data", stockData)
-					if err != nil {
-						log.Println("MongoDB insert failed:", err)
-					}
-				}
+// store decodes and upserts the ticks of batch and reports which messages were
+// handled, i.e. stored or dead-lettered. Messages that are not a tick with a
+// ticker and time are dead-lettered right away. Transient storage errors are
+// retried with exponential backoff; messages that still fail after the last
+// attempt are dead-lettered with the last error. When stop interrupts a retry
+// the remaining messages are left unhandled. Without a MongoDB client nothing
+// can be stored, so store gives up and returns mongo.ErrNotConnected.
+func (c *Consumer) store(batch []*kafka.Message, stop <-chan struct{}) ([]bool, error) {
+	handled := make([]bool, len(batch))
+	var pending []int
+	var ticks []models.StockData
+	for i, msg := range batch {
+		stockData, err := decodeTick(msg.Value)
+		if err != nil {
+			handled[i] = c.deadLetter(msg, FailureDecode, fmt.Errorf("invalid stock tick: %w", err), 0, stop)
+			continue
+		}
+		pending = append(pending, i)
+		ticks = append(ticks, stockData)
+	}
+	if c.stocks == nil {
+		for j, i := range pending {
+			handled[i] = true
+			c.notifyTick(ticks[j])
+		}
+		return handled, nil
+	}
+
+	retry := c.retry.withDefaults()
+	for attempt := 1; len(pending) > 0; attempt++ {
+		failed := make(map[int]error)
+		err := c.stocks.Upsert(context.Background(), ticks)
+		if errors.Is(err, mongo.ErrNotConnected) {
+			return handled, err
+		}
+		var partial *mongo.TickWriteError
+		if errors.As(err, &partial) {
+			for _, f := range partial.Failed {
+				failed[f.Index] = f.Err
+			}
+		} else if err != nil {
+			for j := range pending {
+				failed[j] = err
+			}
+		}
+
+		var retryPending []int
+		var retryTicks []models.StockData
+		var lastErr error
+		for j, i := range pending {
+			err, ok := failed[j]
+			switch {
+			case !ok:
+				handled[i] = true
+				c.notifyTick(ticks[j])
+			case mongo.IsTransientError(err) && attempt < retry.MaxAttempts:
+				retryPending = append(retryPending, i)
+				retryTicks = append(retryTicks, ticks[j])
+				lastErr = err
+			default:
+				handled[i] = c.deadLetter(batch[i], FailureStore, err, attempt, stop)
 			}
 		}
+		pending, ticks = retryPending, retryTicks
+		if len(pending) == 0 {
+			break
+		}
+
+		wait := retry.backoff(attempt)
+		log.Printf("Storing %d stock ticks failed (attempt %d of %d), retrying in %s: %v", len(pending), attempt, retry.MaxAttempts, wait, lastErr)
+		if !sleep(wait, stop) {
+			log.Printf("Consumer stopping, %d stock ticks left uncommitted for redelivery", len(pending))
+			break
+		}
+	}
+	return handled, nil
+}
+
+// decodeTick decodes a tick, which needs a ticker and a time to be stored
+func decodeTick(value []byte) (models.StockData, error) {
+	var tick models.StockData
+	if err := json.Unmarshal(value, &tick); err != nil {
+		return tick, err
+	}
+	if tick.Ticker == "" {
+		return tick, errors.New("missing ticker")
+	}
+	if tick.Time.IsZero() {
+		return tick, errors.New("missing time")
+	}
+	return tick, nil
+}
+
+// notifyTick passes a stored tick to the consumer's onTick
+func (c *Consumer) notifyTick(tick models.StockData) {
+	if c.onTick != nil {
+		c.onTick(tick)
 	}
 }
 
+// commitOffsets returns, per partition, the offset after the last message of
+// batch that was handled with every earlier message of its partition
+func commitOffsets(batch []*kafka.Message, handled []bool) []kafka.TopicPartition {
+	var offsets []kafka.TopicPartition
+	index := make(map[string]int)
+	blocked := make(map[string]bool)
+	for i, msg := range batch {
+		tp := msg.TopicPartition
+		if tp.Topic == nil {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)
+		if blocked[key] {
+			continue
+		}
+		if !handled[i] {
+			blocked[key] = true
+			continue
+		}
+		next := kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition, Offset: tp.Offset + 1}
+		if j, ok := index[key]; ok {
+			offsets[j] = next
+		} else {
+			index[key] = len(offsets)
+			offsets = append(offsets, next)
+		}
+	}
+	return offsets
+}
+
+// deadLetter publishes msg to the dead-letter topic with headers describing
+// why and where it failed, retrying until Kafka acknowledges it. It reports
+// false when stop interrupts the retries.
+func (c *Consumer) deadLetter(msg *kafka.Message, kind string, cause error, attempts int, stop <-chan struct{}) bool {
+	topic := c.topic
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	partition := strconv.Itoa(int(msg.TopicPartition.Partition))
+	offset := strconv.FormatInt(int64(msg.TopicPartition.Offset), 10)
+	log.Printf("Dead-lettering message %s[%s]@%s (%s, %d attempts): %v", topic, partition, offset, kind, attempts, cause)
+	if c.deadLetters == nil {
+		return true
+	}
+
+	headers := append(slices.Clone(msg.Headers),
+		kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+		kafka.Header{Key: HeaderErrorKind, Value: []byte(kind)},
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(topic)},
+		kafka.Header{Key: HeaderOriginalPartition, Value: []byte(partition)},
+		kafka.Header{Key: HeaderOriginalOffset, Value: []byte(offset)},
+		kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+	)
+	retry := c.retry.withDefaults()
+	for n := 1; ; n++ {
+		ctx, cancel := context.WithTimeout(context.Background(), DeadLetterTimeout)
+		err := c.deadLetters.PublishSync(ctx, msg.Key, msg.Value, headers)
+		cancel()
+		if err == nil {
+			return true
+		}
+		wait := retry.backoff(n)
+		log.Printf("Dead-lettering message %s[%s]@%s failed, retrying in %s: %v", topic, partition, offset, wait, err)
+		if !sleep(wait, stop) {
+			return false
+		}
+	}
+}
+
+// sleep waits for d and reports false if stop is closed first
+func sleep(d time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Run consumes until ctx is cancelled or a batch cannot be stored at all
+func (c *Consumer) Run(ctx context.Context) error {
+	stop := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(stop)
+	}()
+	return c.ConsumeLoop(stop)
+}
+
+func isTimeout(err error) bool {
+	kerr, ok := err.(kafka.Error)
+	return ok && kerr.Code() == kafka.ErrTimedOut
+}
+
 func getStopChan(stopChan []chan struct{}) chan struct{} {
 	if len(stopChan) > 0 {
 		return stopChan[0]
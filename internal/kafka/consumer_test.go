@@ -1,19 +1,27 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"slices"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
 	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/pricing"
+	"github.com/yourusername/vehicle-stock-service/internal/service"
+	driver "go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
 	testTopic = "test-topic"
-	testDate  = "2025-08-24"
+	testDate  = "2025-08-24T10:00:00Z"
 )
 
 type mockKafkaConsumer struct {
@@ -21,6 +29,9 @@ type mockKafkaConsumer struct {
 	err      error
 	closed   bool
 	idx      int
+
+	mu      sync.Mutex
+	commits [][]kafka.TopicPartition
 }
 
 func (m *mockKafkaConsumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
@@ -34,6 +45,17 @@ func (m *mockKafkaConsumer) ReadMessage(timeout time.Duration) (*kafka.Message,
 	m.idx++
 	return msg, nil
 }
+func (m *mockKafkaConsumer) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commits = append(m.commits, offsets)
+	return offsets, nil
+}
+func (m *mockKafkaConsumer) committed() [][]kafka.TopicPartition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.commits)
+}
 func (m *mockKafkaConsumer) Close() error { m.closed = true; return nil }
 
 func TestConsumerHappyPath(t *testing.T) {
@@ -69,7 +91,7 @@ func TestConsumerMultipleMessages(t *testing.T) {
 func TestConsumerEmptyMessage(t *testing.T) {
 	msg := &kafka.Message{Value: []byte{}}
 	mock := &mockKafkaConsumer{messages: []*kafka.Message{msg}}
-	c := &Consumer{consumer: mock, topic: testTopic}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: &mongo.MemoryStockRepository{}}
 	done := make(chan struct{})
 	go func() { c.ConsumeLoop(done) }()
 	close(done)
@@ -80,7 +102,7 @@ func TestConsumerEmptyMessage(t *testing.T) {
 func TestConsumerInvalidJSON(t *testing.T) {
 	msg := &kafka.Message{Value: []byte("not-json")}
 	mock := &mockKafkaConsumer{messages: []*kafka.Message{msg}}
-	c := &Consumer{consumer: mock, topic: testTopic}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: &mongo.MemoryStockRepository{}}
 	done := make(chan struct{})
 	go func() { c.ConsumeLoop(done) }()
 	close(done)
@@ -112,24 +134,16 @@ func TestNewConsumerError(t *testing.T) {
 		return nil, errors.New("fail")
 	}
 	defer func() { KafkaConsumerConstructor = orig }()
-	_, err := NewConsumer("invalid:broker", "group", testTopic)
+	_, err := NewConsumer("invalid:broker", "group", testTopic, nil, nil, ConsumerOptions{})
 	assert.Error(t, err)
 }
 
 func TestConsumerMongoInsertSuccess(t *testing.T) {
-	// Mock mongo.Client and mongo.InsertDataFunc
-	// Clean assignment for testability
-	origInsert := mongo.InsertDataFunc
-	mongo.InsertDataFunc = func(database, collection string, data interface{}) error {
-		return nil
-	}
-	defer func() { mongo.InsertDataFunc = origInsert }()
-
 	stock := map[string]interface{}{"ticker": "AAPL", "bid": 150.0, "ask": 151.0, "time": testDate}
 	val, _ := json.Marshal(stock)
 	msg := &kafka.Message{Value: val}
 	mock := &mockKafkaConsumer{messages: []*kafka.Message{msg}}
-	c := &Consumer{consumer: mock, topic: testTopic}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: &mongo.MemoryStockRepository{}}
 	done := make(chan struct{})
 	go func() { c.ConsumeLoop(done) }()
 	close(done)
@@ -137,36 +151,47 @@ func TestConsumerMongoInsertSuccess(t *testing.T) {
 	assert.True(t, mock.closed)
 }
 
-func TestConsumerMongoInsertError(t *testing.T) {
-	// Mock mongo.Client and mongo.InsertDataFunc
-	// Clean assignment for testability
-	origClient := mongo.Client
-	origInsert := mongo.InsertDataFunc
-	mongo.InsertDataFunc = func(database, collection string, data interface{}) error {
-		return errors.New("insert error")
-	}
-	defer func() { mongo.Client = origClient; mongo.InsertDataFunc = origInsert }()
+// failingStocks is a StockRepository whose writes fail permanently
+type failingStocks struct {
+	mongo.StockRepository
+}
 
-	stock := map[string]interface{}{"ticker": "AAPL", "bid": 150.0, "ask": 151.0, "time": testDate}
-	val, _ := json.Marshal(stock)
-	msg := &kafka.Message{Value: val}
-	mock := &mockKafkaConsumer{messages: []*kafka.Message{msg}}
-	c := &Consumer{consumer: mock, topic: testTopic}
+func (failingStocks) Upsert(ctx context.Context, ticks []models.StockData) error {
+	return errors.New("insert error")
+}
+
+func TestConsumerMongoInsertError(t *testing.T) {
+	dlq := &deliveringProducer{}
+	mock := &mockKafkaConsumer{messages: []*kafka.Message{tickMessage(0, 7)}}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: failingStocks{}, batchWait: time.Millisecond,
+		deadLetters: &Producer{producer: dlq, topic: "dlq"}}
 	done := make(chan struct{})
-	go func() { c.ConsumeLoop(done) }()
+	go c.ConsumeLoop(done)
+
+	// The failed tick is dead-lettered, not retried, and its offset committed
+	assert.Eventually(t, func() bool { return len(mock.committed()) == 1 }, time.Second, 5*time.Millisecond)
 	close(done)
-	mock.Close()
-	assert.True(t, mock.closed)
+	assert.Equal(t, kafka.Offset(8), mock.committed()[0][0].Offset)
+	produced := dlq.produced()
+	assert.Len(t, produced, 1)
+	assert.Equal(t, FailureStore, headerMap(produced[0])[HeaderErrorKind])
+	assert.Equal(t, "insert error", headerMap(produced[0])[HeaderError])
+	assert.Equal(t, "1", headerMap(produced[0])[HeaderAttempts])
 }
 
 func TestConsumerReadMessageError(t *testing.T) {
 	mock := &mockKafkaConsumer{err: errors.New("read error")}
-	c := &Consumer{consumer: mock, topic: testTopic}
+	stocks := &mongo.MemoryStockRepository{}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: stocks}
 	done := make(chan struct{})
 	go func() { c.ConsumeLoop(done) }()
+	time.Sleep(10 * time.Millisecond)
 	close(done)
 	mock.Close()
 	assert.True(t, mock.closed)
+	assert.Empty(t, mock.committed())
+	_, err := stocks.Latest(context.Background(), "AAPL")
+	assert.ErrorIs(t, err, mongo.ErrStockNotFound)
 }
 
 func TestConsumerCloseNilConsumer(t *testing.T) {
@@ -191,3 +216,423 @@ func TestGetStopChanDefault(t *testing.T) {
 		// Should block forever
 	}
 }
+
+// timeoutConsumer always times out, like an idle topic
+type timeoutConsumer struct {
+	timeouts []time.Duration
+	mu       sync.Mutex
+}
+
+func (m *timeoutConsumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	m.mu.Lock()
+	m.timeouts = append(m.timeouts, timeout)
+	m.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return nil, kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+}
+func (m *timeoutConsumer) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	return offsets, nil
+}
+func (m *timeoutConsumer) Close() error { return nil }
+
+func TestConsumerRunStopsOnCancel(t *testing.T) {
+	mock := &timeoutConsumer{}
+	c := &Consumer{consumer: mock, topic: testTopic}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	assert.NotEmpty(t, mock.timeouts)
+	assert.Equal(t, ConsumerPollTimeout, mock.timeouts[0])
+}
+
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, isTimeout(kafka.NewError(kafka.ErrTimedOut, "timed out", false)))
+	assert.False(t, isTimeout(kafka.NewError(kafka.ErrTransport, "down", false)))
+	assert.False(t, isTimeout(errors.New("read error")))
+}
+
+func TestConsumerStoresTicks(t *testing.T) {
+	notified := make(chan models.StockData, 1)
+	stocks := &mongo.MemoryStockRepository{}
+	val, _ := json.Marshal(map[string]interface{}{"ticker": "AAPL", "bid": 150.0, "ask": 151.0, "time": testDate})
+	mock := &mockKafkaConsumer{messages: []*kafka.Message{{Value: val}}}
+	mock.messages[0].TopicPartition = kafka.TopicPartition{Topic: &testTopic, Partition: 0, Offset: 9}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: stocks, onTick: func(tick models.StockData) { notified <- tick }, batchWait: time.Millisecond}
+	done := make(chan struct{})
+	defer close(done)
+	go c.ConsumeLoop(done)
+
+	assert.Eventually(t, func() bool { return len(mock.committed()) == 1 }, time.Second, 5*time.Millisecond)
+	tick, err := stocks.Latest(context.Background(), "AAPL")
+	assert.NoError(t, err)
+	assert.Equal(t, 150.0, tick.Bid)
+	assert.Equal(t, "AAPL", (<-notified).Ticker)
+	assert.Equal(t, kafka.Offset(10), mock.committed()[0][0].Offset)
+}
+
+// deliveringProducer records produced messages and acknowledges them with err
+// after failing its first failures deliveries
+type deliveringProducer struct {
+	mockKafkaProducer
+	mu       sync.Mutex
+	messages []*kafka.Message
+	err      error
+	failures int
+}
+
+func (m *deliveringProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	ack := *msg
+	ack.TopicPartition.Error = m.err
+	if m.failures > 0 {
+		m.failures--
+		ack.TopicPartition.Error = kafka.NewError(kafka.ErrTransport, "broker down", false)
+	}
+	m.mu.Unlock()
+	deliveryChan <- &ack
+	return nil
+}
+
+func (m *deliveringProducer) produced() []*kafka.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.messages)
+}
+
+func headerMap(msg *kafka.Message) map[string]string {
+	out := make(map[string]string)
+	for _, h := range msg.Headers {
+		out[h.Key] = string(h.Value)
+	}
+	return out
+}
+
+// flakyStocks fails its first upserts with the queued errors
+type flakyStocks struct {
+	mongo.MemoryStockRepository
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (s *flakyStocks) Upsert(ctx context.Context, ticks []models.StockData) error {
+	s.mu.Lock()
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Unlock()
+	return s.MemoryStockRepository.Upsert(ctx, ticks)
+}
+
+// storeBatch stores batch and expects store not to give up
+func storeBatch(t *testing.T, c *Consumer, batch []*kafka.Message, stop <-chan struct{}) []bool {
+	t.Helper()
+	handled, err := c.store(batch, stop)
+	assert.NoError(t, err)
+	return handled
+}
+
+func tickMessage(partition int32, offset kafka.Offset) *kafka.Message {
+	val, _ := json.Marshal(map[string]interface{}{"ticker": "VEHICLE-VIN1", "bid": 150.0, "ask": 151.0, "time": testDate})
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &testTopic, Partition: partition, Offset: offset},
+		Key:            []byte("VEHICLE-VIN1"),
+		Value:          val,
+		Headers:        []kafka.Header{{Key: "trace-id", Value: []byte("abc")}},
+	}
+}
+
+func TestConsumerDeadLettersPoisonMessages(t *testing.T) {
+	dlq := &deliveringProducer{failures: 1}
+	stocks := &flakyStocks{}
+	c := &Consumer{topic: testTopic, stocks: stocks, retry: RetryPolicy{InitialBackoff: time.Millisecond}, deadLetters: &Producer{producer: dlq, topic: "test-topic.dlq"}}
+
+	msg := tickMessage(2, 41)
+	msg.Value = []byte("not-json")
+	assert.Equal(t, []bool{true}, storeBatch(t, c, []*kafka.Message{msg}, nil))
+
+	// The first delivery failed and was retried
+	produced := dlq.produced()
+	assert.Len(t, produced, 2)
+	assert.Equal(t, "test-topic.dlq", *produced[1].TopicPartition.Topic)
+	assert.Equal(t, []byte("VEHICLE-VIN1"), produced[1].Key)
+	assert.Equal(t, []byte("not-json"), produced[1].Value)
+	headers := headerMap(produced[1])
+	assert.Equal(t, "abc", headers["trace-id"])
+	assert.Equal(t, FailureDecode, headers[HeaderErrorKind])
+	assert.Contains(t, headers[HeaderError], "invalid stock tick")
+	assert.Equal(t, testTopic, headers[HeaderOriginalTopic])
+	assert.Equal(t, "2", headers[HeaderOriginalPartition])
+	assert.Equal(t, "41", headers[HeaderOriginalOffset])
+	assert.Equal(t, "0", headers[HeaderAttempts])
+	assert.Equal(t, 0, stocks.calls)
+	assert.Len(t, msg.Headers, 1)
+
+	// A dead letter Kafka never acknowledges stays unhandled when the consumer stops
+	stop := make(chan struct{})
+	close(stop)
+	dlq.err = kafka.NewError(kafka.ErrTransport, "broker down", false)
+	assert.Equal(t, []bool{false}, storeBatch(t, c, []*kafka.Message{msg}, stop))
+}
+
+func TestConsumerDeadLettersIncompleteTicks(t *testing.T) {
+	dlq := &deliveringProducer{}
+	stocks := &flakyStocks{}
+	c := &Consumer{topic: testTopic, stocks: stocks, deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+
+	// Valid JSON that decodes to a tick without a ticker or time is not stored
+	values := []string{`{}`, `{"foo":1}`, `{"bid":150,"ask":151,"time":"` + testDate + `"}`, `{"ticker":"VEHICLE-VIN1","bid":150,"ask":151}`}
+	var batch []*kafka.Message
+	for i, value := range values {
+		msg := tickMessage(0, kafka.Offset(i))
+		msg.Value = []byte(value)
+		batch = append(batch, msg)
+	}
+	assert.Equal(t, []bool{true, true, true, true}, storeBatch(t, c, batch, nil))
+	assert.Equal(t, 0, stocks.calls)
+
+	produced := dlq.produced()
+	assert.Len(t, produced, 4)
+	for _, msg := range produced {
+		assert.Equal(t, FailureDecode, headerMap(msg)[HeaderErrorKind])
+	}
+	assert.Equal(t, "invalid stock tick: missing ticker", headerMap(produced[1])[HeaderError])
+	assert.Equal(t, "invalid stock tick: missing time", headerMap(produced[3])[HeaderError])
+}
+
+func TestConsumerNotifiesStoredTicksOnly(t *testing.T) {
+	var notified []float64
+	dlq := &deliveringProducer{}
+	rejected := mongo.TickWriteError{Failed: []mongo.FailedTick{{Index: 0, Err: driver.WriteError{Code: 121, Message: "Document failed validation"}}}}
+	stocks := &flakyStocks{errs: []error{&rejected}}
+	c := &Consumer{topic: testTopic, stocks: stocks, onTick: func(tick models.StockData) { notified = append(notified, tick.Bid) },
+		retry: RetryPolicy{InitialBackoff: time.Hour}, deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+
+	// Ticks are told apart by their bid
+	batch := []*kafka.Message{tickMessage(0, 1), tickMessage(0, 2)}
+	for i, msg := range batch {
+		val, _ := json.Marshal(map[string]interface{}{"ticker": "VEHICLE-VIN1", "bid": float64(i + 1), "ask": 151.0, "time": testDate})
+		msg.Value = val
+	}
+
+	// A dead-lettered tick never reaches onTick
+	assert.Equal(t, []bool{true, true}, storeBatch(t, c, batch, nil))
+	assert.Len(t, dlq.produced(), 1)
+	assert.Equal(t, []float64{2}, notified)
+
+	// Neither does a tick left for redelivery by a shutdown during a retry
+	stop := make(chan struct{})
+	close(stop)
+	notified, stocks.errs = nil, []error{context.DeadlineExceeded}
+	assert.Equal(t, []bool{false, false}, storeBatch(t, c, batch, stop))
+	assert.Empty(t, notified)
+}
+
+func TestConsumerRetriesTransientErrors(t *testing.T) {
+	dlq := &deliveringProducer{}
+	retry := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	stocks := &flakyStocks{errs: []error{context.DeadlineExceeded, context.DeadlineExceeded}}
+	c := &Consumer{topic: testTopic, stocks: stocks, retry: retry, deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+
+	// Two timeouts, then stored
+	assert.Equal(t, []bool{true}, storeBatch(t, c, []*kafka.Message{tickMessage(0, 1)}, nil))
+	assert.Equal(t, 3, stocks.calls)
+	tick, err := stocks.Latest(context.Background(), "VEHICLE-VIN1")
+	assert.NoError(t, err)
+	assert.Equal(t, 150.0, tick.Bid)
+	assert.Empty(t, dlq.produced())
+
+	// Permanent errors are not retried
+	stocks.calls, stocks.errs = 0, []error{errors.New("Document failed validation")}
+	storeBatch(t, c, []*kafka.Message{tickMessage(0, 3)}, nil)
+	assert.Equal(t, 1, stocks.calls)
+	assert.Len(t, dlq.produced(), 1)
+	assert.Equal(t, "Document failed validation", headerMap(dlq.produced()[0])[HeaderError])
+	assert.Equal(t, "1", headerMap(dlq.produced()[0])[HeaderAttempts])
+
+	// Shutdown interrupts the backoff and leaves the tick for redelivery
+	stop := make(chan struct{})
+	close(stop)
+	c.retry = RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}
+	stocks.calls, stocks.errs = 0, []error{context.DeadlineExceeded}
+	assert.Equal(t, []bool{false}, storeBatch(t, c, []*kafka.Message{tickMessage(0, 4)}, stop))
+	assert.Equal(t, 1, stocks.calls)
+	assert.Len(t, dlq.produced(), 1)
+}
+
+func TestConsumerDeadLettersTicksAfterLastAttempt(t *testing.T) {
+	dlq := &deliveringProducer{}
+	shutdown := driver.WriteError{Code: 91, Message: "shutting down"}
+	last := mongo.TickWriteError{Failed: []mongo.FailedTick{{Index: 0, Err: shutdown}, {Index: 1, Err: shutdown}}}
+	stocks := &flakyStocks{errs: []error{context.DeadlineExceeded, context.DeadlineExceeded, &last}}
+	mock := &mockKafkaConsumer{}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: stocks,
+		retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+
+	// Every attempt fails transiently, so the batch is dead-lettered and committed
+	batch := []*kafka.Message{tickMessage(0, 1), tickMessage(0, 2)}
+	assert.NoError(t, c.flush(batch, nil))
+	assert.Equal(t, 3, stocks.calls)
+	produced := dlq.produced()
+	assert.Len(t, produced, 2)
+	for i, msg := range produced {
+		headers := headerMap(msg)
+		assert.Equal(t, FailureStore, headers[HeaderErrorKind])
+		assert.Equal(t, "3", headers[HeaderAttempts])
+		assert.Equal(t, shutdown.Error(), headers[HeaderError])
+		assert.Equal(t, strconv.Itoa(i+1), headers[HeaderOriginalOffset])
+	}
+	assert.Equal(t, [][]kafka.TopicPartition{{{Topic: &testTopic, Partition: 0, Offset: 3}}}, mock.committed())
+}
+
+func TestConsumerShutdownDuringRetryLeavesMessageUncommitted(t *testing.T) {
+	dlq := &deliveringProducer{}
+	stocks := &flakyStocks{errs: []error{context.DeadlineExceeded}}
+	mock := &mockKafkaConsumer{messages: []*kafka.Message{tickMessage(0, 5)}}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: stocks, batchWait: time.Millisecond,
+		retry: RetryPolicy{InitialBackoff: time.Hour}, deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		c.ConsumeLoop(stop)
+		close(stopped)
+	}()
+
+	// Stop while the consumer waits to retry the first failed write
+	assert.Eventually(t, func() bool {
+		stocks.mu.Lock()
+		defer stocks.mu.Unlock()
+		return stocks.calls == 1
+	}, time.Second, time.Millisecond)
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	// The healthy message is neither dead-lettered nor committed, so it is redelivered
+	assert.Empty(t, dlq.produced())
+	assert.Empty(t, mock.committed())
+}
+
+func TestConsumerStopsWithoutMongoClient(t *testing.T) {
+	dlq := &deliveringProducer{}
+	stocks := &flakyStocks{errs: []error{mongo.ErrNotConnected}}
+	mock := &mockKafkaConsumer{messages: []*kafka.Message{tickMessage(0, 5)}}
+	c := &Consumer{consumer: mock, topic: testTopic, stocks: stocks, batchWait: time.Millisecond,
+		deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+
+	// The worker stops instead of dead-lettering ticks it could never store
+	assert.ErrorIs(t, c.ConsumeLoop(make(chan struct{})), mongo.ErrNotConnected)
+	assert.Equal(t, 1, stocks.calls)
+	assert.Empty(t, dlq.produced())
+	assert.Empty(t, mock.committed())
+}
+
+func TestConsumerRetriesOnlyFailedTicksOfABatch(t *testing.T) {
+	dlq := &deliveringProducer{}
+	invalid := mongo.TickWriteError{Failed: []mongo.FailedTick{
+		{Index: 0, Err: driver.WriteError{Code: 121, Message: "Document failed validation"}},
+		{Index: 2, Err: driver.WriteError{Code: 91, Message: "shutting down"}},
+	}}
+	stocks := &flakyStocks{errs: []error{&invalid}}
+	consumer := &mockKafkaConsumer{}
+	c := &Consumer{consumer: consumer, topic: testTopic, stocks: stocks, retry: RetryPolicy{InitialBackoff: time.Millisecond}, deadLetters: &Producer{producer: dlq, topic: "dlq"}}
+
+	batch := []*kafka.Message{tickMessage(0, 10), tickMessage(0, 11), tickMessage(1, 5)}
+	assert.NoError(t, c.flush(batch, nil))
+	assert.Equal(t, 2, stocks.calls)
+	assert.Len(t, dlq.produced(), 1)
+	assert.Equal(t, "10", headerMap(dlq.produced()[0])[HeaderOriginalOffset])
+	assert.Equal(t, [][]kafka.TopicPartition{{
+		{Topic: &testTopic, Partition: 0, Offset: 12},
+		{Topic: &testTopic, Partition: 1, Offset: 6},
+	}}, consumer.committed())
+}
+
+func TestCommitOffsets(t *testing.T) {
+	other := "other-topic"
+	batch := []*kafka.Message{tickMessage(0, 10), tickMessage(1, 20), tickMessage(0, 11), tickMessage(1, 21), tickMessage(0, 12), {TopicPartition: kafka.TopicPartition{Topic: &other, Offset: 3}}}
+
+	// A partition commits up to its first unhandled message
+	assert.Equal(t, []kafka.TopicPartition{
+		{Topic: &testTopic, Partition: 0, Offset: 11},
+		{Topic: &testTopic, Partition: 1, Offset: 22},
+		{Topic: &other, Partition: 0, Offset: 4},
+	}, commitOffsets(batch, []bool{true, true, false, true, true, true}))
+	assert.Empty(t, commitOffsets(batch[:1], []bool{false}))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{}.withDefaults()
+	assert.Equal(t, RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second}, p)
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, p.backoff(4))
+	assert.Equal(t, 10*time.Second, p.backoff(20))
+}
+
+// topicPublisher turns published ticks into messages of the test topic
+type topicPublisher struct {
+	messages []*kafka.Message
+}
+
+func (p *topicPublisher) Publish(key string, value []byte) {
+	tp := kafka.TopicPartition{Topic: &testTopic, Offset: kafka.Offset(len(p.messages))}
+	p.messages = append(p.messages, &kafka.Message{TopicPartition: tp, Key: []byte(key), Value: value})
+}
+
+func (p *topicPublisher) Close() {}
+
+func TestTickStoredOnceByProducerAndConsumer(t *testing.T) {
+	// A time-series collection, which has no unique index to reject a second copy
+	origClient, origFind, origMany := mongo.Client, mongo.MongoFindTicksFunc, mongo.MongoInsertManyFunc
+	defer func() {
+		mongo.Client, mongo.MongoFindTicksFunc, mongo.MongoInsertManyFunc = origClient, origFind, origMany
+	}()
+	mongo.Client = &driver.Client{}
+	var stored []models.StockData
+	mongo.MongoFindTicksFunc = func(coll *driver.Collection, ctx context.Context, filter interface{}) ([]models.StockData, error) {
+		return stored, nil
+	}
+	mongo.MongoInsertManyFunc = func(coll *driver.Collection, ctx context.Context, docs []interface{}) (*driver.InsertManyResult, error) {
+		for _, doc := range docs {
+			stored = append(stored, doc.(models.StockData))
+		}
+		return &driver.InsertManyResult{}, nil
+	}
+	repo := mongo.NewMongoStockRepository("db", "ticks")
+	repo.TimeSeries = true
+
+	// The producer stores the tick and publishes it, then the consumer stores
+	// it from the topic, twice when Kafka redelivers it
+	pub := &topicPublisher{}
+	subs := []models.VehicleSubscription{{Vin: "VIN1", Region: "CA", ActivePaidSubscriptions: true}}
+	service.NewStockGenerator(repo, &pricing.FeatureValue{Base: 100, Spread: 1}, nil).SendStockDataForSubscriptions(subs, pub)
+	c := &Consumer{topic: testTopic, stocks: repo}
+	assert.Equal(t, []bool{true}, storeBatch(t, c, pub.messages, nil))
+	assert.Equal(t, []bool{true}, storeBatch(t, c, pub.messages, nil))
+
+	assert.Len(t, stored, 1)
+	assert.Equal(t, &models.StockMeta{Ticker: "VEHICLE-VIN1", VIN: "VIN1", Region: "CA"}, stored[0].Meta)
+}
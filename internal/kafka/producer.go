@@ -1,7 +1,11 @@
 package kafka
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
@@ -18,8 +22,9 @@ type KafkaProducer interface {
 
 // Producer wraps a Kafka producer instance
 type Producer struct {
-	producer KafkaProducer
-	topic    string
+	producer  KafkaProducer
+	topic     string
+	closeOnce sync.Once
 }
 
 // NewProducer initializes a Kafka producer
@@ -70,11 +75,57 @@ func (p *Producer) Publish(key string, value []byte) {
 	}
 }
 
+// PublishSync sends a message with headers to the producer's topic and waits
+// for Kafka to acknowledge it or for ctx to be done
+func (p *Producer) PublishSync(ctx context.Context, key, value []byte, headers []kafka.Header) error {
+	if p == nil || p.producer == nil {
+		return fmt.Errorf("Kafka producer is not initialized")
+	}
+	delivery := make(chan kafka.Event, 1)
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+	}
+	if err := p.producer.Produce(msg, delivery); err != nil {
+		return err
+	}
+	select {
+	case e := <-delivery:
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			return m.TopicPartition.Error
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Deliver sends value keyed by key and waits for Kafka to acknowledge it or for ctx to be done
+func (p *Producer) Deliver(ctx context.Context, key string, value []byte) error {
+	return p.PublishSync(ctx, []byte(key), value, nil)
+}
+
 // Close the producer
 func (p *Producer) Close() {
+	p.CloseWithTimeout(time.Second)
+}
+
+// CloseWithTimeout flushes outstanding messages for up to timeout, closes the
+// producer and returns the number of messages that were still undelivered.
+// Only the first call has any effect.
+func (p *Producer) CloseWithTimeout(timeout time.Duration) int {
 	if p == nil || p.producer == nil {
-		return
+		return 0
 	}
-	p.producer.Flush(1000)
-	p.producer.Close()
+	remaining := 0
+	p.closeOnce.Do(func() {
+		remaining = p.producer.Flush(int(timeout.Milliseconds()))
+		if remaining > 0 {
+			log.Printf("Kafka producer closed with %d undelivered messages", remaining)
+		}
+		p.producer.Close()
+	})
+	return remaining
 }
@@ -1,9 +1,11 @@
 package kafka
 
 import (
+	"context"
 	"errors"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/stretchr/testify/assert"
@@ -12,14 +14,20 @@ import (
 type mockKafkaProducer struct {
 	produced   bool
 	produceErr error
+	flushLeft  int
+	flushMs    []int
+	closeCount int
 }
 
 func (m *mockKafkaProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
 	m.produced = true
 	return m.produceErr
 }
-func (m *mockKafkaProducer) Flush(timeoutMs int) int  { return 0 }
-func (m *mockKafkaProducer) Close()                   { /* intentionally empty for mock */ }
+func (m *mockKafkaProducer) Flush(timeoutMs int) int {
+	m.flushMs = append(m.flushMs, timeoutMs)
+	return m.flushLeft
+}
+func (m *mockKafkaProducer) Close()                   { m.closeCount++ }
 func (m *mockKafkaProducer) Events() chan kafka.Event { return make(chan kafka.Event) }
 
 func TestProducerPublishHappyPath(t *testing.T) {
@@ -150,3 +158,30 @@ func TestProducerCloseNilProducer(t *testing.T) {
 	p := &Producer{producer: nil, topic: testTopic}
 	p.Close()
 }
+
+func TestProducerCloseWithTimeout(t *testing.T) {
+	mock := &mockKafkaProducer{flushLeft: 3}
+	p := &Producer{producer: mock, topic: testTopic}
+	assert.Equal(t, 3, p.CloseWithTimeout(2500*time.Millisecond))
+	assert.Equal(t, []int{2500}, mock.flushMs)
+	assert.Equal(t, 1, mock.closeCount)
+
+	// Later closes do not flush or close the underlying producer again
+	assert.Equal(t, 0, p.CloseWithTimeout(time.Second))
+	p.Close()
+	assert.Equal(t, 1, mock.closeCount)
+}
+
+func TestProducerPublishSync(t *testing.T) {
+	mock := &deliveringProducer{}
+	p := &Producer{producer: mock, topic: "dlq"}
+	headers := []kafka.Header{{Key: HeaderError, Value: []byte("boom")}}
+	assert.NoError(t, p.PublishSync(context.Background(), []byte("key"), []byte("value"), headers))
+	assert.Equal(t, headers, mock.produced()[0].Headers)
+
+	mock.err = kafka.NewError(kafka.ErrMsgSizeTooLarge, "too large", false)
+	assert.Error(t, p.PublishSync(context.Background(), nil, []byte("value"), nil))
+
+	var nilProducer *Producer
+	assert.Error(t, nilProducer.PublishSync(context.Background(), nil, nil, nil))
+}
@@ -0,0 +1,164 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// For testability
+var notifySignals = signal.Notify
+var stopSignals = signal.Stop
+
+// Manager runs long-lived components and tears the service down in order.
+// Components started with Go are cancelled and drained first; hooks registered
+// with OnShutdown then run in reverse registration order.
+type Manager struct {
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	hooks    []hook
+	shutdown sync.Once
+	err      error
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// New creates a Manager; timeout bounds the component drain and each shutdown hook
+func New(timeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{timeout: timeout, ctx: ctx, cancel: cancel}
+}
+
+// Context is cancelled when shutdown starts
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Go runs fn in the background until its context is cancelled. A component
+// that fails on its own triggers shutdown of the whole service.
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := fn(m.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("%s stopped with error: %v", name, err)
+			m.Stop()
+		}
+	}()
+}
+
+// OnShutdown registers a hook that runs after all components have stopped
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Stop requests shutdown without waiting for it
+func (m *Manager) Stop() {
+	m.cancel()
+}
+
+// Wait blocks until SIGINT/SIGTERM or Stop, then shuts everything down
+func (m *Manager) Wait() error {
+	sigs := make(chan os.Signal, 1)
+	notifySignals(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals(sigs)
+
+	select {
+	case sig := <-sigs:
+		log.Printf("Received %s, shutting down", sig)
+	case <-m.ctx.Done():
+		log.Println("Shutdown requested")
+	}
+	return m.Shutdown()
+}
+
+// Shutdown cancels all components, waits up to the timeout for them to return,
+// then runs the shutdown hooks. It is safe to call more than once.
+func (m *Manager) Shutdown() error {
+	m.shutdown.Do(func() {
+		m.cancel()
+
+		var errs []error
+		done := make(chan struct{})
+		go func() {
+			m.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(m.timeout):
+			errs = append(errs, fmt.Errorf("components did not stop within %s", m.timeout))
+		}
+
+		m.mu.Lock()
+		hooks := m.hooks
+		m.mu.Unlock()
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if err := m.runHook(hooks[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		m.err = errors.Join(errs...)
+	})
+	return m.err
+}
+
+func (m *Manager) runHook(h hook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- h.fn(ctx) }()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.name, err)
+		}
+		log.Printf("%s stopped", h.name)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", h.name, ctx.Err())
+	}
+}
+
+// ServeHTTP returns a component that serves srv until cancelled, then drains
+// in-flight requests with Shutdown for at most timeout.
+func ServeHTTP(srv *http.Server, timeout time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		errCh := make(chan error, 1)
+		go func() { errCh <- srv.ListenAndServe() }()
+
+		select {
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownStopsComponentsThenHooksInReverse(t *testing.T) {
+	m := New(time.Second)
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, s)
+	}
+
+	m.Go("loop", func(ctx context.Context) error {
+		<-ctx.Done()
+		record("loop")
+		return ctx.Err()
+	})
+	m.OnShutdown("mongo", func(ctx context.Context) error { record("mongo"); return nil })
+	m.OnShutdown("kafka", func(ctx context.Context) error { record("kafka"); return nil })
+
+	assert.NoError(t, m.Shutdown())
+	assert.Equal(t, []string{"loop", "kafka", "mongo"}, order)
+	assert.Error(t, m.Context().Err())
+
+	// Second call is a no-op
+	assert.NoError(t, m.Shutdown())
+	assert.Len(t, order, 3)
+}
+
+func TestShutdownCollectsHookErrors(t *testing.T) {
+	m := New(time.Second)
+	m.OnShutdown("a", func(ctx context.Context) error { return errors.New("boom") })
+	m.OnShutdown("b", func(ctx context.Context) error { return nil })
+
+	err := m.Shutdown()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "a: boom")
+}
+
+func TestShutdownTimeouts(t *testing.T) {
+	m := New(50 * time.Millisecond)
+	block := make(chan struct{})
+	defer close(block)
+	m.Go("stuck", func(ctx context.Context) error { <-block; return nil })
+	m.OnShutdown("slow", func(ctx context.Context) error { <-block; return nil })
+
+	start := time.Now()
+	err := m.Shutdown()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "components did not stop")
+	assert.Contains(t, err.Error(), "slow: context deadline exceeded")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFailingComponentTriggersShutdown(t *testing.T) {
+	m := New(time.Second)
+	m.Go("bad", func(ctx context.Context) error { return errors.New("bind failed") })
+
+	select {
+	case <-m.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled")
+	}
+}
+
+func TestWaitOnSignal(t *testing.T) {
+	origNotify, origStop := notifySignals, stopSignals
+	defer func() { notifySignals, stopSignals = origNotify, origStop }()
+
+	notifySignals = func(c chan<- os.Signal, sig ...os.Signal) {
+		assert.Equal(t, []os.Signal{syscall.SIGINT, syscall.SIGTERM}, sig)
+		c <- syscall.SIGTERM
+	}
+	stopped := false
+	stopSignals = func(c chan<- os.Signal) { stopped = true }
+
+	m := New(time.Second)
+	hookRan := false
+	m.OnShutdown("hook", func(ctx context.Context) error { hookRan = true; return nil })
+
+	assert.NoError(t, m.Wait())
+	assert.True(t, hookRan)
+	assert.True(t, stopped)
+}
+
+func TestWaitOnStop(t *testing.T) {
+	origNotify := notifySignals
+	defer func() { notifySignals = origNotify }()
+	notifySignals = func(c chan<- os.Signal, sig ...os.Signal) {}
+
+	m := New(time.Second)
+	m.Stop()
+	assert.NoError(t, m.Wait())
+}
+
+func TestServeHTTPDrainsOnCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- ServeHTTP(srv, time.Second)(ctx) }()
+
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusNoContent
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-errCh)
+}
+
+func TestServeHTTPListenError(t *testing.T) {
+	srv := &http.Server{Addr: "256.0.0.1:bad"}
+	assert.Error(t, ServeHTTP(srv, time.Second)(context.Background()))
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Idempotency record states
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord remembers the outcome of a request made with an Idempotency-Key
+type IdempotencyRecord struct {
+	Key          string    `bson:"_id"`
+	RequestHash  string    `bson:"request_hash"`
+	Status       string    `bson:"status"`
+	ResponseCode int       `bson:"response_code,omitempty"`
+	ResponseBody string    `bson:"response_body,omitempty"`
+	LockedAt     time.Time `bson:"locked_at"`
+	ExpiresAt    time.Time `bson:"expires_at"`
+}
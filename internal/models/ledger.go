@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Ledger entry types
+const (
+	LedgerHold    = "hold"
+	LedgerCapture = "capture"
+	LedgerCancel  = "cancel"
+	LedgerRefund  = "refund"
+	LedgerWebhook = "webhook"
+)
+
+// LedgerTypes lists every ledger entry type
+var LedgerTypes = []string{LedgerHold, LedgerCapture, LedgerCancel, LedgerRefund, LedgerWebhook}
+
+// LedgerEntry is one append-only record of a payment operation. The ID is
+// derived from the Stripe object it records, so recording it twice is harmless.
+type LedgerEntry struct {
+	ID              string    `json:"id" bson:"_id"`
+	Type            string    `json:"type" bson:"type"`
+	PaymentIntentID string    `json:"payment_intent_id" bson:"payment_intent_id"`
+	RefundID        string    `json:"refund_id,omitempty" bson:"refund_id,omitempty"`
+	EventID         string    `json:"event_id,omitempty" bson:"event_id,omitempty"`
+	EventType       string    `json:"event_type,omitempty" bson:"event_type,omitempty"`
+	Status          string    `json:"status" bson:"status"`
+	FailureCode     string    `json:"failure_code,omitempty" bson:"failure_code,omitempty"`
+	Amount          int64     `json:"amount" bson:"amount"`
+	Currency        string    `json:"currency" bson:"currency"`
+	VIN             string    `json:"vin,omitempty" bson:"vin,omitempty"`
+	Region          string    `json:"region,omitempty" bson:"region,omitempty"`
+	ReservationID   string    `json:"reservation_id,omitempty" bson:"reservation_id,omitempty"`
+	Actor           string    `json:"actor" bson:"actor"`
+	CreatedAt       time.Time `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// PaymentEvent is the normalized form of a Stripe payment_intent.* webhook event
+// as stored in MongoDB and republished to Kafka
+type PaymentEvent struct {
+	EventID            string            `json:"event_id" bson:"event_id"`
+	Type               string            `json:"type" bson:"type"`
+	PaymentIntentID    string            `json:"payment_intent_id" bson:"payment_intent_id"`
+	Status             string            `json:"status" bson:"status"`
+	Amount             int64             `json:"amount" bson:"amount"`
+	AmountCapturable   int64             `json:"amount_capturable" bson:"amount_capturable"`
+	AmountReceived     int64             `json:"amount_received" bson:"amount_received"`
+	Currency           string            `json:"currency" bson:"currency"`
+	CancellationReason string            `json:"cancellation_reason,omitempty" bson:"cancellation_reason,omitempty"`
+	FailureCode        string            `json:"failure_code,omitempty" bson:"failure_code,omitempty"`
+	FailureMessage     string            `json:"failure_message,omitempty" bson:"failure_message,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Livemode           bool              `json:"livemode" bson:"livemode"`
+	Created            time.Time         `json:"created" bson:"created"`
+
+	// Set on refund.created events only
+	RefundID       string `json:"refund_id,omitempty" bson:"refund_id,omitempty"`
+	RefundAmount   int64  `json:"refund_amount,omitempty" bson:"refund_amount,omitempty"`
+	RefundReason   string `json:"refund_reason,omitempty" bson:"refund_reason,omitempty"`
+	AmountRefunded int64  `json:"amount_refunded,omitempty" bson:"amount_refunded,omitempty"`
+}
+
+// RefundCreatedEvent is the PaymentEvent type published when a refund is issued
+const RefundCreatedEvent = "refund.created"
+
+// Payment is the stored record of a held PaymentIntent, its capture and its refund history
+type Payment struct {
+	ID             string    `json:"payment_intent_id" bson:"_id"`
+	VIN            string    `json:"vin,omitempty" bson:"vin,omitempty"`
+	Region         string    `json:"region,omitempty" bson:"region,omitempty"`
+	Status         string    `json:"status" bson:"status"`
+	Amount         int64     `json:"amount" bson:"amount"`
+	AmountReceived int64     `json:"amount_received" bson:"amount_received"`
+	AmountRefunded int64     `json:"amount_refunded" bson:"amount_refunded"`
+	Currency       string    `json:"currency" bson:"currency"`
+	Refunds        []Refund  `json:"refunds" bson:"refunds"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Refund is one refund of a Payment
+type Refund struct {
+	ID        string    `json:"refund_id" bson:"refund_id"`
+	Amount    int64     `json:"amount" bson:"amount"`
+	Currency  string    `json:"currency" bson:"currency"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status    string    `json:"status" bson:"status"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Reservation states. Pending and active reservations hold their VIN.
+const (
+	ReservationPending   = "pending"
+	ReservationActive    = "active"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+	ReservationFailed    = "failed"
+	ReservationCompleted = "completed"
+)
+
+// Reservation ties a Stripe payment hold to a VIN until it expires or is released
+type Reservation struct {
+	ID              string     `json:"id" bson:"_id"`
+	VIN             string     `json:"vin" bson:"vin"`
+	Region          string     `json:"region,omitempty" bson:"region,omitempty"` // selects the Stripe account of the hold
+	Status          string     `json:"status" bson:"status"`
+	Active          bool       `json:"-" bson:"active"` // true while the reservation holds its VIN
+	PaymentIntentID string     `json:"payment_intent_id,omitempty" bson:"payment_intent_id,omitempty"`
+	Amount          int64      `json:"amount" bson:"amount"`
+	Currency        string     `json:"currency" bson:"currency"`
+	CreatedAt       time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt       time.Time  `json:"expires_at" bson:"expires_at"`
+	ReleasedAt      *time.Time `json:"released_at,omitempty" bson:"released_at,omitempty"`
+}
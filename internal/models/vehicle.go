@@ -1,22 +1,30 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
+// VehicleStatusSubscribed is the vehicleStatus of a vehicle with a live subscription
+const VehicleStatusSubscribed = "SUBSCRIBED"
+
 // VehicleSubscription represents a single vehicle subscription in the JSON response
 type VehicleSubscription struct {
-	VehicleStatus               string `json:"vehicleStatus"`
-	Generation                  string `json:"generation,omitempty"`
-	Region                      string `json:"region,omitempty"` // optional
-	Vin                         string `json:"vin"`
-	IsSafetyActive              bool   `json:"isSafetyActive,omitempty"`
-	IsServiceConnectActive      bool   `json:"isServiceConnectActive,omitempty"`
-	IsRemoteActive              bool   `json:"isRemoteActive,omitempty"`
-	IsDigitalKeyRemoteActive    bool   `json:"isDigitalKeyRemoteActive,omitempty"`
-	IsDestinationAssistActive   bool   `json:"isDestinationAssistActive,omitempty"`
-	IsNavigationActive          bool   `json:"isNavigationActive,omitempty"`
-	IsVirtualAssistantActive    bool   `json:"isVirtualAssistantActive,omitempty"`
-	IsIntegratedStreamingActive bool   `json:"isIntegratedStreamingActive,omitempty"`
-	IsWifiActive                bool   `json:"isWifiActive,omitempty"`
-	Brand                       string `json:"brand,omitempty"`
-	ActivePaidSubscriptions     bool   `json:"activePaidSubscriptions"`
+	VehicleStatus               string `json:"vehicleStatus" bson:"vehicleStatus"`
+	Generation                  string `json:"generation,omitempty" bson:"generation"`
+	Region                      string `json:"region,omitempty" bson:"region"` // optional
+	Vin                         string `json:"vin" bson:"vin"`
+	IsSafetyActive              bool   `json:"isSafetyActive,omitempty" bson:"isSafetyActive"`
+	IsServiceConnectActive      bool   `json:"isServiceConnectActive,omitempty" bson:"isServiceConnectActive"`
+	IsRemoteActive              bool   `json:"isRemoteActive,omitempty" bson:"isRemoteActive"`
+	IsDigitalKeyRemoteActive    bool   `json:"isDigitalKeyRemoteActive,omitempty" bson:"isDigitalKeyRemoteActive"`
+	IsDestinationAssistActive   bool   `json:"isDestinationAssistActive,omitempty" bson:"isDestinationAssistActive"`
+	IsNavigationActive          bool   `json:"isNavigationActive,omitempty" bson:"isNavigationActive"`
+	IsVirtualAssistantActive    bool   `json:"isVirtualAssistantActive,omitempty" bson:"isVirtualAssistantActive"`
+	IsIntegratedStreamingActive bool   `json:"isIntegratedStreamingActive,omitempty" bson:"isIntegratedStreamingActive"`
+	IsWifiActive                bool   `json:"isWifiActive,omitempty" bson:"isWifiActive"`
+	Brand                       string `json:"brand,omitempty" bson:"brand"`
+	ActivePaidSubscriptions     bool   `json:"activePaidSubscriptions" bson:"activePaidSubscriptions"`
 }
 
 // Payload represents the payload containing vehicle subscriptions
@@ -37,12 +45,39 @@ type VehicleResponse struct {
 	Payload Payload `json:"payload"`
 }
 
-// StockData represents the bid/ask stock data sent to Kafka
+// StockData represents the bid/ask stock data sent to Kafka.
+// Time is serialised as RFC3339 in JSON and stored as a native date in MongoDB.
 type StockData struct {
-	Ticker string  `json:"ticker"`
-	Bid    float64 `json:"bid"`
-	Ask    float64 `json:"ask"`
-	Time   string  `json:"time"`
+	Ticker string     `json:"ticker" bson:"ticker"`
+	Bid    float64    `json:"bid" bson:"bid"`
+	Ask    float64    `json:"ask" bson:"ask"`
+	Time   time.Time  `json:"time" bson:"time"`
+	Meta   *StockMeta `json:"meta,omitempty" bson:"meta,omitempty"`
+}
+
+// tickerPrefix starts the ticker of every vehicle
+const tickerPrefix = "VEHICLE-"
+
+// StockMeta identifies the series of a tick; it is the metaField of a
+// time-series stock collection
+type StockMeta struct {
+	Ticker string `json:"ticker" bson:"ticker"`
+	VIN    string `json:"vin" bson:"vin"`
+	Region string `json:"region,omitempty" bson:"region,omitempty"`
+}
+
+// NewStockMeta returns the series of ticker in region, with the VIN taken from the ticker
+func NewStockMeta(ticker, region string) *StockMeta {
+	return &StockMeta{Ticker: ticker, VIN: strings.TrimPrefix(ticker, tickerPrefix), Region: region}
+}
+
+// WithMeta returns the tick with its series set, deriving it from the ticker
+// when the tick carries none
+func (s StockData) WithMeta() StockData {
+	if s.Meta == nil {
+		s.Meta = NewStockMeta(s.Ticker, "")
+	}
+	return s
 }
 
 // Helper method: Validate VIN format (simple example)
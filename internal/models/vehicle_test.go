@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -49,11 +50,11 @@ func TestVehicleResponseMultipleMessages(t *testing.T) {
 }
 
 func TestStockDataEdgeValues(t *testing.T) {
-	stock := StockData{Ticker: "TSLA", Bid: -1.0, Ask: 0.0, Time: ""}
+	stock := StockData{Ticker: "TSLA", Bid: -1.0, Ask: 0.0, Time: time.Time{}}
 	assert.Equal(t, "TSLA", stock.Ticker)
 	assert.Equal(t, -1.0, stock.Bid)
 	assert.Equal(t, 0.0, stock.Ask)
-	assert.Equal(t, "", stock.Time)
+	assert.True(t, stock.Time.IsZero())
 }
 
 func TestPayloadEmptySubscriptions(t *testing.T) {
@@ -89,7 +90,7 @@ func TestVehicleResponseModel(t *testing.T) {
 }
 
 func TestStockDataJSON(t *testing.T) {
-	stock := StockData{Ticker: "AAPL", Bid: 150.0, Ask: 151.0, Time: "2025-08-24"}
+	stock := StockData{Ticker: "AAPL", Bid: 150.0, Ask: 151.0, Time: time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC)}
 	data, err := json.Marshal(stock)
 	assert.NoError(t, err)
 	var out StockData
@@ -116,11 +117,11 @@ func TestVehicleSubscriptionIsValidVIN(t *testing.T) {
 }
 
 func TestStockDataFields(t *testing.T) {
-	s := StockData{Ticker: "AAPL", Bid: 150.0, Ask: 151.0, Time: "2025-08-25"}
+	s := StockData{Ticker: "AAPL", Bid: 150.0, Ask: 151.0, Time: time.Date(2025, 8, 25, 0, 0, 0, 0, time.UTC)}
 	assert.Equal(t, "AAPL", s.Ticker)
 	assert.Equal(t, 150.0, s.Bid)
 	assert.Equal(t, 151.0, s.Ask)
-	assert.Equal(t, "2025-08-25", s.Time)
+	assert.Equal(t, "2025-08-25T00:00:00Z", s.Time.Format(time.RFC3339))
 }
 
 func TestPayloadAndVehicleResponse(t *testing.T) {
@@ -139,3 +140,25 @@ func TestPayloadAndVehicleResponse(t *testing.T) {
 	assert.Equal(t, "code", resp.Status.Messages[0].ResponseCode)
 	assert.Equal(t, "details", resp.Status.Messages[0].DetailedDescription)
 }
+
+func TestStockDataTimeJSONIsRFC3339(t *testing.T) {
+	var out StockData
+	err := json.Unmarshal([]byte(`{"ticker":"VEHICLE-1","bid":1,"ask":2,"time":"2025-08-24T10:00:00Z"}`), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC), out.Time)
+
+	err = json.Unmarshal([]byte(`{"ticker":"VEHICLE-1","time":"2025-08-24"}`), &out)
+	assert.Error(t, err)
+}
+
+func TestStockDataWithMeta(t *testing.T) {
+	tick := StockData{Ticker: "VEHICLE-VIN1"}.WithMeta()
+	assert.Equal(t, &StockMeta{Ticker: "VEHICLE-VIN1", VIN: "VIN1"}, tick.Meta)
+
+	tick = StockData{Ticker: "VEHICLE-VIN2", Meta: NewStockMeta("VEHICLE-VIN2", "CA")}.WithMeta()
+	assert.Equal(t, &StockMeta{Ticker: "VEHICLE-VIN2", VIN: "VIN2", Region: "CA"}, tick.Meta)
+
+	data, err := json.Marshal(tick)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"meta":{"ticker":"VEHICLE-VIN2","vin":"VIN2","region":"CA"}`)
+}
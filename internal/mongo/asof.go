@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const dateOnlyLayout = "2006-01-02"
+
+// MongoUpdateManyFunc wraps UpdateMany for testability
+var MongoUpdateManyFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+	return coll.UpdateMany(ctx, filter, update)
+}
+
+// ResolveAsOf turns a request date into the instant a price lookup should use.
+// A date-only value (2006-01-02) resolves to the last instant of that day in loc;
+// an RFC3339 timestamp is used as-is.
+func ResolveAsOf(value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	if day, err := time.ParseInLocation(dateOnlyLayout, value, loc); err == nil {
+		return day.AddDate(0, 0, 1).Add(-time.Millisecond), nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: expected YYYY-MM-DD or RFC3339", value)
+	}
+	return at, nil
+}
+
+// MigrateStockTimes converts ticks whose time was stored as an RFC3339 string
+// into native BSON dates. It is idempotent; unparsable strings are left as-is.
+func MigrateStockTimes(database, collection string) (int64, error) {
+	if Client == nil {
+		return 0, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	res, err := MongoUpdateManyFunc(coll, ctx, stringTimeFilter(), stringTimeMigration())
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func stringTimeFilter() bson.M {
+	return bson.M{"time": bson.M{"$type": "string"}}
+}
+
+// stringTimeMigration is an update pipeline (MongoDB 4.2+) so the conversion runs server-side
+func stringTimeMigration() bson.A {
+	return bson.A{
+		bson.M{"$set": bson.M{
+			"time": bson.M{"$dateFromString": bson.M{"dateString": "$time", "onError": "$time"}},
+		}},
+	}
+}
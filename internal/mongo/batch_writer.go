@@ -0,0 +1,187 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+// ErrBatchWriterClosed is returned by writes after Close
+var ErrBatchWriterClosed = errors.New("stock batch writer closed")
+
+// BatchWriterOptions tunes a BatchWriter; zero values use the defaults
+type BatchWriterOptions struct {
+	MaxBatch      int           // ticks per Upsert, default 500
+	FlushInterval time.Duration // longest a tick waits for its batch, default 1s
+	QueueSize     int           // ticks buffered before writers block, default 4*MaxBatch
+	FlushTimeout  time.Duration // bound of one Upsert, default 30s
+	// OnError is called for every tick that was not stored; the default logs it
+	OnError func(tick models.StockData, err error)
+}
+
+// BatchWriter buffers ticks and stores them in the wrapped repository with one
+// Upsert per MaxBatch ticks or FlushInterval, whichever comes first, so ticks
+// stored before are skipped as they are for the Kafka consumers. Insert
+// and InsertMany only queue ticks: they block while the queue is full, so a slow
+// database slows the producers down instead of growing memory, and storage
+// errors are reported per tick through OnError. Reads and Upsert go straight
+// to the wrapped repository; reads do not see queued ticks.
+type BatchWriter struct {
+	StockRepository
+	opts    BatchWriterOptions
+	queue   chan models.StockData
+	closing chan struct{} // closed by Close; wakes writers waiting for room
+	done    chan struct{}
+
+	// mu guards closed and the registration of writers in inserts; it is
+	// never held while waiting on the queue
+	mu      sync.Mutex
+	closed  bool
+	inserts sync.WaitGroup
+}
+
+// NewBatchWriter starts a writer that flushes into repo
+func NewBatchWriter(repo StockRepository, opts BatchWriterOptions) *BatchWriter {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 4 * opts.MaxBatch
+	}
+	if opts.FlushTimeout <= 0 {
+		opts.FlushTimeout = 30 * time.Second
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(tick models.StockData, err error) {
+			log.Printf("Storing stock tick %s at %s failed: %v", tick.Ticker, tick.Time.Format(time.RFC3339), err)
+		}
+	}
+	w := &BatchWriter{
+		StockRepository: repo,
+		opts:            opts,
+		queue:           make(chan models.StockData, opts.QueueSize),
+		closing:         make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Insert queues one tick, waiting for room in the queue until ctx is done or
+// the writer is closed
+func (w *BatchWriter) Insert(ctx context.Context, tick models.StockData) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrBatchWriterClosed
+	}
+	w.inserts.Add(1)
+	w.mu.Unlock()
+	defer w.inserts.Done()
+
+	select {
+	case w.queue <- tick:
+		return nil
+	case <-w.closing:
+		return ErrBatchWriterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// InsertMany queues ticks in order; on error the ticks before the failing one stay queued
+func (w *BatchWriter) InsertMany(ctx context.Context, ticks []models.StockData) error {
+	for _, tick := range ticks {
+		if err := w.Insert(ctx, tick); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops accepting ticks and waits until the queued ones are flushed or
+// ctx is done. Writers blocked on a full queue return ErrBatchWriterClosed.
+func (w *BatchWriter) Close(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+		// The queue is closed once no writer can send to it any more
+		go func() {
+			w.inserts.Wait()
+			close(w.queue)
+		}()
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects batches until the queue is closed
+func (w *BatchWriter) run() {
+	defer close(w.done)
+	timer := time.NewTimer(w.opts.FlushInterval)
+	timer.Stop()
+	batch := make([]models.StockData, 0, w.opts.MaxBatch)
+	flush := func() {
+		timer.Stop()
+		if len(batch) > 0 {
+			w.flush(batch)
+			batch = make([]models.StockData, 0, w.opts.MaxBatch)
+		}
+	}
+
+	for {
+		select {
+		case tick, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(w.opts.FlushInterval)
+			}
+			batch = append(batch, tick)
+			if len(batch) >= w.opts.MaxBatch {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// flush stores batch and reports the ticks that were not stored
+func (w *BatchWriter) flush(batch []models.StockData) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.FlushTimeout)
+	defer cancel()
+	err := w.StockRepository.Upsert(ctx, batch)
+	if err == nil {
+		return
+	}
+	var partial *TickWriteError
+	if errors.As(err, &partial) {
+		for _, f := range partial.Failed {
+			w.opts.OnError(f.Tick, f.Err)
+		}
+		return
+	}
+	for _, tick := range batch {
+		w.opts.OnError(tick, err)
+	}
+}
@@ -0,0 +1,168 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+// batchRecorder is a memory repository that records the size of every Upsert
+type batchRecorder struct {
+	MemoryStockRepository
+	mu      sync.Mutex
+	batches []int
+	release chan struct{} // when set, Upsert waits for it
+	err     func(ticks []models.StockData) error
+}
+
+func (r *batchRecorder) Upsert(ctx context.Context, ticks []models.StockData) error {
+	if r.release != nil {
+		<-r.release
+	}
+	r.mu.Lock()
+	r.batches = append(r.batches, len(ticks))
+	r.mu.Unlock()
+	if r.err != nil {
+		return r.err(ticks)
+	}
+	return r.MemoryStockRepository.Upsert(ctx, ticks)
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+func TestBatchWriterFlushesBySize(t *testing.T) {
+	ctx := context.Background()
+	repo := &batchRecorder{}
+	w := NewBatchWriter(repo, BatchWriterOptions{MaxBatch: 3, FlushInterval: time.Hour})
+
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, w.Insert(ctx, repoTick("VEHICLE-1", time.Duration(i)*time.Second, float64(i))))
+	}
+	assert.Eventually(t, func() bool { return len(repo.sizes()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, w.Close(ctx))
+	assert.Equal(t, []int{3, 3, 1}, repo.sizes())
+
+	// Reads go to the wrapped repository
+	latest, err := w.Latest(ctx, "VEHICLE-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 6.0, latest.Bid)
+
+	assert.ErrorIs(t, w.Insert(ctx, repoTick("VEHICLE-1", 0, 100)), ErrBatchWriterClosed)
+	assert.NoError(t, w.Close(ctx))
+}
+
+func TestBatchWriterSkipsStoredTicks(t *testing.T) {
+	ctx := context.Background()
+	repo := &batchRecorder{}
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+	w := NewBatchWriter(repo, BatchWriterOptions{MaxBatch: 100, FlushInterval: time.Hour})
+
+	assert.NoError(t, w.InsertMany(ctx, []models.StockData{repoTick("VEHICLE-1", 0, 999), repoTick("VEHICLE-1", time.Minute, 101)}))
+	assert.NoError(t, w.Close(ctx))
+	ticks, err := repo.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: parseTestTime(testDate), To: parseTestTime(testDate).Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.StockData{repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-1", time.Minute, 101)}, ticks)
+}
+
+func TestBatchWriterFlushesByTime(t *testing.T) {
+	ctx := context.Background()
+	repo := &batchRecorder{}
+	w := NewBatchWriter(repo, BatchWriterOptions{MaxBatch: 100, FlushInterval: 20 * time.Millisecond})
+	defer w.Close(ctx)
+
+	assert.NoError(t, w.InsertMany(ctx, []models.StockData{repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-2", 0, 200)}))
+	assert.Eventually(t, func() bool { return len(repo.sizes()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{2}, repo.sizes())
+}
+
+func TestBatchWriterReportsFailedTicks(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	failed := map[string]error{}
+	repo := &batchRecorder{}
+	w := NewBatchWriter(repo, BatchWriterOptions{MaxBatch: 2, FlushInterval: time.Hour, OnError: func(tick models.StockData, err error) {
+		mu.Lock()
+		failed[tick.Ticker] = err
+		mu.Unlock()
+	}})
+
+	invalid := errors.New("Document failed validation")
+	repo.err = func(ticks []models.StockData) error {
+		if ticks[0].Ticker == "VEHICLE-1" {
+			return &TickWriteError{Failed: []FailedTick{{Tick: ticks[1], Err: invalid}}}
+		}
+		return errors.New("connection reset")
+	}
+	assert.NoError(t, w.InsertMany(ctx, []models.StockData{
+		repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-2", 0, 200),
+		repoTick("VEHICLE-3", 0, 300), repoTick("VEHICLE-4", 0, 400),
+	}))
+	assert.NoError(t, w.Close(ctx))
+
+	assert.Equal(t, map[string]error{
+		"VEHICLE-2": invalid,
+		"VEHICLE-3": errors.New("connection reset"),
+		"VEHICLE-4": errors.New("connection reset"),
+	}, failed)
+}
+
+func TestBatchWriterBackpressure(t *testing.T) {
+	ctx := context.Background()
+	repo := &batchRecorder{release: make(chan struct{})}
+	w := NewBatchWriter(repo, BatchWriterOptions{MaxBatch: 1, QueueSize: 1, FlushInterval: time.Hour})
+
+	// The first tick is being flushed and the second fills the queue
+	assert.NoError(t, w.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+	assert.Eventually(t, func() bool { return len(w.queue) == 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, w.Insert(ctx, repoTick("VEHICLE-1", time.Second, 101)))
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, w.Insert(short, repoTick("VEHICLE-1", 2*time.Second, 102)), context.DeadlineExceeded)
+
+	close(repo.release)
+	assert.NoError(t, w.Close(ctx))
+	assert.Equal(t, []int{1, 1}, repo.sizes())
+}
+
+func TestBatchWriterCloseWithFullQueue(t *testing.T) {
+	ctx := context.Background()
+	repo := &batchRecorder{release: make(chan struct{})}
+	w := NewBatchWriter(repo, BatchWriterOptions{MaxBatch: 1, QueueSize: 1, FlushInterval: time.Hour})
+
+	// The flusher stalls on the first tick, the second fills the queue and a
+	// third writer without a deadline waits for room
+	assert.NoError(t, w.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+	assert.Eventually(t, func() bool { return len(w.queue) == 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, w.Insert(ctx, repoTick("VEHICLE-1", time.Second, 101)))
+	blocked := make(chan error, 1)
+	go func() { blocked <- w.Insert(ctx, repoTick("VEHICLE-1", 2*time.Second, 102)) }()
+
+	// Close gives up at its deadline and releases the waiting writer
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, w.Close(short), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	select {
+	case err := <-blocked:
+		assert.ErrorIs(t, err, ErrBatchWriterClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Insert still blocked after Close")
+	}
+	assert.ErrorIs(t, w.Close(short), context.DeadlineExceeded)
+
+	// Once the flusher recovers the queued tick is still stored
+	close(repo.release)
+	assert.NoError(t, w.Close(ctx))
+	assert.Equal(t, []int{1, 1}, repo.sizes())
+}
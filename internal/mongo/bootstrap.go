@@ -0,0 +1,482 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Validation settings of the stock collection. Moderate validation leaves
+// legacy documents (e.g. string times awaiting migration) updatable.
+const (
+	stockValidationLevel  = "moderate"
+	stockValidationAction = "error"
+)
+
+// timeSeriesMinServerVersion is the first MongoDB major version with time-series collections
+const timeSeriesMinServerVersion = 5
+
+// stockMetaField is the tick subdocument identifying a series by ticker, VIN and region
+const stockMetaField = "meta"
+
+// stockTTLIndex expires ticks on a regular collection
+const stockTTLIndex = "time_ttl"
+
+// StockCollectionOptions selects the type of stock collection BootstrapStockCollection sets up
+type StockCollectionOptions struct {
+	// TimeSeries creates a time-series collection on MongoDB 5.0+; older servers
+	// and existing regular collections fall back to a regular collection. A
+	// time-series collection has no unique index and no change streams, so
+	// callers check StockSchemaDrift.TimeSeries before relying on either.
+	TimeSeries  bool
+	MetaField   string // "meta", the default, is the only supported value
+	Granularity string // default "seconds"
+	// ExpireAfterSeconds removes older ticks, natively on a time-series
+	// collection and through a TTL index on the fallback; 0 keeps them forever
+	ExpireAfterSeconds int64
+}
+
+func (o StockCollectionOptions) metaField() string {
+	if o.MetaField == "" {
+		return stockMetaField
+	}
+	return o.MetaField
+}
+
+func (o StockCollectionOptions) granularity() string {
+	if o.Granularity == "" {
+		return "seconds"
+	}
+	return o.Granularity
+}
+
+// expiry is the tick lifetime in seconds; expiry belongs to the time-series setup
+func (o StockCollectionOptions) expiry() int64 {
+	if !o.TimeSeries || o.ExpireAfterSeconds < 0 {
+		return 0
+	}
+	return o.ExpireAfterSeconds
+}
+
+// MongoCollectionOptionsFunc returns the creation options of a collection and whether it exists
+var MongoCollectionOptionsFunc = func(db *mongo.Database, ctx context.Context, name string) (bson.Raw, bool, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil || len(specs) == 0 {
+		return nil, false, err
+	}
+	return specs[0].Options, true, nil
+}
+
+// MongoServerVersionFunc returns the server version as [major, minor, patch, ...] for testability
+var MongoServerVersionFunc = func(db *mongo.Database, ctx context.Context) ([]int32, error) {
+	var info struct {
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	err := db.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	return info.VersionArray, err
+}
+
+// MongoListIndexesFunc lists a collection's indexes for testability
+var MongoListIndexesFunc = func(coll *mongo.Collection, ctx context.Context) ([]*mongo.IndexSpecification, error) {
+	return coll.Indexes().ListSpecifications(ctx)
+}
+
+// MongoRunCommandFunc runs a database command for testability
+var MongoRunCommandFunc = func(db *mongo.Database, ctx context.Context, cmd bson.D) error {
+	return db.RunCommand(ctx, cmd).Err()
+}
+
+// MongoAggregateFunc runs an aggregation and decodes every result for testability
+var MongoAggregateFunc = func(coll *mongo.Collection, ctx context.Context, pipeline interface{}) ([]bson.M, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var out []bson.M
+	err = cursor.All(ctx, &out)
+	return out, err
+}
+
+// StockSchemaDrift lists how a stock collection differs from what
+// BootstrapStockCollection sets up. The zero value means no drift.
+type StockSchemaDrift struct {
+	Collection        string `json:"collection"`
+	CollectionMissing bool   `json:"collection_missing,omitempty"`
+	// TimeSeries is set when the collection is, or will be created as, a time-series collection
+	TimeSeries bool `json:"time_series,omitempty"`
+	// TimeSeriesUnavailable is set when a time-series collection was requested but the
+	// collection exists as a regular one or the server is too old; it is kept as is
+	TimeSeriesUnavailable bool `json:"time_series_unavailable,omitempty"`
+	// TimeSeriesOutdated is set when the granularity or expiry of a time-series collection differ
+	TimeSeriesOutdated bool `json:"time_series_outdated,omitempty"`
+	ValidatorOutdated  bool `json:"validator_outdated,omitempty"`
+	// TTLOutdated is set when the TTL index of a regular collection expires
+	// ticks after another lifetime; the bootstrap changes it in place
+	TTLOutdated bool `json:"ttl_outdated,omitempty"`
+	// MissingIndexes are created by the bootstrap
+	MissingIndexes []string `json:"missing_indexes,omitempty"`
+	// StaleIndexes are dropped by the bootstrap, such as the TTL index once ticks are kept forever
+	StaleIndexes []string `json:"stale_indexes,omitempty"`
+	// ConflictingIndexes share a name with an expected index but not its definition;
+	// they have to be dropped by hand
+	ConflictingIndexes []string `json:"conflicting_indexes,omitempty"`
+	// DuplicateTicks counts ticker/time pairs stored more than once, which block the unique index
+	DuplicateTicks int64 `json:"duplicate_ticks,omitempty"`
+}
+
+// None reports whether the collection matches the expected schema. An
+// unavailable time-series collection is not drift the bootstrap could fix.
+func (d StockSchemaDrift) None() bool {
+	return !d.CollectionMissing && !d.TimeSeriesOutdated && !d.ValidatorOutdated && !d.TTLOutdated &&
+		len(d.MissingIndexes) == 0 && len(d.StaleIndexes) == 0 && len(d.ConflictingIndexes) == 0 && d.DuplicateTicks == 0
+}
+
+// String summarizes the drift for logs
+func (d StockSchemaDrift) String() string {
+	var parts []string
+	if d.CollectionMissing {
+		kind := "regular"
+		if d.TimeSeries {
+			kind = "time-series"
+		}
+		parts = append(parts, "collection missing ("+kind+")")
+	}
+	if d.TimeSeriesUnavailable {
+		parts = append(parts, "time-series unavailable, using a regular collection")
+	}
+	if d.TimeSeriesOutdated {
+		parts = append(parts, "time-series options outdated")
+	}
+	if d.ValidatorOutdated {
+		parts = append(parts, "validator outdated")
+	}
+	if d.TTLOutdated {
+		parts = append(parts, "TTL outdated")
+	}
+	if len(d.MissingIndexes) > 0 {
+		parts = append(parts, "missing indexes "+strings.Join(d.MissingIndexes, ", "))
+	}
+	if len(d.StaleIndexes) > 0 {
+		parts = append(parts, "stale indexes "+strings.Join(d.StaleIndexes, ", "))
+	}
+	if len(d.ConflictingIndexes) > 0 {
+		parts = append(parts, "conflicting indexes "+strings.Join(d.ConflictingIndexes, ", "))
+	}
+	if d.DuplicateTicks > 0 {
+		parts = append(parts, fmt.Sprintf("%d duplicated ticker/time pairs", d.DuplicateTicks))
+	}
+	if len(parts) == 0 {
+		return d.Collection + ": up to date"
+	}
+	return d.Collection + ": " + strings.Join(parts, "; ")
+}
+
+// stockIndexes are the indexes of the stock collection. On a regular collection
+// the unique {ticker, time} index serves as-of and range lookups and rejects
+// duplicate ticks, and a TTL index implements expiry. Time-series collections
+// support neither unique nor TTL indexes, look ticks up by their meta series
+// and expire them natively.
+func stockIndexes(timeSeries bool, want StockCollectionOptions) []mongo.IndexModel {
+	if timeSeries {
+		keys := bson.D{{Key: stockTickerField(true), Value: 1}, {Key: "time", Value: 1}}
+		return []mongo.IndexModel{{Keys: keys, Options: options.Index().SetName("meta_ticker_time")}}
+	}
+	keys := bson.D{{Key: "ticker", Value: 1}, {Key: "time", Value: 1}}
+	indexes := []mongo.IndexModel{{Keys: keys, Options: options.Index().SetName("ticker_time_unique").SetUnique(true)}}
+	if expiry := want.expiry(); expiry > 0 {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: "time", Value: 1}},
+			Options: options.Index().SetName(stockTTLIndex).SetExpireAfterSeconds(int32(expiry)),
+		})
+	}
+	return indexes
+}
+
+// stockValidator requires a ticker, a native date and numeric prices on every
+// tick. The meta series is optional, as legacy ticks were stored without one.
+func stockValidator() bson.D {
+	number := bson.M{"bsonType": bson.A{"double", "int", "long", "decimal"}}
+	return bson.D{{Key: "$jsonSchema", Value: bson.D{
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: bson.A{"ticker", "time", "bid", "ask"}},
+		{Key: "properties", Value: bson.D{
+			{Key: "ticker", Value: bson.M{"bsonType": "string"}},
+			{Key: "time", Value: bson.M{"bsonType": "date"}},
+			{Key: "bid", Value: number},
+			{Key: "ask", Value: number},
+			{Key: stockMetaField, Value: bson.D{
+				{Key: "bsonType", Value: "object"},
+				{Key: "required", Value: bson.A{"ticker", "vin"}},
+				{Key: "properties", Value: bson.D{
+					{Key: "ticker", Value: bson.M{"bsonType": "string"}},
+					{Key: "vin", Value: bson.M{"bsonType": "string"}},
+					{Key: "region", Value: bson.M{"bsonType": "string"}},
+				}},
+			}},
+		}},
+	}}}
+}
+
+// BootstrapStockCollection creates the stock collection with its options and
+// indexes, or brings an existing one up to date. With dryRun it changes nothing
+// and only reports the drift. Conflicting indexes and duplicate ticks are not
+// repaired automatically and fail a real run.
+func BootstrapStockCollection(database, collection string, want StockCollectionOptions, dryRun bool) (StockSchemaDrift, error) {
+	drift := StockSchemaDrift{Collection: collection}
+	if want.TimeSeries && want.metaField() != stockMetaField {
+		return drift, fmt.Errorf("unsupported time-series meta field %q: stock ticks are identified by their %q series", want.MetaField, stockMetaField)
+	}
+	if Client == nil {
+		return drift, fmt.Errorf("Mongo client is not initialized")
+	}
+	db := Client.Database(database)
+	coll := db.Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	opts, exists, err := MongoCollectionOptionsFunc(db, ctx, collection)
+	if err != nil {
+		return drift, fmt.Errorf("reading collection options: %w", err)
+	}
+	timeSeries := exists && isTimeSeries(opts)
+	if meta, _ := opts.Lookup("timeseries", "metaField").StringValueOK(); timeSeries && meta != stockMetaField {
+		return drift, fmt.Errorf("time-series collection %s groups ticks by %q, not their %q series; it has to be recreated", collection, meta, stockMetaField)
+	}
+	if !exists && want.TimeSeries {
+		version, err := MongoServerVersionFunc(db, ctx)
+		if err != nil {
+			return drift, fmt.Errorf("reading server version: %w", err)
+		}
+		timeSeries = len(version) > 0 && version[0] >= timeSeriesMinServerVersion
+	}
+	var existing []*mongo.IndexSpecification
+	if exists {
+		if existing, err = MongoListIndexesFunc(coll, ctx); err != nil {
+			return drift, fmt.Errorf("listing indexes: %w", err)
+		}
+	}
+	drift = stockSchemaDrift(collection, exists, timeSeries, opts, existing, want)
+	if exists && !timeSeries && slices.Contains(drift.MissingIndexes, "ticker_time_unique") {
+		if drift.DuplicateTicks, err = countDuplicateTicks(coll, ctx); err != nil {
+			return drift, fmt.Errorf("counting duplicate ticks: %w", err)
+		}
+	}
+	if dryRun || drift.None() {
+		return drift, nil
+	}
+	if len(drift.ConflictingIndexes) > 0 || drift.DuplicateTicks > 0 {
+		return drift, fmt.Errorf("stock collection needs manual repair: %s", drift)
+	}
+
+	for _, cmd := range stockBootstrapCommands(drift, want) {
+		if err := MongoRunCommandFunc(db, ctx, cmd); err != nil {
+			return drift, fmt.Errorf("applying collection options: %w", err)
+		}
+	}
+	if missing := missingStockIndexes(drift, want); len(missing) > 0 {
+		if _, err := MongoCreateIndexesFunc(coll, ctx, missing); err != nil {
+			return drift, fmt.Errorf("creating indexes: %w", err)
+		}
+	}
+	return drift, nil
+}
+
+// stockBootstrapCommands are the create/collMod commands that fix drift
+func stockBootstrapCommands(drift StockSchemaDrift, want StockCollectionOptions) []bson.D {
+	name := drift.Collection
+	var cmds []bson.D
+	switch {
+	case drift.CollectionMissing && drift.TimeSeries:
+		cmd := bson.D{{Key: "create", Value: name}, {Key: "timeseries", Value: timeSeriesOptions(want)}}
+		if expiry := want.expiry(); expiry > 0 {
+			cmd = append(cmd, bson.E{Key: "expireAfterSeconds", Value: expiry})
+		}
+		cmds = append(cmds, cmd)
+	case drift.CollectionMissing:
+		cmds = append(cmds, append(bson.D{{Key: "create", Value: name}}, validatorOptions()...))
+	case drift.ValidatorOutdated:
+		cmds = append(cmds, append(bson.D{{Key: "collMod", Value: name}}, validatorOptions()...))
+	case drift.TimeSeriesOutdated:
+		// Granularity can only grow; the server rejects anything else
+		cmds = append(cmds, bson.D{{Key: "collMod", Value: name}, {Key: "timeseries", Value: bson.D{{Key: "granularity", Value: want.granularity()}}}})
+		var expiry interface{} = "off"
+		if want.expiry() > 0 {
+			expiry = want.expiry()
+		}
+		cmds = append(cmds, bson.D{{Key: "collMod", Value: name}, {Key: "expireAfterSeconds", Value: expiry}})
+	}
+	if drift.TTLOutdated {
+		cmds = append(cmds, bson.D{{Key: "collMod", Value: name}, {Key: "index", Value: bson.D{
+			{Key: "name", Value: stockTTLIndex},
+			{Key: "expireAfterSeconds", Value: want.expiry()},
+		}}})
+	}
+	for _, index := range drift.StaleIndexes {
+		cmds = append(cmds, bson.D{{Key: "dropIndexes", Value: name}, {Key: "index", Value: index}})
+	}
+	return cmds
+}
+
+func timeSeriesOptions(want StockCollectionOptions) bson.D {
+	return bson.D{
+		{Key: "timeField", Value: "time"},
+		{Key: "metaField", Value: want.metaField()},
+		{Key: "granularity", Value: want.granularity()},
+	}
+}
+
+func validatorOptions() bson.D {
+	return bson.D{
+		{Key: "validator", Value: stockValidator()},
+		{Key: "validationLevel", Value: stockValidationLevel},
+		{Key: "validationAction", Value: stockValidationAction},
+	}
+}
+
+// stockSchemaDrift compares a collection's options and indexes with the expected schema
+func stockSchemaDrift(collection string, exists, timeSeries bool, opts bson.Raw, existing []*mongo.IndexSpecification, want StockCollectionOptions) StockSchemaDrift {
+	drift := StockSchemaDrift{Collection: collection, TimeSeries: timeSeries, TimeSeriesUnavailable: want.TimeSeries && !timeSeries}
+	if !exists {
+		drift.CollectionMissing = true
+		for _, idx := range stockIndexes(timeSeries, want) {
+			drift.MissingIndexes = append(drift.MissingIndexes, *idx.Options.Name)
+		}
+		return drift
+	}
+	if timeSeries {
+		drift.TimeSeriesOutdated = want.TimeSeries && !timeSeriesMatches(opts, want)
+	} else {
+		drift.ValidatorOutdated = !validatorMatches(opts)
+	}
+
+	byName := make(map[string]*mongo.IndexSpecification, len(existing))
+	for _, spec := range existing {
+		byName[spec.Name] = spec
+	}
+	for _, idx := range stockIndexes(timeSeries, want) {
+		name := *idx.Options.Name
+		spec, ok := byName[name]
+		switch {
+		case !ok:
+			drift.MissingIndexes = append(drift.MissingIndexes, name)
+		case indexMatches(idx, spec):
+		case name == stockTTLIndex && ttlOnlyDiffers(idx, spec):
+			drift.TTLOutdated = true
+		default:
+			drift.ConflictingIndexes = append(drift.ConflictingIndexes, name)
+		}
+	}
+	// A TTL index left over from an earlier expiry keeps deleting ticks
+	if _, ok := byName[stockTTLIndex]; ok && !timeSeries && want.expiry() == 0 {
+		drift.StaleIndexes = append(drift.StaleIndexes, stockTTLIndex)
+	}
+	return drift
+}
+
+// ttlOnlyDiffers reports whether got is the TTL index want with another lifetime
+func ttlOnlyDiffers(want mongo.IndexModel, got *mongo.IndexSpecification) bool {
+	if got.ExpireAfterSeconds == nil {
+		return false
+	}
+	relabeled := *got
+	relabeled.ExpireAfterSeconds = want.Options.ExpireAfterSeconds
+	return indexMatches(want, &relabeled)
+}
+
+func isTimeSeries(opts bson.Raw) bool {
+	if opts == nil {
+		return false
+	}
+	_, ok := opts.Lookup("timeseries").DocumentOK()
+	return ok
+}
+
+// timeSeriesMatches compares granularity and expiry; timeField and metaField cannot change
+func timeSeriesMatches(opts bson.Raw, want StockCollectionOptions) bool {
+	granularity, _ := opts.Lookup("timeseries", "granularity").StringValueOK()
+	expiry, _ := opts.Lookup("expireAfterSeconds").AsInt64OK()
+	return granularity == want.granularity() && expiry == want.expiry()
+}
+
+func validatorMatches(opts bson.Raw) bool {
+	if opts == nil {
+		return false
+	}
+	want, err := bson.Marshal(stockValidator())
+	if err != nil {
+		return false
+	}
+	validator, ok := opts.Lookup("validator").DocumentOK()
+	level, _ := opts.Lookup("validationLevel").StringValueOK()
+	action, _ := opts.Lookup("validationAction").StringValueOK()
+	return ok && bytes.Equal(validator, want) && level == stockValidationLevel && action == stockValidationAction
+}
+
+// indexMatches compares key fields, directions, uniqueness and TTL
+func indexMatches(want mongo.IndexModel, got *mongo.IndexSpecification) bool {
+	unique := want.Options.Unique != nil && *want.Options.Unique
+	if unique != (got.Unique != nil && *got.Unique) {
+		return false
+	}
+	if (want.Options.ExpireAfterSeconds == nil) != (got.ExpireAfterSeconds == nil) ||
+		want.Options.ExpireAfterSeconds != nil && *want.Options.ExpireAfterSeconds != *got.ExpireAfterSeconds {
+		return false
+	}
+	elems, err := got.KeysDocument.Elements()
+	keys := want.Keys.(bson.D)
+	if err != nil || len(elems) != len(keys) {
+		return false
+	}
+	for i, e := range elems {
+		dir, ok := e.Value().AsInt64OK()
+		if !ok {
+			if f, isDouble := e.Value().DoubleOK(); isDouble {
+				dir, ok = int64(f), true
+			}
+		}
+		if !ok || e.Key() != keys[i].Key || dir != int64(keys[i].Value.(int)) {
+			return false
+		}
+	}
+	return true
+}
+
+func missingStockIndexes(drift StockSchemaDrift, want StockCollectionOptions) []mongo.IndexModel {
+	var out []mongo.IndexModel
+	for _, idx := range stockIndexes(drift.TimeSeries, want) {
+		if slices.Contains(drift.MissingIndexes, *idx.Options.Name) {
+			out = append(out, idx)
+		}
+	}
+	return out
+}
+
+// countDuplicateTicks counts ticker/time pairs stored more than once
+func countDuplicateTicks(coll *mongo.Collection, ctx context.Context) (int64, error) {
+	res, err := MongoAggregateFunc(coll, ctx, duplicateTicksPipeline())
+	if err != nil || len(res) == 0 {
+		return 0, err
+	}
+	switch n := res[0]["duplicates"].(type) {
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	}
+	return 0, nil
+}
+
+func duplicateTicksPipeline() bson.A {
+	return bson.A{
+		bson.M{"$group": bson.M{"_id": bson.M{"ticker": "$ticker", "time": "$time"}, "n": bson.M{"$sum": 1}}},
+		bson.M{"$match": bson.M{"n": bson.M{"$gt": 1}}},
+		bson.M{"$count": "duplicates"},
+	}
+}
@@ -0,0 +1,321 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// currentStockOptions are the collection options a bootstrapped collection reports
+func currentStockOptions(t *testing.T) bson.Raw {
+	raw, err := bson.Marshal(validatorOptions())
+	assert.NoError(t, err)
+	return raw
+}
+
+func stockIndexSpec(t *testing.T, name string, keys bson.D, unique bool) *mongo.IndexSpecification {
+	raw, err := bson.Marshal(keys)
+	assert.NoError(t, err)
+	return &mongo.IndexSpecification{Name: name, KeysDocument: raw, Unique: &unique}
+}
+
+func TestStockSchemaDrift(t *testing.T) {
+	idIndex := stockIndexSpec(t, "_id_", bson.D{{Key: "_id", Value: 1}}, false)
+	unique := stockIndexSpec(t, "ticker_time_unique", bson.D{{Key: "ticker", Value: int32(1)}, {Key: "time", Value: int32(1)}}, true)
+
+	missing := stockSchemaDrift("ticks", false, false, nil, nil, StockCollectionOptions{})
+	assert.Equal(t, StockSchemaDrift{Collection: "ticks", CollectionMissing: true, MissingIndexes: []string{"ticker_time_unique"}}, missing)
+
+	current := stockSchemaDrift("ticks", true, false, currentStockOptions(t), []*mongo.IndexSpecification{idIndex, unique}, StockCollectionOptions{})
+	assert.True(t, current.None())
+	assert.Equal(t, "ticks: up to date", current.String())
+
+	legacy := stockSchemaDrift("ticks", true, false, bson.Raw{5, 0, 0, 0, 0}, []*mongo.IndexSpecification{idIndex}, StockCollectionOptions{})
+	assert.Equal(t, StockSchemaDrift{Collection: "ticks", ValidatorOutdated: true, MissingIndexes: []string{"ticker_time_unique"}}, legacy)
+
+	notUnique := stockIndexSpec(t, "ticker_time_unique", bson.D{{Key: "ticker", Value: 1}, {Key: "time", Value: 1}}, false)
+	descending := stockIndexSpec(t, "ticker_time_unique", bson.D{{Key: "ticker", Value: 1}, {Key: "time", Value: -1.0}}, true)
+	for _, spec := range []*mongo.IndexSpecification{notUnique, descending} {
+		drift := stockSchemaDrift("ticks", true, false, currentStockOptions(t), []*mongo.IndexSpecification{spec}, StockCollectionOptions{})
+		assert.Equal(t, []string{"ticker_time_unique"}, drift.ConflictingIndexes)
+		assert.Empty(t, drift.MissingIndexes)
+	}
+
+	drift := StockSchemaDrift{Collection: "ticks", ValidatorOutdated: true, MissingIndexes: []string{"ticker_time_unique"}, DuplicateTicks: 3}
+	assert.Equal(t, "ticks: validator outdated; missing indexes ticker_time_unique; 3 duplicated ticker/time pairs", drift.String())
+}
+
+// bootstrapMocks swaps the database calls of BootstrapStockCollection and records the writes
+type bootstrapMocks struct {
+	commands   []bson.D
+	created    []mongo.IndexModel
+	duplicates int32
+	version    []int32
+}
+
+func useBootstrapMocks(t *testing.T, exists bool, opts bson.Raw, indexes []*mongo.IndexSpecification) *bootstrapMocks {
+	origClient, origOpts, origVersion, origList, origRun, origAgg, origCreate := Client, MongoCollectionOptionsFunc, MongoServerVersionFunc, MongoListIndexesFunc, MongoRunCommandFunc, MongoAggregateFunc, MongoCreateIndexesFunc
+	t.Cleanup(func() {
+		Client, MongoCollectionOptionsFunc, MongoServerVersionFunc, MongoListIndexesFunc, MongoRunCommandFunc, MongoAggregateFunc, MongoCreateIndexesFunc = origClient, origOpts, origVersion, origList, origRun, origAgg, origCreate
+	})
+	m := &bootstrapMocks{version: []int32{7, 0, 2, 0}}
+	Client = &mongo.Client{}
+	MongoCollectionOptionsFunc = func(db *mongo.Database, ctx context.Context, name string) (bson.Raw, bool, error) {
+		assert.Equal(t, "db", db.Name())
+		assert.Equal(t, "ticks", name)
+		return opts, exists, nil
+	}
+	MongoServerVersionFunc = func(db *mongo.Database, ctx context.Context) ([]int32, error) {
+		return m.version, nil
+	}
+	MongoListIndexesFunc = func(coll *mongo.Collection, ctx context.Context) ([]*mongo.IndexSpecification, error) {
+		return indexes, nil
+	}
+	MongoRunCommandFunc = func(db *mongo.Database, ctx context.Context, cmd bson.D) error {
+		m.commands = append(m.commands, cmd)
+		return nil
+	}
+	MongoAggregateFunc = func(coll *mongo.Collection, ctx context.Context, pipeline interface{}) ([]bson.M, error) {
+		assert.Equal(t, duplicateTicksPipeline(), pipeline)
+		if m.duplicates == 0 {
+			return nil, nil
+		}
+		return []bson.M{{"duplicates": m.duplicates}}, nil
+	}
+	MongoCreateIndexesFunc = func(coll *mongo.Collection, ctx context.Context, models []mongo.IndexModel) ([]string, error) {
+		m.created = append(m.created, models...)
+		return nil, nil
+	}
+	return m
+}
+
+func TestBootstrapStockCollectionCreates(t *testing.T) {
+	m := useBootstrapMocks(t, false, nil, nil)
+
+	drift, err := BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, true)
+	assert.NoError(t, err)
+	assert.True(t, drift.CollectionMissing)
+	assert.Empty(t, m.commands)
+	assert.Empty(t, m.created)
+
+	_, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, false)
+	assert.NoError(t, err)
+	assert.Len(t, m.commands, 1)
+	assert.Equal(t, bson.E{Key: "create", Value: "ticks"}, m.commands[0][0])
+	assert.Equal(t, validatorOptions(), m.commands[0][1:])
+	assert.Equal(t, stockIndexes(false, StockCollectionOptions{}), m.created)
+}
+
+func TestBootstrapStockCollectionUpdates(t *testing.T) {
+	m := useBootstrapMocks(t, true, nil, nil)
+
+	drift, err := BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, false)
+	assert.NoError(t, err)
+	assert.True(t, drift.ValidatorOutdated)
+	assert.Equal(t, bson.E{Key: "collMod", Value: "ticks"}, m.commands[0][0])
+	assert.Len(t, m.created, 1)
+
+	// Duplicate ticks are only reported in a dry run and block a real one
+	m.commands, m.created, m.duplicates = nil, nil, 2
+	drift, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), drift.DuplicateTicks)
+	_, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, false)
+	assert.ErrorContains(t, err, "2 duplicated ticker/time pairs")
+	assert.Empty(t, m.commands)
+	assert.Empty(t, m.created)
+}
+
+func TestBootstrapStockCollectionUpToDate(t *testing.T) {
+	unique := stockIndexSpec(t, "ticker_time_unique", bson.D{{Key: "ticker", Value: 1}, {Key: "time", Value: 1}}, true)
+	m := useBootstrapMocks(t, true, currentStockOptions(t), []*mongo.IndexSpecification{unique})
+	m.duplicates = 5
+
+	drift, err := BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, false)
+	assert.NoError(t, err)
+	assert.True(t, drift.None())
+	assert.Empty(t, m.commands)
+	assert.Empty(t, m.created)
+
+	MongoCollectionOptionsFunc = func(db *mongo.Database, ctx context.Context, name string) (bson.Raw, bool, error) {
+		return nil, false, errors.New("not authorized")
+	}
+	_, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, true)
+	assert.ErrorContains(t, err, "not authorized")
+
+	Client = nil
+	_, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, true)
+	assert.Error(t, err)
+}
+
+func TestMongoStockRepositoryIgnoresDuplicates(t *testing.T) {
+	origClient, origInsert, origMany := Client, MongoInsertOneFunc, MongoInsertManyFunc
+	defer func() { Client, MongoInsertOneFunc, MongoInsertManyFunc = origClient, origInsert, origMany }()
+	Client = &mongo.Client{}
+	ctx := context.Background()
+	repo := NewMongoStockRepository("db", "ticks")
+	dup := mongo.WriteError{Code: 11000, Message: "E11000 duplicate key error"}
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{dup}}
+	}
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+
+	var bulkErr error
+	MongoInsertManyFunc = func(coll *mongo.Collection, ctx context.Context, docs []interface{}) (*mongo.InsertManyResult, error) {
+		return nil, bulkErr
+	}
+	ticks := []models.StockData{repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-1", 0, 100)}
+	bulkErr = mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: dup}}}
+	assert.NoError(t, repo.InsertMany(ctx, ticks))
+
+	invalid := mongo.WriteError{Index: 1, Code: 121, Message: "Document failed validation"}
+	bulkErr = mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: dup}, {WriteError: invalid}}}
+	err := repo.InsertMany(ctx, ticks)
+	var partial *TickWriteError
+	assert.ErrorAs(t, err, &partial)
+	assert.Equal(t, []FailedTick{{Index: 1, Tick: ticks[1], Err: invalid}}, partial.Failed)
+
+	bulkErr = mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}
+	assert.Equal(t, bulkErr, repo.InsertMany(ctx, ticks))
+}
+
+func TestBootstrapStockCollectionTimeSeries(t *testing.T) {
+	want := StockCollectionOptions{TimeSeries: true, Granularity: "minutes", ExpireAfterSeconds: 86400}
+	m := useBootstrapMocks(t, false, nil, nil)
+
+	drift, err := BootstrapStockCollection("db", "ticks", want, false)
+	assert.NoError(t, err)
+	assert.True(t, drift.TimeSeries)
+	assert.Equal(t, "ticks: collection missing (time-series); missing indexes meta_ticker_time", drift.String())
+	assert.Equal(t, []bson.D{{
+		{Key: "create", Value: "ticks"},
+		{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "time"}, {Key: "metaField", Value: "meta"}, {Key: "granularity", Value: "minutes"}}},
+		{Key: "expireAfterSeconds", Value: int64(86400)},
+	}}, m.commands)
+	assert.Len(t, m.created, 1)
+	assert.Equal(t, bson.D{{Key: "meta.ticker", Value: 1}, {Key: "time", Value: 1}}, m.created[0].Keys)
+	assert.Nil(t, m.created[0].Options.Unique)
+
+	// MongoDB 4.4 gets a regular collection with the unique index and a TTL index
+	m.commands, m.created, m.version = nil, nil, []int32{4, 4, 18, 0}
+	drift, err = BootstrapStockCollection("db", "ticks", want, false)
+	assert.NoError(t, err)
+	assert.False(t, drift.TimeSeries)
+	assert.True(t, drift.TimeSeriesUnavailable)
+	assert.Equal(t, bson.E{Key: "create", Value: "ticks"}, m.commands[0][0])
+	assert.Equal(t, []string{"ticker_time_unique", "time_ttl"}, drift.MissingIndexes)
+	assert.Equal(t, int32(86400), *m.created[1].Options.ExpireAfterSeconds)
+
+	// Series are identified by the meta subdocument of ticks only
+	m.commands, m.created = nil, nil
+	_, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{TimeSeries: true, MetaField: "vin"}, true)
+	assert.ErrorContains(t, err, `unsupported time-series meta field "vin"`)
+	assert.Empty(t, m.commands)
+}
+
+func TestBootstrapStockCollectionTimeSeriesDrift(t *testing.T) {
+	opts, err := bson.Marshal(bson.D{
+		{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "time"}, {Key: "metaField", Value: "meta"}, {Key: "granularity", Value: "seconds"}}},
+	})
+	assert.NoError(t, err)
+	index := stockIndexSpec(t, "meta_ticker_time", bson.D{{Key: "meta.ticker", Value: 1}, {Key: "time", Value: 1}}, false)
+	m := useBootstrapMocks(t, true, opts, []*mongo.IndexSpecification{index})
+
+	drift, err := BootstrapStockCollection("db", "ticks", StockCollectionOptions{TimeSeries: true}, false)
+	assert.NoError(t, err)
+	assert.True(t, drift.None())
+	assert.True(t, drift.TimeSeries)
+	assert.Empty(t, m.commands)
+
+	drift, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{TimeSeries: true, Granularity: "minutes", ExpireAfterSeconds: 3600}, false)
+	assert.NoError(t, err)
+	assert.True(t, drift.TimeSeriesOutdated)
+	assert.Equal(t, []bson.D{
+		{{Key: "collMod", Value: "ticks"}, {Key: "timeseries", Value: bson.D{{Key: "granularity", Value: "minutes"}}}},
+		{{Key: "collMod", Value: "ticks"}, {Key: "expireAfterSeconds", Value: int64(3600)}},
+	}, m.commands)
+
+	// An existing time-series collection stays one when the feature is switched off
+	m.commands = nil
+	drift, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{}, false)
+	assert.NoError(t, err)
+	assert.True(t, drift.None())
+	assert.Empty(t, m.commands)
+
+	// A collection grouping ticks by another field cannot be changed in place
+	legacy, err := bson.Marshal(bson.D{
+		{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "time"}, {Key: "metaField", Value: "ticker"}, {Key: "granularity", Value: "seconds"}}},
+	})
+	assert.NoError(t, err)
+	MongoCollectionOptionsFunc = func(db *mongo.Database, ctx context.Context, name string) (bson.Raw, bool, error) {
+		return legacy, true, nil
+	}
+	_, err = BootstrapStockCollection("db", "ticks", StockCollectionOptions{TimeSeries: true}, true)
+	assert.ErrorContains(t, err, `groups ticks by "ticker"`)
+}
+
+func ttlIndexSpec(t *testing.T, expiry int32) *mongo.IndexSpecification {
+	spec := stockIndexSpec(t, "time_ttl", bson.D{{Key: "time", Value: 1}}, false)
+	spec.ExpireAfterSeconds = &expiry
+	return spec
+}
+
+func TestBootstrapStockCollectionTTLChanges(t *testing.T) {
+	unique := stockIndexSpec(t, "ticker_time_unique", bson.D{{Key: "ticker", Value: 1}, {Key: "time", Value: 1}}, true)
+	m := useBootstrapMocks(t, true, currentStockOptions(t), []*mongo.IndexSpecification{unique, ttlIndexSpec(t, 3600)})
+	want := StockCollectionOptions{TimeSeries: true, ExpireAfterSeconds: 7200}
+
+	drift, err := BootstrapStockCollection("db", "ticks", want, true)
+	assert.NoError(t, err)
+	assert.True(t, drift.TTLOutdated)
+	assert.Empty(t, drift.ConflictingIndexes)
+	assert.Equal(t, "ticks: time-series unavailable, using a regular collection; TTL outdated", drift.String())
+	assert.Empty(t, m.commands)
+
+	// The new lifetime is set in place instead of requiring a manual repair
+	_, err = BootstrapStockCollection("db", "ticks", want, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []bson.D{{
+		{Key: "collMod", Value: "ticks"},
+		{Key: "index", Value: bson.D{{Key: "name", Value: "time_ttl"}, {Key: "expireAfterSeconds", Value: int64(7200)}}},
+	}}, m.commands)
+	assert.Empty(t, m.created)
+
+	// An index with the TTL name on other keys still needs a manual repair
+	m.commands = nil
+	other := stockIndexSpec(t, "time_ttl", bson.D{{Key: "ticker", Value: 1}}, false)
+	other.ExpireAfterSeconds = ttlIndexSpec(t, 3600).ExpireAfterSeconds
+	MongoListIndexesFunc = func(coll *mongo.Collection, ctx context.Context) ([]*mongo.IndexSpecification, error) {
+		return []*mongo.IndexSpecification{unique, other}, nil
+	}
+	_, err = BootstrapStockCollection("db", "ticks", want, false)
+	assert.ErrorContains(t, err, "conflicting indexes time_ttl")
+	assert.Empty(t, m.commands)
+}
+
+func TestBootstrapStockCollectionTTLRemoved(t *testing.T) {
+	unique := stockIndexSpec(t, "ticker_time_unique", bson.D{{Key: "ticker", Value: 1}, {Key: "time", Value: 1}}, true)
+	m := useBootstrapMocks(t, true, currentStockOptions(t), []*mongo.IndexSpecification{unique, ttlIndexSpec(t, 3600)})
+
+	// Keeping ticks forever reports the old TTL index as drift and drops it
+	for _, want := range []StockCollectionOptions{{TimeSeries: true}, {}} {
+		m.commands = nil
+		drift, err := BootstrapStockCollection("db", "ticks", want, true)
+		assert.NoError(t, err)
+		assert.False(t, drift.None())
+		assert.Equal(t, []string{"time_ttl"}, drift.StaleIndexes)
+		assert.Contains(t, drift.String(), "stale indexes time_ttl")
+		assert.Empty(t, m.commands)
+
+		_, err = BootstrapStockCollection("db", "ticks", want, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []bson.D{{{Key: "dropIndexes", Value: "ticks"}, {Key: "index", Value: "time_ttl"}}}, m.commands)
+		assert.Empty(t, m.created)
+	}
+}
@@ -0,0 +1,170 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CandleIntervals are the supported candle bucket sizes. Buckets are aligned to
+// the Unix epoch, so 1d candles start at midnight UTC.
+var CandleIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// OHLC holds open/high/low/close prices for one side of the book
+type OHLC struct {
+	Open  float64 `json:"open" bson:"open"`
+	High  float64 `json:"high" bson:"high"`
+	Low   float64 `json:"low" bson:"low"`
+	Close float64 `json:"close" bson:"close"`
+}
+
+// Candle is one bucket of bid and ask OHLC prices
+type Candle struct {
+	Start time.Time `json:"start" bson:"_id"`
+	Bid   OHLC      `json:"bid" bson:"bid"`
+	Ask   OHLC      `json:"ask" bson:"ask"`
+	Count int       `json:"count" bson:"count"`
+}
+
+// CandleAggregator builds candles for a ticker over [from, to)
+type CandleAggregator interface {
+	Candles(ctx context.Context, ticker string, from, to time.Time, interval time.Duration) ([]Candle, error)
+}
+
+// MongoCandleAggregator computes candles server-side with an aggregation pipeline
+type MongoCandleAggregator struct {
+	Database   string
+	Collection string
+	TimeSeries bool // matches ticks by their meta.ticker series
+}
+
+// Candles runs the candle pipeline against the stock collection
+func (a *MongoCandleAggregator) Candles(ctx context.Context, ticker string, from, to time.Time, interval time.Duration) ([]Candle, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(a.Database).Collection(a.Collection)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := coll.Aggregate(ctx, candlePipeline(ticker, from, to, interval, a.TimeSeries))
+	if err != nil {
+		return nil, err
+	}
+	var candles []Candle
+	if err := cursor.All(ctx, &candles); err != nil {
+		return nil, err
+	}
+	for i := range candles {
+		candles[i].Start = candles[i].Start.UTC()
+	}
+	return candles, nil
+}
+
+// candlePipeline groups ticks into epoch-aligned buckets of interval, taking
+// open/close from the first/last tick by time.
+func candlePipeline(ticker string, from, to time.Time, interval time.Duration, timeSeries bool) bson.A {
+	ms := interval.Milliseconds()
+	epochMs := bson.M{"$toLong": "$time"}
+	bucket := bson.M{"$toDate": bson.M{"$subtract": bson.A{epochMs, bson.M{"$mod": bson.A{epochMs, ms}}}}}
+	return bson.A{
+		bson.M{"$match": stockRangeFilter(StockRangeQuery{Ticker: ticker, From: from, To: to}, timeSeries)},
+		bson.M{"$sort": bson.D{{Key: "time", Value: 1}}},
+		bson.M{"$group": bson.M{
+			"_id":      bucket,
+			"bidOpen":  bson.M{"$first": "$bid"},
+			"bidHigh":  bson.M{"$max": "$bid"},
+			"bidLow":   bson.M{"$min": "$bid"},
+			"bidClose": bson.M{"$last": "$bid"},
+			"askOpen":  bson.M{"$first": "$ask"},
+			"askHigh":  bson.M{"$max": "$ask"},
+			"askLow":   bson.M{"$min": "$ask"},
+			"askClose": bson.M{"$last": "$ask"},
+			"count":    bson.M{"$sum": 1},
+		}},
+		bson.M{"$sort": bson.D{{Key: "_id", Value: 1}}},
+		bson.M{"$project": bson.M{
+			"_id":   1,
+			"count": 1,
+			"bid":   bson.M{"open": "$bidOpen", "high": "$bidHigh", "low": "$bidLow", "close": "$bidClose"},
+			"ask":   bson.M{"open": "$askOpen", "high": "$askHigh", "low": "$askLow", "close": "$askClose"},
+		}},
+	}
+}
+
+// MemoryCandleAggregator computes candles from ticks held in memory. It is used
+// in tests and wherever no database is available.
+type MemoryCandleAggregator struct {
+	mu    sync.RWMutex
+	ticks []models.StockData
+}
+
+// Add stores ticks for later aggregation
+func (a *MemoryCandleAggregator) Add(ticks ...models.StockData) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ticks = append(a.ticks, ticks...)
+}
+
+// Candles aggregates the stored ticks for ticker in [from, to)
+func (a *MemoryCandleAggregator) Candles(ctx context.Context, ticker string, from, to time.Time, interval time.Duration) ([]Candle, error) {
+	a.mu.RLock()
+	var selected []models.StockData
+	for _, t := range a.ticks {
+		if t.Ticker == ticker && !t.Time.Before(from) && t.Time.Before(to) {
+			selected = append(selected, t)
+		}
+	}
+	a.mu.RUnlock()
+	return AggregateCandles(selected, interval), nil
+}
+
+// AggregateCandles groups ticks into epoch-aligned candles of the given interval
+func AggregateCandles(ticks []models.StockData, interval time.Duration) []Candle {
+	if interval <= 0 || len(ticks) == 0 {
+		return nil
+	}
+	sorted := make([]models.StockData, len(ticks))
+	copy(sorted, ticks)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	var candles []Candle
+	for _, t := range sorted {
+		start := t.Time.UTC().Truncate(interval)
+		if n := len(candles); n > 0 && candles[n-1].Start.Equal(start) {
+			c := &candles[n-1]
+			c.Bid = c.Bid.add(t.Bid)
+			c.Ask = c.Ask.add(t.Ask)
+			c.Count++
+			continue
+		}
+		candles = append(candles, Candle{
+			Start: start,
+			Bid:   OHLC{Open: t.Bid, High: t.Bid, Low: t.Bid, Close: t.Bid},
+			Ask:   OHLC{Open: t.Ask, High: t.Ask, Low: t.Ask, Close: t.Ask},
+			Count: 1,
+		})
+	}
+	return candles
+}
+
+func (o OHLC) add(price float64) OHLC {
+	if price > o.High {
+		o.High = price
+	}
+	if price < o.Low {
+		o.Low = price
+	}
+	o.Close = price
+	return o
+}
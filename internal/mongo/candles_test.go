@@ -0,0 +1,99 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var candleBase = time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC)
+
+func tick(offset time.Duration, bid, ask float64) models.StockData {
+	return models.StockData{Ticker: "VEHICLE-1", Bid: bid, Ask: ask, Time: candleBase.Add(offset)}
+}
+
+func TestAggregateCandlesOHLC(t *testing.T) {
+	ticks := []models.StockData{
+		tick(90*time.Second, 103, 104), // second bucket, out of order on purpose
+		tick(0, 100, 101),
+		tick(20*time.Second, 105, 106),
+		tick(40*time.Second, 95, 96),
+		tick(50*time.Second, 99, 100),
+		tick(60*time.Second, 101, 102),
+	}
+	candles := AggregateCandles(ticks, time.Minute)
+	assert.Len(t, candles, 2)
+
+	first := candles[0]
+	assert.Equal(t, candleBase, first.Start)
+	assert.Equal(t, OHLC{Open: 100, High: 105, Low: 95, Close: 99}, first.Bid)
+	assert.Equal(t, OHLC{Open: 101, High: 106, Low: 96, Close: 100}, first.Ask)
+	assert.Equal(t, 4, first.Count)
+
+	second := candles[1]
+	assert.Equal(t, candleBase.Add(time.Minute), second.Start)
+	assert.Equal(t, OHLC{Open: 101, High: 103, Low: 101, Close: 103}, second.Bid)
+	assert.Equal(t, 2, second.Count)
+}
+
+func TestAggregateCandlesBucketAlignment(t *testing.T) {
+	ticks := []models.StockData{tick(7*time.Minute, 1, 2), tick(23*time.Hour, 3, 4)}
+
+	five := AggregateCandles(ticks, CandleIntervals["5m"])
+	assert.Equal(t, candleBase.Add(5*time.Minute), five[0].Start)
+
+	hourly := AggregateCandles(ticks, CandleIntervals["1h"])
+	assert.Equal(t, candleBase, hourly[0].Start)
+	assert.Equal(t, candleBase.Add(23*time.Hour), hourly[1].Start)
+
+	daily := AggregateCandles(ticks, CandleIntervals["1d"])
+	assert.Len(t, daily, 2)
+	assert.Equal(t, time.Date(2025, 8, 24, 0, 0, 0, 0, time.UTC), daily[0].Start)
+	assert.Equal(t, time.Date(2025, 8, 25, 0, 0, 0, 0, time.UTC), daily[1].Start)
+}
+
+func TestAggregateCandlesEmpty(t *testing.T) {
+	assert.Nil(t, AggregateCandles(nil, time.Minute))
+	assert.Nil(t, AggregateCandles([]models.StockData{tick(0, 1, 2)}, 0))
+}
+
+func TestMemoryCandleAggregatorFiltersRangeAndTicker(t *testing.T) {
+	agg := &MemoryCandleAggregator{}
+	agg.Add(tick(0, 100, 101), tick(30*time.Second, 102, 103), tick(2*time.Minute, 110, 111))
+	agg.Add(models.StockData{Ticker: "VEHICLE-2", Bid: 1, Ask: 2, Time: candleBase})
+
+	candles, err := agg.Candles(context.Background(), "VEHICLE-1", candleBase, candleBase.Add(2*time.Minute), time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, candles, 1)
+	assert.Equal(t, 2, candles[0].Count)
+	assert.Equal(t, 102.0, candles[0].Bid.Close)
+}
+
+func TestMongoCandleAggregatorNilClient(t *testing.T) {
+	origClient := Client
+	defer func() { Client = origClient }()
+	Client = nil
+	agg := &MongoCandleAggregator{Database: "db", Collection: "coll"}
+	candles, err := agg.Candles(context.Background(), "VEHICLE-1", candleBase, candleBase.Add(time.Hour), time.Minute)
+	assert.Error(t, err)
+	assert.Nil(t, candles)
+}
+
+func TestCandlePipeline(t *testing.T) {
+	p := candlePipeline("VEHICLE-1", candleBase, candleBase.Add(time.Hour), 5*time.Minute, false)
+	assert.Len(t, p, 5)
+
+	match := p[0].(bson.M)["$match"].(bson.M)
+	assert.Equal(t, "VEHICLE-1", match["ticker"])
+
+	group := p[2].(bson.M)["$group"].(bson.M)
+	assert.Equal(t, bson.M{"$first": "$bid"}, group["bidOpen"])
+	assert.Equal(t, bson.M{"$last": "$ask"}, group["askClose"])
+	id := group["_id"].(bson.M)["$toDate"].(bson.M)["$subtract"].(bson.A)
+	mod := id[1].(bson.M)["$mod"].(bson.A)
+	assert.Equal(t, int64(300000), mod[1])
+}
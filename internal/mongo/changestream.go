@@ -0,0 +1,101 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server codes of a change stream that cannot resume from its token
+const (
+	changeStreamHistoryLost = 286
+	changeStreamFatalError  = 280
+)
+
+// resumeTokenDoc is one persisted change stream position
+type resumeTokenDoc struct {
+	ID        string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// WatchStockTicks follows the ticks inserted into database/collection with a
+// change stream and calls handle with each tick and the resume token after it.
+// With a token the stream starts after that event, otherwise at the current
+// time. It returns when ctx is done, the stream fails or handle returns an error.
+var WatchStockTicks = func(ctx context.Context, database, collection string, resumeAfter bson.Raw, handle func(tick models.StockData, token bson.Raw) error) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	opts := options.ChangeStream()
+	if resumeAfter != nil {
+		opts.SetStartAfter(resumeAfter)
+	}
+	stream, err := coll.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event struct {
+			FullDocument models.StockData `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		if err := handle(event.FullDocument, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// IsChangeStreamHistoryLost reports whether a change stream failed because
+// its resume token is no longer in the oplog; it has to restart without one.
+func IsChangeStreamHistoryLost(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && (se.HasErrorCode(changeStreamHistoryLost) || se.HasErrorCode(changeStreamFatalError))
+}
+
+// LoadResumeToken returns the change stream token saved under name, or nil when there is none
+var LoadResumeToken = func(database, collection, name string) (bson.Raw, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var doc resumeTokenDoc
+	err := coll.FindOne(ctx, bson.M{"_id": name}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+// SaveResumeToken stores token under name, replacing the previous one
+var SaveResumeToken = func(database, collection, name string, token bson.Raw) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"token": token, "updated_at": time.Now().UTC()}}
+	_, err := MongoUpsertOneFunc(coll, ctx, bson.M{"_id": name}, update)
+	return err
+}
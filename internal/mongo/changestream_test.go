@@ -0,0 +1,44 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsChangeStreamHistoryLost(t *testing.T) {
+	assert.True(t, IsChangeStreamHistoryLost(mongo.CommandError{Code: 286}))
+	assert.True(t, IsChangeStreamHistoryLost(fmt.Errorf("watch: %w", mongo.CommandError{Code: 280})))
+	assert.False(t, IsChangeStreamHistoryLost(mongo.CommandError{Code: 40573}))
+	assert.False(t, IsChangeStreamHistoryLost(errors.New("connection reset")))
+	assert.False(t, IsChangeStreamHistoryLost(nil))
+}
+
+func TestResumeTokens(t *testing.T) {
+	origClient, origUpsert := Client, MongoUpsertOneFunc
+	defer func() { Client, MongoUpsertOneFunc = origClient, origUpsert }()
+	raw, _ := bson.Marshal(bson.M{"_data": "8263"})
+	token := bson.Raw(raw)
+
+	Client = nil
+	_, err := LoadResumeToken("db", "tokens", "stock_data")
+	assert.Error(t, err)
+	assert.Error(t, SaveResumeToken("db", "tokens", "stock_data", token))
+	err = WatchStockTicks(context.Background(), "db", "stock_data", nil, func(models.StockData, bson.Raw) error { return nil })
+	assert.Error(t, err)
+
+	Client = &mongo.Client{}
+	MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		assert.Equal(t, "tokens", coll.Name())
+		assert.Equal(t, bson.M{"_id": "stock_data"}, filter)
+		assert.Equal(t, token, update.(bson.M)["$set"].(bson.M)["token"])
+		return &mongo.UpdateResult{UpsertedCount: 1}, nil
+	}
+	assert.NoError(t, SaveResumeToken("db", "tokens", "stock_data", token))
+}
@@ -0,0 +1,119 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDeleteOneFunc wraps DeleteOne for testability
+var MongoDeleteOneFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	return coll.DeleteOne(ctx, filter)
+}
+
+// EnsureIdempotencyIndexes creates the TTL index that expires idempotency records at expires_at
+func EnsureIdempotencyIndexes(database, collection string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := MongoCreateIndexesFunc(coll, ctx, idempotencyIndexes())
+	return err
+}
+
+func idempotencyIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+	}}
+}
+
+// ClaimIdempotencyKey inserts rec as in progress. If the key already exists the
+// stored record is returned instead and the caller must not repeat the work.
+// An in-progress record with the same request hash locked before staleBefore
+// is taken over, so a crash mid-request does not block retries until expiry.
+var ClaimIdempotencyKey = func(database, collection string, rec models.IdempotencyRecord, staleBefore time.Time) (*models.IdempotencyRecord, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	c := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rec.Status = models.IdempotencyInProgress
+	_, err := MongoInsertOneFunc(c, ctx, rec)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing models.IdempotencyRecord
+	coll := &mongoCollectionAdapter{coll: c}
+	if err := coll.FindOne(ctx, bson.M{"_id": rec.Key}).Decode(&existing); err != nil {
+		return nil, err
+	}
+	if !canTakeOver(existing, rec, staleBefore) {
+		return &existing, nil
+	}
+
+	res, err := MongoUpdateOneFunc(c, ctx, staleLockFilter(existing), bson.M{"$set": bson.M{"locked_at": rec.LockedAt}})
+	if err != nil {
+		return nil, err
+	}
+	if res.ModifiedCount == 0 {
+		// Another retry took the lock first
+		return &existing, nil
+	}
+	return nil, nil
+}
+
+// canTakeOver reports whether rec may take over existing: the same request
+// still in progress but locked before staleBefore
+func canTakeOver(existing, rec models.IdempotencyRecord, staleBefore time.Time) bool {
+	return existing.Status == models.IdempotencyInProgress &&
+		existing.RequestHash == rec.RequestHash &&
+		existing.LockedAt.Before(staleBefore)
+}
+
+func staleLockFilter(rec models.IdempotencyRecord) bson.M {
+	return bson.M{"_id": rec.Key, "status": models.IdempotencyInProgress, "locked_at": rec.LockedAt}
+}
+
+// CompleteIdempotencyKey stores the response sent for a claimed key
+var CompleteIdempotencyKey = func(database, collection, key string, code int, body []byte) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := MongoUpdateOneFunc(coll, ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{
+		"status":        models.IdempotencyCompleted,
+		"response_code": code,
+		"response_body": string(body),
+	}})
+	return err
+}
+
+// ReleaseIdempotencyKey deletes a claimed key so the request can be retried
+var ReleaseIdempotencyKey = func(database, collection, key string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := MongoDeleteOneFunc(coll, ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,145 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLedgerEntryNotFound is returned when no ledger entry matches the ID
+var ErrLedgerEntryNotFound = errors.New("ledger entry not found")
+
+// LedgerQuery selects ledger entries created in [From, To]; empty fields match everything.
+// With After set only entries sorted after that position are returned.
+type LedgerQuery struct {
+	VIN    string
+	Status string
+	Type   string
+	From   time.Time
+	To     time.Time
+	After  *LedgerCursor
+	Limit  int64
+}
+
+// LedgerCursor is the sort position of a ledger entry, which is ordered by
+// created_at and then _id
+type LedgerCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// EnsureLedgerIndexes creates the indexes used to filter the payment ledger
+func EnsureLedgerIndexes(database, collection string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := MongoCreateIndexesFunc(coll, ctx, ledgerIndexes())
+	return err
+}
+
+func ledgerIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetName("created_at")},
+		{Keys: bson.D{{Key: "vin", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("vin_created_at")},
+		{Keys: bson.D{{Key: "payment_intent_id", Value: 1}}, Options: options.Index().SetName("payment_intent_id")},
+	}
+}
+
+// AppendLedgerEntry inserts e into the ledger. Entries are never updated; an
+// entry that was already recorded is ignored.
+var AppendLedgerEntry = func(database, collection string, e models.LedgerEntry) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := MongoInsertOneFunc(coll, ctx, e)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// FindLedgerEntry returns the ledger entry with id, e.g. "hold:pi_123"
+var FindLedgerEntry = func(database, collection, id string) (*models.LedgerEntry, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := &mongoCollectionAdapter{coll: Client.Database(database).Collection(collection)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var e models.LedgerEntry
+	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&e); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrLedgerEntryNotFound
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+// FindLedgerEntries returns up to q.Limit entries matching q, oldest first
+var FindLedgerEntries = func(database, collection string, q LedgerQuery) ([]models.LedgerEntry, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	cursor, err := coll.Find(ctx, ledgerFilter(q), opts)
+	if err != nil {
+		return nil, err
+	}
+	out := []models.LedgerEntry{}
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func ledgerFilter(q LedgerQuery) bson.M {
+	filter := bson.M{}
+	if q.VIN != "" {
+		filter["vin"] = q.VIN
+	}
+	if q.Status != "" {
+		filter["status"] = q.Status
+	}
+	if q.Type != "" {
+		filter["type"] = q.Type
+	}
+	created := bson.M{}
+	if !q.From.IsZero() {
+		created["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		created["$lte"] = q.To
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	if q.After != nil {
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{"$gt": q.After.CreatedAt}},
+			bson.M{"created_at": q.After.CreatedAt, "_id": bson.M{"$gt": q.After.ID}},
+		}
+	}
+	return filter
+}
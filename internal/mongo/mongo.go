@@ -3,10 +3,8 @@ package mongo
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/yourusername/vehicle-stock-service/internal/models"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -22,11 +20,20 @@ var MongoPingFunc = func(client *mongo.Client, ctx context.Context) error {
 	return client.Ping(ctx, readpref.Primary())
 }
 
+var MongoDisconnectFunc = func(client *mongo.Client, ctx context.Context) error {
+	return client.Disconnect(ctx)
+}
+
 // Add function variable for InsertOne
 var MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
 	return coll.InsertOne(ctx, data)
 }
 
+// MongoUpdateOneFunc wraps UpdateOne for testability
+var MongoUpdateOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+	return coll.UpdateOne(ctx, filter, update)
+}
+
 // ConnectMongo connects to MongoDB Atlas, allows mocking for tests
 func ConnectMongo(uri string) (*mongo.Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -46,21 +53,14 @@ func ConnectMongo(uri string) (*mongo.Client, error) {
 	return client, nil
 }
 
-// InsertData inserts a record into the collection (generic)
-func InsertData(database, collection string, data interface{}) error {
+// Disconnect closes the shared client, if any, and clears it
+func Disconnect(ctx context.Context) error {
 	if Client == nil {
-		return fmt.Errorf("Mongo client is not initialized")
+		return nil
 	}
-	coll := Client.Database(database).Collection(collection)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := MongoInsertOneFunc(coll, ctx, data)
-	if err != nil {
-		log.Println("Error inserting data:", err)
-		return err
-	}
-	log.Println("Inserted data successfully:", data)
-	return nil
+	err := MongoDisconnectFunc(Client, ctx)
+	Client = nil
+	return err
 }
 
 // Define interfaces for testability
@@ -89,27 +89,3 @@ type mongoSingleResultAdapter struct {
 func (m *mongoSingleResultAdapter) Decode(v interface{}) error {
 	return m.res.Decode(v)
 }
-
-// Update FindStockByTickerAndDate to use adapters
-var FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-	if Client == nil {
-		return nil, fmt.Errorf("Mongo client is not initialized")
-	}
-	coll := &mongoCollectionAdapter{coll: Client.Database(database).Collection(collection)}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	filter := map[string]interface{}{
-		"ticker": ticker,
-		"time":   date,
-	}
-	var result models.StockData
-	singleResult := coll.FindOne(ctx, filter)
-	if err := singleResult.Decode(&result); err != nil {
-		return nil, err
-	}
-	return &result, nil
-}
-
-// Exported for testability in other packages
-var InsertDataFunc = InsertData
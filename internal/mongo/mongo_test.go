@@ -4,37 +4,22 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Mocks for error branch testing
-type mockSingleResult struct {
-	decodeErr error
-}
-
-func (r *mockSingleResult) Decode(v interface{}) error {
-	return r.decodeErr
-}
+const testDate = "2025-08-24T10:00:00Z"
 
-type mockCollection struct {
-	findErr, decodeErr error
-}
-
-func (c *mockCollection) FindOne(ctx context.Context, filter interface{}) SingleResult {
-	if c.findErr != nil {
-		return &mockSingleResult{decodeErr: c.findErr}
-	}
-	return &mockSingleResult{decodeErr: c.decodeErr}
+// parseTestTime parses an RFC3339 test timestamp
+func parseTestTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
 }
 
-const findErrMsg = "find error"
-const decodeErrMsg = "decode error"
-
-const testDate = "2025-08-24"
-
 // --- ConnectMongo tests ---
 func TestConnectMongoSuccess(t *testing.T) {
 	origConnect := MongoConnectFunc
@@ -74,206 +59,453 @@ func TestConnectMongoPingError(t *testing.T) {
 	assert.Error(t, err)
 }
 
-// --- InsertData tests ---
-func TestInsertDataSuccess(t *testing.T) {
-	// Mock Client and collection
-	Client = &mongo.Client{}
-	// This will error unless a real MongoDB is running, so we expect error
-	err := InsertData("db", "coll", map[string]interface{}{"foo": "bar"})
+// --- FindVehicleSubscriptions tests ---
+func TestFindVehicleSubscriptionsNilClient(t *testing.T) {
+	origClient := Client
+	defer func() { Client = origClient }()
+	Client = nil
+	subs, err := FindVehicleSubscriptions(context.Background(), "db", "subs")
 	assert.Error(t, err)
+	assert.Nil(t, subs)
 }
 
-func TestInsertDataInsertError(t *testing.T) {
-	// Simulate error by setting Client to nil
-	Client = nil
-	err := InsertData("db", "coll", map[string]interface{}{"foo": "bar"})
+func TestStockRangeFilter(t *testing.T) {
+	from := parseTestTime("2025-08-24T00:00:00Z")
+	to := parseTestTime("2025-08-25T00:00:00Z")
+	filter := stockRangeFilter(StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: to}, false)
+	assert.Equal(t, "VEHICLE-1", filter["ticker"])
+	assert.Equal(t, bson.M{"$gte": from, "$lt": to}, filter["time"])
+
+	filter = stockRangeFilter(StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: to}, true)
+	assert.Equal(t, "VEHICLE-1", filter["meta.ticker"])
+	assert.NotContains(t, filter, "ticker")
+}
+
+// --- As-of lookup and migration tests ---
+func TestResolveAsOf(t *testing.T) {
+	at, err := ResolveAsOf("2025-08-01", time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 8, 1, 23, 59, 59, 999000000, time.UTC), at)
+
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	at, err = ResolveAsOf("2025-08-01", tokyo)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 8, 1, 14, 59, 59, 999000000, time.UTC), at.UTC())
+
+	at, err = ResolveAsOf("2025-08-01T10:00:00Z", tokyo)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 8, 1, 10, 0, 0, 0, time.UTC), at.UTC())
+
+	at, err = ResolveAsOf("2025-08-01", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, at.Location())
+
+	_, err = ResolveAsOf("08/01/2025", time.UTC)
 	assert.Error(t, err)
 }
 
-// --- InsertData full branch coverage ---
-func TestInsertDataBranches(t *testing.T) {
+func TestMigrateStockTimes(t *testing.T) {
 	origClient := Client
-	origInsert := MongoInsertOneFunc
-	defer func() { Client = origClient; MongoInsertOneFunc = origInsert }()
+	origUpdate := MongoUpdateManyFunc
+	defer func() { Client = origClient; MongoUpdateManyFunc = origUpdate }()
 
 	t.Run("nil client", func(t *testing.T) {
 		Client = nil
-		err := InsertData("db", "coll", map[string]interface{}{"foo": "bar"})
+		_, err := MigrateStockTimes("db", "coll")
 		assert.Error(t, err)
 	})
 
-	t.Run("insert error", func(t *testing.T) {
-		const findErrMsg = "find error"
-		const decodeErrMsg = "decode error"
-
-		type mockSingleResult struct {
-			decodeErr error
-		}
-		Client = &mongo.Client{} // Just to pass nil check
-		MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
-			return nil, errors.New("insert fail")
+	t.Run("converts string times", func(t *testing.T) {
+		Client = &mongo.Client{}
+		MongoUpdateManyFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+			assert.Equal(t, bson.M{"time": bson.M{"$type": "string"}}, filter)
+			stage := update.(bson.A)[0].(bson.M)["$set"].(bson.M)
+			assert.Contains(t, stage["time"], "$dateFromString")
+			return &mongo.UpdateResult{MatchedCount: 3, ModifiedCount: 3}, nil
 		}
-		err := InsertData("db", "coll", map[string]interface{}{"foo": "bar"})
-		assert.Error(t, err)
+		n, err := MigrateStockTimes("db", "coll")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
 	})
 
-	t.Run("success path", func(t *testing.T) {
+	t.Run("update error", func(t *testing.T) {
 		Client = &mongo.Client{}
-		MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
-			return struct{}{}, nil
+		MongoUpdateManyFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+			return nil, errors.New("update failed")
 		}
-		err := InsertData("db", "coll", map[string]interface{}{"foo": "bar"})
-		assert.NoError(t, err)
+		_, err := MigrateStockTimes("db", "coll")
+		assert.Error(t, err)
 	})
 }
 
-// --- FindStockByTickerAndDate tests ---
-func TestFindStockByTickerAndDateSuccess(t *testing.T) {
-	// Mock FindStockByTickerAndDate to return success
-	orig := FindStockByTickerAndDate
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		return &models.StockData{Ticker: ticker, Bid: 100.0, Ask: 101.0, Time: date}, nil
+func TestDisconnect(t *testing.T) {
+	origClient := Client
+	origDisconnect := MongoDisconnectFunc
+	defer func() { Client = origClient; MongoDisconnectFunc = origDisconnect }()
+
+	Client = nil
+	assert.NoError(t, Disconnect(context.Background()))
+
+	calls := 0
+	MongoDisconnectFunc = func(client *mongo.Client, ctx context.Context) error {
+		calls++
+		return errors.New("disconnect failed")
+	}
+	Client = &mongo.Client{}
+	assert.Error(t, Disconnect(context.Background()))
+	assert.Nil(t, Client)
+	assert.NoError(t, Disconnect(context.Background()))
+	assert.Equal(t, 1, calls)
+}
+
+func TestInsertStripeEvent(t *testing.T) {
+	origClient, origInsert, origUpdate := Client, MongoInsertOneFunc, MongoUpdateOneFunc
+	defer func() { Client, MongoInsertOneFunc, MongoUpdateOneFunc = origClient, origInsert, origUpdate }()
+
+	event := models.PaymentEvent{EventID: "evt_1", Type: "payment_intent.canceled", PaymentIntentID: "pi_1"}
+
+	Client = nil
+	_, err := InsertStripeEvent("db", "events", event, []byte("{}"))
+	assert.Error(t, err)
+
+	Client = &mongo.Client{}
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		doc := data.(bson.M)
+		assert.Equal(t, "evt_1", doc["_id"])
+		assert.Equal(t, "payment_intent.canceled", doc["type"])
+		assert.Equal(t, "{}", doc["payload"])
+		assert.Equal(t, false, doc["published"])
+		return nil, nil
 	}
-	defer func() { FindStockByTickerAndDate = orig }()
-	result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
+	duplicate, err := InsertStripeEvent("db", "events", event, []byte("{}"))
 	assert.NoError(t, err)
-	assert.Equal(t, "AAPL", result.Ticker)
-	assert.Equal(t, 100.0, result.Bid)
-	assert.Equal(t, 101.0, result.Ask)
-	assert.Equal(t, testDate, result.Time)
+	assert.False(t, duplicate)
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key"}}}
+	}
+	var unpublished int64
+	MongoUpdateOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		assert.Equal(t, bson.M{"_id": "evt_1", "published": false}, filter)
+		return &mongo.UpdateResult{MatchedCount: unpublished}, nil
+	}
+	duplicate, err = InsertStripeEvent("db", "events", event, []byte("{}"))
+	assert.NoError(t, err)
+	assert.True(t, duplicate)
+
+	// Stored before, but its publish failed
+	unpublished = 1
+	duplicate, err = InsertStripeEvent("db", "events", event, []byte("{}"))
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+
+	MongoUpdateOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		assert.Equal(t, bson.M{"_id": "evt_1"}, filter)
+		assert.Equal(t, true, update.(bson.M)["$set"].(bson.M)["published"])
+		return &mongo.UpdateResult{MatchedCount: 1}, nil
+	}
+	assert.NoError(t, MarkStripeEventPublished("db", "events", "evt_1"))
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, errors.New("insert failed")
+	}
+	_, err = InsertStripeEvent("db", "events", event, []byte("{}"))
+	assert.Error(t, err)
+}
+
+func TestReservationIndexes(t *testing.T) {
+	idx := reservationIndexes()
+	assert.Len(t, idx, 2)
+	assert.Equal(t, bson.D{{Key: "vin", Value: 1}}, idx[0].Keys)
+	assert.True(t, *idx[0].Options.Unique)
+	assert.Equal(t, bson.M{"active": true}, idx[0].Options.PartialFilterExpression)
+
+	origClient := Client
+	origCreate := MongoCreateIndexesFunc
+	defer func() { Client = origClient; MongoCreateIndexesFunc = origCreate }()
+
+	Client = nil
+	assert.Error(t, EnsureReservationIndexes("db", "reservations"))
+
+	Client = &mongo.Client{}
+	MongoCreateIndexesFunc = func(coll *mongo.Collection, ctx context.Context, models []mongo.IndexModel) ([]string, error) {
+		assert.Len(t, models, 2)
+		return []string{"vin_active_unique", "active_expires_at"}, nil
+	}
+	assert.NoError(t, EnsureReservationIndexes("db", "reservations"))
 }
 
-func TestFindStockByTickerAndDateFindError(t *testing.T) {
-	orig := FindStockByTickerAndDate
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		return nil, errors.New("find error")
+func TestInsertReservation(t *testing.T) {
+	origClient := Client
+	origInsert := MongoInsertOneFunc
+	defer func() { Client = origClient; MongoInsertOneFunc = origInsert }()
+
+	Client = nil
+	assert.Error(t, InsertReservation("db", "reservations", models.Reservation{ID: "r1"}))
+
+	Client = &mongo.Client{}
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		assert.True(t, data.(models.Reservation).Active)
+		return nil, nil
+	}
+	assert.NoError(t, InsertReservation("db", "reservations", models.Reservation{ID: "r1", VIN: "VIN1"}))
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+	}
+	assert.ErrorIs(t, InsertReservation("db", "reservations", models.Reservation{ID: "r2", VIN: "VIN1"}), ErrVINReserved)
+}
+
+func TestActivateAndReleaseReservation(t *testing.T) {
+	origClient := Client
+	origUpdate := MongoUpdateOneFunc
+	defer func() { Client = origClient; MongoUpdateOneFunc = origUpdate }()
+
+	Client = nil
+	assert.Error(t, AttachReservationIntent("db", "reservations", "r1", "pi_1"))
+	assert.Error(t, ActivateReservation("db", "reservations", "r1", "pi_1"))
+	_, err := ReleaseReservation("db", "reservations", "r1", models.ReservationReleased)
+	assert.Error(t, err)
+
+	Client = &mongo.Client{}
+	var matched int64
+	MongoUpdateOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		assert.Equal(t, bson.M{"_id": "r1", "active": true}, filter)
+		return &mongo.UpdateResult{MatchedCount: matched, ModifiedCount: matched}, nil
 	}
-	defer func() { FindStockByTickerAndDate = orig }()
-	result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
+	assert.ErrorIs(t, AttachReservationIntent("db", "reservations", "r1", "pi_1"), ErrReservationNotFound)
+	assert.ErrorIs(t, ActivateReservation("db", "reservations", "r1", "pi_1"), ErrReservationNotFound)
+	released, err := ReleaseReservation("db", "reservations", "r1", models.ReservationReleased)
+	assert.NoError(t, err)
+	assert.False(t, released)
+
+	matched = 1
+	assert.NoError(t, AttachReservationIntent("db", "reservations", "r1", "pi_1"))
+	assert.NoError(t, ActivateReservation("db", "reservations", "r1", "pi_1"))
+	released, err = ReleaseReservation("db", "reservations", "r1", models.ReservationReleased)
+	assert.NoError(t, err)
+	assert.True(t, released)
+}
+
+func TestReservationFilters(t *testing.T) {
+	at := parseTestTime(testDate)
+	assert.Equal(t, bson.M{"$set": bson.M{"status": "expired", "active": false, "released_at": at}}, releaseUpdate("expired", at))
+	assert.Equal(t, bson.M{"active": true, "expires_at": bson.M{"$lte": at}}, expiredReservationsFilter(at))
+
+	origClient := Client
+	defer func() { Client = origClient }()
+	Client = nil
+	_, err := FindReservation("db", "reservations", "r1")
+	assert.Error(t, err)
+	_, err = FindExpiredReservations("db", "reservations", at, 10)
 	assert.Error(t, err)
-	assert.Nil(t, result)
 }
 
-func TestFindStockByTickerAndDateDecodeError(t *testing.T) {
-	orig := FindStockByTickerAndDate
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		return nil, errors.New("decode error")
+func TestClaimIdempotencyKey(t *testing.T) {
+	origClient := Client
+	origInsert := MongoInsertOneFunc
+	origUpdate := MongoUpdateOneFunc
+	defer func() { Client = origClient; MongoInsertOneFunc = origInsert; MongoUpdateOneFunc = origUpdate }()
+
+	now := parseTestTime(testDate)
+	rec := models.IdempotencyRecord{Key: "holdpayment:k1", RequestHash: "h1", LockedAt: now}
+
+	Client = nil
+	_, err := ClaimIdempotencyKey("db", "keys", rec, now.Add(-time.Minute))
+	assert.Error(t, err)
+
+	Client = &mongo.Client{}
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		assert.Equal(t, models.IdempotencyInProgress, data.(models.IdempotencyRecord).Status)
+		return nil, nil
 	}
-	defer func() { FindStockByTickerAndDate = orig }()
-	result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
+	existing, err := ClaimIdempotencyKey("db", "keys", rec, now.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, errors.New("insert failed")
+	}
+	_, err = ClaimIdempotencyKey("db", "keys", rec, now.Add(-time.Minute))
 	assert.Error(t, err)
-	assert.Nil(t, result)
 }
 
-// --- FindStockByTickerAndDate full branch coverage ---
-func TestFindStockByTickerAndDateBranches(t *testing.T) {
-	orig := FindStockByTickerAndDate
-	defer func() { FindStockByTickerAndDate = orig }()
+func TestCanTakeOver(t *testing.T) {
+	now := parseTestTime(testDate)
+	rec := models.IdempotencyRecord{Key: "k1", RequestHash: "h1", LockedAt: now}
+	stale := models.IdempotencyRecord{Key: "k1", RequestHash: "h1", Status: models.IdempotencyInProgress, LockedAt: now.Add(-2 * time.Minute)}
+	staleBefore := now.Add(-time.Minute)
 
-	t.Run("nil client", func(t *testing.T) {
-		Client = nil
-		result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
-		assert.Error(t, err)
-		assert.Nil(t, result)
-	})
+	assert.True(t, canTakeOver(stale, rec, staleBefore))
 
-	t.Run("find error", func(t *testing.T) {
-		FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-			return nil, errors.New("find error")
-		}
-		result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
-		assert.Error(t, err)
-		assert.Nil(t, result)
-	})
+	fresh := stale
+	fresh.LockedAt = now.Add(-time.Second)
+	assert.False(t, canTakeOver(fresh, rec, staleBefore))
 
-	t.Run("decode error", func(t *testing.T) {
-		FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-			return nil, errors.New("decode error")
-		}
-		result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
-		assert.Error(t, err)
-		assert.Nil(t, result)
-	})
+	done := stale
+	done.Status = models.IdempotencyCompleted
+	assert.False(t, canTakeOver(done, rec, staleBefore))
 
-	t.Run("success path", func(t *testing.T) {
-		FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-			return &models.StockData{Ticker: ticker, Bid: 100.0, Ask: 101.0, Time: date}, nil
-		}
-		result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
-		assert.NoError(t, err)
-		assert.NotNil(t, result)
-		assert.Equal(t, "AAPL", result.Ticker)
-		assert.Equal(t, testDate, result.Time)
-	})
+	other := stale
+	other.RequestHash = "h2"
+	assert.False(t, canTakeOver(other, rec, staleBefore))
 }
 
-// --- Adapter-based error branch coverage for FindStockByTickerAndDate ---
-func TestFindStockByTickerAndDate_AdapterErrorBranches(t *testing.T) {
-	const findErrMsg = "find error"
-
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		coll := &mockCollection{findErr: errors.New(findErrMsg), decodeErr: nil}
-		ctx := context.Background()
-		var result models.StockData
-		singleResult := coll.FindOne(ctx, map[string]interface{}{})
-		if err := singleResult.Decode(&result); err != nil {
-			return nil, err
-		}
-		return &result, nil
+func TestStaleLockFilter(t *testing.T) {
+	at := parseTestTime(testDate)
+	rec := models.IdempotencyRecord{Key: "holdpayment:k1", LockedAt: at}
+	assert.Equal(t, bson.M{"_id": "holdpayment:k1", "status": models.IdempotencyInProgress, "locked_at": at}, staleLockFilter(rec))
+
+	idx := idempotencyIndexes()
+	assert.Len(t, idx, 1)
+	assert.Equal(t, int32(0), *idx[0].Options.ExpireAfterSeconds)
+}
+
+func TestCompleteAndReleaseIdempotencyKey(t *testing.T) {
+	origClient := Client
+	origUpdate := MongoUpdateOneFunc
+	origDelete := MongoDeleteOneFunc
+	defer func() { Client = origClient; MongoUpdateOneFunc = origUpdate; MongoDeleteOneFunc = origDelete }()
+
+	Client = nil
+	assert.Error(t, CompleteIdempotencyKey("db", "keys", "k1", 200, []byte("{}")))
+	assert.Error(t, ReleaseIdempotencyKey("db", "keys", "k1"))
+	assert.Error(t, EnsureIdempotencyIndexes("db", "keys"))
+
+	Client = &mongo.Client{}
+	MongoUpdateOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		set := update.(bson.M)["$set"].(bson.M)
+		assert.Equal(t, models.IdempotencyCompleted, set["status"])
+		assert.Equal(t, 201, set["response_code"])
+		assert.Equal(t, `{"id":"x"}`, set["response_body"])
+		return &mongo.UpdateResult{MatchedCount: 1}, nil
 	}
-	result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), findErrMsg)
-	assert.Nil(t, result)
-
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		coll := &mockCollection{findErr: nil, decodeErr: errors.New(decodeErrMsg)}
-		ctx := context.Background()
-		var result models.StockData
-		singleResult := coll.FindOne(ctx, map[string]interface{}{})
-		if err := singleResult.Decode(&result); err != nil {
-			return nil, err
-		}
-		return &result, nil
+	assert.NoError(t, CompleteIdempotencyKey("db", "keys", "k1", 201, []byte(`{"id":"x"}`)))
+
+	MongoDeleteOneFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+		assert.Equal(t, bson.M{"_id": "k1"}, filter)
+		return &mongo.DeleteResult{DeletedCount: 1}, nil
 	}
-	result, err = FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
+	assert.NoError(t, ReleaseIdempotencyKey("db", "keys", "k1"))
+}
+
+func TestRecordRefund(t *testing.T) {
+	origClient := Client
+	origUpsert := MongoUpsertOneFunc
+	defer func() { Client = origClient; MongoUpsertOneFunc = origUpsert }()
+
+	p := models.Payment{ID: "pi_1", VIN: "VIN1", Status: "succeeded", Amount: 5000, AmountReceived: 5000, AmountRefunded: 2000, Currency: "usd"}
+	r := models.Refund{ID: "re_1", Amount: 2000, Currency: "usd", Status: "succeeded"}
+
+	Client = nil
+	assert.Error(t, RecordRefund("db", "payments", p, r))
+	_, err := FindPayment("db", "payments", "pi_1")
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), decodeErrMsg)
-	assert.Nil(t, result)
+
+	Client = &mongo.Client{}
+	MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		assert.Equal(t, bson.M{"_id": "pi_1", "refunds.refund_id": bson.M{"$ne": "re_1"}}, filter)
+		u := update.(bson.M)
+		assert.Equal(t, "VIN1", u["$setOnInsert"].(bson.M)["vin"])
+		assert.Equal(t, bson.M{"amount_received": int64(5000), "amount_refunded": int64(2000)}, u["$max"])
+		assert.NotContains(t, u["$set"], "amount_refunded")
+		assert.Equal(t, bson.M{"refunds": r}, u["$push"])
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	assert.NoError(t, RecordRefund("db", "payments", p, r))
+
+	// An already recorded refund misses the filter and collides on insert
+	MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+	}
+	assert.NoError(t, RecordRefund("db", "payments", p, r))
+
+	MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		return nil, errors.New("write failed")
+	}
+	assert.Error(t, RecordRefund("db", "payments", p, r))
 }
 
-func TestFindStockByTickerAndDate_ErrorBranches(t *testing.T) {
+func TestRecordPayment(t *testing.T) {
+	origClient := Client
+	origUpsert := MongoUpsertOneFunc
+	defer func() { Client = origClient; MongoUpsertOneFunc = origUpsert }()
 
-	const findErrMsg = "find error"
-	const decodeErrMsg = "decode error"
+	p := models.Payment{ID: "pi_1", VIN: "VIN1", Region: "US", Status: "requires_capture", Amount: 5000, Currency: "usd"}
 
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		coll := &mockCollection{findErr: errors.New(findErrMsg), decodeErr: nil}
-		ctx := context.Background()
-		var result models.StockData
-		singleResult := coll.FindOne(ctx, map[string]interface{}{})
-		if err := singleResult.Decode(&result); err != nil {
-			return nil, err
-		}
-		return &result, nil
+	Client = nil
+	assert.Error(t, RecordPayment("db", "payments", p))
+
+	Client = &mongo.Client{}
+	MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		assert.Equal(t, bson.M{"_id": "pi_1"}, filter)
+		u := update.(bson.M)
+		assert.Equal(t, "VIN1", u["$setOnInsert"].(bson.M)["vin"])
+		assert.Equal(t, []models.Refund{}, u["$setOnInsert"].(bson.M)["refunds"])
+		assert.Equal(t, "requires_capture", u["$set"].(bson.M)["status"])
+		// Refund totals are only ever written by RecordRefund
+		assert.NotContains(t, u["$set"], "amount_refunded")
+		return &mongo.UpdateResult{UpsertedCount: 1}, nil
 	}
-	result, err := FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), findErrMsg)
-	assert.Nil(t, result)
-
-	FindStockByTickerAndDate = func(database, collection, ticker, date string) (*models.StockData, error) {
-		coll := &mockCollection{findErr: nil, decodeErr: errors.New(decodeErrMsg)}
-		ctx := context.Background()
-		var result models.StockData
-		singleResult := coll.FindOne(ctx, map[string]interface{}{})
-		if err := singleResult.Decode(&result); err != nil {
-			return nil, err
-		}
-		return &result, nil
+	assert.NoError(t, RecordPayment("db", "payments", p))
+
+	MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+		return nil, errors.New("write failed")
 	}
-	result, err = FindStockByTickerAndDate("db", "coll", "AAPL", testDate)
+	assert.Error(t, RecordPayment("db", "payments", p))
+}
+
+func TestAppendLedgerEntry(t *testing.T) {
+	origClient := Client
+	origInsert := MongoInsertOneFunc
+	defer func() { Client = origClient; MongoInsertOneFunc = origInsert }()
+
+	e := models.LedgerEntry{ID: "capture:pi_1", Type: models.LedgerCapture, PaymentIntentID: "pi_1", Amount: 500}
+
+	Client = nil
+	assert.Error(t, AppendLedgerEntry("db", "ledger", e))
+	_, err := FindLedgerEntries("db", "ledger", LedgerQuery{})
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), decodeErrMsg)
-	assert.Nil(t, result)
+	_, err = FindLedgerEntry("db", "ledger", "capture:pi_1")
+	assert.Error(t, err)
+	assert.Error(t, EnsureLedgerIndexes("db", "ledger"))
+
+	Client = &mongo.Client{}
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		assert.Equal(t, e, data)
+		return nil, nil
+	}
+	assert.NoError(t, AppendLedgerEntry("db", "ledger", e))
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+	}
+	assert.NoError(t, AppendLedgerEntry("db", "ledger", e))
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, errors.New("insert failed")
+	}
+	assert.Error(t, AppendLedgerEntry("db", "ledger", e))
+}
+
+func TestLedgerFilter(t *testing.T) {
+	assert.Equal(t, bson.M{}, ledgerFilter(LedgerQuery{}))
+
+	from := parseTestTime(testDate)
+	to := from.Add(time.Hour)
+	assert.Equal(t, bson.M{
+		"vin":        "VIN1",
+		"status":     "succeeded",
+		"type":       models.LedgerRefund,
+		"created_at": bson.M{"$gte": from, "$lte": to},
+	}, ledgerFilter(LedgerQuery{VIN: "VIN1", Status: "succeeded", Type: models.LedgerRefund, From: from, To: to}))
+
+	assert.Equal(t, bson.M{
+		"$or": bson.A{
+			bson.M{"created_at": bson.M{"$gt": from}},
+			bson.M{"created_at": from, "_id": bson.M{"$gt": "hold:pi_1"}},
+		},
+	}, ledgerFilter(LedgerQuery{After: &LedgerCursor{CreatedAt: from, ID: "hold:pi_1"}}))
+
+	assert.Len(t, ledgerIndexes(), 3)
 }
@@ -0,0 +1,120 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrPaymentNotFound is returned when no payment record matches the ID
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// MongoUpsertOneFunc wraps UpdateOne with upsert for testability
+var MongoUpsertOneFunc = func(coll *mongo.Collection, ctx context.Context, filter, update interface{}) (*mongo.UpdateResult, error) {
+	return coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+}
+
+// RecordPayment creates or updates the record of payment p when its hold is
+// placed or captured, keeping any refunds already recorded
+var RecordPayment = func(database, collection string, p models.Payment) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := MongoUpsertOneFunc(coll, ctx, bson.M{"_id": p.ID}, paymentUpdate(p, time.Now().UTC()))
+	return err
+}
+
+func paymentUpdate(p models.Payment, now time.Time) bson.M {
+	return bson.M{
+		"$setOnInsert": bson.M{
+			"vin":             p.VIN,
+			"region":          p.Region,
+			"amount":          p.Amount,
+			"currency":        p.Currency,
+			"amount_refunded": int64(0),
+			"refunds":         []models.Refund{},
+			"created_at":      now,
+		},
+		"$set": bson.M{
+			"status":          p.Status,
+			"amount_received": p.AmountReceived,
+			"updated_at":      now,
+		},
+	}
+}
+
+// RecordRefund appends r to the refund history of payment p. The record is
+// created if RecordPayment never stored it. p.AmountRefunded is the total
+// Stripe reports including r; the stored totals only ever grow. Recording the
+// same refund twice is a no-op.
+var RecordRefund = func(database, collection string, p models.Payment, r models.Refund) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := MongoUpsertOneFunc(coll, ctx, refundFilter(p.ID, r.ID), refundUpdate(p, r, time.Now().UTC()))
+	if mongo.IsDuplicateKeyError(err) {
+		// The record exists and already lists r, so the upsert tried to insert it again
+		return nil
+	}
+	return err
+}
+
+// refundFilter matches payment id unless it already lists the refund
+func refundFilter(paymentID, refundID string) bson.M {
+	return bson.M{"_id": paymentID, "refunds.refund_id": bson.M{"$ne": refundID}}
+}
+
+func refundUpdate(p models.Payment, r models.Refund, now time.Time) bson.M {
+	return bson.M{
+		"$setOnInsert": bson.M{
+			"vin":        p.VIN,
+			"region":     p.Region,
+			"amount":     p.Amount,
+			"currency":   p.Currency,
+			"created_at": now,
+		},
+		"$set": bson.M{
+			"status":     p.Status,
+			"updated_at": now,
+		},
+		// Refunds recorded out of order must not shrink the totals
+		"$max": bson.M{
+			"amount_received": p.AmountReceived,
+			"amount_refunded": p.AmountRefunded,
+		},
+		"$push": bson.M{"refunds": r},
+	}
+}
+
+// FindPayment returns the payment record of a PaymentIntent
+var FindPayment = func(database, collection, id string) (*models.Payment, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := &mongoCollectionAdapter{coll: Client.Database(database).Collection(collection)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var p models.Payment
+	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&p); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,181 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrVINReserved is returned when a VIN already has an active reservation
+var ErrVINReserved = errors.New("vehicle already has an active reservation")
+
+// ErrReservationNotFound is returned when no reservation matches the ID
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// MongoCreateIndexesFunc wraps CreateMany on a collection's indexes for testability
+var MongoCreateIndexesFunc = func(coll *mongo.Collection, ctx context.Context, models []mongo.IndexModel) ([]string, error) {
+	return coll.Indexes().CreateMany(ctx, models)
+}
+
+// EnsureReservationIndexes creates the unique partial index that allows only one
+// active reservation per VIN, plus the index used to find expired reservations
+func EnsureReservationIndexes(database, collection string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := MongoCreateIndexesFunc(coll, ctx, reservationIndexes())
+	return err
+}
+
+func reservationIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "vin", Value: 1}},
+			Options: options.Index().
+				SetName("vin_active_unique").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"active": true}),
+		},
+		{
+			Keys:    bson.D{{Key: "active", Value: 1}, {Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("active_expires_at"),
+		},
+	}
+}
+
+// InsertReservation claims r.VIN by inserting an active reservation. The unique
+// partial index makes the claim atomic: a second active reservation for the
+// same VIN fails with ErrVINReserved.
+var InsertReservation = func(database, collection string, r models.Reservation) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r.Active = true
+	_, err := MongoInsertOneFunc(coll, ctx, r)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVINReserved
+	}
+	return err
+}
+
+// AttachReservationIntent records the not yet confirmed PaymentIntent of a
+// pending reservation, so its hold can be cancelled even if confirming it is
+// never followed by ActivateReservation
+var AttachReservationIntent = func(database, collection, id, paymentIntentID string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := MongoUpdateOneFunc(coll, ctx,
+		bson.M{"_id": id, "active": true},
+		bson.M{"$set": bson.M{"payment_intent_id": paymentIntentID}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrReservationNotFound
+	}
+	return nil
+}
+
+// ActivateReservation records the payment hold of a pending reservation
+var ActivateReservation = func(database, collection, id, paymentIntentID string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := MongoUpdateOneFunc(coll, ctx,
+		bson.M{"_id": id, "active": true},
+		bson.M{"$set": bson.M{"status": models.ReservationActive, "payment_intent_id": paymentIntentID}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrReservationNotFound
+	}
+	return nil
+}
+
+// ReleaseReservation frees the VIN of an active reservation and records the final
+// status. It reports false if the reservation was already released.
+var ReleaseReservation = func(database, collection, id, status string) (bool, error) {
+	if Client == nil {
+		return false, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := MongoUpdateOneFunc(coll, ctx, bson.M{"_id": id, "active": true}, releaseUpdate(status, time.Now().UTC()))
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+func releaseUpdate(status string, at time.Time) bson.M {
+	return bson.M{"$set": bson.M{"status": status, "active": false, "released_at": at}}
+}
+
+// FindReservation returns the reservation with the given ID
+var FindReservation = func(database, collection, id string) (*models.Reservation, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := &mongoCollectionAdapter{coll: Client.Database(database).Collection(collection)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var r models.Reservation
+	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&r); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrReservationNotFound
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// FindExpiredReservations returns up to limit active reservations that expired at or before now
+var FindExpiredReservations = func(database, collection string, now time.Time, limit int64) ([]models.Reservation, error) {
+	if Client == nil {
+		return nil, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "expires_at", Value: 1}}).SetLimit(limit)
+	cursor, err := coll.Find(ctx, expiredReservationsFilter(now), opts)
+	if err != nil {
+		return nil, err
+	}
+	var out []models.Reservation
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func expiredReservationsFilter(now time.Time) bson.M {
+	return bson.M{"active": true, "expires_at": bson.M{"$lte": now}}
+}
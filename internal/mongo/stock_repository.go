@@ -0,0 +1,503 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrStockNotFound is returned when no tick matches a lookup
+var ErrStockNotFound = errors.New("stock tick not found")
+
+// ErrNotConnected is returned by a MongoStockRepository used before Connect
+var ErrNotConnected = errors.New("Mongo client is not initialized")
+
+// StockRepository stores and queries stock ticks
+type StockRepository interface {
+	// Insert stores one tick
+	Insert(ctx context.Context, tick models.StockData) error
+	// InsertMany stores ticks in one round trip; a *TickWriteError lists ticks that were not stored
+	InsertMany(ctx context.Context, ticks []models.StockData) error
+	// Upsert stores the ticks not stored yet for their ticker and time, so
+	// repeating it is harmless; a *TickWriteError lists ticks that were not stored
+	Upsert(ctx context.Context, ticks []models.StockData) error
+	// FindAt returns the last tick for ticker at or before at
+	FindAt(ctx context.Context, ticker string, at time.Time) (*models.StockData, error)
+	// Range returns the ticks matching q, oldest first
+	Range(ctx context.Context, q StockRangeQuery) ([]models.StockData, error)
+	// Latest returns the newest tick for ticker
+	Latest(ctx context.Context, ticker string) (*models.StockData, error)
+	// Delete removes the ticks of q.Ticker in [q.From, q.To) and returns how many it removed
+	Delete(ctx context.Context, q StockRangeQuery) (int64, error)
+}
+
+// FailedTick is a tick that could not be stored
+type FailedTick struct {
+	Index int // position in the batch
+	Tick  models.StockData
+	Err   error
+}
+
+// TickWriteError reports the ticks of a batch that were not stored; the rest were
+type TickWriteError struct {
+	Failed []FailedTick
+}
+
+func (e *TickWriteError) Error() string {
+	return fmt.Sprintf("%d ticks not stored, first: %v", len(e.Failed), e.Failed[0].Err)
+}
+
+// StockRangeQuery selects ticks for one ticker with From <= time < To, oldest first
+type StockRangeQuery struct {
+	Ticker string
+	From   time.Time
+	To     time.Time
+	Limit  int64
+}
+
+// MongoInsertManyFunc wraps an unordered InsertMany for testability, so one
+// duplicate does not stop the rest of the batch
+var MongoInsertManyFunc = func(coll *mongo.Collection, ctx context.Context, docs []interface{}) (*mongo.InsertManyResult, error) {
+	return coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+}
+
+// MongoBulkWriteFunc wraps an unordered BulkWrite for testability
+var MongoBulkWriteFunc = func(coll *mongo.Collection, ctx context.Context, writes []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	return coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+}
+
+// MongoFindTicksFunc returns the ticks matching filter for testability
+var MongoFindTicksFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) ([]models.StockData, error) {
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var ticks []models.StockData
+	err = cursor.All(ctx, &ticks)
+	return ticks, err
+}
+
+// MongoDeleteManyFunc wraps DeleteMany for testability
+var MongoDeleteManyFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	return coll.DeleteMany(ctx, filter)
+}
+
+// MongoStockRepository keeps ticks in a MongoDB collection of the shared Client
+type MongoStockRepository struct {
+	Database   string
+	Collection string
+	// TimeSeries is set for a time-series collection, which has no unique index
+	// and rejects upserts: Upsert then looks the ticks up and inserts the ones
+	// not stored yet. Its ticks are looked up by their meta.ticker series.
+	TimeSeries bool
+}
+
+// NewMongoStockRepository returns a repository for database/collection
+func NewMongoStockRepository(database, collection string) *MongoStockRepository {
+	return &MongoStockRepository{Database: database, Collection: collection}
+}
+
+func (r *MongoStockRepository) collection() (*mongo.Collection, error) {
+	if Client == nil {
+		return nil, ErrNotConnected
+	}
+	return Client.Database(r.Database).Collection(r.Collection), nil
+}
+
+// Insert stores one tick. A tick already stored for the same ticker and time
+// is rejected by the unique index and ignored.
+func (r *MongoStockRepository) Insert(ctx context.Context, tick models.StockData) error {
+	coll, err := r.collection()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = MongoInsertOneFunc(coll, ctx, tick.WithMeta())
+	return ignoreDuplicateTicks(err)
+}
+
+// InsertMany stores ticks with a single unordered InsertMany, skipping duplicate
+// ticks. Ticks the server rejected are reported in a *TickWriteError.
+func (r *MongoStockRepository) InsertMany(ctx context.Context, ticks []models.StockData) error {
+	if len(ticks) == 0 {
+		return nil
+	}
+	coll, err := r.collection()
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, len(ticks))
+	for i, t := range ticks {
+		docs[i] = t.WithMeta()
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err = MongoInsertManyFunc(coll, ctx, docs)
+	return tickWriteError(err, ticks)
+}
+
+// Upsert stores ticks with one unordered bulk write of upserts matching ticker
+// and time; ticks already stored are left unchanged. Ticks the server rejected
+// are reported in a *TickWriteError. On a time-series collection it inserts the
+// ticks it does not find instead; see insertUnstored.
+func (r *MongoStockRepository) Upsert(ctx context.Context, ticks []models.StockData) error {
+	if len(ticks) == 0 {
+		return nil
+	}
+	if r.TimeSeries {
+		return r.insertUnstored(ctx, ticks)
+	}
+	coll, err := r.collection()
+	if err != nil {
+		return err
+	}
+	writes := make([]mongo.WriteModel, len(ticks))
+	for i, t := range ticks {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"ticker": t.Ticker, "time": t.Time}).
+			SetUpdate(bson.M{"$setOnInsert": t.WithMeta()}).
+			SetUpsert(true)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err = MongoBulkWriteFunc(coll, ctx, writes)
+	return tickWriteError(err, ticks)
+}
+
+// insertUnstored inserts the ticks whose ticker and time are neither stored nor
+// repeated earlier in ticks. Without a unique index this is not atomic: two
+// writers storing the same tick at the same moment can both insert it.
+func (r *MongoStockRepository) insertUnstored(ctx context.Context, ticks []models.StockData) error {
+	coll, err := r.collection()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tickers := make([]string, 0, len(ticks))
+	times := make([]time.Time, 0, len(ticks))
+	for _, t := range ticks {
+		tickers = append(tickers, t.Ticker)
+		times = append(times, t.Time)
+	}
+	stored, err := MongoFindTicksFunc(coll, ctx, bson.M{
+		stockTickerField(true): bson.M{"$in": tickers},
+		"time":                 bson.M{"$in": times},
+	})
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(stored)+len(ticks))
+	for _, t := range stored {
+		seen[tickKey(t)] = true
+	}
+	var docs []interface{}
+	var positions []int
+	for i, t := range ticks {
+		if key := tickKey(t); !seen[key] {
+			seen[key] = true
+			docs = append(docs, t.WithMeta())
+			positions = append(positions, i)
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err = MongoInsertManyFunc(coll, ctx, docs)
+	var partial *TickWriteError
+	if err = tickWriteError(err, subset(ticks, positions)); errors.As(err, &partial) {
+		for i := range partial.Failed {
+			partial.Failed[i].Index = positions[partial.Failed[i].Index]
+		}
+	}
+	return err
+}
+
+// tickKey identifies a tick by its ticker and time
+func tickKey(t models.StockData) string {
+	return t.Ticker + "@" + strconv.FormatInt(t.Time.UnixMilli(), 10)
+}
+
+func subset(ticks []models.StockData, positions []int) []models.StockData {
+	out := make([]models.StockData, len(positions))
+	for i, p := range positions {
+		out[i] = ticks[p]
+	}
+	return out
+}
+
+// ignoreDuplicateTicks drops a duplicate key error
+func ignoreDuplicateTicks(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// tickWriteError maps the per-document errors of an unordered InsertMany of
+// ticks to a *TickWriteError, dropping duplicate keys. Errors that do not name
+// single documents are returned unchanged.
+func tickWriteError(err error, ticks []models.StockData) error {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil || len(bulk.WriteErrors) == 0 {
+		return err
+	}
+	var failed []FailedTick
+	for _, we := range bulk.WriteErrors {
+		if we.HasErrorCode(11000) {
+			continue
+		}
+		if we.Index < 0 || we.Index >= len(ticks) {
+			return err
+		}
+		failed = append(failed, FailedTick{Index: we.Index, Tick: ticks[we.Index], Err: we.WriteError})
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &TickWriteError{Failed: failed}
+}
+
+// transientCodes are server errors raised while a replica set fails over or shuts down
+var transientCodes = []int{6, 7, 89, 91, 189, 262, 9001, 10107, 11600, 11602, 13435, 13436}
+
+// IsTransientError reports whether a failed write may succeed when retried:
+// network errors, timeouts, replica set failovers and errors the server
+// labels retryable. Validation failures and other rejections are permanent.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var le mongo.LabeledError
+	if errors.As(err, &le) && (le.HasErrorLabel("RetryableWriteError") || le.HasErrorLabel("TransientTransactionError")) {
+		return true
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		for _, code := range transientCodes {
+			if se.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FindAt returns the last tick for ticker at or before at
+func (r *MongoStockRepository) FindAt(ctx context.Context, ticker string, at time.Time) (*models.StockData, error) {
+	return r.findOne(ctx, bson.M{stockTickerField(r.TimeSeries): ticker, "time": bson.M{"$lte": at}})
+}
+
+// Latest returns the newest tick for ticker
+func (r *MongoStockRepository) Latest(ctx context.Context, ticker string) (*models.StockData, error) {
+	return r.findOne(ctx, bson.M{stockTickerField(r.TimeSeries): ticker})
+}
+
+// findOne returns the newest tick matching filter
+func (r *MongoStockRepository) findOne(ctx context.Context, filter bson.M) (*models.StockData, error) {
+	coll, err := r.collection()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: -1}})
+	var result models.StockData
+	if err := coll.FindOne(ctx, filter, opts).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrStockNotFound
+		}
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Range returns the ticks matching q sorted by time ascending
+func (r *MongoStockRepository) Range(ctx context.Context, q StockRangeQuery) ([]models.StockData, error) {
+	coll, err := r.collection()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	cursor, err := coll.Find(ctx, stockRangeFilter(q, r.TimeSeries), opts)
+	if err != nil {
+		return nil, err
+	}
+	var ticks []models.StockData
+	if err := cursor.All(ctx, &ticks); err != nil {
+		return nil, err
+	}
+	return ticks, nil
+}
+
+// Delete removes the ticks of q.Ticker in [q.From, q.To)
+func (r *MongoStockRepository) Delete(ctx context.Context, q StockRangeQuery) (int64, error) {
+	coll, err := r.collection()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	res, err := MongoDeleteManyFunc(coll, ctx, stockRangeFilter(q, r.TimeSeries))
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// MemoryStockRepository keeps ticks in memory. It is safe for concurrent use and
+// serves tests and runs without a database; the zero value is ready to use.
+// Like the unique {ticker, time} index of the stock collection it stores one
+// tick per ticker and time.
+type MemoryStockRepository struct {
+	mu    sync.RWMutex
+	ticks []models.StockData
+	keys  map[string]struct{} // tickKey of every stored tick
+}
+
+// NewMemoryStockRepository returns a repository holding ticks
+func NewMemoryStockRepository(ticks ...models.StockData) *MemoryStockRepository {
+	r := &MemoryStockRepository{}
+	r.add(ticks)
+	return r
+}
+
+// Insert stores one tick unless its ticker and time are stored already
+func (r *MemoryStockRepository) Insert(ctx context.Context, tick models.StockData) error {
+	return r.InsertMany(ctx, []models.StockData{tick})
+}
+
+// InsertMany stores the ticks whose ticker and time are not stored yet
+func (r *MemoryStockRepository) InsertMany(ctx context.Context, ticks []models.StockData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(ticks)
+	return nil
+}
+
+// Upsert stores the ticks whose ticker and time are not stored yet
+func (r *MemoryStockRepository) Upsert(ctx context.Context, ticks []models.StockData) error {
+	return r.InsertMany(ctx, ticks)
+}
+
+// add appends the ticks not stored yet; the caller holds r.mu
+func (r *MemoryStockRepository) add(ticks []models.StockData) {
+	if r.keys == nil {
+		r.keys = make(map[string]struct{}, len(r.ticks)+len(ticks))
+		for _, t := range r.ticks {
+			r.keys[tickKey(t)] = struct{}{}
+		}
+	}
+	for _, tick := range ticks {
+		key := tickKey(tick)
+		if _, ok := r.keys[key]; ok {
+			continue
+		}
+		r.keys[key] = struct{}{}
+		r.ticks = append(r.ticks, tick)
+	}
+}
+
+// FindAt returns the last tick for ticker at or before at
+func (r *MemoryStockRepository) FindAt(ctx context.Context, ticker string, at time.Time) (*models.StockData, error) {
+	return r.newest(func(t models.StockData) bool { return t.Ticker == ticker && !t.Time.After(at) })
+}
+
+// Latest returns the newest tick for ticker
+func (r *MemoryStockRepository) Latest(ctx context.Context, ticker string) (*models.StockData, error) {
+	return r.newest(func(t models.StockData) bool { return t.Ticker == ticker })
+}
+
+func (r *MemoryStockRepository) newest(match func(models.StockData) bool) (*models.StockData, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found *models.StockData
+	for i := range r.ticks {
+		if match(r.ticks[i]) && (found == nil || r.ticks[i].Time.After(found.Time)) {
+			found = &r.ticks[i]
+		}
+	}
+	if found == nil {
+		return nil, ErrStockNotFound
+	}
+	tick := *found
+	return &tick, nil
+}
+
+// Range returns the ticks matching q sorted by time ascending
+func (r *MemoryStockRepository) Range(ctx context.Context, q StockRangeQuery) ([]models.StockData, error) {
+	r.mu.RLock()
+	var ticks []models.StockData
+	for _, t := range r.ticks {
+		if inStockRange(t, q) {
+			ticks = append(ticks, t)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(ticks, func(i, j int) bool { return ticks[i].Time.Before(ticks[j].Time) })
+	if q.Limit > 0 && int64(len(ticks)) > q.Limit {
+		ticks = ticks[:q.Limit]
+	}
+	return ticks, nil
+}
+
+// Delete removes the ticks of q.Ticker in [q.From, q.To)
+func (r *MemoryStockRepository) Delete(ctx context.Context, q StockRangeQuery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.ticks[:0]
+	for _, t := range r.ticks {
+		if !inStockRange(t, q) {
+			kept = append(kept, t)
+		} else {
+			delete(r.keys, tickKey(t))
+		}
+	}
+	removed := int64(len(r.ticks) - len(kept))
+	r.ticks = kept
+	return removed, nil
+}
+
+func stockRangeFilter(q StockRangeQuery, timeSeries bool) bson.M {
+	return bson.M{
+		stockTickerField(timeSeries): q.Ticker,
+		"time":                       bson.M{"$gte": q.From, "$lt": q.To},
+	}
+}
+
+// stockTickerField is the field ticks are looked up by: the series in the
+// metaField of a time-series collection, which its buckets are grouped by,
+// and the top-level ticker that legacy ticks of a regular collection carry
+func stockTickerField(timeSeries bool) string {
+	if timeSeries {
+		return stockMetaField + ".ticker"
+	}
+	return "ticker"
+}
+
+// inStockRange mirrors stockRangeFilter
+func inStockRange(t models.StockData, q StockRangeQuery) bool {
+	return t.Ticker == q.Ticker && !t.Time.Before(q.From) && t.Time.Before(q.To)
+}
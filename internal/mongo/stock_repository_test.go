@@ -0,0 +1,271 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func repoTick(ticker string, offset time.Duration, bid float64) models.StockData {
+	return models.StockData{Ticker: ticker, Bid: bid, Ask: bid + 1, Time: parseTestTime(testDate).Add(offset)}
+}
+
+func TestMemoryStockRepositoryLookups(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryStockRepository(repoTick("VEHICLE-1", 2*time.Minute, 102), repoTick("VEHICLE-1", 0, 100))
+	assert.NoError(t, repo.InsertMany(ctx, []models.StockData{repoTick("VEHICLE-1", time.Minute, 101), repoTick("VEHICLE-2", 5*time.Minute, 200)}))
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-1", 3*time.Minute, 103)))
+
+	at, err := repo.FindAt(ctx, "VEHICLE-1", parseTestTime(testDate).Add(90*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 101.0, at.Bid)
+
+	latest, err := repo.Latest(ctx, "VEHICLE-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 103.0, latest.Bid)
+
+	_, err = repo.FindAt(ctx, "VEHICLE-1", parseTestTime(testDate).Add(-time.Second))
+	assert.True(t, errors.Is(err, ErrStockNotFound))
+	_, err = repo.Latest(ctx, "VEHICLE-3")
+	assert.True(t, errors.Is(err, ErrStockNotFound))
+}
+
+func TestMemoryStockRepositoryRangeAndDelete(t *testing.T) {
+	ctx := context.Background()
+	repo := &MemoryStockRepository{}
+	for i := 3; i >= 0; i-- {
+		repo.Insert(ctx, repoTick("VEHICLE-1", time.Duration(i)*time.Minute, float64(100+i)))
+	}
+	repo.Insert(ctx, repoTick("VEHICLE-2", time.Minute, 200))
+	from := parseTestTime(testDate)
+
+	ticks, err := repo.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(3 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{100, 101, 102}, []float64{ticks[0].Bid, ticks[1].Bid, ticks[2].Bid})
+
+	ticks, _ = repo.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(time.Hour), Limit: 2})
+	assert.Len(t, ticks, 2)
+
+	n, err := repo.Delete(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(2 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	ticks, _ = repo.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(time.Hour)})
+	assert.Len(t, ticks, 2)
+	other, _ := repo.Latest(ctx, "VEHICLE-2")
+	assert.Equal(t, 200.0, other.Bid)
+}
+
+func TestMemoryStockRepositoryKeepsOneTickPerTime(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryStockRepository(repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-1", 0, 999))
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 998)))
+	assert.NoError(t, repo.InsertMany(ctx, []models.StockData{repoTick("VEHICLE-1", time.Minute, 101), repoTick("VEHICLE-1", time.Minute, 997)}))
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-2", 0, 200)))
+
+	from := parseTestTime(testDate)
+	all := StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(time.Hour)}
+	ticks, _ := repo.Range(ctx, all)
+	assert.Equal(t, []float64{100, 101}, []float64{ticks[0].Bid, ticks[1].Bid})
+	assert.Len(t, ticks, 2)
+
+	// A deleted tick can be stored again
+	_, err := repo.Delete(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(time.Second)})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 102)))
+	ticks, _ = repo.Range(ctx, all)
+	assert.Equal(t, []float64{102, 101}, []float64{ticks[0].Bid, ticks[1].Bid})
+}
+
+func TestMemoryStockRepositoryConcurrentInserts(t *testing.T) {
+	ctx := context.Background()
+	repo := &MemoryStockRepository{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			repo.Insert(ctx, repoTick("VEHICLE-1", time.Duration(i)*time.Second, float64(i)))
+			repo.Latest(ctx, "VEHICLE-1")
+		}(i)
+	}
+	wg.Wait()
+	ticks, _ := repo.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: parseTestTime(testDate), To: parseTestTime(testDate).Add(time.Hour)})
+	assert.Len(t, ticks, 20)
+}
+
+func TestMongoStockRepositoryNilClient(t *testing.T) {
+	origClient := Client
+	defer func() { Client = origClient }()
+	Client = nil
+	ctx := context.Background()
+	repo := NewMongoStockRepository("db", "coll")
+
+	assert.Error(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+	assert.Error(t, repo.InsertMany(ctx, []models.StockData{repoTick("VEHICLE-1", 0, 100)}))
+	tick, err := repo.FindAt(ctx, "VEHICLE-1", time.Now())
+	assert.Error(t, err)
+	assert.Nil(t, tick)
+	_, err = repo.Latest(ctx, "VEHICLE-1")
+	assert.Error(t, err)
+	ticks, err := repo.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1"})
+	assert.Error(t, err)
+	assert.Nil(t, ticks)
+	_, err = repo.Delete(ctx, StockRangeQuery{Ticker: "VEHICLE-1"})
+	assert.Error(t, err)
+}
+
+func TestMongoStockRepositoryWrites(t *testing.T) {
+	origClient, origInsert, origMany, origDelete := Client, MongoInsertOneFunc, MongoInsertManyFunc, MongoDeleteManyFunc
+	defer func() {
+		Client, MongoInsertOneFunc, MongoInsertManyFunc, MongoDeleteManyFunc = origClient, origInsert, origMany, origDelete
+	}()
+	Client = &mongo.Client{}
+	ctx := context.Background()
+	repo := NewMongoStockRepository("db", "ticks")
+
+	var inserted []interface{}
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		assert.Equal(t, "ticks", coll.Name())
+		inserted = append(inserted, data)
+		return nil, nil
+	}
+	MongoInsertManyFunc = func(coll *mongo.Collection, ctx context.Context, docs []interface{}) (*mongo.InsertManyResult, error) {
+		inserted = append(inserted, docs...)
+		return &mongo.InsertManyResult{}, nil
+	}
+	MongoDeleteManyFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+		from := parseTestTime(testDate)
+		assert.Equal(t, stockRangeFilter(StockRangeQuery{Ticker: "VEHICLE-1", From: from, To: from.Add(time.Hour)}, false), filter)
+		return &mongo.DeleteResult{DeletedCount: 3}, nil
+	}
+
+	assert.NoError(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+	assert.NoError(t, repo.InsertMany(ctx, []models.StockData{repoTick("VEHICLE-1", time.Minute, 101), repoTick("VEHICLE-1", 2*time.Minute, 102)}))
+	assert.NoError(t, repo.InsertMany(ctx, nil))
+	assert.Len(t, inserted, 3)
+	assert.Equal(t, repoTick("VEHICLE-1", 0, 100).WithMeta(), inserted[0])
+	assert.Equal(t, "1", inserted[1].(models.StockData).Meta.VIN)
+
+	n, err := repo.Delete(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: parseTestTime(testDate), To: parseTestTime(testDate).Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	MongoInsertOneFunc = func(coll *mongo.Collection, ctx context.Context, data interface{}) (interface{}, error) {
+		return nil, errors.New("insert fail")
+	}
+	assert.Error(t, repo.Insert(ctx, repoTick("VEHICLE-1", 0, 100)))
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, IsTransientError(context.DeadlineExceeded))
+	assert.True(t, IsTransientError(mongo.CommandError{Code: 189, Message: "primary stepped down"}))
+	assert.True(t, IsTransientError(mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}))
+	assert.True(t, IsTransientError(mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 10107}}}))
+
+	assert.False(t, IsTransientError(nil))
+	assert.False(t, IsTransientError(context.Canceled))
+	assert.False(t, IsTransientError(mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 121, Message: "Document failed validation"}}}))
+	assert.False(t, IsTransientError(errors.New("Mongo client is not initialized")))
+}
+
+func TestStockRepositoryUpsert(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryStockRepository(repoTick("VEHICLE-1", 0, 100))
+	assert.NoError(t, mem.Upsert(ctx, []models.StockData{repoTick("VEHICLE-1", 0, 999), repoTick("VEHICLE-1", time.Minute, 101)}))
+	assert.NoError(t, mem.Upsert(ctx, []models.StockData{repoTick("VEHICLE-1", time.Minute, 101)}))
+	ticks, _ := mem.Range(ctx, StockRangeQuery{Ticker: "VEHICLE-1", From: parseTestTime(testDate), To: parseTestTime(testDate).Add(time.Hour)})
+	assert.Equal(t, []models.StockData{repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-1", time.Minute, 101)}, ticks)
+
+	origClient, origBulk := Client, MongoBulkWriteFunc
+	defer func() { Client, MongoBulkWriteFunc = origClient, origBulk }()
+	Client = &mongo.Client{}
+	repo := NewMongoStockRepository("db", "ticks")
+	var writes []mongo.WriteModel
+	var bulkErr error
+	MongoBulkWriteFunc = func(coll *mongo.Collection, ctx context.Context, w []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		assert.Equal(t, "ticks", coll.Name())
+		writes = w
+		return &mongo.BulkWriteResult{}, bulkErr
+	}
+
+	tick := repoTick("VEHICLE-1", 0, 100)
+	assert.NoError(t, repo.Upsert(ctx, []models.StockData{tick}))
+	model := writes[0].(*mongo.UpdateOneModel)
+	assert.Equal(t, bson.M{"ticker": "VEHICLE-1", "time": tick.Time}, model.Filter)
+	assert.Equal(t, bson.M{"$setOnInsert": tick.WithMeta()}, model.Update)
+	assert.True(t, *model.Upsert)
+
+	// Concurrent upserts of one tick can race into the unique index; that is not a failure
+	bulkErr = mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 11000}}}}
+	assert.NoError(t, repo.Upsert(ctx, []models.StockData{tick}))
+	assert.NoError(t, repo.Upsert(ctx, nil))
+}
+
+// timeSeriesCollection swaps the reads and inserts of a time-series stock
+// collection, which has no unique index, for a slice of stored ticks
+func timeSeriesCollection(t *testing.T) *[]models.StockData {
+	origClient, origBulk, origMany, origFind := Client, MongoBulkWriteFunc, MongoInsertManyFunc, MongoFindTicksFunc
+	t.Cleanup(func() {
+		Client, MongoBulkWriteFunc, MongoInsertManyFunc, MongoFindTicksFunc = origClient, origBulk, origMany, origFind
+	})
+	Client = &mongo.Client{}
+	var stored []models.StockData
+	MongoBulkWriteFunc = func(coll *mongo.Collection, ctx context.Context, w []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		t.Error("upserts are not supported on time-series collections")
+		return nil, nil
+	}
+	MongoFindTicksFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) ([]models.StockData, error) {
+		tickers := filter.(bson.M)["meta.ticker"].(bson.M)["$in"].([]string)
+		var found []models.StockData
+		for _, tick := range stored {
+			if slices.Contains(tickers, tick.Meta.Ticker) {
+				found = append(found, tick)
+			}
+		}
+		return found, nil
+	}
+	MongoInsertManyFunc = func(coll *mongo.Collection, ctx context.Context, docs []interface{}) (*mongo.InsertManyResult, error) {
+		assert.Equal(t, "ticks", coll.Name())
+		for _, doc := range docs {
+			stored = append(stored, doc.(models.StockData))
+		}
+		return &mongo.InsertManyResult{}, nil
+	}
+	return &stored
+}
+
+func TestStockRepositoryUpsertTimeSeries(t *testing.T) {
+	stored := timeSeriesCollection(t)
+	ctx := context.Background()
+	repo := NewMongoStockRepository("db", "ticks")
+	repo.TimeSeries = true
+
+	// Ticks stored before or repeated within the batch are skipped
+	ticks := []models.StockData{repoTick("VEHICLE-1", 0, 100), repoTick("VEHICLE-1", time.Minute, 101)}
+	assert.NoError(t, repo.Upsert(ctx, ticks))
+	assert.NoError(t, repo.Upsert(ctx, []models.StockData{ticks[0], repoTick("VEHICLE-2", 0, 200), repoTick("VEHICLE-2", 0, 999)}))
+	assert.Equal(t, []models.StockData{ticks[0].WithMeta(), ticks[1].WithMeta(), repoTick("VEHICLE-2", 0, 200).WithMeta()}, *stored)
+
+	// Rejected ticks are reported at their position in the batch
+	invalid := mongo.WriteError{Index: 0, Code: 121, Message: "Document failed validation"}
+	MongoInsertManyFunc = func(coll *mongo.Collection, ctx context.Context, docs []interface{}) (*mongo.InsertManyResult, error) {
+		return nil, mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: invalid}}}
+	}
+	batch := []models.StockData{ticks[0], repoTick("VEHICLE-3", 0, 300)}
+	var partial *TickWriteError
+	assert.ErrorAs(t, repo.Upsert(ctx, batch), &partial)
+	assert.Equal(t, []FailedTick{{Index: 1, Tick: batch[1], Err: invalid}}, partial.Failed)
+
+	MongoFindTicksFunc = func(coll *mongo.Collection, ctx context.Context, filter interface{}) ([]models.StockData, error) {
+		return nil, errors.New("find fail")
+	}
+	assert.ErrorContains(t, repo.Upsert(ctx, batch), "find fail")
+}
@@ -0,0 +1,60 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// InsertStripeEvent stores a webhook event keyed by its Stripe event ID and
+// marked unpublished. Stripe delivers events at least once, so a redelivery
+// reports duplicate instead of failing once the event was published; an event
+// whose publish failed is not a duplicate and gets published on redelivery.
+var InsertStripeEvent = func(database, collection string, event models.PaymentEvent, raw []byte) (duplicate bool, err error) {
+	if Client == nil {
+		return false, fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	_, err = MongoInsertOneFunc(coll, ctx, stripeEventDocument(event, raw, now))
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+	res, err := MongoUpdateOneFunc(coll, ctx, bson.M{"_id": event.EventID, "published": false}, bson.M{"$set": bson.M{"redelivered_at": now}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 0, nil
+}
+
+// MarkStripeEventPublished records that the event was published, so later
+// redeliveries are duplicates
+var MarkStripeEventPublished = func(database, collection, eventID string) error {
+	if Client == nil {
+		return fmt.Errorf("Mongo client is not initialized")
+	}
+	coll := Client.Database(database).Collection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := MongoUpdateOneFunc(coll, ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{"published": true, "published_at": time.Now().UTC()}})
+	return err
+}
+
+func stripeEventDocument(event models.PaymentEvent, raw []byte, receivedAt time.Time) bson.M {
+	return bson.M{
+		"_id":         event.EventID,
+		"type":        event.Type,
+		"event":       event,
+		"payload":     string(raw),
+		"received_at": receivedAt,
+		"published":   false,
+	}
+}
@@ -0,0 +1,179 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/client"
+	"github.com/stripe/stripe-go/v78/paymentintent"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+)
+
+// ErrNotConfigured is returned when no Stripe account serves a region
+var ErrNotConfigured = errors.New("stripe account not configured")
+
+// Intents is the part of the Stripe PaymentIntent API the service uses;
+// *paymentintent.Client implements it
+type Intents interface {
+	New(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	Get(id string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	Confirm(id string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error)
+	Capture(id string, params *stripe.PaymentIntentCaptureParams) (*stripe.PaymentIntent, error)
+	Cancel(id string, params *stripe.PaymentIntentCancelParams) (*stripe.PaymentIntent, error)
+}
+
+// Lister pages through PaymentIntents; *paymentintent.Client implements it
+type Lister interface {
+	List(params *stripe.PaymentIntentListParams) *paymentintent.Iter
+}
+
+// Refunds is the part of the Stripe Refund API the service uses;
+// *refund.Client implements it
+type Refunds interface {
+	New(params *stripe.RefundParams) (*stripe.Refund, error)
+}
+
+// Account holds the Stripe clients of one account
+type Account struct {
+	Intents Intents
+	Refunds Refunds
+	Lister  Lister
+}
+
+// Service routes Stripe calls to the account of a vehicle's region, falling
+// back to the default account for regions without their own key
+type Service struct {
+	accounts map[string]Account
+	fallback *Account
+}
+
+// newClient builds a Stripe API client with its own key (can be mocked in tests)
+var newClient = func(key string, backends *stripe.Backends) *client.API {
+	return client.New(key, backends)
+}
+
+// New builds a Service from stripe_key (default account) and stripe_keys (per region).
+// stripe_api_base, when set, points every client at another API host such as stripefake.
+func New(cfg config.Config) (*Service, error) {
+	backends := apiBackends(cfg.StripeAPIBase)
+	accounts := make(map[string]Account, len(cfg.StripeKeys))
+	for region, key := range cfg.StripeKeys {
+		if key == "" {
+			return nil, fmt.Errorf("empty Stripe key for region %q", region)
+		}
+		accounts[region] = newAccount(newClient(key, backends))
+	}
+	var fallback *Account
+	if cfg.StripeKey != "" {
+		a := newAccount(newClient(cfg.StripeKey, backends))
+		fallback = &a
+	}
+	if fallback == nil && len(accounts) == 0 {
+		return nil, fmt.Errorf("%w: set stripe_key or stripe_keys", ErrNotConfigured)
+	}
+	return NewWithAccounts(accounts, fallback), nil
+}
+
+func newAccount(api *client.API) Account {
+	return Account{Intents: api.PaymentIntents, Refunds: api.Refunds, Lister: api.PaymentIntents}
+}
+
+// NewWithAccounts builds a Service from ready-made accounts keyed by region
+func NewWithAccounts(accounts map[string]Account, fallback *Account) *Service {
+	s := &Service{accounts: make(map[string]Account, len(accounts)), fallback: fallback}
+	for region, a := range accounts {
+		s.accounts[normalizeRegion(region)] = a
+	}
+	return s
+}
+
+// NewWithIntents builds a Service from ready-made PaymentIntent clients keyed by
+// region; its accounts cannot refund
+func NewWithIntents(accounts map[string]Intents, fallback Intents) *Service {
+	full := make(map[string]Account, len(accounts))
+	for region, in := range accounts {
+		full[region] = Account{Intents: in}
+	}
+	var fb *Account
+	if fallback != nil {
+		fb = &Account{Intents: fallback}
+	}
+	return NewWithAccounts(full, fb)
+}
+
+// Account returns the Stripe account for region ("" selects the default account)
+func (s *Service) Account(region string) (Account, error) {
+	if s == nil {
+		return Account{}, ErrNotConfigured
+	}
+	if a, ok := s.accounts[normalizeRegion(region)]; ok {
+		return a, nil
+	}
+	if s.fallback != nil {
+		return *s.fallback, nil
+	}
+	return Account{}, fmt.Errorf("%w for region %q", ErrNotConfigured, region)
+}
+
+// Intents returns the PaymentIntent client for region ("" selects the default account)
+func (s *Service) Intents(region string) (Intents, error) {
+	a, err := s.Account(region)
+	return a.Intents, err
+}
+
+// Refunds returns the Refund client for region ("" selects the default account)
+func (s *Service) Refunds(region string) (Refunds, error) {
+	a, err := s.Account(region)
+	if err == nil && a.Refunds == nil {
+		err = fmt.Errorf("%w: refunds for region %q", ErrNotConfigured, region)
+	}
+	return a.Refunds, err
+}
+
+// Accounts returns every configured account keyed by region, with the
+// default account under ""
+func (s *Service) Accounts() map[string]Account {
+	if s == nil {
+		return nil
+	}
+	out := make(map[string]Account, len(s.accounts)+1)
+	for region, a := range s.accounts {
+		out[region] = a
+	}
+	if s.fallback != nil {
+		out[""] = *s.fallback
+	}
+	return out
+}
+
+// Regions lists the regions with their own Stripe account, sorted
+func (s *Service) Regions() []string {
+	if s == nil {
+		return nil
+	}
+	regions := make([]string, 0, len(s.accounts))
+	for region := range s.accounts {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// apiBackends returns Stripe backends for base, or nil to use api.stripe.com
+func apiBackends(base string) *stripe.Backends {
+	if base == "" {
+		return nil
+	}
+	return &stripe.Backends{
+		API:     stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(base)}),
+		Connect: stripe.GetBackendWithConfig(stripe.ConnectBackend, &stripe.BackendConfig{URL: stripe.String(base)}),
+		Uploads: stripe.GetBackendWithConfig(stripe.UploadsBackend, &stripe.BackendConfig{URL: stripe.String(base)}),
+	}
+}
+
+func normalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
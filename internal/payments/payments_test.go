@@ -0,0 +1,90 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/client"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+)
+
+// useClientKeys records the key of every client New builds
+func useClientKeys(t *testing.T) map[Intents]string {
+	orig := newClient
+	t.Cleanup(func() { newClient = orig })
+	keys := map[Intents]string{}
+	newClient = func(key string, backends *stripe.Backends) *client.API {
+		api := orig(key, backends)
+		keys[api.PaymentIntents] = key
+		return api
+	}
+	return keys
+}
+
+func TestNewRoutesByRegion(t *testing.T) {
+	keys := useClientKeys(t)
+	s, err := New(config.Config{StripeKey: "sk_default", StripeKeys: map[string]string{"us": "sk_us", "CA": "sk_ca"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CA", "US"}, s.Regions())
+
+	for region, want := range map[string]string{"US": "sk_us", " us ": "sk_us", "CA": "sk_ca", "MX": "sk_default", "": "sk_default"} {
+		in, err := s.Intents(region)
+		assert.NoError(t, err, region)
+		assert.Equal(t, want, keys[in], region)
+	}
+}
+
+func TestNewWithoutDefaultAccount(t *testing.T) {
+	useClientKeys(t)
+	s, err := New(config.Config{StripeKeys: map[string]string{"US": "sk_us"}})
+	assert.NoError(t, err)
+
+	_, err = s.Intents("US")
+	assert.NoError(t, err)
+	_, err = s.Intents("CA")
+	assert.True(t, errors.Is(err, ErrNotConfigured))
+	assert.Contains(t, err.Error(), `"CA"`)
+}
+
+func TestNewRejectsMissingKeys(t *testing.T) {
+	_, err := New(config.Config{})
+	assert.True(t, errors.Is(err, ErrNotConfigured))
+
+	_, err = New(config.Config{StripeKey: "sk_default", StripeKeys: map[string]string{"US": ""}})
+	assert.Error(t, err)
+}
+
+func TestNilServiceIsNotConfigured(t *testing.T) {
+	var s *Service
+	_, err := s.Intents("US")
+	assert.Equal(t, ErrNotConfigured, err)
+}
+
+func TestRefundsFollowAccount(t *testing.T) {
+	useClientKeys(t)
+	s, err := New(config.Config{StripeKey: "sk_default", StripeKeys: map[string]string{"CA": "sk_ca"}})
+	assert.NoError(t, err)
+
+	ca, err := s.Refunds("ca")
+	assert.NoError(t, err)
+	def, err := s.Refunds("US")
+	assert.NoError(t, err)
+	assert.NotSame(t, ca, def)
+
+	_, err = NewWithIntents(nil, client.New("sk_test", nil).PaymentIntents).Refunds("US")
+	assert.True(t, errors.Is(err, ErrNotConfigured))
+}
+
+func TestAccountsIncludeDefault(t *testing.T) {
+	keys := useClientKeys(t)
+	s, err := New(config.Config{StripeKey: "sk_default", StripeKeys: map[string]string{"ca": "sk_ca"}})
+	assert.NoError(t, err)
+
+	accounts := s.Accounts()
+	assert.Len(t, accounts, 2)
+	assert.Equal(t, "sk_ca", keys[accounts["CA"].Intents])
+	assert.Equal(t, "sk_default", keys[accounts[""].Intents])
+	assert.NotNil(t, accounts[""].Lister)
+}
@@ -0,0 +1,218 @@
+package pricing
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+const (
+	defaultBase       = 100.0
+	defaultSpread     = 1.0
+	defaultVolatility = 0.5
+	defaultReversion  = 0.1
+	minPrice          = 0.01
+)
+
+// DefaultFeaturePremiums is the value each active feature adds in the feature model
+var DefaultFeaturePremiums = map[string]float64{
+	"safety":              2,
+	"serviceConnect":      1.5,
+	"remote":              4,
+	"digitalKeyRemote":    3,
+	"destinationAssist":   2.5,
+	"navigation":          5,
+	"virtualAssistant":    2,
+	"integratedStreaming": 3.5,
+	"wifi":                3,
+}
+
+// Quote is a bid/ask pair for one vehicle
+type Quote struct {
+	Bid float64
+	Ask float64
+}
+
+// PricingModel produces bid/ask quotes for a vehicle subscription
+type PricingModel interface {
+	Quote(v models.VehicleSubscription, at time.Time) Quote
+}
+
+// NewFromConfig builds the pricing model selected by cfg.Model. A zero seed
+// seeds from the clock; any other seed makes the model reproducible.
+func NewFromConfig(cfg config.PricingConfig) (PricingModel, error) {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	base := orDefault(cfg.Base, defaultBase)
+	spread := orDefault(cfg.Spread, defaultSpread)
+	volatility := orDefault(cfg.Volatility, defaultVolatility)
+
+	switch strings.ToLower(cfg.Model) {
+	case "random_walk", "":
+		return NewRandomWalk(base, volatility, spread, seed), nil
+	case "mean_reverting":
+		m := NewMeanReverting(base, orDefault(cfg.Reversion, defaultReversion), volatility, spread, seed)
+		m.BrandFactors = cfg.BrandFactors
+		m.RegionFactors = cfg.RegionFactors
+		m.GenerationFactors = cfg.GenerationFactors
+		return m, nil
+	case "feature":
+		// Configured premiums override the defaults per feature
+		premiums := maps.Clone(DefaultFeaturePremiums)
+		maps.Copy(premiums, cfg.FeaturePremiums)
+		return &FeatureValue{Base: base, Spread: spread, Premiums: premiums}, nil
+	default:
+		return nil, fmt.Errorf("unknown pricing model %q", cfg.Model)
+	}
+}
+
+// RandomWalk moves each VIN's price by a normally distributed step per quote
+type RandomWalk struct {
+	Start      float64
+	Volatility float64
+	Spread     float64
+
+	mu   sync.Mutex
+	rng  *rand.Rand
+	last map[string]float64
+}
+
+// NewRandomWalk creates a seeded random walk starting every VIN at start
+func NewRandomWalk(start, volatility, spread float64, seed int64) *RandomWalk {
+	return &RandomWalk{
+		Start:      start,
+		Volatility: volatility,
+		Spread:     spread,
+		rng:        rand.New(rand.NewSource(seed)),
+		last:       make(map[string]float64),
+	}
+}
+
+// Quote advances the walk for v.Vin by one step
+func (m *RandomWalk) Quote(v models.VehicleSubscription, at time.Time) Quote {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	price, ok := m.last[v.Vin]
+	if !ok {
+		price = m.Start
+	}
+	price = math.Max(minPrice, price+m.rng.NormFloat64()*m.Volatility)
+	m.last[v.Vin] = price
+	return newQuote(price, m.Spread)
+}
+
+// MeanReverting is an Ornstein-Uhlenbeck process pulling each VIN towards a
+// target of Base scaled by its brand, region and generation factors.
+type MeanReverting struct {
+	Base              float64
+	Reversion         float64
+	Volatility        float64
+	Spread            float64
+	BrandFactors      map[string]float64
+	RegionFactors     map[string]float64
+	GenerationFactors map[string]float64
+
+	mu   sync.Mutex
+	rng  *rand.Rand
+	last map[string]float64
+}
+
+// NewMeanReverting creates a seeded mean-reverting model with no factors set
+func NewMeanReverting(base, reversion, volatility, spread float64, seed int64) *MeanReverting {
+	return &MeanReverting{
+		Base:       base,
+		Reversion:  reversion,
+		Volatility: volatility,
+		Spread:     spread,
+		rng:        rand.New(rand.NewSource(seed)),
+		last:       make(map[string]float64),
+	}
+}
+
+// Target is the long-run price for v
+func (m *MeanReverting) Target(v models.VehicleSubscription) float64 {
+	return m.Base * factor(m.BrandFactors, v.Brand) * factor(m.RegionFactors, v.Region) * factor(m.GenerationFactors, v.Generation)
+}
+
+// Quote moves v.Vin's price a step towards its target plus noise
+func (m *MeanReverting) Quote(v models.VehicleSubscription, at time.Time) Quote {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := m.Target(v)
+	price, ok := m.last[v.Vin]
+	if !ok {
+		price = target
+	}
+	price += m.Reversion*(target-price) + m.rng.NormFloat64()*m.Volatility
+	price = math.Max(minPrice, price)
+	m.last[v.Vin] = price
+	return newQuote(price, m.Spread)
+}
+
+// FeatureValue prices a vehicle as Base plus a premium per active feature.
+// It is deterministic: the same subscription always gets the same quote.
+type FeatureValue struct {
+	Base     float64
+	Spread   float64
+	Premiums map[string]float64
+}
+
+// Quote sums the premiums of v's active features
+func (m *FeatureValue) Quote(v models.VehicleSubscription, at time.Time) Quote {
+	price := m.Base
+	for name, active := range ActiveFeatures(v) {
+		if active {
+			price += m.Premiums[name]
+		}
+	}
+	return newQuote(math.Max(minPrice, price), m.Spread)
+}
+
+// ActiveFeatures maps feature names (as used in FeaturePremiums) to their flag on v
+func ActiveFeatures(v models.VehicleSubscription) map[string]bool {
+	return map[string]bool{
+		"safety":              v.IsSafetyActive,
+		"serviceConnect":      v.IsServiceConnectActive,
+		"remote":              v.IsRemoteActive,
+		"digitalKeyRemote":    v.IsDigitalKeyRemoteActive,
+		"destinationAssist":   v.IsDestinationAssistActive,
+		"navigation":          v.IsNavigationActive,
+		"virtualAssistant":    v.IsVirtualAssistantActive,
+		"integratedStreaming": v.IsIntegratedStreamingActive,
+		"wifi":                v.IsWifiActive,
+	}
+}
+
+func newQuote(bid, spread float64) Quote {
+	return Quote{Bid: round2(bid), Ask: round2(bid + spread)}
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+func factor(factors map[string]float64, key string) float64 {
+	if f, ok := factors[key]; ok && f > 0 {
+		return f
+	}
+	return 1
+}
+
+// orDefault returns *v, or def when v is not configured
+func orDefault(v *float64, def float64) float64 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
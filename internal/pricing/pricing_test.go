@@ -0,0 +1,143 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+var testTime = time.Date(2025, 8, 24, 10, 0, 0, 0, time.UTC)
+
+func quotes(m PricingModel, v models.VehicleSubscription, n int) []Quote {
+	var out []Quote
+	for i := 0; i < n; i++ {
+		out = append(out, m.Quote(v, testTime.Add(time.Duration(i)*time.Second)))
+	}
+	return out
+}
+
+func TestRandomWalkIsReproducible(t *testing.T) {
+	v := models.VehicleSubscription{Vin: "VIN1"}
+	a := quotes(NewRandomWalk(100, 0.5, 1, 42), v, 20)
+	b := quotes(NewRandomWalk(100, 0.5, 1, 42), v, 20)
+	c := quotes(NewRandomWalk(100, 0.5, 1, 43), v, 20)
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+
+	for _, q := range a {
+		assert.InDelta(t, 1.0, q.Ask-q.Bid, 0.011)
+		assert.Greater(t, q.Bid, 0.0)
+	}
+}
+
+func TestRandomWalkTracksVINsSeparately(t *testing.T) {
+	m := NewRandomWalk(100, 0, 1, 1)
+	m.last["VIN2"] = 50
+	assert.Equal(t, Quote{Bid: 100, Ask: 101}, m.Quote(models.VehicleSubscription{Vin: "VIN1"}, testTime))
+	assert.Equal(t, Quote{Bid: 50, Ask: 51}, m.Quote(models.VehicleSubscription{Vin: "VIN2"}, testTime))
+}
+
+func TestRandomWalkNeverGoesNonPositive(t *testing.T) {
+	m := NewRandomWalk(0.01, 50, 0.5, 7)
+	for _, q := range quotes(m, models.VehicleSubscription{Vin: "VIN1"}, 100) {
+		assert.GreaterOrEqual(t, q.Bid, minPrice)
+	}
+}
+
+func TestMeanRevertingTarget(t *testing.T) {
+	m := NewMeanReverting(100, 0.5, 0, 1, 1)
+	m.BrandFactors = map[string]float64{"LEXUS": 1.5}
+	m.RegionFactors = map[string]float64{"CA": 0.9}
+	m.GenerationFactors = map[string]float64{"21MM": 1.1, "17CY": 0}
+
+	assert.InDelta(t, 148.5, m.Target(models.VehicleSubscription{Brand: "LEXUS", Region: "CA", Generation: "21MM"}), 1e-9)
+	assert.InDelta(t, 100.0, m.Target(models.VehicleSubscription{Brand: "TOYOTA", Generation: "17CY"}), 1e-9)
+}
+
+func TestMeanRevertingConvergesToTarget(t *testing.T) {
+	m := NewMeanReverting(100, 0.5, 0, 1, 1)
+	m.BrandFactors = map[string]float64{"LEXUS": 2}
+	v := models.VehicleSubscription{Vin: "VIN1", Brand: "LEXUS"}
+
+	// Start the VIN far from its target and let it revert without noise
+	m.last["VIN1"] = 100
+	q := m.Quote(v, testTime)
+	assert.Equal(t, 150.0, q.Bid)
+	qs := quotes(m, v, 30)
+	q = qs[len(qs)-1]
+	assert.InDelta(t, 200.0, q.Bid, 0.01)
+	assert.InDelta(t, 201.0, q.Ask, 0.01)
+}
+
+func TestMeanRevertingIsReproducible(t *testing.T) {
+	v := models.VehicleSubscription{Vin: "VIN1", Region: "US"}
+	a := quotes(NewMeanReverting(100, 0.1, 0.5, 1, 9), v, 20)
+	b := quotes(NewMeanReverting(100, 0.1, 0.5, 1, 9), v, 20)
+	assert.Equal(t, a, b)
+}
+
+func TestFeatureValue(t *testing.T) {
+	m := &FeatureValue{Base: 100, Spread: 0.5, Premiums: map[string]float64{"navigation": 5, "wifi": 3, "remote": 4}}
+
+	assert.Equal(t, Quote{Bid: 100, Ask: 100.5}, m.Quote(models.VehicleSubscription{}, testTime))
+	assert.Equal(t, Quote{Bid: 108, Ask: 108.5}, m.Quote(models.VehicleSubscription{IsNavigationActive: true, IsWifiActive: true}, testTime))
+	// Features without a configured premium add nothing
+	assert.Equal(t, Quote{Bid: 104, Ask: 104.5}, m.Quote(models.VehicleSubscription{IsRemoteActive: true, IsSafetyActive: true}, testTime))
+}
+
+func TestActiveFeatures(t *testing.T) {
+	flags := ActiveFeatures(models.VehicleSubscription{IsNavigationActive: true, IsIntegratedStreamingActive: true})
+	assert.Len(t, flags, len(DefaultFeaturePremiums))
+	assert.True(t, flags["navigation"])
+	assert.True(t, flags["integratedStreaming"])
+	assert.False(t, flags["wifi"])
+}
+
+func TestNewFromConfig(t *testing.T) {
+	m, err := NewFromConfig(config.PricingConfig{})
+	assert.NoError(t, err)
+	rw := m.(*RandomWalk)
+	assert.Equal(t, defaultBase, rw.Start)
+	assert.Equal(t, defaultSpread, rw.Spread)
+	assert.Equal(t, defaultVolatility, rw.Volatility)
+
+	m, err = NewFromConfig(config.PricingConfig{Model: "Mean_Reverting", Seed: 3, Base: float(80), RegionFactors: map[string]float64{"CA": 0.9}})
+	assert.NoError(t, err)
+	mr := m.(*MeanReverting)
+	assert.Equal(t, 80.0, mr.Base)
+	assert.Equal(t, defaultReversion, mr.Reversion)
+	assert.InDelta(t, 72.0, mr.Target(models.VehicleSubscription{Region: "CA"}), 1e-9)
+
+	m, err = NewFromConfig(config.PricingConfig{Model: "feature"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultFeaturePremiums, m.(*FeatureValue).Premiums)
+
+	// Premiums override the defaults per feature; zero spread and volatility are kept
+	m, err = NewFromConfig(config.PricingConfig{Model: "feature", Spread: float(0), FeaturePremiums: map[string]float64{"wifi": 10, "safety": 0}})
+	assert.NoError(t, err)
+	fv := m.(*FeatureValue)
+	assert.Equal(t, 0.0, fv.Spread)
+	assert.Equal(t, 10.0, fv.Premiums["wifi"])
+	assert.Equal(t, 0.0, fv.Premiums["safety"])
+	assert.Equal(t, DefaultFeaturePremiums["navigation"], fv.Premiums["navigation"])
+	assert.Equal(t, 2.0, DefaultFeaturePremiums["safety"])
+
+	m, err = NewFromConfig(config.PricingConfig{Volatility: float(0)})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, m.(*RandomWalk).Volatility)
+
+	_, err = NewFromConfig(config.PricingConfig{Model: "black_scholes"})
+	assert.Error(t, err)
+}
+
+func TestNewFromConfigSeedIsDeterministic(t *testing.T) {
+	v := models.VehicleSubscription{Vin: "VIN1"}
+	a, _ := NewFromConfig(config.PricingConfig{Model: "random_walk", Seed: 99})
+	b, _ := NewFromConfig(config.PricingConfig{Model: "random_walk", Seed: 99})
+	assert.Equal(t, quotes(a, v, 10), quotes(b, v, 10))
+}
+
+func float(v float64) *float64 { return &v }
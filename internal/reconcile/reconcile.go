@@ -0,0 +1,303 @@
+// Package reconcile compares the PaymentIntents Stripe holds for a date range
+// with the payment ledger and reports where they disagree.
+package reconcile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+)
+
+// Discrepancy kinds
+const (
+	MissingLocally  = "missing_locally"
+	MissingInStripe = "missing_in_stripe"
+	AmountMismatch  = "amount_mismatch"
+	StatusMismatch  = "status_mismatch"
+)
+
+// Discrepancy is one PaymentIntent on which Stripe and the ledger disagree.
+// Stripe fields are empty for missing_in_stripe, local fields for missing_locally.
+type Discrepancy struct {
+	Kind                 string `json:"kind"`
+	PaymentIntentID      string `json:"payment_intent_id"`
+	Region               string `json:"region,omitempty"`
+	VIN                  string `json:"vin,omitempty"`
+	Currency             string `json:"currency"`
+	StripeStatus         string `json:"stripe_status,omitempty"`
+	LocalStatus          string `json:"local_status,omitempty"`
+	StripeAmount         int64  `json:"stripe_amount"`
+	LocalAmount          int64  `json:"local_amount"`
+	StripeAmountReceived int64  `json:"stripe_amount_received"`
+	LocalAmountReceived  int64  `json:"local_amount_received"`
+}
+
+// Report is the outcome of reconciling PaymentIntents created in [From, To).
+// Unfunded counts Stripe-only intents that never held money, see unfunded.
+type Report struct {
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	StripeIntents int           `json:"stripe_intents"`
+	LocalIntents  int           `json:"local_intents"`
+	Matched       int           `json:"matched"`
+	Unfunded      int           `json:"unfunded"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// csvHeader names the columns written by WriteCSV
+var csvHeader = []string{
+	"kind", "payment_intent_id", "region", "vin", "currency",
+	"stripe_status", "local_status", "stripe_amount", "local_amount",
+	"stripe_amount_received", "local_amount_received",
+}
+
+// localIntent is the state of a PaymentIntent according to the ledger
+type localIntent struct {
+	id, region, vin, currency, status string
+	amount, received                  int64
+	captured                          bool
+	held                              time.Time
+}
+
+// Run lists the PaymentIntents created in [from, to) on every Stripe account
+// of svc and compares them with the ledger in database/collection.
+func Run(svc *payments.Service, database, collection string, from, to time.Time) (*Report, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("empty reconciliation range %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	remote, err := listStripe(svc, from, to)
+	if err != nil {
+		return nil, err
+	}
+	// Entries after the range still count: a hold made yesterday may be captured today
+	entries, err := mongo.FindLedgerEntries(database, collection, mongo.LedgerQuery{From: from})
+	if err != nil {
+		return nil, fmt.Errorf("loading ledger: %w", err)
+	}
+	local := localIntents(entries)
+
+	report := &Report{From: from, To: to, StripeIntents: len(remote), Discrepancies: []Discrepancy{}}
+	for id, pi := range remote {
+		l, ok := local[id]
+		if !ok && unfunded(pi) {
+			report.Unfunded++
+			continue
+		}
+		if !ok {
+			report.Discrepancies = append(report.Discrepancies, stripeOnly(pi))
+			continue
+		}
+		report.add(compare(pi, l))
+	}
+	for id, l := range local {
+		if l.held.IsZero() || l.held.Before(from) || !l.held.Before(to) {
+			continue
+		}
+		report.LocalIntents++
+		if _, ok := remote[id]; ok {
+			continue
+		}
+		pi, err := getIntent(svc, l)
+		if err != nil {
+			return nil, err
+		}
+		if pi == nil {
+			report.Discrepancies = append(report.Discrepancies, localOnly(l))
+			continue
+		}
+		report.add(compare(pi, l))
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		a, b := report.Discrepancies[i], report.Discrepancies[j]
+		if a.PaymentIntentID != b.PaymentIntentID {
+			return a.PaymentIntentID < b.PaymentIntentID
+		}
+		return a.Kind < b.Kind
+	})
+	return report, nil
+}
+
+// add records the discrepancies found for one PaymentIntent, or a match
+func (r *Report) add(found []Discrepancy) {
+	if len(found) == 0 {
+		r.Matched++
+	}
+	r.Discrepancies = append(r.Discrepancies, found...)
+}
+
+// listStripe returns the PaymentIntents created in [from, to) keyed by ID.
+// Regions sharing a key with another account are listed once per account.
+func listStripe(svc *payments.Service, from, to time.Time) (map[string]*stripe.PaymentIntent, error) {
+	out := map[string]*stripe.PaymentIntent{}
+	for region, account := range svc.Accounts() {
+		if account.Lister == nil {
+			return nil, fmt.Errorf("%w: listing for region %q", payments.ErrNotConfigured, region)
+		}
+		params := &stripe.PaymentIntentListParams{
+			CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: from.Unix(), LesserThan: to.Unix()},
+		}
+		params.Limit = stripe.Int64(100)
+		it := account.Lister.List(params)
+		for it.Next() {
+			pi := it.PaymentIntent()
+			out[pi.ID] = pi
+		}
+		if err := it.Err(); err != nil {
+			return nil, fmt.Errorf("listing PaymentIntents for region %q: %w", region, err)
+		}
+	}
+	return out, nil
+}
+
+// unfunded reports whether pi never held or moved money: it was declined or
+// never confirmed, or canceled after a failed payment. Older ledgers did not
+// record declined holds, so these are not reported as missing locally.
+func unfunded(pi *stripe.PaymentIntent) bool {
+	switch pi.Status {
+	case stripe.PaymentIntentStatusRequiresPaymentMethod,
+		stripe.PaymentIntentStatusRequiresConfirmation,
+		stripe.PaymentIntentStatusRequiresAction:
+		return true
+	case stripe.PaymentIntentStatusCanceled:
+		return pi.AmountReceived == 0 && pi.LastPaymentError != nil
+	}
+	return false
+}
+
+// getIntent fetches a PaymentIntent Stripe did not list; nil means Stripe does not know it
+func getIntent(svc *payments.Service, l *localIntent) (*stripe.PaymentIntent, error) {
+	intents, err := svc.Intents(l.region)
+	if err != nil {
+		return nil, err
+	}
+	pi, err := intents.Get(l.id, nil)
+	var serr *stripe.Error
+	if errors.As(err, &serr) && (serr.Code == stripe.ErrorCodeResourceMissing || serr.HTTPStatusCode == http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("retrieving PaymentIntent %s: %w", l.id, err)
+	}
+	return pi, nil
+}
+
+// localIntents folds ledger entries, oldest first, into the state of each PaymentIntent
+func localIntents(entries []models.LedgerEntry) map[string]*localIntent {
+	out := map[string]*localIntent{}
+	for _, e := range entries {
+		l, ok := out[e.PaymentIntentID]
+		if !ok {
+			l = &localIntent{id: e.PaymentIntentID}
+			out[e.PaymentIntentID] = l
+		}
+		if e.Region != "" {
+			l.region = e.Region
+		}
+		if e.VIN != "" {
+			l.vin = e.VIN
+		}
+		if e.Currency != "" {
+			l.currency = e.Currency
+		}
+		switch e.Type {
+		case models.LedgerRefund:
+			// Refund entries carry the refund's status, not the PaymentIntent's
+			continue
+		case models.LedgerHold:
+			l.amount, l.held = e.Amount, e.CreatedAt
+		case models.LedgerCapture:
+			l.received, l.captured = e.Amount, true
+		case models.LedgerWebhook:
+			if l.amount == 0 {
+				l.amount = e.Amount
+			}
+		}
+		l.status = e.Status
+	}
+	return out
+}
+
+// compare returns the amount and status discrepancies between pi and l
+func compare(pi *stripe.PaymentIntent, l *localIntent) []Discrepancy {
+	var out []Discrepancy
+	amountDiffers := l.amount != 0 && l.amount != pi.Amount
+	if amountDiffers || (l.captured && l.received != pi.AmountReceived) {
+		out = append(out, both(AmountMismatch, pi, l))
+	}
+	if l.status != "" && l.status != string(pi.Status) {
+		out = append(out, both(StatusMismatch, pi, l))
+	}
+	return out
+}
+
+func both(kind string, pi *stripe.PaymentIntent, l *localIntent) Discrepancy {
+	d := stripeOnly(pi)
+	d.Kind = kind
+	d.LocalStatus, d.LocalAmount, d.LocalAmountReceived = l.status, l.amount, l.received
+	return d
+}
+
+func stripeOnly(pi *stripe.PaymentIntent) Discrepancy {
+	return Discrepancy{
+		Kind:                 MissingLocally,
+		PaymentIntentID:      pi.ID,
+		Region:               pi.Metadata["region"],
+		VIN:                  pi.Metadata["vin"],
+		Currency:             string(pi.Currency),
+		StripeStatus:         string(pi.Status),
+		StripeAmount:         pi.Amount,
+		StripeAmountReceived: pi.AmountReceived,
+	}
+}
+
+func localOnly(l *localIntent) Discrepancy {
+	return Discrepancy{
+		Kind:                MissingInStripe,
+		PaymentIntentID:     l.id,
+		Region:              l.region,
+		VIN:                 l.vin,
+		Currency:            l.currency,
+		LocalStatus:         l.status,
+		LocalAmount:         l.amount,
+		LocalAmountReceived: l.received,
+	}
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per discrepancy under a header row
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, d := range r.Discrepancies {
+		row := []string{
+			d.Kind, d.PaymentIntentID, d.Region, d.VIN, d.Currency,
+			d.StripeStatus, d.LocalStatus,
+			strconv.FormatInt(d.StripeAmount, 10), strconv.FormatInt(d.LocalAmount, 10),
+			strconv.FormatInt(d.StripeAmountReceived, 10), strconv.FormatInt(d.LocalAmountReceived, 10),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
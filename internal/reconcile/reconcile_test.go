@@ -0,0 +1,218 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/client"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/stripefake"
+)
+
+var day = time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+
+// fixture is a fake Stripe server with a default and a CA account, and the ledger Run reads
+type fixture struct {
+	t      *testing.T
+	srv    *stripefake.Server
+	svc    *payments.Service
+	ledger []models.LedgerEntry
+	query  mongo.LedgerQuery
+}
+
+func newFixture(t *testing.T) *fixture {
+	srv := stripefake.NewServer()
+	t.Cleanup(srv.Close)
+	svc, err := payments.New(config.Config{
+		StripeKey:     "sk_test_default",
+		StripeKeys:    map[string]string{"CA": "sk_test_ca"},
+		StripeAPIBase: srv.URL,
+	})
+	assert.NoError(t, err)
+
+	f := &fixture{t: t, srv: srv, svc: svc}
+	orig := mongo.FindLedgerEntries
+	t.Cleanup(func() { mongo.FindLedgerEntries = orig })
+	mongo.FindLedgerEntries = func(database, collection string, q mongo.LedgerQuery) ([]models.LedgerEntry, error) {
+		assert.Equal(t, "payment_ledger", collection)
+		f.query = q
+		return f.ledger, nil
+	}
+	return f
+}
+
+// client returns a Stripe client for the fake account of key
+func (f *fixture) client(key string) *client.API {
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(f.srv.URL), MaxNetworkRetries: stripe.Int64(0)})
+	return client.New(key, &stripe.Backends{API: backend})
+}
+
+// hold creates a confirmed manual-capture PaymentIntent on the account of key at the given time
+func (f *fixture) hold(key, region string, amount int64, at time.Time) *stripe.PaymentIntent {
+	f.srv.SetClock(func() time.Time { return at })
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String("usd"),
+		PaymentMethod: stripe.String("pm_card_visa"),
+		CaptureMethod: stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		Confirm:       stripe.Bool(true),
+	}
+	params.AddMetadata("vin", "VIN1")
+	params.AddMetadata("region", region)
+	pi, err := f.client(key).PaymentIntents.New(params)
+	assert.NoError(f.t, err)
+	return pi
+}
+
+// record adds a ledger entry for a PaymentIntent
+func (f *fixture) record(entryType, id, region, status string, amount int64, at time.Time) {
+	f.ledger = append(f.ledger, models.LedgerEntry{
+		ID:              entryType + ":" + id,
+		Type:            entryType,
+		PaymentIntentID: id,
+		Status:          status,
+		Amount:          amount,
+		Currency:        "usd",
+		VIN:             "VIN1",
+		Region:          region,
+		CreatedAt:       at,
+	})
+}
+
+// decline attempts a hold that Stripe declines and returns the PaymentIntent it keeps
+func (f *fixture) decline(key string, at time.Time) *stripe.PaymentIntent {
+	f.srv.SetClock(func() time.Time { return at })
+	_, err := f.client(key).PaymentIntents.New(&stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(5000),
+		Currency:      stripe.String("usd"),
+		PaymentMethod: stripe.String(stripefake.PaymentMethodDeclined),
+		CaptureMethod: stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		Confirm:       stripe.Bool(true),
+	})
+	var serr *stripe.Error
+	assert.ErrorAs(f.t, err, &serr)
+	return serr.PaymentIntent
+}
+
+// capture captures a PaymentIntent directly in Stripe, bypassing the ledger
+func (f *fixture) capture(key, id string, amount int64) {
+	_, err := f.client(key).PaymentIntents.Capture(id, &stripe.PaymentIntentCaptureParams{AmountToCapture: stripe.Int64(amount)})
+	assert.NoError(f.t, err)
+}
+
+func TestRunFindsDiscrepancies(t *testing.T) {
+	f := newFixture(t)
+	at := day.Add(10 * time.Hour)
+
+	matched := f.hold("sk_test_default", "US", 5000, at)
+	f.record(models.LedgerHold, matched.ID, "US", "requires_capture", 5000, at)
+
+	// Captured the next day, for a different amount than Stripe took
+	captured := f.hold("sk_test_default", "US", 5000, at)
+	f.record(models.LedgerHold, captured.ID, "US", "requires_capture", 5000, at)
+	f.record(models.LedgerCapture, captured.ID, "US", "succeeded", 4000, day.Add(30*time.Hour))
+	f.record(models.LedgerRefund, captured.ID, "US", "succeeded", 1000, day.Add(31*time.Hour))
+	f.capture("sk_test_default", captured.ID, 4500)
+
+	// Captured in Stripe without the service knowing
+	stale := f.hold("sk_test_ca", "CA", 7000, at)
+	f.record(models.LedgerHold, stale.ID, "CA", "requires_capture", 7000, at)
+	f.capture("sk_test_ca", stale.ID, 7000)
+
+	unrecorded := f.hold("sk_test_ca", "CA", 7000, at)
+	f.record(models.LedgerHold, "pi_unknown", "CA", "requires_capture", 3000, at)
+
+	// Outside the day on both sides
+	f.hold("sk_test_default", "US", 5000, day.Add(-time.Minute))
+	f.hold("sk_test_default", "US", 5000, day.Add(24*time.Hour))
+	f.record(models.LedgerHold, "pi_tomorrow", "US", "requires_capture", 5000, day.Add(25*time.Hour))
+
+	// Declined holds: one recorded, one unrecorded and one canceled afterwards
+	declined := f.decline("sk_test_default", at)
+	f.record(models.LedgerHold, declined.ID, "", "requires_payment_method", 5000, at)
+	f.decline("sk_test_default", at)
+	abandoned := f.decline("sk_test_ca", at)
+	_, err := f.client("sk_test_ca").PaymentIntents.Cancel(abandoned.ID, nil)
+	assert.NoError(t, err)
+
+	report, err := Run(f.svc, "vehicles", "payment_ledger", day, day.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, mongo.LedgerQuery{From: day}, f.query)
+	assert.Equal(t, 7, report.StripeIntents)
+	assert.Equal(t, 5, report.LocalIntents)
+	assert.Equal(t, 2, report.Matched)
+	assert.Equal(t, 2, report.Unfunded)
+
+	byID := map[string]Discrepancy{}
+	for _, d := range report.Discrepancies {
+		byID[d.PaymentIntentID] = d
+	}
+	assert.Len(t, report.Discrepancies, 4)
+	assert.Equal(t, Discrepancy{
+		Kind: AmountMismatch, PaymentIntentID: captured.ID, Region: "US", VIN: "VIN1", Currency: "usd",
+		StripeStatus: "succeeded", LocalStatus: "succeeded", StripeAmount: 5000, LocalAmount: 5000,
+		StripeAmountReceived: 4500, LocalAmountReceived: 4000,
+	}, byID[captured.ID])
+	assert.Equal(t, Discrepancy{
+		Kind: StatusMismatch, PaymentIntentID: stale.ID, Region: "CA", VIN: "VIN1", Currency: "usd",
+		StripeStatus: "succeeded", LocalStatus: "requires_capture", StripeAmount: 7000, LocalAmount: 7000,
+		StripeAmountReceived: 7000,
+	}, byID[stale.ID])
+	assert.Equal(t, Discrepancy{
+		Kind: MissingLocally, PaymentIntentID: unrecorded.ID, Region: "CA", VIN: "VIN1", Currency: "usd",
+		StripeStatus: "requires_capture", StripeAmount: 7000,
+	}, byID[unrecorded.ID])
+	assert.Equal(t, Discrepancy{
+		Kind: MissingInStripe, PaymentIntentID: "pi_unknown", Region: "CA", VIN: "VIN1", Currency: "usd",
+		LocalStatus: "requires_capture", LocalAmount: 3000,
+	}, byID["pi_unknown"])
+}
+
+func TestRunStopsOnErrors(t *testing.T) {
+	f := newFixture(t)
+
+	_, err := Run(f.svc, "vehicles", "payment_ledger", day, day)
+	assert.Error(t, err)
+
+	f.srv.FailNext(500, "api_error", "", "boom")
+	_, err = Run(f.svc, "vehicles", "payment_ledger", day, day.Add(24*time.Hour))
+	assert.Error(t, err)
+
+	mongo.FindLedgerEntries = func(database, collection string, q mongo.LedgerQuery) ([]models.LedgerEntry, error) {
+		return nil, errors.New("mongo down")
+	}
+	_, err = Run(f.svc, "vehicles", "payment_ledger", day, day.Add(24*time.Hour))
+	assert.ErrorContains(t, err, "mongo down")
+}
+
+func TestReportOutputs(t *testing.T) {
+	report := &Report{From: day, To: day.Add(24 * time.Hour), StripeIntents: 1, Discrepancies: []Discrepancy{
+		{Kind: MissingLocally, PaymentIntentID: "pi_1", Region: "US", VIN: "VIN1", Currency: "usd", StripeStatus: "requires_capture", StripeAmount: 5000},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.WriteCSV(&buf))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		csvHeader,
+		{"missing_locally", "pi_1", "US", "VIN1", "usd", "requires_capture", "", "5000", "0", "0", "0"},
+	}, rows)
+
+	buf.Reset()
+	assert.NoError(t, report.WriteJSON(&buf))
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, "2025-08-01T00:00:00Z", decoded["from"])
+	assert.Equal(t, float64(1), decoded["stripe_intents"])
+	assert.Equal(t, "pi_1", decoded["discrepancies"].([]interface{})[0].(map[string]interface{})["payment_intent_id"])
+}
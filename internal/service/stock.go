@@ -1,15 +1,16 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
-	"github.com/yourusername/vehicle-stock-service/internal/config"
-	"github.com/yourusername/vehicle-stock-service/internal/kafka"
 	"github.com/yourusername/vehicle-stock-service/internal/models"
 	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/pricing"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
 )
 
 // KafkaPublisher abstracts Publish method for Kafka producer
@@ -18,30 +19,53 @@ type KafkaPublisher interface {
 	Close()
 }
 
-// SendStockDataFromVehicles generates stock data for all active subscriptions
-func SendStockDataFromVehicles(jsonInput string, prod KafkaPublisher) {
-	var data models.VehicleResponse
-	if err := json.Unmarshal([]byte(jsonInput), &data); err != nil {
-		log.Println("Error parsing vehicle JSON:", err)
-		return
-	}
+// StoreTimeout bounds storing one generated tick, including the wait for room
+// in a full batch writer
+var StoreTimeout = 5 * time.Second
 
-	for _, v := range data.Payload.VehicleSubscriptions {
+// StockGenerator quotes ticks for subscribed vehicles, publishes them to Kafka
+// and stores them in its repository
+type StockGenerator struct {
+	stocks  mongo.StockRepository
+	pricing pricing.PricingModel
+	onTick  func(tick models.StockData)
+}
+
+// NewStockGenerator returns a StockGenerator that quotes bid/ask with model and
+// stores ticks in stocks; a nil repository only publishes them. onTick, when
+// set, is called with every generated tick, e.g. to feed the WebSocket hub.
+func NewStockGenerator(stocks mongo.StockRepository, model pricing.PricingModel, onTick func(tick models.StockData)) *StockGenerator {
+	return &StockGenerator{stocks: stocks, pricing: model, onTick: onTick}
+}
+
+// SendStockDataForSubscriptions generates stock data for the active subscriptions in subs
+func (g *StockGenerator) SendStockDataForSubscriptions(subs []models.VehicleSubscription, prod KafkaPublisher) {
+	for _, v := range subs {
 		if v.ActivePaidSubscriptions {
+			now := time.Now().UTC()
+			quote := g.pricing.Quote(v, now)
+			ticker := fmt.Sprintf("VEHICLE-%s", v.Vin)
 			stock := models.StockData{
-				Ticker: fmt.Sprintf("VEHICLE-%s", v.Vin),
-				Bid:    100.0 + float64(time.Now().Second())*0.1,
-				Ask:    101.0 + float64(time.Now().Second())*0.1,
-				Time:   time.Now().Format(time.RFC3339),
+				Ticker: ticker,
+				Bid:    quote.Bid,
+				Ask:    quote.Ask,
+				Time:   now,
+				Meta:   models.NewStockMeta(ticker, v.Region),
 			}
 
 			value, _ := json.Marshal(stock)
 			prod.Publish(stock.Ticker, value)
 			log.Println("Stock sent to Kafka:", stock)
+			if g.onTick != nil {
+				g.onTick(stock)
+			}
 
-			if mongo.Client != nil {
-				if err := mongo.InsertData(config.AppConfig.MongoDB, config.AppConfig.MongoColl, stock); err != nil {
-					log.Println("MongoDB insert failed:", err)
+			if g.stocks != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), StoreTimeout)
+				err := g.stocks.Insert(ctx, stock)
+				cancel()
+				if err != nil {
+					log.Println("Storing stock tick failed:", err)
 				}
 			}
 		}
@@ -65,21 +89,24 @@ func ParseVehicleJSON(jsonInput string) (bool, error) {
 	return active, nil
 }
 
-// StartStockProducerLoop starts sending stock data to Kafka periodically
-func StartStockProducerLoop(jsonInput string, interval time.Duration) {
-	prod, err := kafka.NewProducer(config.AppConfig.KafkaBrokers[0], config.AppConfig.KafkaTopic)
-	if err != nil {
-		log.Println("Kafka producer initialization failed:", err)
-		return
-	}
-
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		defer prod.Close()
+// RunStockProducerLoop publishes stock data for the vehicles returned by src
+// every interval, re-reading the source on each tick. It blocks until ctx is
+// cancelled; closing prod is left to the caller.
+func (g *StockGenerator) RunStockProducerLoop(ctx context.Context, src subscription.SubscriptionSource, prod KafkaPublisher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		for range ticker.C {
-			SendStockDataFromVehicles(jsonInput, prod)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resp, err := src.Fetch(ctx)
+			if err != nil {
+				log.Println("Fetching vehicle subscriptions failed:", err)
+				continue
+			}
+			g.SendStockDataForSubscriptions(resp.Payload.VehicleSubscriptions, prod)
 		}
-	}()
+	}
 }
@@ -1,22 +1,19 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
-	"github.com/yourusername/vehicle-stock-service/internal/config"
 	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/pricing"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
 )
 
-// importConfig sets dummy config values for testing
-func importConfig() {
-	config.AppConfig.KafkaBrokers = []string{"dummy-broker:9092"}
-	config.AppConfig.KafkaTopic = "dummy-topic"
-}
-
 // Mock Kafka Producer
 type MockProducer struct {
 	mock.Mock
@@ -103,153 +100,202 @@ func TestParseVehicleJSON(t *testing.T) {
 	assert.True(t, active)
 }
 
-func TestSendStockDataFromVehicles(t *testing.T) {
-	var mockProd *MockProducer
-	mockProd = &MockProducer{}
+// singleFetchSource serves JSON once and cancels the loop on the next fetch,
+// so RunStockProducerLoop returns after handling exactly one payload
+type singleFetchSource struct {
+	src    subscription.StaticSource
+	cancel context.CancelFunc
+	served bool
+}
+
+func (s *singleFetchSource) Fetch(ctx context.Context) (*models.VehicleResponse, error) {
+	if s.served {
+		s.cancel()
+		return nil, context.Canceled
+	}
+	s.served = true
+	return s.src.Fetch(ctx)
+}
+
+// testPricing quotes every vehicle at the same fixed bid/ask
+var testPricing = &pricing.FeatureValue{Base: 100, Spread: 1}
+
+// runProducerOnce runs the producer loop for one payload and returns the tickers it published
+func runProducerOnce(t *testing.T, payload string) []string {
+	mockProd := &MockProducer{}
 	mockProd.On("Publish", mock.Anything, mock.Anything)
-	var noPayload, emptySubs, extraFields, dupVIN, allInactive, allActive, mixed string
-	// Edge: input with no payload
-	mockProd.Published = nil
-	noPayload = `{"foo":123}`
-	SendStockDataFromVehicles(noPayload, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &singleFetchSource{src: subscription.StaticSource{JSON: payload}, cancel: cancel}
+
+	done := make(chan struct{})
+	go func() {
+		NewStockGenerator(nil, testPricing, nil).RunStockProducerLoop(ctx, src, mockProd, time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("producer loop did not stop")
+	}
+
+	tickers := []string{}
+	for _, stock := range mockProd.Published {
+		tickers = append(tickers, stock.Ticker)
+	}
+	return tickers
+}
 
-	// Edge: input with empty vehicleSubscriptions
-	mockProd.Published = nil
-	emptySubs = `{"payload":{"vehicleSubscriptions":[]}}`
-	SendStockDataFromVehicles(emptySubs, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+func TestRunStockProducerLoopPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+	}{
+		{"no payload", `{"foo":123}`, []string{}},
+		{"empty subscriptions", `{"payload":{"vehicleSubscriptions":[]}}`, []string{}},
+		{"extra fields", `{"payload":{"vehicleSubscriptions":[{"vin":"VINX","activePaidSubscriptions":true,"extra":123}]}}`, []string{"VEHICLE-VINX"}},
+		{"duplicate VINs", `{"payload":{"vehicleSubscriptions":[{"vin":"VINY","activePaidSubscriptions":true},{"vin":"VINY","activePaidSubscriptions":true}]}}`, []string{"VEHICLE-VINY", "VEHICLE-VINY"}},
+		{"all inactive", `{"payload":{"vehicleSubscriptions":[{"vin":"VINZ","activePaidSubscriptions":false}]}}`, []string{}},
+		{"all active", `{"payload":{"vehicleSubscriptions":[{"vin":"VINA","activePaidSubscriptions":true},{"vin":"VINB","activePaidSubscriptions":true}]}}`, []string{"VEHICLE-VINA", "VEHICLE-VINB"}},
+		{"mixed", `{"payload":{"vehicleSubscriptions":[{"vin":"VIN3","activePaidSubscriptions":false},{"vin":"VIN4","activePaidSubscriptions":true}]}}`, []string{"VEHICLE-VIN4"}},
+		{"missing active flag", `{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","activePaidSubscriptions":true},{"vin":"VIN2"}]}}`, []string{"VEHICLE-VIN1"}},
+		{"invalid JSON", `{"payload":{"vehicleSubscriptions":[{"vin":"VIN5"}]}`, []string{}},
+		{"empty input", ``, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, runProducerOnce(t, tt.payload))
+		})
+	}
+}
 
-	// Edge: input with extra fields
-	mockProd.Published = nil
-	extraFields = `{"payload":{"vehicleSubscriptions":[{"vin":"VINX","activePaidSubscriptions":true,"extra":123}]}}`
-	SendStockDataFromVehicles(extraFields, mockProd)
-	assert.Len(t, mockProd.Published, 1)
-	assert.Equal(t, "VEHICLE-VINX", mockProd.Published[0].Ticker)
+func TestSendStockDataForSubscriptions(t *testing.T) {
+	g := NewStockGenerator(nil, testPricing, nil)
+	mockProd := &MockProducer{}
+	mockProd.On("Publish", mock.Anything, mock.Anything)
 
-	// Edge: input with duplicate VINs
-	mockProd.Published = nil
-	dupVIN = `{"payload":{"vehicleSubscriptions":[{"vin":"VINY","activePaidSubscriptions":true},{"vin":"VINY","activePaidSubscriptions":true}]}}`
-	SendStockDataFromVehicles(dupVIN, mockProd)
+	subs := []models.VehicleSubscription{
+		{Vin: "VINA", ActivePaidSubscriptions: true},
+		{Vin: "VINB", ActivePaidSubscriptions: false},
+		{Vin: "VINC", ActivePaidSubscriptions: true},
+	}
+	g.SendStockDataForSubscriptions(subs, mockProd)
 	assert.Len(t, mockProd.Published, 2)
-	assert.Equal(t, "VEHICLE-VINY", mockProd.Published[0].Ticker)
-	assert.Equal(t, "VEHICLE-VINY", mockProd.Published[1].Ticker)
+	assert.Equal(t, "VEHICLE-VINA", mockProd.Published[0].Ticker)
+	assert.Equal(t, "VEHICLE-VINC", mockProd.Published[1].Ticker)
 
-	// Edge: input with all inactive
 	mockProd.Published = nil
-	allInactive = `{"payload":{"vehicleSubscriptions":[{"vin":"VINZ","activePaidSubscriptions":false}]}}`
-	SendStockDataFromVehicles(allInactive, mockProd)
+	g.SendStockDataForSubscriptions(nil, mockProd)
 	assert.Len(t, mockProd.Published, 0)
+}
+
+func TestSendStockDataUsesPricingModel(t *testing.T) {
+	model := &pricing.FeatureValue{Base: 50, Spread: 2, Premiums: map[string]float64{"wifi": 10}}
+
+	mockProd := &MockProducer{}
+	mockProd.On("Publish", mock.Anything, mock.Anything)
+	NewStockGenerator(nil, model, nil).SendStockDataForSubscriptions([]models.VehicleSubscription{
+		{Vin: "VINA", ActivePaidSubscriptions: true, IsWifiActive: true},
+		{Vin: "VINB", ActivePaidSubscriptions: true},
+	}, mockProd)
 
-	// Edge: input with all active
-	mockProd.Published = nil
-	allActive = `{"payload":{"vehicleSubscriptions":[{"vin":"VINA","activePaidSubscriptions":true},{"vin":"VINB","activePaidSubscriptions":true}]}}`
-	SendStockDataFromVehicles(allActive, mockProd)
 	assert.Len(t, mockProd.Published, 2)
-	assert.Equal(t, "VEHICLE-VINA", mockProd.Published[0].Ticker)
-	assert.Equal(t, "VEHICLE-VINB", mockProd.Published[1].Ticker)
+	assert.Equal(t, 60.0, mockProd.Published[0].Bid)
+	assert.Equal(t, 62.0, mockProd.Published[0].Ask)
+	assert.Equal(t, 50.0, mockProd.Published[1].Bid)
+	assert.Equal(t, 52.0, mockProd.Published[1].Ask)
+}
 
-	// Edge: input with mixed valid/invalid vehicles
-	mockProd.Published = nil
-	mixed = `{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","activePaidSubscriptions":true},{"vin":"VIN2"}]}}`
-	SendStockDataFromVehicles(mixed, mockProd)
-	assert.Len(t, mockProd.Published, 1)
-	assert.Equal(t, "VEHICLE-VIN1", mockProd.Published[0].Ticker)
-	// Setup mock producer implementing KafkaPublisher
-	mockProd = &MockProducer{}
+func TestSendStockDataStoresTicks(t *testing.T) {
+	stocks := &mongo.MemoryStockRepository{}
+	var notified []models.StockData
+	onTick := func(tick models.StockData) { notified = append(notified, tick) }
+
+	mockProd := &MockProducer{}
 	mockProd.On("Publish", mock.Anything, mock.Anything)
+	NewStockGenerator(stocks, testPricing, onTick).SendStockDataForSubscriptions([]models.VehicleSubscription{{Vin: "VINA", Region: "CA", ActivePaidSubscriptions: true}}, mockProd)
 
-	// Valid input with active subscription
-	jsonInput := `{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","activePaidSubscriptions":true}]}}`
-	SendStockDataFromVehicles(jsonInput, mockProd)
-	assert.Len(t, mockProd.Published, 1)
-	assert.Equal(t, "VEHICLE-VIN1", mockProd.Published[0].Ticker)
+	stored, err := stocks.Latest(context.Background(), "VEHICLE-VINA")
+	assert.NoError(t, err)
+	assert.Equal(t, mockProd.Published[0], *stored)
+	assert.Equal(t, &models.StockMeta{Ticker: "VEHICLE-VINA", VIN: "VINA", Region: "CA"}, stored.Meta)
+	assert.Equal(t, []models.StockData{*stored}, notified)
+}
 
-	// Valid input with no active subscription
-	mockProd.Published = nil
-	jsonInput = `{"payload":{"vehicleSubscriptions":[{"vin":"VIN2","activePaidSubscriptions":false}]}}`
-	SendStockDataFromVehicles(jsonInput, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+// stalledStocks blocks every Upsert until release is closed
+type stalledStocks struct {
+	mongo.StockRepository
+	release chan struct{}
+}
 
-	// Multiple vehicles, mixed active
-	mockProd.Published = nil
-	jsonInput = `{"payload":{"vehicleSubscriptions":[{"vin":"VIN3","activePaidSubscriptions":false},{"vin":"VIN4","activePaidSubscriptions":true}]}}`
-	SendStockDataFromVehicles(jsonInput, mockProd)
-	assert.Len(t, mockProd.Published, 1)
-	assert.Equal(t, "VEHICLE-VIN4", mockProd.Published[0].Ticker)
+func (s *stalledStocks) Upsert(ctx context.Context, ticks []models.StockData) error {
+	<-s.release
+	return nil
+}
 
-	// Invalid JSON
-	mockProd.Published = nil
-	jsonInput = `{"payload":{"vehicleSubscriptions":[{"vin":"VIN5"}]}`
-	SendStockDataFromVehicles(jsonInput, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+func TestSendStockDataStopsWaitingForSlowStore(t *testing.T) {
+	orig := StoreTimeout
+	StoreTimeout = 20 * time.Millisecond
+	defer func() { StoreTimeout = orig }()
 
-	// Empty input
-	mockProd.Published = nil
-	SendStockDataFromVehicles("", mockProd)
-	assert.Len(t, mockProd.Published, 0)
-	// Edge: input with no payload
-	mockProd.Published = nil
-	noPayload = `{"foo":123}`
-	SendStockDataFromVehicles(noPayload, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+	// One tick is being flushed and one fills the queue, so the last one cannot be queued
+	stalled := &stalledStocks{release: make(chan struct{})}
+	writer := mongo.NewBatchWriter(stalled, mongo.BatchWriterOptions{MaxBatch: 1, QueueSize: 1})
+	defer writer.Close(context.Background())
+	defer close(stalled.release)
 
-	// Edge: input with empty vehicleSubscriptions
-	mockProd.Published = nil
-	emptySubs = `{"payload":{"vehicleSubscriptions":[]}}`
-	SendStockDataFromVehicles(emptySubs, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+	mockProd := &MockProducer{}
+	mockProd.On("Publish", mock.Anything, mock.Anything)
+	subs := []models.VehicleSubscription{
+		{Vin: "VINA", ActivePaidSubscriptions: true},
+		{Vin: "VINB", ActivePaidSubscriptions: true},
+		{Vin: "VINC", ActivePaidSubscriptions: true},
+	}
+	start := time.Now()
+	NewStockGenerator(writer, testPricing, nil).SendStockDataForSubscriptions(subs, mockProd)
+
+	// The producer gives up on the tick instead of stalling with MongoDB
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Len(t, mockProd.Published, 3)
+}
 
-	// Edge: input with extra fields
-	mockProd.Published = nil
-	extraFields = `{"payload":{"vehicleSubscriptions":[{"vin":"VINX","activePaidSubscriptions":true,"extra":123}]}}`
-	SendStockDataFromVehicles(extraFields, mockProd)
-	assert.Len(t, mockProd.Published, 1)
-	assert.Equal(t, "VEHICLE-VINX", mockProd.Published[0].Ticker)
+// chanPublisher reports published keys on a channel so tests can wait for them
+type chanPublisher struct {
+	keys chan string
+}
 
-	// Edge: input with duplicate VINs
-	mockProd.Published = nil
-	dupVIN = `{"payload":{"vehicleSubscriptions":[{"vin":"VINY","activePaidSubscriptions":true},{"vin":"VINY","activePaidSubscriptions":true}]}}`
-	SendStockDataFromVehicles(dupVIN, mockProd)
-	assert.Len(t, mockProd.Published, 2)
-	assert.Equal(t, "VEHICLE-VINY", mockProd.Published[0].Ticker)
-	assert.Equal(t, "VEHICLE-VINY", mockProd.Published[1].Ticker)
+func (p *chanPublisher) Publish(key string, value []byte) {
+	select {
+	case p.keys <- key:
+	default:
+	}
+}
 
-	// Edge: input with all inactive
-	mockProd.Published = nil
-	allInactive = `{"payload":{"vehicleSubscriptions":[{"vin":"VINZ","activePaidSubscriptions":false}]}}`
-	SendStockDataFromVehicles(allInactive, mockProd)
-	assert.Len(t, mockProd.Published, 0)
+func (p *chanPublisher) Close() {}
 
-	// Edge: input with all active
-	mockProd.Published = nil
-	allActive = `{"payload":{"vehicleSubscriptions":[{"vin":"VINA","activePaidSubscriptions":true},{"vin":"VINB","activePaidSubscriptions":true}]}}`
-	SendStockDataFromVehicles(allActive, mockProd)
-	assert.Len(t, mockProd.Published, 2)
-	assert.Equal(t, "VEHICLE-VINA", mockProd.Published[0].Ticker)
-	assert.Equal(t, "VEHICLE-VINB", mockProd.Published[1].Ticker)
+func TestRunStockProducerLoopStopsOnCancel(t *testing.T) {
+	prod := &chanPublisher{keys: make(chan string, 1)}
+	src := &subscription.StaticSource{JSON: `{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","activePaidSubscriptions":true}]}}`}
 
-	// Edge: input with mixed valid/invalid vehicles
-	mockProd.Published = nil
-	mixed = `{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","activePaidSubscriptions":true},{"vin":"VIN2"}]}}`
-	SendStockDataFromVehicles(mixed, mockProd)
-	assert.Len(t, mockProd.Published, 1)
-	assert.Equal(t, "VEHICLE-VIN1", mockProd.Published[0].Ticker)
-}
-
-func TestStartStockProducerLoopInit(t *testing.T) {
-	// This test only checks initialization, not the actual loop
-	// The actual periodic sending is best tested with integration tests or with a timer mock
-	// Here, we just ensure no panic and correct producer creation
-	// Set dummy config values to prevent index out of range
-	importConfig()
-	defer func() {
-		if r := recover(); r != nil {
-			t.Errorf("StartStockProducerLoop panicked: %v", r)
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewStockGenerator(nil, testPricing, nil).RunStockProducerLoop(ctx, src, prod, 10*time.Millisecond)
+		close(done)
 	}()
-	go StartStockProducerLoop(`{"payload":{"vehicleSubscriptions":[]}}`, 1*time.Second)
-	// Allow goroutine to start
-	time.Sleep(100 * time.Millisecond)
-	// No assertion, just ensure no panic
+
+	select {
+	case key := <-prod.keys:
+		assert.Equal(t, "VEHICLE-VIN1", key)
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("producer loop did not stop")
+	}
 }
@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// tickerPrefix precedes the VIN in every stock ticker
+const tickerPrefix = "VEHICLE-"
+
+// maxRetryDelay caps the wait between change stream restarts
+const maxRetryDelay = time.Minute
+
+// Feed publishes the ticks inserted into the stock collection to a Hub. It
+// follows a MongoDB change stream and persists the stream's resume token, so a
+// restarted feed continues after the last tick it handled; ticks between the
+// last saved token and a crash are published again.
+type Feed struct {
+	Hub        *Hub
+	Database   string
+	Collection string // stock collection
+
+	// TokenCollection and Name locate the persisted resume token
+	TokenCollection string
+	Name            string
+
+	// Vehicles resolves the region and brand of each tick's VIN; optional
+	Vehicles subscription.SubscriptionSource
+
+	TokenSaveInterval time.Duration // default 1s
+	VehicleRefresh    time.Duration // default 1m
+	RetryDelay        time.Duration // first wait after a stream failure, default 1s
+
+	token     bson.Raw
+	unsaved   bool
+	savedAt   time.Time
+	vehicles  map[string]models.VehicleSubscription
+	fetchedAt time.Time
+}
+
+// Run follows the change stream until ctx is cancelled, restarting it with
+// growing delays when it fails
+func (f *Feed) Run(ctx context.Context) {
+	token, err := mongo.LoadResumeToken(f.Database, f.TokenCollection, f.Name)
+	if err != nil {
+		log.Println("Loading live feed resume token failed, starting from now:", err)
+	}
+	f.token = token
+
+	delay := durationOr(f.RetryDelay, time.Second)
+	for {
+		started := time.Now()
+		err := mongo.WatchStockTicks(ctx, f.Database, f.Collection, f.token, func(tick models.StockData, token bson.Raw) error {
+			f.publish(ctx, tick)
+			f.token, f.unsaved = token, true
+			if time.Since(f.savedAt) >= durationOr(f.TokenSaveInterval, time.Second) {
+				f.saveToken()
+			}
+			return nil
+		})
+		f.saveToken()
+		if ctx.Err() != nil {
+			return
+		}
+		if mongo.IsChangeStreamHistoryLost(err) {
+			log.Println("Live feed resume token expired, restarting from now:", err)
+			f.token = nil
+		} else {
+			log.Println("Live feed change stream failed:", err)
+		}
+
+		if time.Since(started) > maxRetryDelay {
+			delay = durationOr(f.RetryDelay, time.Second)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// saveToken persists the latest token if it changed since the last save
+func (f *Feed) saveToken() {
+	if !f.unsaved {
+		return
+	}
+	if err := mongo.SaveResumeToken(f.Database, f.TokenCollection, f.Name, f.token); err != nil {
+		log.Println("Saving live feed resume token failed:", err)
+		return
+	}
+	f.unsaved, f.savedAt = false, time.Now()
+}
+
+// publish enriches tick with its vehicle and hands it to the hub
+func (f *Feed) publish(ctx context.Context, tick models.StockData) {
+	vin := strings.TrimPrefix(tick.Ticker, tickerPrefix)
+	u := Update{VIN: vin, Ticker: tick.Ticker, Bid: tick.Bid, Ask: tick.Ask, Time: tick.Time}
+	if v, ok := f.vehicle(ctx, vin); ok {
+		u.Region, u.Brand = v.Region, v.Brand
+	}
+	f.Hub.Publish(u)
+}
+
+// vehicle looks vin up in the subscriptions, refetched at most every VehicleRefresh
+func (f *Feed) vehicle(ctx context.Context, vin string) (models.VehicleSubscription, bool) {
+	if f.Vehicles == nil {
+		return models.VehicleSubscription{}, false
+	}
+	if time.Since(f.fetchedAt) >= durationOr(f.VehicleRefresh, time.Minute) {
+		f.fetchedAt = time.Now()
+		fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		resp, err := f.Vehicles.Fetch(fetchCtx)
+		cancel()
+		if err != nil {
+			log.Println("Fetching vehicle subscriptions for the live feed failed:", err)
+		} else {
+			f.vehicles = make(map[string]models.VehicleSubscription, len(resp.Payload.VehicleSubscriptions))
+			for _, v := range resp.Payload.VehicleSubscriptions {
+				f.vehicles[v.Vin] = v
+			}
+		}
+	}
+	v, ok := f.vehicles[vin]
+	return v, ok
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+func token(n byte) bson.Raw {
+	raw, _ := bson.Marshal(bson.M{"_data": string([]byte{'0' + n})})
+	return raw
+}
+
+func TestFeedPublishesAndResumes(t *testing.T) {
+	origLoad, origSave, origWatch := mongo.LoadResumeToken, mongo.SaveResumeToken, mongo.WatchStockTicks
+	defer func() {
+		mongo.LoadResumeToken, mongo.SaveResumeToken, mongo.WatchStockTicks = origLoad, origSave, origWatch
+	}()
+
+	mongo.LoadResumeToken = func(database, collection, name string) (bson.Raw, error) {
+		assert.Equal(t, "stream_resume_tokens", collection)
+		assert.Equal(t, "stock_data", name)
+		return token(1), nil
+	}
+	var saved []bson.Raw
+	mongo.SaveResumeToken = func(database, collection, name string, token bson.Raw) error {
+		saved = append(saved, token)
+		return nil
+	}
+
+	at := time.Date(2025, 8, 1, 10, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var resumedFrom []bson.Raw
+	mongo.WatchStockTicks = func(ctx context.Context, database, collection string, resumeAfter bson.Raw, handle func(models.StockData, bson.Raw) error) error {
+		resumedFrom = append(resumedFrom, resumeAfter)
+		switch len(resumedFrom) {
+		case 1:
+			handle(models.StockData{Ticker: "VEHICLE-VIN1", Bid: 100, Ask: 101, Time: at}, token(2))
+			handle(models.StockData{Ticker: "VEHICLE-VIN9", Bid: 50, Ask: 51, Time: at}, token(3))
+			return errors.New("connection reset")
+		case 2:
+			return driver.CommandError{Code: 286, Message: "resume point no longer in the oplog"}
+		default:
+			handle(models.StockData{Ticker: "VEHICLE-VIN1", Bid: 102, Ask: 103, Time: at}, token(4))
+			cancel()
+			return ctx.Err()
+		}
+	}
+
+	hub := NewHub()
+	sub, _ := hub.Subscribe(Filter{Brands: []string{"acme"}}, 10)
+	feed := &Feed{
+		Hub:               hub,
+		Database:          "db",
+		Collection:        "stock_data",
+		TokenCollection:   "stream_resume_tokens",
+		Name:              "stock_data",
+		Vehicles:          &subscription.StaticSource{JSON: `{"payload":{"vehicleSubscriptions":[{"vin":"VIN1","region":"US","brand":"Acme"}]}}`},
+		TokenSaveInterval: time.Hour,
+		RetryDelay:        time.Millisecond,
+	}
+	feed.Run(ctx)
+
+	// Failed streams resume after the last tick; an expired token restarts from now
+	assert.Equal(t, []bson.Raw{token(1), token(3), nil}, resumedFrom)
+	assert.Equal(t, []bson.Raw{token(2), token(3), token(4)}, saved)
+
+	assert.Equal(t, Update{VIN: "VIN1", Region: "US", Brand: "Acme", Ticker: "VEHICLE-VIN1", Bid: 100, Ask: 101, Time: at}, <-sub.C)
+	assert.Equal(t, 102.0, (<-sub.C).Bid)
+	assert.Len(t, sub.C, 0)
+}
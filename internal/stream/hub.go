@@ -0,0 +1,134 @@
+// Package stream fans live stock ticks out to subscribers such as the
+// /stream/stock Server-Sent Events clients.
+package stream
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHubClosed is returned when subscribing to a closed Hub
+var ErrHubClosed = errors.New("stream hub closed")
+
+// Update is one tick enriched with the vehicle it belongs to
+type Update struct {
+	VIN    string    `json:"vin"`
+	Region string    `json:"region,omitempty"`
+	Brand  string    `json:"brand,omitempty"`
+	Ticker string    `json:"ticker"`
+	Bid    float64   `json:"bid"`
+	Ask    float64   `json:"ask"`
+	Time   time.Time `json:"time"`
+}
+
+// Filter selects updates by VIN, region and brand. An empty list matches
+// everything; regions and brands compare case-insensitively.
+type Filter struct {
+	VINs    []string
+	Regions []string
+	Brands  []string
+}
+
+// Matches reports whether u passes every non-empty list of f
+func (f Filter) Matches(u Update) bool {
+	return matchAny(f.VINs, u.VIN, false) && matchAny(f.Regions, u.Region, true) && matchAny(f.Brands, u.Brand, true)
+}
+
+func matchAny(list []string, value string, fold bool) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value || fold && strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub delivers published updates to every matching subscription. Publishing
+// never blocks: a subscriber whose buffer is full misses the update.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub returns an empty hub
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the updates matching its filter on C until it or the hub is closed
+type Subscription struct {
+	C       <-chan Update
+	c       chan Update
+	filter  Filter
+	hub     *Hub
+	dropped atomic.Int64
+}
+
+// Subscribe registers a subscription buffering up to buffer updates
+func (h *Hub) Subscribe(f Filter, buffer int) (*Subscription, error) {
+	c := make(chan Update, buffer)
+	s := &Subscription{C: c, c: c, filter: f, hub: h}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+	h.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Publish sends u to every subscription whose filter matches
+func (h *Hub) Publish(u Update) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs {
+		if !s.filter.Matches(u) {
+			continue
+		}
+		select {
+		case s.c <- u:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribers returns the number of open subscriptions
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Close ends every subscription and rejects new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Close unsubscribes s and closes C; it is safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped returns how many updates s missed because its buffer was full
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMatches(t *testing.T) {
+	u := Update{VIN: "VIN1", Region: "US", Brand: "Acme"}
+	assert.True(t, Filter{}.Matches(u))
+	assert.True(t, Filter{VINs: []string{"VIN2", "VIN1"}}.Matches(u))
+	assert.False(t, Filter{VINs: []string{"vin1"}}.Matches(u))
+	assert.True(t, Filter{Regions: []string{"us"}, Brands: []string{"ACME"}}.Matches(u))
+	assert.False(t, Filter{Regions: []string{"US"}, Brands: []string{"Other"}}.Matches(u))
+}
+
+func TestHubDeliversMatchingUpdates(t *testing.T) {
+	hub := NewHub()
+	all, err := hub.Subscribe(Filter{}, 1)
+	assert.NoError(t, err)
+	ca, _ := hub.Subscribe(Filter{Regions: []string{"CA"}}, 1)
+	assert.Equal(t, 2, hub.Subscribers())
+
+	hub.Publish(Update{VIN: "VIN1", Region: "US"})
+	hub.Publish(Update{VIN: "VIN2", Region: "CA"})
+	assert.Equal(t, Update{VIN: "VIN1", Region: "US"}, <-all.C)
+	assert.Equal(t, int64(1), all.Dropped())
+	assert.Equal(t, Update{VIN: "VIN2", Region: "CA"}, <-ca.C)
+	assert.Equal(t, int64(0), ca.Dropped())
+
+	ca.Close()
+	ca.Close()
+	_, open := <-ca.C
+	assert.False(t, open)
+	assert.Equal(t, 1, hub.Subscribers())
+
+	hub.Close()
+	_, open = <-all.C
+	assert.False(t, open)
+	all.Close()
+	_, err = hub.Subscribe(Filter{}, 1)
+	assert.ErrorIs(t, err, ErrHubClosed)
+	hub.Publish(Update{VIN: "VIN1"})
+}
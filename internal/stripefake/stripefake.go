@@ -0,0 +1,694 @@
+// Package stripefake is an in-memory stand-in for the Stripe PaymentIntent and
+// Refund APIs, for running payment flows offline. Point a Stripe client at it
+// with stripe_api_base (or NewServer in tests).
+package stripefake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Test payment methods with special behaviour; any other pm_ ID succeeds
+const (
+	PaymentMethodDeclined          = "pm_card_chargeDeclined"
+	PaymentMethodInsufficientFunds = "pm_card_chargeDeclinedInsufficientFunds"
+)
+
+// minimumAmounts are Stripe's minimum charges in the smallest currency unit
+var minimumAmounts = map[string]int64{"usd": 50, "cad": 50, "eur": 50, "gbp": 30, "jpy": 50}
+
+var cancellationReasons = map[string]bool{"duplicate": true, "fraudulent": true, "requested_by_customer": true, "abandoned": true}
+
+var refundReasons = map[string]bool{"duplicate": true, "fraudulent": true, "requested_by_customer": true}
+
+// cancelable are the PaymentIntent states Stripe allows cancelling from
+var cancelable = map[string]bool{
+	"requires_payment_method": true,
+	"requires_confirmation":   true,
+	"requires_action":         true,
+	"requires_capture":        true,
+	"processing":              true,
+}
+
+// PaymentIntent is the fake's view of a PaymentIntent
+type PaymentIntent struct {
+	ID                 string            `json:"id"`
+	Object             string            `json:"object"`
+	Amount             int64             `json:"amount"`
+	AmountCapturable   int64             `json:"amount_capturable"`
+	AmountReceived     int64             `json:"amount_received"`
+	Currency           string            `json:"currency"`
+	Status             string            `json:"status"`
+	CaptureMethod      string            `json:"capture_method"`
+	PaymentMethod      string            `json:"payment_method,omitempty"`
+	CancellationReason string            `json:"cancellation_reason,omitempty"`
+	CanceledAt         int64             `json:"canceled_at,omitempty"`
+	LatestCharge       string            `json:"latest_charge,omitempty"`
+	LastPaymentError   *apiError         `json:"last_payment_error,omitempty"`
+	Metadata           map[string]string `json:"metadata"`
+	Created            int64             `json:"created"`
+	Livemode           bool              `json:"livemode"`
+
+	account string
+}
+
+// Refund is the fake's view of a Refund
+type Refund struct {
+	ID            string            `json:"id"`
+	Object        string            `json:"object"`
+	Amount        int64             `json:"amount"`
+	Currency      string            `json:"currency"`
+	PaymentIntent string            `json:"payment_intent"`
+	Charge        string            `json:"charge,omitempty"`
+	Reason        string            `json:"reason,omitempty"`
+	Status        string            `json:"status"`
+	Metadata      map[string]string `json:"metadata"`
+	Created       int64             `json:"created"`
+
+	account string
+}
+
+// Charge is the fake's view of the latest charge of a PaymentIntent, derived
+// from it and its refunds when a request expands it
+type Charge struct {
+	ID             string `json:"id"`
+	Object         string `json:"object"`
+	Amount         int64  `json:"amount"`
+	AmountCaptured int64  `json:"amount_captured"`
+	AmountRefunded int64  `json:"amount_refunded"`
+	Captured       bool   `json:"captured"`
+	Refunded       bool   `json:"refunded"`
+	Currency       string `json:"currency"`
+	PaymentIntent  string `json:"payment_intent"`
+	Status         string `json:"status"`
+}
+
+// expandedIntent is a PaymentIntent with its latest charge expanded
+type expandedIntent struct {
+	*PaymentIntent
+	LatestCharge *Charge `json:"latest_charge"`
+}
+
+// expandedRefund is a Refund with its charge expanded
+type expandedRefund struct {
+	*Refund
+	Charge *Charge `json:"charge"`
+}
+
+// apiError is a Stripe error body
+type apiError struct {
+	status      int
+	Type        string `json:"type"`
+	Code        string `json:"code,omitempty"`
+	DeclineCode string `json:"decline_code,omitempty"`
+	Message     string `json:"message"`
+	Param       string `json:"param,omitempty"`
+
+	// PaymentIntent is the intent a failed confirmation left behind
+	PaymentIntent *PaymentIntent `json:"payment_intent,omitempty"`
+}
+
+// idempotentResponse is a stored response replayed for a reused Idempotency-Key
+type idempotentResponse struct {
+	requestHash string
+	status      int
+	body        []byte
+}
+
+// Backend serves the fake Stripe API. Objects belong to the API key that created
+// them, so separate keys behave like separate Stripe accounts.
+type Backend struct {
+	mu          sync.Mutex
+	seq         int
+	intents     map[string]*PaymentIntent
+	refunds     map[string]*Refund
+	idempotency map[string]idempotentResponse
+	failures    []apiError
+	requests    int
+	now         func() time.Time
+}
+
+// NewBackend returns an empty fake Stripe API
+func NewBackend() *Backend {
+	return &Backend{
+		intents:     map[string]*PaymentIntent{},
+		refunds:     map[string]*Refund{},
+		idempotency: map[string]idempotentResponse{},
+		now:         time.Now,
+	}
+}
+
+// Server is a Backend listening on a local httptest server
+type Server struct {
+	*httptest.Server
+	*Backend
+}
+
+// NewServer starts a fake Stripe API; Close it when done
+func NewServer() *Server {
+	b := NewBackend()
+	return &Server{Server: httptest.NewServer(b), Backend: b}
+}
+
+// FailNext makes the next request fail with the given HTTP status and Stripe error type and code.
+// The failure is not retried by Stripe clients.
+func (b *Backend) FailNext(status int, errType, code, message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = append(b.failures, apiError{status: status, Type: errType, Code: code, Message: message})
+}
+
+// SetClock sets the time used for created and canceled_at timestamps
+func (b *Backend) SetClock(now func() time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.now = now
+}
+
+// PaymentIntent returns a copy of a stored PaymentIntent
+func (b *Backend) PaymentIntent(id string) (PaymentIntent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pi, ok := b.intents[id]
+	if !ok {
+		return PaymentIntent{}, false
+	}
+	return *pi, true
+}
+
+// Refunds returns copies of the refunds of a PaymentIntent, oldest first
+func (b *Backend) Refunds(paymentIntentID string) []Refund {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.refundsFor(paymentIntentID)
+}
+
+// Requests is the number of API requests served, including replays and failures
+func (b *Backend) Requests() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.requests
+}
+
+// ServeHTTP implements the /v1/payment_intents and /v1/refunds endpoints
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+
+	account := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if account == "" || account == r.Header.Get("Authorization") {
+		writeError(w, &apiError{status: http.StatusUnauthorized, Type: "invalid_request_error", Message: "You did not provide an API key."})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Message: "Invalid request body."})
+		return
+	}
+	if len(b.failures) > 0 {
+		f := b.failures[0]
+		b.failures = b.failures[1:]
+		// Injected failures are final so the client does not retry them away
+		w.Header().Set("Stripe-Should-Retry", "false")
+		writeError(w, &f)
+		return
+	}
+
+	// Reused Idempotency-Keys replay the first response, or fail if the parameters differ
+	var idemKey, hash string
+	if key := r.Header.Get("Idempotency-Key"); key != "" && r.Method == http.MethodPost {
+		idemKey, hash = account+":"+key, requestHash(r)
+		if prev, ok := b.idempotency[idemKey]; ok {
+			if prev.requestHash != hash {
+				writeError(w, &apiError{status: http.StatusBadRequest, Type: "idempotency_error",
+					Message: "Keys for idempotent requests can only be used with the same parameters they were first used with."})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prev.status)
+			w.Write(prev.body)
+			return
+		}
+	}
+
+	v, apiErr := b.route(r, account)
+	status, body := http.StatusOK, []byte(nil)
+	if apiErr != nil {
+		status = apiErr.status
+		body, _ = json.Marshal(map[string]*apiError{"error": apiErr})
+	} else {
+		body, _ = json.Marshal(v)
+	}
+	// Like Stripe, only responses that reached the API logic are stored
+	if idemKey != "" && status != http.StatusInternalServerError {
+		b.idempotency[idemKey] = idempotentResponse{requestHash: hash, status: status, body: body}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Request-Id", fmt.Sprintf("req_fake_%d", b.requests))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (b *Backend) route(r *http.Request, account string) (interface{}, *apiError) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		return nil, notFound(r)
+	}
+	switch {
+	case parts[1] == "payment_intents" && len(parts) == 2 && r.Method == http.MethodPost:
+		return b.createIntent(r.Form, account)
+	case parts[1] == "payment_intents" && len(parts) == 2 && r.Method == http.MethodGet:
+		return b.listIntents(r.Form, account)
+	case parts[1] == "payment_intents" && len(parts) == 3 && r.Method == http.MethodGet:
+		return b.getIntent(parts[2], r.Form, account)
+	case parts[1] == "payment_intents" && len(parts) == 4 && parts[3] == "capture" && r.Method == http.MethodPost:
+		return b.captureIntent(parts[2], r.Form, account)
+	case parts[1] == "payment_intents" && len(parts) == 4 && parts[3] == "confirm" && r.Method == http.MethodPost:
+		return b.confirmIntent(parts[2], r.Form, account)
+	case parts[1] == "payment_intents" && len(parts) == 4 && parts[3] == "cancel" && r.Method == http.MethodPost:
+		return b.cancelIntent(parts[2], r.Form, account)
+	case parts[1] == "refunds" && len(parts) == 2 && r.Method == http.MethodPost:
+		return b.createRefund(r.Form, account)
+	case parts[1] == "refunds" && len(parts) == 3 && r.Method == http.MethodGet:
+		return b.refund(parts[2], account)
+	}
+	return nil, notFound(r)
+}
+
+func (b *Backend) createIntent(form url.Values, account string) (interface{}, *apiError) {
+	amount, apiErr := requiredAmount(form, "amount")
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	currency := strings.ToLower(form.Get("currency"))
+	if currency == "" {
+		return nil, missingParam("currency")
+	}
+	if min, ok := minimumAmounts[currency]; ok && amount < min {
+		return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "amount_too_small", Param: "amount",
+			Message: fmt.Sprintf("Amount must be at least %d %s", min, currency)}
+	}
+	pm := form.Get("payment_method")
+	if pm != "" && !strings.HasPrefix(pm, "pm_") {
+		return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "resource_missing", Param: "payment_method",
+			Message: fmt.Sprintf("No such PaymentMethod: '%s'", pm)}
+	}
+
+	pi := &PaymentIntent{
+		ID:            b.newID("pi"),
+		Object:        "payment_intent",
+		Amount:        amount,
+		Currency:      currency,
+		Status:        "requires_payment_method",
+		CaptureMethod: "automatic",
+		PaymentMethod: pm,
+		Metadata:      metadata(form),
+		Created:       b.now().Unix(),
+		account:       account,
+	}
+	if cm := form.Get("capture_method"); cm != "" {
+		pi.CaptureMethod = cm
+	}
+	if pm != "" {
+		pi.Status = "requires_confirmation"
+	}
+
+	confirm := form.Get("confirm") == "true"
+	if confirm && pm == "" {
+		return nil, missingPaymentMethod()
+	}
+	b.intents[pi.ID] = pi
+	if confirm {
+		return b.confirm(pi)
+	}
+	return pi, nil
+}
+
+func (b *Backend) confirmIntent(id string, form url.Values, account string) (interface{}, *apiError) {
+	pi, apiErr := b.intent(id, account)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if pm := form.Get("payment_method"); pm != "" {
+		if !strings.HasPrefix(pm, "pm_") {
+			return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "resource_missing", Param: "payment_method",
+				Message: fmt.Sprintf("No such PaymentMethod: '%s'", pm)}
+		}
+		pi.PaymentMethod = pm
+	}
+	if pi.Status != "requires_confirmation" && pi.Status != "requires_payment_method" {
+		return nil, unexpectedState(pi, "confirm")
+	}
+	return b.confirm(pi)
+}
+
+// confirm authorises pi against its payment method, declining the test cards
+// in declineCodes
+func (b *Backend) confirm(pi *PaymentIntent) (interface{}, *apiError) {
+	if pi.PaymentMethod == "" {
+		return nil, missingPaymentMethod()
+	}
+	if declineCode := declineCodes[pi.PaymentMethod]; declineCode != "" {
+		// Stripe keeps the declined PaymentIntent and reports the decline
+		pi.Status = "requires_payment_method"
+		pi.LastPaymentError = &apiError{Type: "card_error", Code: "card_declined", DeclineCode: declineCode, Message: "Your card was declined."}
+		declined := *pi
+		apiErr := *pi.LastPaymentError
+		apiErr.status, apiErr.PaymentIntent = http.StatusPaymentRequired, &declined
+		return nil, &apiErr
+	}
+	pi.LastPaymentError = nil
+	pi.LatestCharge = b.newID("ch")
+	if pi.CaptureMethod == "manual" {
+		pi.Status = "requires_capture"
+		pi.AmountCapturable = pi.Amount
+	} else {
+		pi.Status = "succeeded"
+		pi.AmountReceived = pi.Amount
+	}
+	return pi, nil
+}
+
+var declineCodes = map[string]string{
+	PaymentMethodDeclined:          "generic_decline",
+	PaymentMethodInsufficientFunds: "insufficient_funds",
+}
+
+// listIntents pages through the account's PaymentIntents newest first,
+// filtered by created[gt|gte|lt|lte]
+func (b *Backend) listIntents(form url.Values, account string) (interface{}, *apiError) {
+	limit := 10
+	if v := form.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return nil, invalidParam("limit", v)
+		}
+		limit = n
+	}
+	bounds := map[string]int64{}
+	for _, op := range []string{"gt", "gte", "lt", "lte"} {
+		if v := form.Get("created[" + op + "]"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, invalidParam("created["+op+"]", v)
+			}
+			bounds[op] = n
+		}
+	}
+
+	var matched []*PaymentIntent
+	for _, pi := range b.intents {
+		if pi.account == account && inRange(pi.Created, bounds) {
+			matched = append(matched, pi)
+		}
+	}
+	// IDs are sequential, so this is newest first like Stripe
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID > matched[j].ID })
+	if after := form.Get("starting_after"); after != "" {
+		for i, pi := range matched {
+			if pi.ID == after {
+				matched = matched[i+1:]
+				break
+			}
+		}
+	}
+	hasMore := len(matched) > limit
+	if hasMore {
+		matched = matched[:limit]
+	}
+	data := make([]PaymentIntent, len(matched))
+	for i, pi := range matched {
+		data[i] = *pi
+	}
+	return map[string]interface{}{"object": "list", "url": "/v1/payment_intents", "has_more": hasMore, "data": data}, nil
+}
+
+func inRange(created int64, bounds map[string]int64) bool {
+	if v, ok := bounds["gt"]; ok && created <= v {
+		return false
+	}
+	if v, ok := bounds["gte"]; ok && created < v {
+		return false
+	}
+	if v, ok := bounds["lt"]; ok && created >= v {
+		return false
+	}
+	if v, ok := bounds["lte"]; ok && created > v {
+		return false
+	}
+	return true
+}
+
+func (b *Backend) intent(id, account string) (*PaymentIntent, *apiError) {
+	pi, ok := b.intents[id]
+	if !ok || pi.account != account {
+		return nil, &apiError{status: http.StatusNotFound, Type: "invalid_request_error", Code: "resource_missing", Param: "intent",
+			Message: fmt.Sprintf("No such payment_intent: '%s'", id)}
+	}
+	return pi, nil
+}
+
+// getIntent retrieves a PaymentIntent, expanding latest_charge on request
+func (b *Backend) getIntent(id string, form url.Values, account string) (interface{}, *apiError) {
+	pi, apiErr := b.intent(id, account)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if pi.LatestCharge != "" && expands(form, "latest_charge") {
+		return &expandedIntent{PaymentIntent: pi, LatestCharge: b.charge(pi)}, nil
+	}
+	return pi, nil
+}
+
+func (b *Backend) captureIntent(id string, form url.Values, account string) (interface{}, *apiError) {
+	pi, apiErr := b.intent(id, account)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if pi.Status != "requires_capture" {
+		return nil, unexpectedState(pi, "capture")
+	}
+	amount := pi.AmountCapturable
+	if form.Get("amount_to_capture") != "" {
+		if amount, apiErr = requiredAmount(form, "amount_to_capture"); apiErr != nil {
+			return nil, apiErr
+		}
+		if amount > pi.AmountCapturable {
+			return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "amount_too_large", Param: "amount_to_capture",
+				Message: fmt.Sprintf("The amount to capture (%d) is greater than the amount capturable (%d).", amount, pi.AmountCapturable)}
+		}
+	}
+	pi.Status = "succeeded"
+	pi.AmountReceived = amount
+	pi.AmountCapturable = 0
+	return pi, nil
+}
+
+func (b *Backend) cancelIntent(id string, form url.Values, account string) (interface{}, *apiError) {
+	pi, apiErr := b.intent(id, account)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	reason := form.Get("cancellation_reason")
+	if reason != "" && !cancellationReasons[reason] {
+		return nil, invalidParam("cancellation_reason", reason)
+	}
+	if !cancelable[pi.Status] {
+		return nil, unexpectedState(pi, "cancel")
+	}
+	pi.Status = "canceled"
+	pi.CancellationReason = reason
+	pi.CanceledAt = b.now().Unix()
+	pi.AmountCapturable = 0
+	return pi, nil
+}
+
+func (b *Backend) createRefund(form url.Values, account string) (interface{}, *apiError) {
+	id := form.Get("payment_intent")
+	if id == "" {
+		return nil, missingParam("payment_intent")
+	}
+	pi, apiErr := b.intent(id, account)
+	if apiErr != nil {
+		apiErr.Param = "payment_intent"
+		return nil, apiErr
+	}
+	if pi.Status != "succeeded" {
+		return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "charge_not_refundable", Param: "payment_intent",
+			Message: fmt.Sprintf("This PaymentIntent (%s) does not have a successful charge to refund.", pi.ID)}
+	}
+	remaining := pi.AmountReceived
+	for _, re := range b.refundsFor(pi.ID) {
+		remaining -= re.Amount
+	}
+	if remaining <= 0 {
+		return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "charge_already_refunded",
+			Message: fmt.Sprintf("Charge %s has already been refunded.", pi.LatestCharge)}
+	}
+	amount := remaining
+	if form.Get("amount") != "" {
+		if amount, apiErr = requiredAmount(form, "amount"); apiErr != nil {
+			return nil, apiErr
+		}
+		if amount > remaining {
+			return nil, &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "amount_too_large", Param: "amount",
+				Message: fmt.Sprintf("Refund amount (%d) is greater than unrefunded amount on charge (%d)", amount, remaining)}
+		}
+	}
+	reason := form.Get("reason")
+	if reason != "" && !refundReasons[reason] {
+		return nil, invalidParam("reason", reason)
+	}
+
+	re := &Refund{
+		ID:            b.newID("re"),
+		Object:        "refund",
+		Amount:        amount,
+		Currency:      pi.Currency,
+		PaymentIntent: pi.ID,
+		Charge:        pi.LatestCharge,
+		Reason:        reason,
+		Status:        "succeeded",
+		Metadata:      metadata(form),
+		Created:       b.now().Unix(),
+		account:       account,
+	}
+	b.refunds[re.ID] = re
+	if expands(form, "charge") {
+		return &expandedRefund{Refund: re, Charge: b.charge(pi)}, nil
+	}
+	return re, nil
+}
+
+// charge returns the latest charge of pi as of its current refunds
+func (b *Backend) charge(pi *PaymentIntent) *Charge {
+	var refunded int64
+	for _, re := range b.refundsFor(pi.ID) {
+		refunded += re.Amount
+	}
+	return &Charge{
+		ID:             pi.LatestCharge,
+		Object:         "charge",
+		Amount:         pi.Amount,
+		AmountCaptured: pi.AmountReceived,
+		AmountRefunded: refunded,
+		Captured:       pi.AmountReceived > 0,
+		Refunded:       pi.AmountReceived > 0 && refunded >= pi.AmountReceived,
+		Currency:       pi.Currency,
+		PaymentIntent:  pi.ID,
+		Status:         "succeeded",
+	}
+}
+
+// expands reports whether the request asks to expand field, sent as expand[]=field or expand[0]=field
+func expands(form url.Values, field string) bool {
+	for key, values := range form {
+		if !strings.HasPrefix(key, "expand[") {
+			continue
+		}
+		for _, v := range values {
+			if v == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *Backend) refund(id, account string) (interface{}, *apiError) {
+	re, ok := b.refunds[id]
+	if !ok || re.account != account {
+		return nil, &apiError{status: http.StatusNotFound, Type: "invalid_request_error", Code: "resource_missing", Param: "id",
+			Message: fmt.Sprintf("No such refund: '%s'", id)}
+	}
+	return re, nil
+}
+
+func (b *Backend) refundsFor(paymentIntentID string) []Refund {
+	var out []Refund
+	for _, re := range b.refunds {
+		if re.PaymentIntent == paymentIntentID {
+			out = append(out, *re)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// newID returns a unique, sortable ID such as pi_fake_000001
+func (b *Backend) newID(prefix string) string {
+	b.seq++
+	return fmt.Sprintf("%s_fake_%06d", prefix, b.seq)
+}
+
+func metadata(form url.Values) map[string]string {
+	md := map[string]string{}
+	for k, v := range form {
+		if strings.HasPrefix(k, "metadata[") && strings.HasSuffix(k, "]") {
+			md[strings.TrimSuffix(strings.TrimPrefix(k, "metadata["), "]")] = v[0]
+		}
+	}
+	return md
+}
+
+func requiredAmount(form url.Values, param string) (int64, *apiError) {
+	v := form.Get(param)
+	if v == "" {
+		return 0, missingParam(param)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, invalidParam(param, v)
+	}
+	return n, nil
+}
+
+// requestHash identifies a request's method, path and parameters
+func requestHash(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "?" + r.Form.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+func missingParam(param string) *apiError {
+	return &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "parameter_missing", Param: param,
+		Message: fmt.Sprintf("Missing required param: %s.", param)}
+}
+
+func invalidParam(param, value string) *apiError {
+	return &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "parameter_invalid_string", Param: param,
+		Message: fmt.Sprintf("Invalid %s: %q", param, value)}
+}
+
+func missingPaymentMethod() *apiError {
+	return &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "payment_intent_unexpected_state",
+		Message: "You cannot confirm this PaymentIntent because it's missing a payment method."}
+}
+
+func unexpectedState(pi *PaymentIntent, action string) *apiError {
+	return &apiError{status: http.StatusBadRequest, Type: "invalid_request_error", Code: "payment_intent_unexpected_state",
+		Message: fmt.Sprintf("This PaymentIntent could not be %s because it has a status of %s.", pastTense[action], pi.Status)}
+}
+
+var pastTense = map[string]string{"capture": "captured", "cancel": "canceled", "confirm": "confirmed"}
+
+func notFound(r *http.Request) *apiError {
+	return &apiError{status: http.StatusNotFound, Type: "invalid_request_error",
+		Message: fmt.Sprintf("Unrecognized request URL (%s: %s).", r.Method, r.URL.Path)}
+}
+
+func writeError(w http.ResponseWriter, e *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(map[string]*apiError{"error": e})
+}
@@ -0,0 +1,281 @@
+package stripefake
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/client"
+)
+
+// newClient starts a fake server and returns a real Stripe client for key pointed at it
+func newClient(t *testing.T, key string) (*Server, *client.API) {
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	return srv, clientFor(srv, key)
+}
+
+func clientFor(srv *Server, key string) *client.API {
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(srv.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+	})
+	return client.New(key, &stripe.Backends{API: backend})
+}
+
+func holdParams(pm string) *stripe.PaymentIntentParams {
+	return &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(5000),
+		Currency:      stripe.String("usd"),
+		PaymentMethod: stripe.String(pm),
+		CaptureMethod: stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		Confirm:       stripe.Bool(true),
+	}
+}
+
+func stripeErr(t *testing.T, err error) *stripe.Error {
+	var se *stripe.Error
+	if !errors.As(err, &se) {
+		t.Fatalf("expected *stripe.Error, got %v", err)
+	}
+	return se
+}
+
+func TestHoldCaptureLifecycle(t *testing.T) {
+	srv, sc := newClient(t, "sk_test_a")
+	params := holdParams("pm_card_visa")
+	params.AddMetadata("vin", "VIN1")
+
+	pi, err := sc.PaymentIntents.New(params)
+	assert.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusRequiresCapture, pi.Status)
+	assert.Equal(t, int64(5000), pi.AmountCapturable)
+	assert.Equal(t, "VIN1", pi.Metadata["vin"])
+
+	got, err := sc.PaymentIntents.Get(pi.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, pi.ID, got.ID)
+
+	_, err = sc.PaymentIntents.Capture(pi.ID, &stripe.PaymentIntentCaptureParams{AmountToCapture: stripe.Int64(6000)})
+	assert.Equal(t, stripe.ErrorCodeAmountTooLarge, stripeErr(t, err).Code)
+
+	pi, err = sc.PaymentIntents.Capture(pi.ID, &stripe.PaymentIntentCaptureParams{AmountToCapture: stripe.Int64(3000)})
+	assert.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusSucceeded, pi.Status)
+	assert.Equal(t, int64(3000), pi.AmountReceived)
+
+	_, err = sc.PaymentIntents.Cancel(pi.ID, nil)
+	assert.Equal(t, stripe.ErrorCodePaymentIntentUnexpectedState, stripeErr(t, err).Code)
+
+	stored, ok := srv.PaymentIntent(pi.ID)
+	assert.True(t, ok)
+	assert.Equal(t, "succeeded", stored.Status)
+}
+
+func TestCancelHold(t *testing.T) {
+	_, sc := newClient(t, "sk_test_a")
+	pi, err := sc.PaymentIntents.New(holdParams("pm_card_visa"))
+	assert.NoError(t, err)
+
+	_, err = sc.PaymentIntents.Cancel(pi.ID, &stripe.PaymentIntentCancelParams{CancellationReason: stripe.String("bored")})
+	assert.Equal(t, "cancellation_reason", stripeErr(t, err).Param)
+
+	pi, err = sc.PaymentIntents.Cancel(pi.ID, &stripe.PaymentIntentCancelParams{CancellationReason: stripe.String("abandoned")})
+	assert.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusCanceled, pi.Status)
+	assert.Equal(t, stripe.PaymentIntentCancellationReasonAbandoned, pi.CancellationReason)
+
+	_, err = sc.PaymentIntents.Capture(pi.ID, nil)
+	assert.Equal(t, stripe.ErrorCodePaymentIntentUnexpectedState, stripeErr(t, err).Code)
+}
+
+func TestConfirmHold(t *testing.T) {
+	srv, sc := newClient(t, "sk_test_a")
+	params := holdParams(PaymentMethodDeclined)
+	params.Confirm = nil
+	pi, err := sc.PaymentIntents.New(params)
+	assert.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusRequiresConfirmation, pi.Status)
+	assert.Zero(t, pi.AmountCapturable)
+
+	_, err = sc.PaymentIntents.Confirm(pi.ID, nil)
+	assert.Equal(t, stripe.DeclineCodeGenericDecline, stripeErr(t, err).DeclineCode)
+	stored, _ := srv.PaymentIntent(pi.ID)
+	assert.Equal(t, "requires_payment_method", stored.Status)
+
+	pi, err = sc.PaymentIntents.Confirm(pi.ID, &stripe.PaymentIntentConfirmParams{PaymentMethod: stripe.String("pm_card_visa")})
+	assert.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusRequiresCapture, pi.Status)
+	assert.Equal(t, int64(5000), pi.AmountCapturable)
+
+	_, err = sc.PaymentIntents.Confirm(pi.ID, nil)
+	assert.Equal(t, stripe.ErrorCodePaymentIntentUnexpectedState, stripeErr(t, err).Code)
+	assert.Contains(t, stripeErr(t, err).Msg, "could not be confirmed")
+}
+
+func TestCreateErrors(t *testing.T) {
+	_, sc := newClient(t, "sk_test_a")
+
+	_, err := sc.PaymentIntents.New(holdParams(PaymentMethodInsufficientFunds))
+	se := stripeErr(t, err)
+	assert.Equal(t, http.StatusPaymentRequired, se.HTTPStatusCode)
+	assert.Equal(t, stripe.ErrorTypeCard, se.Type)
+	assert.Equal(t, stripe.ErrorCodeCardDeclined, se.Code)
+	assert.Equal(t, stripe.DeclineCodeInsufficientFunds, se.DeclineCode)
+	if assert.NotNil(t, se.PaymentIntent) {
+		assert.Equal(t, stripe.PaymentIntentStatusRequiresPaymentMethod, se.PaymentIntent.Status)
+		assert.Equal(t, stripe.DeclineCodeInsufficientFunds, se.PaymentIntent.LastPaymentError.DeclineCode)
+	}
+
+	small := holdParams("pm_card_visa")
+	small.Amount = stripe.Int64(49)
+	_, err = sc.PaymentIntents.New(small)
+	assert.Equal(t, stripe.ErrorCodeAmountTooSmall, stripeErr(t, err).Code)
+
+	_, err = sc.PaymentIntents.New(holdParams("tok_visa"))
+	assert.Equal(t, stripe.ErrorCodeResourceMissing, stripeErr(t, err).Code)
+
+	_, err = sc.PaymentIntents.New(&stripe.PaymentIntentParams{Currency: stripe.String("usd")})
+	assert.Equal(t, stripe.ErrorCodeParameterMissing, stripeErr(t, err).Code)
+}
+
+func TestAccountsAreIsolated(t *testing.T) {
+	srv, us := newClient(t, "sk_test_us")
+	ca := clientFor(srv, "sk_test_ca")
+
+	pi, err := us.PaymentIntents.New(holdParams("pm_card_visa"))
+	assert.NoError(t, err)
+
+	_, err = ca.PaymentIntents.Get(pi.ID, nil)
+	se := stripeErr(t, err)
+	assert.Equal(t, http.StatusNotFound, se.HTTPStatusCode)
+	assert.Equal(t, stripe.ErrorCodeResourceMissing, se.Code)
+
+	_, err = clientFor(srv, "").PaymentIntents.Get(pi.ID, nil)
+	assert.Error(t, err)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	srv, sc := newClient(t, "sk_test_a")
+
+	params := holdParams("pm_card_visa")
+	params.SetIdempotencyKey("hold-1")
+	first, err := sc.PaymentIntents.New(params)
+	assert.NoError(t, err)
+
+	replay := holdParams("pm_card_visa")
+	replay.SetIdempotencyKey("hold-1")
+	second, err := sc.PaymentIntents.New(replay)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, "true", second.LastResponse.Header.Get("Idempotent-Replayed"))
+
+	changed := holdParams("pm_card_visa")
+	changed.Amount = stripe.Int64(7000)
+	changed.SetIdempotencyKey("hold-1")
+	_, err = sc.PaymentIntents.New(changed)
+	assert.Equal(t, stripe.ErrorTypeIdempotency, stripeErr(t, err).Type)
+
+	// The same key on another account is a new request
+	other, err := clientFor(srv, "sk_test_b").PaymentIntents.New(replay)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID)
+}
+
+func TestRefunds(t *testing.T) {
+	srv, sc := newClient(t, "sk_test_a")
+	pi, err := sc.PaymentIntents.New(holdParams("pm_card_visa"))
+	assert.NoError(t, err)
+
+	_, err = sc.Refunds.New(&stripe.RefundParams{PaymentIntent: stripe.String(pi.ID)})
+	assert.Equal(t, stripe.ErrorCodeChargeNotRefundable, stripeErr(t, err).Code)
+
+	_, err = sc.PaymentIntents.Capture(pi.ID, nil)
+	assert.NoError(t, err)
+
+	re, err := sc.Refunds.New(&stripe.RefundParams{
+		PaymentIntent: stripe.String(pi.ID),
+		Amount:        stripe.Int64(2000),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, stripe.RefundStatusSucceeded, re.Status)
+	assert.Equal(t, int64(2000), re.Amount)
+
+	_, err = sc.Refunds.New(&stripe.RefundParams{PaymentIntent: stripe.String(pi.ID), Amount: stripe.Int64(3001)})
+	assert.Equal(t, stripe.ErrorCodeAmountTooLarge, stripeErr(t, err).Code)
+
+	// Expanded charges report the amount refunded so far
+	params := &stripe.PaymentIntentParams{}
+	params.AddExpand("latest_charge")
+	got, err := sc.PaymentIntents.Get(pi.ID, params)
+	assert.NoError(t, err)
+	if assert.NotNil(t, got.LatestCharge) {
+		assert.Equal(t, int64(2000), got.LatestCharge.AmountRefunded)
+		assert.False(t, got.LatestCharge.Refunded)
+	}
+
+	restParams := &stripe.RefundParams{PaymentIntent: stripe.String(pi.ID)}
+	restParams.AddExpand("charge")
+	rest, err := sc.Refunds.New(restParams)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3000), rest.Amount)
+	if assert.NotNil(t, rest.Charge) {
+		assert.Equal(t, int64(5000), rest.Charge.AmountRefunded)
+		assert.True(t, rest.Charge.Refunded)
+	}
+
+	_, err = sc.Refunds.New(&stripe.RefundParams{PaymentIntent: stripe.String(pi.ID)})
+	assert.Equal(t, stripe.ErrorCodeChargeAlreadyRefunded, stripeErr(t, err).Code)
+
+	gotRefund, err := sc.Refunds.Get(re.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, re.ID, gotRefund.ID)
+	assert.Len(t, srv.Refunds(pi.ID), 2)
+}
+
+func TestFailNext(t *testing.T) {
+	srv, sc := newClient(t, "sk_test_a")
+	srv.FailNext(http.StatusInternalServerError, "api_error", "", "Stripe is down")
+
+	_, err := sc.PaymentIntents.New(holdParams("pm_card_visa"))
+	se := stripeErr(t, err)
+	assert.Equal(t, http.StatusInternalServerError, se.HTTPStatusCode)
+	assert.Equal(t, "Stripe is down", se.Msg)
+
+	_, err = sc.PaymentIntents.New(holdParams("pm_card_visa"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, srv.Requests())
+}
+
+func TestListPaymentIntents(t *testing.T) {
+	srv, sc := newClient(t, "sk_test_a")
+	day := time.Date(2025, 8, 24, 0, 0, 0, 0, time.UTC)
+	var created []string
+	for _, at := range []time.Time{day.Add(-time.Hour), day.Add(time.Hour), day.Add(2 * time.Hour), day.Add(3 * time.Hour)} {
+		at := at
+		srv.SetClock(func() time.Time { return at })
+		pi, err := sc.PaymentIntents.New(holdParams("pm_card_visa"))
+		assert.NoError(t, err)
+		created = append(created, pi.ID)
+	}
+	_, err := clientFor(srv, "sk_test_b").PaymentIntents.New(holdParams("pm_card_visa"))
+	assert.NoError(t, err)
+
+	params := &stripe.PaymentIntentListParams{CreatedRange: &stripe.RangeQueryParams{
+		GreaterThanOrEqual: day.Unix(),
+		LesserThan:         day.Add(24 * time.Hour).Unix(),
+	}}
+	params.Limit = stripe.Int64(2)
+	var ids []string
+	it := sc.PaymentIntents.List(params)
+	for it.Next() {
+		ids = append(ids, it.PaymentIntent().ID)
+	}
+	assert.NoError(t, it.Err())
+	// Newest first, across pages of two, without the hold from the day before
+	assert.Equal(t, []string{created[3], created[2], created[1]}, ids)
+}
@@ -0,0 +1,174 @@
+package subscription
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+// SubscriptionSource provides the vehicle subscriptions the service prices and reports on
+type SubscriptionSource interface {
+	Fetch(ctx context.Context) (*models.VehicleResponse, error)
+}
+
+// HTTPSource fetches subscriptions from the upstream subscription API
+type HTTPSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPSource creates an HTTPSource with a bounded request timeout
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Fetch calls the upstream API and parses its VehicleResponse body
+func (s *HTTPSource) Fetch(ctx context.Context) (*models.VehicleResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("subscription API returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVehicleResponse(body)
+}
+
+// FileSource reads subscriptions from a local file. Files ending in .ndjson or
+// .jsonl hold one VehicleSubscription per line; anything else is a full VehicleResponse.
+type FileSource struct {
+	Path string
+}
+
+// Fetch reads the file on every call so edits are picked up without a restart
+func (s *FileSource) Fetch(ctx context.Context) (*models.VehicleResponse, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(s.Path)) {
+	case ".ndjson", ".jsonl":
+		subs, err := parseNDJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Path, err)
+		}
+		return wrapSubscriptions(subs), nil
+	default:
+		return ParseVehicleResponse(data)
+	}
+}
+
+// MongoSource reads subscriptions from a MongoDB collection
+type MongoSource struct {
+	Database   string
+	Collection string
+}
+
+// Fetch loads all subscription documents from the collection
+func (s *MongoSource) Fetch(ctx context.Context) (*models.VehicleResponse, error) {
+	subs, err := mongo.FindVehicleSubscriptions(ctx, s.Database, s.Collection)
+	if err != nil {
+		return nil, err
+	}
+	return wrapSubscriptions(subs), nil
+}
+
+// StaticSource serves a fixed JSON payload (useful for tests and demos)
+type StaticSource struct {
+	JSON string
+}
+
+// Fetch parses the static payload
+func (s *StaticSource) Fetch(ctx context.Context) (*models.VehicleResponse, error) {
+	return ParseVehicleResponse([]byte(s.JSON))
+}
+
+// NewFromConfig builds the SubscriptionSource selected by cfg.SubscriptionSource
+func NewFromConfig(cfg config.Config) (SubscriptionSource, error) {
+	switch strings.ToLower(cfg.SubscriptionSource) {
+	case "http":
+		if cfg.SubscriptionURL == "" {
+			return nil, fmt.Errorf("subscription_url is required for the http subscription source")
+		}
+		return NewHTTPSource(cfg.SubscriptionURL), nil
+	case "file", "":
+		path := cfg.SubscriptionFile
+		if path == "" {
+			path = "subscriptions.json"
+		}
+		return &FileSource{Path: path}, nil
+	case "mongo":
+		coll := cfg.SubscriptionColl
+		if coll == "" {
+			coll = "vehicle_subscriptions"
+		}
+		return &MongoSource{Database: cfg.MongoDB, Collection: coll}, nil
+	default:
+		return nil, fmt.Errorf("unknown subscription source %q", cfg.SubscriptionSource)
+	}
+}
+
+// ParseVehicleResponse decodes a subscription API response body
+func ParseVehicleResponse(data []byte) (*models.VehicleResponse, error) {
+	var resp models.VehicleResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func parseNDJSON(data []byte) ([]models.VehicleSubscription, error) {
+	var subs []models.VehicleSubscription
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var sub models.VehicleSubscription
+		if err := json.Unmarshal(text, &sub); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		subs = append(subs, sub)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func wrapSubscriptions(subs []models.VehicleSubscription) *models.VehicleResponse {
+	resp := &models.VehicleResponse{}
+	resp.Payload.VehicleSubscriptions = subs
+	return resp
+}
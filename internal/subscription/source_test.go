@@ -0,0 +1,156 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+)
+
+const testResponse = `{
+	"status": {"messages": [{"description": "Request Processed Successfully", "responseCode": "SUB-0000"}]},
+	"payload": {
+		"guid": "guid-1",
+		"vehicleSubscriptions": [
+			{"vehicleStatus": "SUBSCRIBED", "region": "US", "vin": "AA450000007141513", "activePaidSubscriptions": true},
+			{"vehicleStatus": "SUBSCRIBED", "region": "CA", "vin": "AA450000007141573", "activePaidSubscriptions": false}
+		]
+	}
+}`
+
+func TestHTTPSourceFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+		w.Write([]byte(testResponse))
+	}))
+	defer srv.Close()
+
+	resp, err := NewHTTPSource(srv.URL).Fetch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "guid-1", resp.Payload.Guid)
+	assert.Equal(t, "SUB-0000", resp.Status.Messages[0].ResponseCode)
+	assert.Len(t, resp.Payload.VehicleSubscriptions, 2)
+	assert.True(t, resp.Payload.VehicleSubscriptions[0].ActivePaidSubscriptions)
+}
+
+func TestHTTPSourceErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad-json" {
+			w.Write([]byte("not-json"))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := NewHTTPSource(srv.URL + "/down").Fetch(context.Background())
+	assert.Error(t, err)
+
+	_, err = NewHTTPSource(srv.URL + "/bad-json").Fetch(context.Background())
+	assert.Error(t, err)
+
+	_, err = NewHTTPSource("http://%zz").Fetch(context.Background())
+	assert.Error(t, err)
+}
+
+func TestFileSourceJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subs.json")
+	assert.NoError(t, os.WriteFile(path, []byte(testResponse), 0o600))
+
+	resp, err := (&FileSource{Path: path}).Fetch(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, resp.Payload.VehicleSubscriptions, 2)
+	assert.Equal(t, "AA450000007141573", resp.Payload.VehicleSubscriptions[1].Vin)
+}
+
+func TestFileSourceNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subs.ndjson")
+	lines := `{"vin":"VIN1","region":"US","activePaidSubscriptions":true}
+
+{"vin":"VIN2","region":"CA","activePaidSubscriptions":false}
+`
+	assert.NoError(t, os.WriteFile(path, []byte(lines), 0o600))
+
+	resp, err := (&FileSource{Path: path}).Fetch(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, resp.Payload.VehicleSubscriptions, 2)
+	assert.Equal(t, "VIN1", resp.Payload.VehicleSubscriptions[0].Vin)
+	assert.Equal(t, "CA", resp.Payload.VehicleSubscriptions[1].Region)
+}
+
+func TestFileSourceErrors(t *testing.T) {
+	_, err := (&FileSource{Path: filepath.Join(t.TempDir(), "missing.json")}).Fetch(context.Background())
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "bad.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte("{\"vin\":\"VIN1\"}\nnot-json\n"), 0o600))
+	_, err = (&FileSource{Path: path}).Fetch(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
+
+// ctxKey tags a test context so fakes can check it was passed through
+type ctxKey struct{}
+
+func TestMongoSourceFetch(t *testing.T) {
+	orig := mongo.FindVehicleSubscriptions
+	defer func() { mongo.FindVehicleSubscriptions = orig }()
+
+	mongo.FindVehicleSubscriptions = func(ctx context.Context, database, collection string) ([]models.VehicleSubscription, error) {
+		assert.Equal(t, "db", database)
+		assert.Equal(t, "subs", collection)
+		assert.Equal(t, "req-1", ctx.Value(ctxKey{}))
+		return []models.VehicleSubscription{{Vin: "VIN1", ActivePaidSubscriptions: true}}, nil
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "req-1")
+	resp, err := (&MongoSource{Database: "db", Collection: "subs"}).Fetch(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Payload.VehicleSubscriptions, 1)
+
+	mongo.FindVehicleSubscriptions = func(ctx context.Context, database, collection string) ([]models.VehicleSubscription, error) {
+		return nil, errors.New("find error")
+	}
+	_, err = (&MongoSource{Database: "db", Collection: "subs"}).Fetch(context.Background())
+	assert.Error(t, err)
+}
+
+func TestStaticSourceFetch(t *testing.T) {
+	resp, err := (&StaticSource{JSON: testResponse}).Fetch(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, resp.Payload.VehicleSubscriptions, 2)
+
+	_, err = (&StaticSource{JSON: "{"}).Fetch(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	src, err := NewFromConfig(config.Config{SubscriptionSource: "http", SubscriptionURL: "http://example.test/subs"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.test/subs", src.(*HTTPSource).URL)
+
+	_, err = NewFromConfig(config.Config{SubscriptionSource: "http"})
+	assert.Error(t, err)
+
+	src, err = NewFromConfig(config.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, "subscriptions.json", src.(*FileSource).Path)
+
+	src, err = NewFromConfig(config.Config{SubscriptionSource: "FILE", SubscriptionFile: "subs.ndjson"})
+	assert.NoError(t, err)
+	assert.Equal(t, "subs.ndjson", src.(*FileSource).Path)
+
+	src, err = NewFromConfig(config.Config{SubscriptionSource: "mongo", MongoDB: "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, &MongoSource{Database: "db", Collection: "vehicle_subscriptions"}, src)
+
+	_, err = NewFromConfig(config.Config{SubscriptionSource: "ftp"})
+	assert.Error(t, err)
+}
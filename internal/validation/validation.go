@@ -0,0 +1,224 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+)
+
+// Field error codes
+const (
+	CodeRequired    = "required"
+	CodeInvalid     = "invalid"
+	CodeUnsupported = "unsupported"
+	CodeTooSmall    = "too_small"
+	CodeTooLarge    = "too_large"
+)
+
+// MaxAmount is the largest amount Stripe accepts, in the smallest currency unit
+const MaxAmount = 99999999
+
+// FieldError describes one invalid request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors collects the field errors for one request
+type Errors []FieldError
+
+// Add records an error for field
+func (e *Errors) Add(field, code, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Error joins the field messages
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Required records an error when value is blank
+func Required(errs *Errors, field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		errs.Add(field, CodeRequired, "%s is required", field)
+		return false
+	}
+	return true
+}
+
+// PaymentMethod checks that value looks like a Stripe PaymentMethod ID
+func PaymentMethod(errs *Errors, field, value string) {
+	if !Required(errs, field, value) {
+		return
+	}
+	if !strings.HasPrefix(value, "pm_") {
+		errs.Add(field, CodeInvalid, "%s must be a PaymentMethod ID (pm_...)", field)
+	}
+}
+
+// OneOf records an error unless value is one of allowed
+func OneOf(errs *Errors, field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	errs.Add(field, CodeUnsupported, "%s must be one of %s", field, strings.Join(allowed, ", "))
+}
+
+// zeroDecimal lists the currencies Stripe charges in whole units
+var zeroDecimal = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true,
+	"krw": true, "mga": true, "pyg": true, "rwf": true, "ugx": true, "vnd": true,
+	"vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+// IsZeroDecimal reports whether currency has no minor unit
+func IsZeroDecimal(currency string) bool {
+	return zeroDecimal[strings.ToLower(currency)]
+}
+
+// ToMinorUnits converts an amount in major units (e.g. 0.50 usd) to the smallest currency unit
+func ToMinorUnits(amount float64, currency string) int64 {
+	if IsZeroDecimal(currency) {
+		return int64(math.Round(amount))
+	}
+	return int64(math.Round(amount * 100))
+}
+
+// FormatAmount renders an amount in the smallest currency unit as major units, e.g. "0.50 usd"
+func FormatAmount(amount int64, currency string) string {
+	if IsZeroDecimal(currency) {
+		return strconv.FormatInt(amount, 10) + " " + currency
+	}
+	return strconv.FormatFloat(float64(amount)/100, 'f', 2, 64) + " " + currency
+}
+
+// defaultMinimums are Stripe's minimum charge amounts in major units
+var defaultMinimums = map[string]float64{
+	"usd": 0.50, "cad": 0.50, "aud": 0.50, "eur": 0.50, "chf": 0.50, "nzd": 0.50,
+	"gbp": 0.30, "dkk": 2.50, "nok": 3.00, "sek": 3.00, "mxn": 10, "hkd": 4.00,
+	"sgd": 0.50, "jpy": 50, "pln": 2.00, "czk": 15.00, "huf": 175,
+}
+
+// DefaultCurrencies are accepted when no currencies are configured
+var DefaultCurrencies = []string{"usd", "cad"}
+
+type amountRange struct {
+	min, max int64
+}
+
+// Validator checks payment input against the whitelisted currencies
+type Validator struct {
+	limits map[string]amountRange
+}
+
+// New builds a Validator from ISO-4217 codes mapped to optional limits in major units.
+// A zero limit falls back to Stripe's minimum charge and MaxAmount.
+func New(currencies map[string]config.CurrencyLimits) (*Validator, error) {
+	if len(currencies) == 0 {
+		currencies = make(map[string]config.CurrencyLimits, len(DefaultCurrencies))
+		for _, c := range DefaultCurrencies {
+			currencies[c] = config.CurrencyLimits{}
+		}
+	}
+	v := &Validator{limits: make(map[string]amountRange, len(currencies))}
+	for code, l := range currencies {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if !isCurrencyCode(code) {
+			return nil, fmt.Errorf("invalid currency code %q", code)
+		}
+		r := amountRange{min: 1, max: MaxAmount}
+		if min, ok := defaultMinimums[code]; ok {
+			r.min = ToMinorUnits(min, code)
+		}
+		if l.Min > 0 {
+			r.min = ToMinorUnits(l.Min, code)
+		}
+		if l.Max > 0 {
+			r.max = ToMinorUnits(l.Max, code)
+		}
+		if r.max > MaxAmount || r.min > r.max {
+			return nil, fmt.Errorf("invalid amount limits for %s", code)
+		}
+		v.limits[code] = r
+	}
+	return v, nil
+}
+
+// Default returns a Validator for DefaultCurrencies
+func Default() *Validator {
+	v, _ := New(nil)
+	return v
+}
+
+// Currencies returns the accepted currency codes, sorted
+func (v *Validator) Currencies() []string {
+	codes := make([]string, 0, len(v.limits))
+	for code := range v.limits {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Currency checks currency against the whitelist and returns it lower-cased
+func (v *Validator) Currency(errs *Errors, field, currency string) string {
+	code := strings.ToLower(strings.TrimSpace(currency))
+	if !Required(errs, field, code) {
+		return code
+	}
+	if _, ok := v.limits[code]; !ok {
+		errs.Add(field, CodeUnsupported, "%s must be one of %s", field, strings.Join(v.Currencies(), ", "))
+	}
+	return code
+}
+
+// Amount checks an amount in the smallest unit of currency against its limits.
+// Unsupported currencies only get the positive check; Currency reports them.
+func (v *Validator) Amount(errs *Errors, field string, amount int64, currency string) {
+	if amount <= 0 {
+		errs.Add(field, CodeTooSmall, "%s must be positive", field)
+		return
+	}
+	r, ok := v.limits[currency]
+	if !ok {
+		return
+	}
+	if amount < r.min {
+		errs.Add(field, CodeTooSmall, "%s must be at least %d (%s)", field, r.min, FormatAmount(r.min, currency))
+	} else if amount > r.max {
+		errs.Add(field, CodeTooLarge, "%s must be at most %d (%s)", field, r.max, FormatAmount(r.max, currency))
+	}
+}
+
+// Range checks that n lies in [min, max]
+func Range(errs *Errors, field string, n, min, max int64) {
+	if n < min {
+		errs.Add(field, CodeTooSmall, "%s must be between %d and %d", field, min, max)
+	} else if n > max {
+		errs.Add(field, CodeTooLarge, "%s must be between %d and %d", field, min, max)
+	}
+}
+
+// isCurrencyCode reports whether code has the three-letter ISO-4217 form
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
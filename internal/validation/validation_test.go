@@ -0,0 +1,117 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/config"
+)
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	assert.Empty(t, errs)
+	errs.Add("amount", CodeTooSmall, "amount must be at least %d", 50)
+	errs.Add("currency", CodeRequired, "currency is required")
+	assert.Equal(t, "amount: amount must be at least 50; currency: currency is required", errs.Error())
+	assert.Equal(t, FieldError{Field: "amount", Code: CodeTooSmall, Message: "amount must be at least 50"}, errs[0])
+}
+
+func TestRequiredAndPaymentMethod(t *testing.T) {
+	var errs Errors
+	assert.False(t, Required(&errs, "vin", "  "))
+	assert.True(t, Required(&errs, "vin", "VIN1"))
+	PaymentMethod(&errs, "payment_method", "")
+	PaymentMethod(&errs, "payment_method", "tok_visa")
+	PaymentMethod(&errs, "payment_method", "pm_card_visa")
+	assert.Len(t, errs, 3)
+	assert.Equal(t, CodeRequired, errs[1].Code)
+	assert.Equal(t, CodeInvalid, errs[2].Code)
+}
+
+func TestOneOfAndRange(t *testing.T) {
+	var errs Errors
+	OneOf(&errs, "interval", "1h", "1m", "1h")
+	OneOf(&errs, "interval", "2h", "1m", "1h")
+	Range(&errs, "limit", 0, 1, 10)
+	Range(&errs, "limit", 11, 1, 10)
+	Range(&errs, "limit", 10, 1, 10)
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "interval must be one of 1m, 1h", errs[0].Message)
+	assert.Equal(t, CodeTooSmall, errs[1].Code)
+	assert.Equal(t, CodeTooLarge, errs[2].Code)
+}
+
+func TestZeroDecimalCurrencies(t *testing.T) {
+	assert.True(t, IsZeroDecimal("JPY"))
+	assert.False(t, IsZeroDecimal("usd"))
+	assert.Equal(t, int64(50), ToMinorUnits(0.5, "usd"))
+	assert.Equal(t, int64(50), ToMinorUnits(50, "jpy"))
+	assert.Equal(t, "0.50 usd", FormatAmount(50, "usd"))
+	assert.Equal(t, "50 jpy", FormatAmount(50, "jpy"))
+}
+
+func TestDefaultValidator(t *testing.T) {
+	v := Default()
+	assert.Equal(t, []string{"cad", "usd"}, v.Currencies())
+
+	var errs Errors
+	assert.Equal(t, "usd", v.Currency(&errs, "currency", " USD "))
+	assert.Empty(t, errs)
+	v.Amount(&errs, "amount", 50, "usd")
+	v.Amount(&errs, "amount", MaxAmount, "usd")
+	assert.Empty(t, errs)
+
+	v.Amount(&errs, "amount", 49, "usd")
+	v.Amount(&errs, "amount", MaxAmount+1, "usd")
+	v.Amount(&errs, "amount", -1, "eur")
+	v.Currency(&errs, "currency", "eur")
+	v.Currency(&errs, "currency", "")
+	assert.Equal(t, []string{CodeTooSmall, CodeTooLarge, CodeTooSmall, CodeUnsupported, CodeRequired}, codes(errs))
+	assert.Equal(t, "amount must be at least 50 (0.50 usd)", errs[0].Message)
+	assert.Equal(t, "currency must be one of cad, usd", errs[3].Message)
+}
+
+func TestNewWithLimits(t *testing.T) {
+	v, err := New(map[string]config.CurrencyLimits{
+		"USD": {Min: 10, Max: 5000},
+		"jpy": {Max: 500000},
+		"isk": {},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"isk", "jpy", "usd"}, v.Currencies())
+
+	var errs Errors
+	v.Amount(&errs, "amount", 1000, "usd")
+	v.Amount(&errs, "amount", 500000, "usd")
+	v.Amount(&errs, "amount", 50, "jpy")
+	v.Amount(&errs, "amount", 500000, "jpy")
+	v.Amount(&errs, "amount", 1, "isk")
+	assert.Empty(t, errs)
+
+	v.Amount(&errs, "amount", 999, "usd")
+	v.Amount(&errs, "amount", 500001, "usd")
+	v.Amount(&errs, "amount", 49, "jpy")
+	v.Amount(&errs, "amount", 500001, "jpy")
+	assert.Equal(t, []string{CodeTooSmall, CodeTooLarge, CodeTooSmall, CodeTooLarge}, codes(errs))
+	assert.Equal(t, "amount must be at most 500000 (500000 jpy)", errs[3].Message)
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	for _, cfg := range []map[string]config.CurrencyLimits{
+		{"dollars": {}},
+		{"us1": {}},
+		{"usd": {Min: 100, Max: 10}},
+		{"usd": {Max: 1000000}},
+	} {
+		_, err := New(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func codes(errs Errors) []string {
+	out := make([]string, len(errs))
+	for i, e := range errs {
+		out[i] = e.Code
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxMessageSize bounds client messages; subscription requests are small
+const maxMessageSize = 64 * 1024
+
+// client is one WebSocket connection. Its writes happen on writePump only.
+type client struct {
+	hub  *Hub
+	conn *websocket.Conn
+	addr string
+	send chan []byte
+	vins map[string]struct{} // guarded by hub.mu
+
+	closeOnce   sync.Once
+	done        chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+func newClient(h *Hub, conn *websocket.Conn) *client {
+	c := &client{
+		hub:  h,
+		conn: conn,
+		send: make(chan []byte, h.opts.SendBuffer),
+		vins: make(map[string]struct{}),
+		done: make(chan struct{}),
+	}
+	if conn != nil {
+		c.addr = conn.RemoteAddr().String()
+	}
+	return c
+}
+
+// queue buffers msg for sending and reports false when the buffer is full
+func (c *client) queue(msg []byte) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnect asks writePump to send a close frame with code and reason and end the connection
+func (c *client) disconnect(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.done)
+	})
+}
+
+// reply queues a message about the client's own request
+func (c *client) reply(msg ServerMessage) {
+	data, err := json.Marshal(msg)
+	if err == nil && !c.queue(data) {
+		c.disconnect(websocket.ClosePolicyViolation, "slow consumer")
+	}
+}
+
+// readPump handles client messages and pongs until the connection fails
+func (c *client) readPump() {
+	defer func() {
+		c.hub.unregister(c)
+		c.disconnect(websocket.CloseNormalClosure, "")
+	}()
+	pongWait := 2 * c.hub.opts.PingInterval
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg ClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.reply(ServerMessage{Type: TypeError, Error: "invalid JSON message"})
+			continue
+		}
+		c.handle(msg)
+	}
+}
+
+// handle applies one subscription change and replies with the current VINs
+func (c *client) handle(msg ClientMessage) {
+	var vins []string
+	for _, vin := range msg.VINs {
+		if vin = strings.TrimSpace(vin); vin != "" {
+			vins = append(vins, vin)
+		}
+	}
+	if len(vins) == 0 {
+		c.reply(ServerMessage{Type: TypeError, Error: "vins is required"})
+		return
+	}
+	switch msg.Action {
+	case ActionSubscribe:
+		if err := c.hub.subscribe(c, vins); err != nil {
+			c.reply(ServerMessage{Type: TypeError, Error: err.Error()})
+			return
+		}
+	case ActionUnsubscribe:
+		c.hub.unsubscribe(c, vins)
+	default:
+		c.reply(ServerMessage{Type: TypeError, Error: `action must be "subscribe" or "unsubscribe"`})
+		return
+	}
+	c.reply(ServerMessage{Type: TypeSubscriptions, VINs: c.hub.subscriptions(c)})
+}
+
+// writePump sends queued messages and heartbeat pings, and closes the
+// connection once the client is disconnected
+func (c *client) writePump() {
+	ping := time.NewTicker(c.hub.opts.PingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	deadline := func() time.Time { return time.Now().Add(c.hub.opts.WriteTimeout) }
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(deadline())
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.disconnect(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline()); err != nil {
+				c.disconnect(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason), deadline())
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package ws serves real-time stock ticks over WebSocket. Clients subscribe to
+// VINs with JSON messages and receive every tick of those vehicles.
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+// tickerPrefix precedes the VIN in every stock ticker
+const tickerPrefix = "VEHICLE-"
+
+// Client message actions
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// Server message types
+const (
+	TypeTick          = "tick"
+	TypeSubscriptions = "subscriptions"
+	TypeError         = "error"
+)
+
+// ClientMessage is sent by clients to change their subscriptions
+type ClientMessage struct {
+	Action string   `json:"action"`
+	VINs   []string `json:"vins"`
+}
+
+// ServerMessage is sent to clients: a tick, the current subscriptions after a
+// change, or an error about the last client message
+type ServerMessage struct {
+	Type  string            `json:"type"`
+	Tick  *models.StockData `json:"tick,omitempty"`
+	VINs  []string          `json:"vins,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// Options tunes a Hub; zero values use the defaults
+type Options struct {
+	SendBuffer       int           // messages queued per client before it counts as slow, default 256
+	MaxSubscriptions int           // VINs per connection, default 100
+	PingInterval     time.Duration // heartbeat period; clients missing two pongs are dropped, default 30s
+	WriteTimeout     time.Duration // bound of one write, default 10s
+	// CheckOrigin accepts the handshake origin; the default allows every origin like the REST API's CORS policy
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Hub tracks WebSocket clients and their VIN subscriptions. Publish never
+// blocks: a client whose send buffer is full is disconnected as a slow consumer.
+type Hub struct {
+	opts     Options
+	upgrader websocket.Upgrader
+
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+	byVIN   map[string]map[*client]struct{}
+	closed  bool
+}
+
+// NewHub returns a hub with no clients
+func NewHub(opts Options) *Hub {
+	if opts.SendBuffer <= 0 {
+		opts.SendBuffer = 256
+	}
+	if opts.MaxSubscriptions <= 0 {
+		opts.MaxSubscriptions = 100
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = 30 * time.Second
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	if opts.CheckOrigin == nil {
+		opts.CheckOrigin = func(r *http.Request) bool { return true }
+	}
+	return &Hub{
+		opts:     opts,
+		upgrader: websocket.Upgrader{CheckOrigin: opts.CheckOrigin},
+		clients:  make(map[*client]struct{}),
+		byVIN:    make(map[string]map[*client]struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves it
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		http.Error(w, "WebSocket hub closed", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an HTTP error
+		return
+	}
+	c := newClient(h, conn)
+	if !h.register(c) {
+		c.disconnect(websocket.CloseGoingAway, "server shutting down")
+	}
+	go c.writePump()
+	c.readPump()
+}
+
+// Publish sends tick to every client subscribed to its VIN
+func (h *Hub) Publish(tick models.StockData) {
+	vin := strings.TrimPrefix(tick.Ticker, tickerPrefix)
+	msg, err := json.Marshal(ServerMessage{Type: TypeTick, Tick: &tick})
+	if err != nil {
+		return
+	}
+	var slow []*client
+	h.mu.RLock()
+	for c := range h.byVIN[vin] {
+		if !c.queue(msg) {
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+	for _, c := range slow {
+		log.Printf("Disconnecting slow WebSocket client %s", c.addr)
+		c.disconnect(websocket.ClosePolicyViolation, "slow consumer")
+	}
+}
+
+// Clients returns the number of connected clients
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// Close disconnects every client and rejects new connections
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	clients := make([]*client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+	for _, c := range clients {
+		c.disconnect(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+func (h *Hub) register(c *client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	return true
+}
+
+func (h *Hub) unregister(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+	for vin := range c.vins {
+		h.removeVIN(c, vin)
+	}
+}
+
+// subscribe adds vins to c unless that exceeds the subscription cap
+func (h *Hub) subscribe(c *client, vins []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	added := 0
+	for _, vin := range vins {
+		if _, ok := c.vins[vin]; !ok {
+			added++
+		}
+	}
+	if len(c.vins)+added > h.opts.MaxSubscriptions {
+		return fmt.Errorf("subscription limit of %d VINs per connection exceeded", h.opts.MaxSubscriptions)
+	}
+	for _, vin := range vins {
+		c.vins[vin] = struct{}{}
+		if h.byVIN[vin] == nil {
+			h.byVIN[vin] = make(map[*client]struct{})
+		}
+		h.byVIN[vin][c] = struct{}{}
+	}
+	return nil
+}
+
+func (h *Hub) unsubscribe(c *client, vins []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, vin := range vins {
+		delete(c.vins, vin)
+		h.removeVIN(c, vin)
+	}
+}
+
+// removeVIN drops c from the subscribers of vin; h.mu must be held
+func (h *Hub) removeVIN(c *client, vin string) {
+	if subs := h.byVIN[vin]; subs != nil {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(h.byVIN, vin)
+		}
+	}
+}
+
+// subscriptions returns the sorted VINs of c
+func (h *Hub) subscriptions(c *client) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	vins := make([]string, 0, len(c.vins))
+	for vin := range c.vins {
+		vins = append(vins, vin)
+	}
+	sort.Strings(vins)
+	return vins
+}
@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
+)
+
+var tickTime = time.Date(2025, 8, 1, 10, 0, 0, 0, time.UTC)
+
+// dial connects a test client to a server for hub
+func dial(t *testing.T, hub *Hub) *websocket.Conn {
+	srv := httptest.NewServer(hub)
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, action string, vins ...string) ServerMessage {
+	assert.NoError(t, conn.WriteJSON(ClientMessage{Action: action, VINs: vins}))
+	return read(t, conn)
+}
+
+func read(t *testing.T, conn *websocket.Conn) ServerMessage {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg ServerMessage
+	assert.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestHubSubscriptions(t *testing.T) {
+	hub := NewHub(Options{MaxSubscriptions: 2})
+	conn := dial(t, hub)
+
+	assert.Equal(t, ServerMessage{Type: TypeSubscriptions, VINs: []string{"VIN1", "VIN2"}}, send(t, conn, ActionSubscribe, "VIN2", "VIN1"))
+	assert.Equal(t, 1, hub.Clients())
+
+	hub.Publish(models.StockData{Ticker: "VEHICLE-VIN3", Bid: 1, Time: tickTime})
+	hub.Publish(models.StockData{Ticker: "VEHICLE-VIN2", Bid: 100, Ask: 101, Time: tickTime})
+	assert.Equal(t, ServerMessage{Type: TypeTick, Tick: &models.StockData{Ticker: "VEHICLE-VIN2", Bid: 100, Ask: 101, Time: tickTime}}, read(t, conn))
+
+	// The cap rejects the whole request; resubscribing is free
+	assert.Equal(t, "subscription limit of 2 VINs per connection exceeded", send(t, conn, ActionSubscribe, "VIN1", "VIN3").Error)
+	assert.Equal(t, []string{"VIN1", "VIN2"}, send(t, conn, ActionSubscribe, "VIN1").VINs)
+
+	assert.Equal(t, []string{"VIN1"}, send(t, conn, ActionUnsubscribe, "VIN2", "VIN9").VINs)
+	hub.Publish(models.StockData{Ticker: "VEHICLE-VIN2", Bid: 200, Time: tickTime})
+	hub.Publish(models.StockData{Ticker: "VEHICLE-VIN1", Bid: 300, Time: tickTime})
+	assert.Equal(t, 300.0, read(t, conn).Tick.Bid)
+
+	assert.Equal(t, "vins is required", send(t, conn, ActionSubscribe).Error)
+	assert.Equal(t, `action must be "subscribe" or "unsubscribe"`, send(t, conn, "watch", "VIN1").Error)
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{")))
+	assert.Equal(t, "invalid JSON message", read(t, conn).Error)
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return hub.Clients() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Empty(t, hub.byVIN)
+}
+
+func TestHubHeartbeatAndClose(t *testing.T) {
+	hub := NewHub(Options{PingInterval: 20 * time.Millisecond})
+	conn := dial(t, hub)
+	pings := make(chan struct{}, 10)
+	conn.SetPingHandler(func(string) error {
+		pings <- struct{}{}
+		return conn.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second))
+	})
+	send(t, conn, ActionSubscribe, "VIN1")
+
+	// Control frames are handled while reading; answered pings keep the connection open
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		hub.Close()
+	}()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+	assert.GreaterOrEqual(t, len(pings), 2)
+
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.Error(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+}
+
+func TestHubDisconnectsSlowConsumers(t *testing.T) {
+	hub := NewHub(Options{SendBuffer: 1})
+	c := newClient(hub, nil)
+	assert.True(t, hub.register(c))
+	assert.NoError(t, hub.subscribe(c, []string{"VIN1"}))
+
+	hub.Publish(models.StockData{Ticker: "VEHICLE-VIN1", Bid: 100})
+	select {
+	case <-c.done:
+		t.Fatal("disconnected with room in the buffer")
+	default:
+	}
+	hub.Publish(models.StockData{Ticker: "VEHICLE-VIN1", Bid: 101})
+	<-c.done
+	assert.Equal(t, websocket.ClosePolicyViolation, c.closeCode)
+	assert.Equal(t, "slow consumer", c.closeReason)
+}
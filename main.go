@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -8,43 +12,253 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/yourusername/vehicle-stock-service/internal/config"
 	"github.com/yourusername/vehicle-stock-service/internal/handlers"
+	"github.com/yourusername/vehicle-stock-service/internal/kafka"
+	"github.com/yourusername/vehicle-stock-service/internal/lifecycle"
+	"github.com/yourusername/vehicle-stock-service/internal/models"
 	"github.com/yourusername/vehicle-stock-service/internal/mongo"
+	"github.com/yourusername/vehicle-stock-service/internal/payments"
+	"github.com/yourusername/vehicle-stock-service/internal/pricing"
 	"github.com/yourusername/vehicle-stock-service/internal/service"
+	"github.com/yourusername/vehicle-stock-service/internal/stream"
+	"github.com/yourusername/vehicle-stock-service/internal/subscription"
+	"github.com/yourusername/vehicle-stock-service/internal/validation"
+	"github.com/yourusername/vehicle-stock-service/internal/ws"
 )
 
 func main() {
+	modeFlag := flag.String("mode", "", "run mode: producer, consumer or all (overrides run_mode)")
+	flag.Parse()
+
 	// Load configuration
 	config.LoadConfig("vehicle-stock-service")
 
+	// The -mode flag wins over the configured run mode
+	modeValue := config.AppConfig.RunMode
+	if *modeFlag != "" {
+		modeValue = *modeFlag
+	}
+	mode, err := config.ParseRunMode(modeValue)
+	if err != nil {
+		log.Fatal("Run mode configuration failed:", err)
+	}
+	log.Printf("Starting in %s mode", mode)
+
+	// Lifecycle manager: components stop on SIGINT/SIGTERM, cleanup runs in reverse order
+	app := lifecycle.New(config.AppConfig.ShutdownTimeout())
+
 	// Connect to MongoDB
 	if _, err := mongo.ConnectMongo(config.AppConfig.MongoURI); err != nil {
 		log.Fatal("MongoDB connection failed:", err)
 	}
+	app.OnShutdown("MongoDB client", mongo.Disconnect)
+
+	// Convert legacy string timestamps to native dates when requested
+	if config.AppConfig.MigrateStockTimes {
+		n, err := mongo.MigrateStockTimes(config.AppConfig.MongoDB, config.AppConfig.MongoColl)
+		if err != nil {
+			log.Fatal("Stock time migration failed:", err)
+		}
+		log.Printf("Migrated %d stock ticks to native timestamps", n)
+	}
+
+	// Ensure the stock collection's type, validator and {ticker, time} index
+	ts := config.AppConfig.StockTimeSeries
+	stockOpts := mongo.StockCollectionOptions{
+		TimeSeries:         ts.Enabled,
+		MetaField:          ts.MetaField,
+		Granularity:        ts.Granularity,
+		ExpireAfterSeconds: ts.ExpireAfterSeconds,
+	}
+	drift, err := mongo.BootstrapStockCollection(config.AppConfig.MongoDB, config.AppConfig.MongoColl, stockOpts, config.AppConfig.StockSchemaDryRun)
+	if err != nil {
+		log.Fatal("Stock collection bootstrap failed:", err)
+	}
+	if drift.TimeSeriesUnavailable {
+		log.Printf("Time-series stock collection unavailable, using a regular collection with TTL expiry")
+	}
+	if config.AppConfig.StockSchemaDryRun {
+		log.Printf("Stock schema drift (dry run): %s", drift)
+	} else if !drift.None() {
+		log.Printf("Stock schema bootstrapped: %s", drift)
+	}
+
+	// The consumers and the API read and write stock ticks through one
+	// repository on the configured collection. A time-series collection
+	// rejects upserts, so ticks not found there are inserted instead.
+	stocks := mongo.NewMongoStockRepository(config.AppConfig.MongoDB, config.AppConfig.MongoColl)
+	stocks.TimeSeries = drift.TimeSeries
+
+	// The /ws/stock hub gets every tick on the topic from the consumers in "all"
+	// mode and the ticks this process generates in "producer" mode
+	var sockets *ws.Hub
+	var consumedTicks, generatedTicks func(tick models.StockData)
+	if config.RunsProducer(mode) {
+		sockets = ws.NewHub(ws.Options{
+			SendBuffer:       config.AppConfig.WSSendBuffer,
+			MaxSubscriptions: config.AppConfig.WSMaxSubscriptions,
+			PingInterval:     time.Duration(config.AppConfig.WSPingIntervalSec) * time.Second,
+		})
+		if config.RunsConsumer(mode) {
+			consumedTicks = sockets.Publish
+		} else {
+			generatedTicks = sockets.Publish
+		}
+	}
+
+	if config.RunsConsumer(mode) {
+		startConsumers(app, stocks, consumedTicks)
+	}
+	if config.RunsProducer(mode) {
+		startProducer(app, stocks, sockets, generatedTicks, drift.TimeSeries, config.AppConfig.ProducerStores(mode))
+	}
+
+	if err := app.Wait(); err != nil {
+		log.Fatal("Shutdown incomplete:", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// startConsumers starts the configured number of Kafka consumer workers in one
+// consumer group; Kafka spreads the topic's partitions across them. Consumers
+// batch messages themselves and upsert each batch in one bulk write: offsets
+// are committed only once a batch is stored, so failed writes can be retried
+// and dead-lettered with the messages they came from. Stored ticks are passed
+// to onTick when set.
+func startConsumers(app *lifecycle.Manager, stocks mongo.StockRepository, onTick func(tick models.StockData)) {
+	deadLetters, err := kafka.NewProducer(config.AppConfig.KafkaBrokers[0], config.AppConfig.DeadLetterTopic())
+	if err != nil {
+		log.Fatal("Kafka dead-letter producer initialization failed:", err)
+	}
+	app.OnShutdown("Kafka dead-letter producer", closeProducer(deadLetters))
+	opts := kafka.ConsumerOptions{
+		MaxBatch:  config.AppConfig.ConsumerBatchSize,
+		BatchWait: time.Duration(config.AppConfig.ConsumerBatchWaitMs) * time.Millisecond,
+		Retry: kafka.RetryPolicy{
+			MaxAttempts:    config.AppConfig.ConsumerMaxAttempts,
+			InitialBackoff: time.Duration(config.AppConfig.ConsumerRetryBackoffMs) * time.Millisecond,
+			MaxBackoff:     time.Duration(config.AppConfig.ConsumerMaxBackoffMs) * time.Millisecond,
+		},
+		DeadLetters: deadLetters,
+	}
+
+	workers := config.AppConfig.Workers()
+	for i := 1; i <= workers; i++ {
+		c, err := kafka.NewConsumer(config.AppConfig.KafkaBrokers[0], config.AppConfig.GroupID(), config.AppConfig.KafkaTopic, stocks, onTick, opts)
+		if err != nil {
+			log.Fatal("Kafka consumer initialization failed:", err)
+		}
+		name := fmt.Sprintf("Kafka consumer %d", i)
+		app.OnShutdown(name, func(ctx context.Context) error {
+			c.Close()
+			return nil
+		})
+		app.Go(name, c.Run)
+	}
+	log.Printf("Started %d Kafka consumer workers in group %s, dead letters to %s", workers, config.AppConfig.GroupID(), config.AppConfig.DeadLetterTopic())
+}
+
+// startProducer starts the stock producer loop and the REST API. The live
+// /stream/stock feed only runs on a regular stock collection, as change streams
+// are not available on a timeSeries one. The loop only publishes ticks to Kafka,
+// where consumers store them, unless storeTicks lets it write them itself; it
+// passes every generated tick to onTick when set.
+func startProducer(app *lifecycle.Manager, stocks mongo.StockRepository, sockets *ws.Hub, onTick func(tick models.StockData), timeSeries, storeTicks bool) {
+	// Build the vehicle subscription source shared by the producer loop and the handlers
+	subs, err := subscription.NewFromConfig(config.AppConfig)
+	if err != nil {
+		log.Fatal("Subscription source configuration failed:", err)
+	}
+
+	// Select the pricing model used to quote generated ticks
+	model, err := pricing.NewFromConfig(config.AppConfig.Pricing)
+	if err != nil {
+		log.Fatal("Pricing model configuration failed:", err)
+	}
 
 	// Start stock producer loop in background
-	jsonInput := `{
-			"status": {
-				"messages": [
-					{
-						"description": "Request Processed Successfully",
-						"responseCode": "SUB-0000",
-						"detailedDescription": "Request Processed Successfully"
-					}
-				]
-			},
-			"payload": {
-				"guid": "200d617c92c9a889cdda4c31559472f",
-				"vehicleSubscriptions": [
-					{
-						"vehicleStatus": "SUBSCRIBED",
-						"region": "US",
-						"vin": "AA450000007141513",
-						"activePaidSubscriptions": true
-					}
-				]
-			}
-		}`
-	service.StartStockProducerLoop(jsonInput, 30*time.Second)
+	prod, err := kafka.NewProducer(config.AppConfig.KafkaBrokers[0], config.AppConfig.KafkaTopic)
+	if err != nil {
+		log.Fatal("Kafka producer initialization failed:", err)
+	}
+	app.OnShutdown("Kafka producer", closeProducer(prod))
+	// Ticks the producer stores itself are queued and written in bulk; the
+	// loop blocks while MongoDB falls behind
+	var generated mongo.StockRepository
+	if storeTicks {
+		writer := mongo.NewBatchWriter(stocks, mongo.BatchWriterOptions{
+			MaxBatch:      config.AppConfig.StockBatch(),
+			FlushInterval: config.AppConfig.StockFlushInterval(),
+			QueueSize:     config.AppConfig.StockQueue(),
+		})
+		app.OnShutdown("stock batch writer", writer.Close)
+		generated = writer
+	}
+	app.Go("stock producer loop", func(ctx context.Context) error {
+		service.NewStockGenerator(generated, model, onTick).RunStockProducerLoop(ctx, subs, prod, 30*time.Second)
+		return nil
+	})
+
+	// Stripe accounts per vehicle region; payment endpoints return 500 until a key is configured
+	paymentService, err := payments.New(config.AppConfig)
+	if errors.Is(err, payments.ErrNotConfigured) {
+		log.Println("Stripe not configured:", err)
+	} else if err != nil {
+		log.Fatal("Stripe configuration failed:", err)
+	}
+
+	// Payment requests are checked against the configured currency whitelist
+	validator, err := validation.New(config.AppConfig.Currencies)
+	if err != nil {
+		log.Fatal("Currency configuration failed:", err)
+	}
+
+	// Idempotency-Key records expire through a TTL index
+	if err := mongo.EnsureIdempotencyIndexes(config.AppConfig.MongoDB, config.AppConfig.IdempotencyCollection()); err != nil {
+		log.Fatal("Idempotency index creation failed:", err)
+	}
+
+	// Every hold, capture, cancel, refund and webhook event is appended to the payment ledger
+	if err := mongo.EnsureLedgerIndexes(config.AppConfig.MongoDB, config.AppConfig.LedgerCollection()); err != nil {
+		log.Fatal("Payment ledger index creation failed:", err)
+	}
+
+	// Republish verified Stripe webhook events for downstream consumers
+	paymentEvents, err := kafka.NewProducer(config.AppConfig.KafkaBrokers[0], config.AppConfig.PaymentTopic())
+	if err != nil {
+		log.Fatal("Kafka payment event producer initialization failed:", err)
+	}
+	app.OnShutdown("Kafka payment event producer", closeProducer(paymentEvents))
+	paymentHandlers := handlers.NewPaymentHandlers(paymentService, subs, validator, paymentEvents)
+
+	// One active reservation per VIN is enforced by a unique index; expired ones release their hold
+	if err := mongo.EnsureReservationIndexes(config.AppConfig.MongoDB, config.AppConfig.ReservationsCollection()); err != nil {
+		log.Fatal("Reservation index creation failed:", err)
+	}
+	app.Go("reservation sweeper", func(ctx context.Context) error {
+		paymentHandlers.RunReservationSweeper(ctx, config.AppConfig.ReservationSweepInterval())
+		return nil
+	})
+
+	// Live price feed: a change stream on the stock collection fans new ticks out to /stream/stock.
+	// Every replica follows the stream itself, so its resume token is saved under its instance ID.
+	var liveStocks *stream.Hub
+	if !timeSeries {
+		liveStocks = stream.NewHub()
+		feed := &stream.Feed{
+			Hub:             liveStocks,
+			Database:        config.AppConfig.MongoDB,
+			Collection:      config.AppConfig.MongoColl,
+			TokenCollection: config.AppConfig.StreamTokensCollection(),
+			Name:            config.AppConfig.MongoColl + "@" + config.AppConfig.InstanceID(),
+			Vehicles:        subs,
+		}
+		app.Go("live stock feed", func(ctx context.Context) error {
+			feed.Run(ctx)
+			return nil
+		})
+	} else {
+		log.Println("Live stock feed disabled: change streams are not available on a time-series stock collection")
+	}
 
 	// Initialize router
 	r := mux.NewRouter()
@@ -53,8 +267,8 @@ func main() {
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, startDate, endDate")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key, startDate, endDate")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 			log.Printf("CORS middleware executed for %s %s", req.Method, req.URL.Path)
 			if req.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusNoContent)
@@ -65,12 +279,61 @@ func main() {
 	})
 
 	// Register /getstock endpoint
-	r.HandleFunc("/getstock", handlers.GetStockHandler).Methods("GET")
+	candles := &mongo.MongoCandleAggregator{Database: config.AppConfig.MongoDB, Collection: config.AppConfig.MongoColl, TimeSeries: timeSeries}
+	stockHandlers := handlers.NewStockHandlers(stocks, subs, candles, liveStocks, sockets)
+	r.HandleFunc("/getstock", stockHandlers.GetStockHandler).Methods("GET")
+
+	// Register /stock/{vin}/history endpoint for time-range tick history
+	r.HandleFunc("/stock/{vin}/history", stockHandlers.StockHistoryHandler).Methods("GET")
+
+	// Register /stock/{vin}/candles endpoint for OHLC candles
+	r.HandleFunc("/stock/{vin}/candles", stockHandlers.StockCandlesHandler).Methods("GET")
+
+	// Register /stream/stock Server-Sent Events endpoint for live ticks
+	r.HandleFunc("/stream/stock", stockHandlers.StockStreamHandler).Methods("GET")
+
+	// Register /ws/stock WebSocket endpoint for per-VIN tick subscriptions
+	r.HandleFunc("/ws/stock", stockHandlers.StockSocketHandler).Methods("GET")
 
 	// Register /holdpayment endpoint for Stripe payment hold
-	r.HandleFunc("/holdpayment", handlers.HoldPaymentHandler).Methods("POST")
+	r.HandleFunc("/holdpayment", paymentHandlers.HoldPaymentHandler).Methods("POST")
+
+	// Register hold lifecycle endpoints: status, capture and release
+	r.HandleFunc("/holdpayment/{id}", paymentHandlers.GetHoldHandler).Methods("GET")
+	r.HandleFunc("/holdpayment/{id}/capture", paymentHandlers.CaptureHoldHandler).Methods("POST")
+	r.HandleFunc("/holdpayment/{id}/cancel", paymentHandlers.CancelHoldHandler).Methods("POST")
+
+	// Register the payment ledger and refund endpoints for captured payments
+	r.HandleFunc("/payments", handlers.ListPaymentsHandler).Methods("GET")
+	r.HandleFunc("/payments/{id}/refunds", paymentHandlers.RefundPaymentHandler).Methods("POST")
+	r.HandleFunc("/payments/{id}/refunds", handlers.ListRefundsHandler).Methods("GET")
+
+	// Register vehicle reservation endpoints
+	r.HandleFunc("/reservations", paymentHandlers.CreateReservationHandler).Methods("POST")
+	r.HandleFunc("/reservations/{id}", handlers.GetReservationHandler).Methods("GET")
+	r.HandleFunc("/reservations/{id}/release", paymentHandlers.ReleaseReservationHandler).Methods("POST")
+
+	// Register Stripe webhook receiver
+	r.HandleFunc("/webhooks/stripe", paymentHandlers.StripeWebhookHandler).Methods("POST")
 
 	// Start HTTP server
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	// End open /stream/stock responses so draining does not wait for them, and
+	// close the hijacked WebSocket connections that draining does not track
+	if liveStocks != nil {
+		srv.RegisterOnShutdown(liveStocks.Close)
+	}
+	srv.RegisterOnShutdown(sockets.Close)
+	app.Go("HTTP server", lifecycle.ServeHTTP(srv, config.AppConfig.ShutdownTimeout()))
 	log.Println("REST API running on http://localhost:8080/getstock")
-	log.Fatal(http.ListenAndServe(":8080", r))
+}
+
+// closeProducer returns a shutdown hook that flushes and closes prod
+func closeProducer(prod *kafka.Producer) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if remaining := prod.CloseWithTimeout(config.AppConfig.KafkaFlushTimeout()); remaining > 0 {
+			return fmt.Errorf("%d messages not delivered", remaining)
+		}
+		return nil
+	}
 }